@@ -6,7 +6,6 @@ import (
     //    "fmt"
     "github.com/fcheslack/webtypes/twitter"
     "github.com/fcheslack/tweetlog/tweetstore"
-    _ "github.com/mattn/go-sqlite3"
     "io/ioutil"
     "sort"
     "strings"
@@ -18,8 +17,6 @@ var _ = ioutil.ReadAll //DEBUG
 var _ = strings.Join         //DEBUG
 var _ = twittertypes.Tweet{} //DEBUG
 
-var ts = tweetstore.SqliteTweetStore{}
-
 type sortedMap struct {
     m   map[string]int
     s   []string
@@ -50,9 +47,24 @@ func sortedKeys(m map[string]int) []string {
     return sm.s
 }
 
+//Count is a key and how many times it occurred
+type Count = tweetstore.KeyCount
+
+//Top returns the first n keys of sorted, as returned by the ByFrequency methods, with their counts
+func Top(sorted []string, counts map[string]int, n int) []Count {
+    if n > len(sorted) {
+        n = len(sorted)
+    }
+    top := make([]Count, n)
+    for i := 0; i < n; i++ {
+        top[i] = Count{Key: sorted[i], Count: counts[sorted[i]]}
+    }
+    return top
+}
+
 type Analytics struct {
     DB         *sql.DB
-    Tweetstore *tweetstore.SqliteTweetStore
+    Tweetstore tweetstore.TweetStore
     Tweets     []*twittertypes.Tweet
 }
 
@@ -65,21 +77,55 @@ func (a *Analytics) Init(db *sql.DB) {
 func (a *Analytics) PrevDayUrls() []*twittertypes.TwitterUrl {
     startTime := time.Now().Add(-24 * time.Hour)
     endTime := time.Now()
-    tweeturls := ts.IntervalUrls(startTime, endTime)
+    tweeturls := a.Tweetstore.IntervalUrls(startTime, endTime)
     return tweeturls
 }
 
 func (a *Analytics) TweetFrequencies() []int {
     iDuration := time.Hour * 4
-    tweetcounts := ts.IntervalTweetCount(iDuration, 10)
+    tweetcounts := a.Tweetstore.IntervalTweetCount(iDuration, 10)
     return tweetcounts
 }
 
+//TopUrls counts in the store, without loading the tweets
+func (a *Analytics) TopUrls(startTime, endTime time.Time, n int) []Count {
+    return a.Tweetstore.IntervalTop(tweetstore.TopUrls, startTime, endTime, n)
+}
+
+func (a *Analytics) TopUsers(startTime, endTime time.Time, n int) []Count {
+    return a.Tweetstore.IntervalTop(tweetstore.TopUsers, startTime, endTime, n)
+}
+
+func (a *Analytics) TopHashtags(startTime, endTime time.Time, n int) []Count {
+    return a.Tweetstore.IntervalTop(tweetstore.TopHashtags, startTime, endTime, n)
+}
+
+func (a *Analytics) TopMentions(startTime, endTime time.Time, n int) []Count {
+    return a.Tweetstore.IntervalTop(tweetstore.TopMentions, startTime, endTime, n)
+}
+
+func (a *Analytics) TopLangs(startTime, endTime time.Time, n int) []Count {
+    return a.Tweetstore.IntervalTop(tweetstore.TopLangs, startTime, endTime, n)
+}
+
+//The ByFrequency methods count a.Tweets in memory. They are the reference the store
+//aggregations above are checked against, and are used when tweets must be filtered first.
+//Like the store's entity tables they count a key once per tweet, however often it repeats.
+
+//countOnce adds one to counts[key] unless the tweet being counted already has, per seen
+func countOnce(counts map[string]int, seen map[string]bool, key string) {
+    if !seen[key] {
+        seen[key] = true
+        counts[key]++
+    }
+}
+
 func (a *Analytics) UrlsByFrequency() ([]string, map[string]int) {
     urls := make(map[string]int)
     for _, t := range a.Tweets {
+        seen := make(map[string]bool)
         for _, u := range t.Entities.Urls {
-            urls[string(u.Expanded_url)] = urls[string(u.Expanded_url)] + 1
+            countOnce(urls, seen, string(u.Expanded_url))
         }
     }
     sortedUrls := sortedKeys(urls)
@@ -100,8 +146,9 @@ func (a *Analytics) UsersByPosts() ([]string, map[string]int) {
 func (a *Analytics) HashtagsByFrequency() ([]string, map[string]int) {
     tags := make(map[string]int)
     for _, t := range a.Tweets {
+        seen := make(map[string]bool)
         for _, ht := range t.Entities.Hashtags {
-            tags[ht.Text] = tags[ht.Text] + 1
+            countOnce(tags, seen, ht.Text)
         }
     }
     sortedHashtags := sortedKeys(tags)
@@ -109,6 +156,43 @@ func (a *Analytics) HashtagsByFrequency() ([]string, map[string]int) {
     return sortedHashtags, tags
 }
 
+func (a *Analytics) MentionsByFrequency() ([]string, map[string]int) {
+    mentions := make(map[string]int)
+    for _, t := range a.Tweets {
+        seen := make(map[string]bool)
+        for _, m := range t.Entities.User_mentions {
+            countOnce(mentions, seen, m.Screen_name)
+        }
+    }
+    sortedMentions := sortedKeys(mentions)
+
+    return sortedMentions, mentions
+}
+
+func (a *Analytics) LangsByFrequency() ([]string, map[string]int) {
+    langs := make(map[string]int)
+    for _, t := range a.Tweets {
+        lang := tweetstore.TweetLang(t)
+        langs[lang] = langs[lang] + 1
+    }
+    sortedLangs := sortedKeys(langs)
+
+    return sortedLangs, langs
+}
+
+//ByLang splits a.Tweets by language, into an Analytics per language code sharing a's store
+func (a *Analytics) ByLang() map[string]*Analytics {
+    split := make(map[string]*Analytics)
+    for _, t := range a.Tweets {
+        lang := tweetstore.TweetLang(t)
+        if split[lang] == nil {
+            split[lang] = &Analytics{DB: a.DB, Tweetstore: a.Tweetstore}
+        }
+        split[lang].Tweets = append(split[lang].Tweets, t)
+    }
+    return split
+}
+
 /*
 var (
     dbname   *string = flag.String("dbname", "tweets.db", "SQLite3 DB")
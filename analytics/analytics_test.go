@@ -0,0 +1,105 @@
+package analytics
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/fcheslack/tweetlog/tweetstore"
+    "github.com/fcheslack/webtypes/twitter"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+//TestTopMatchesByFrequency checks the store's IntervalTop counts against the in-memory
+//ByFrequency counts of the same tweets
+func TestTopMatchesByFrequency(t *testing.T) {
+    store, err := tweetstore.Open("sqlite3://" + filepath.Join(t.TempDir(), "top.db"))
+    if err != nil {
+        t.Fatalf("opening sqlite store: %s", err)
+    }
+    defer store.Close()
+
+    users := []string{"alice", "bob", "alice", "carol", "alice", "bob", "dave", "erin"}
+    tags := [][]string{{"go", "db"}, {"go"}, {}, {"Go", "sql"}, {"db"}, {"go", "go"}, {"sql"}, {}}
+    mentions := [][]string{{"bob"}, {}, {"carol", "bob"}, {}, {"bob"}, {"alice"}, {}, {"dave"}}
+    urls := [][]string{{"http://a.example/1"}, {"http://b.example/"}, {}, {"http://a.example/1", "http://c.example/"}, {}, {}, {"http://b.example/"}, {}}
+    langs := []string{"en", "en", "fr", "en", "de", "fr", "en", "en"}
+
+    now := time.Now().UTC().Truncate(time.Second)
+    for i := range users {
+        entities := make([]string, 0, 3)
+        list := make([]string, 0, 2)
+        for _, tag := range tags[i] {
+            list = append(list, fmt.Sprintf(`{"text":%q,"indices":[0,0]}`, tag))
+        }
+        entities = append(entities, `"hashtags":[`+strings.Join(list, ",")+`]`)
+        list = list[:0]
+        for j, m := range mentions[i] {
+            list = append(list, fmt.Sprintf(`{"screen_name":%q,"name":%q,"id":%d,"id_str":"%d","indices":[0,0]}`, m, m, 100+j, 100+j))
+        }
+        entities = append(entities, `"user_mentions":[`+strings.Join(list, ",")+`]`)
+        list = list[:0]
+        for _, u := range urls[i] {
+            list = append(list, fmt.Sprintf(`{"url":"http://t.co/x","expanded_url":%q,"display_url":%q,"indices":[0,0]}`, u, u))
+        }
+        entities = append(entities, `"urls":[`+strings.Join(list, ",")+`]`, `"media":[]`)
+
+        id := 1000 + i
+        line := fmt.Sprintf(`{"id":%d,"id_str":"%d","text":"tweet %d","created_at":%q,"lang":%q,"source":"test","user":{"id":%d,"id_str":"%d","screen_name":%q},"entities":{%s}}`,
+            id, id, i, now.Add(time.Duration(i-len(users))*time.Minute).Format(time.RubyDate), langs[i], len(users[i]), len(users[i]), users[i], strings.Join(entities, ","))
+        tweet := &twittertypes.Tweet{}
+        err = json.Unmarshal([]byte(line), tweet)
+        if err != nil {
+            t.Fatalf("unmarshalling tweet %d: %s", i, err)
+        }
+        tweet.RawBytes = []byte(line)
+        err = store.SaveTweet(tweet)
+        if err != nil {
+            t.Fatalf("saving tweet %d: %s", i, err)
+        }
+    }
+
+    start, end := now.Add(-time.Hour), now.Add(time.Minute)
+    a := &Analytics{Tweetstore: store, Tweets: store.IntervalTweets(start, end)}
+    if len(a.Tweets) != len(users) {
+        t.Fatalf("loaded %d tweets, want %d", len(a.Tweets), len(users))
+    }
+    for _, c := range []struct {
+        name      string
+        sql       []Count
+        reference func() ([]string, map[string]int)
+    }{
+        {"urls", a.TopUrls(start, end, 100), a.UrlsByFrequency},
+        {"users", a.TopUsers(start, end, 100), a.UsersByPosts},
+        {"hashtags", a.TopHashtags(start, end, 100), a.HashtagsByFrequency},
+        {"mentions", a.TopMentions(start, end, 100), a.MentionsByFrequency},
+        {"langs", a.TopLangs(start, end, 100), a.LangsByFrequency},
+    } {
+        sorted, counts := c.reference()
+        if len(c.sql) != len(sorted) {
+            t.Errorf("%s: store has %d keys %v, reference %d %v", c.name, len(c.sql), c.sql, len(sorted), counts)
+            continue
+        }
+        for i, kc := range c.sql {
+            if counts[kc.Key] != kc.Count {
+                t.Errorf("%s: store counts %q %d times, reference %d", c.name, kc.Key, kc.Count, counts[kc.Key])
+            }
+            //same order up to ties, which the store breaks by key
+            if i > 0 && kc.Count > c.sql[i-1].Count {
+                t.Errorf("%s: %q after a smaller count", c.name, kc.Key)
+            }
+        }
+        //a limit keeps the largest
+        top := c.sql
+        if len(top) > 2 {
+            top = top[:2]
+        }
+        reference := Top(sorted, counts, 2)
+        for i := range top {
+            if top[i].Count != reference[i].Count {
+                t.Errorf("%s: top %d count %d, reference %d", c.name, i, top[i].Count, reference[i].Count)
+            }
+        }
+    }
+}
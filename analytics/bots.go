@@ -0,0 +1,408 @@
+package analytics
+
+import (
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "github.com/fcheslack/webtypes/twitter"
+    "github.com/fcheslack/tweetlog/tweetstore"
+    "io"
+    "math"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+//Bot signals, the keys of BotConfig.Weights
+const (
+    SignalCadence    = "cadence"    //evenly spaced tweets
+    SignalSource     = "source"     //tweets from automation clients
+    SignalDuplicates = "duplicates" //the same text again and again
+    SignalFollowers  = "followers"  //following far more accounts than follow back
+    SignalAge        = "age"        //a new account
+    SignalUrls       = "urls"       //nearly every tweet links out
+)
+
+//AccountScore is how automated an account looks and why, see tweetstore.AccountScore
+type AccountScore = tweetstore.AccountScore
+
+type BotSignal = tweetstore.BotSignal
+
+//BotConfig tunes a BotScorer
+type BotConfig struct {
+    Weights    map[string]float64 //share of each signal in the score
+    Threshold  float64            //accounts scoring above this are treated as automated
+    MinTweets  int                //fewer tweets leave cadence and duplicates unscored
+    MaxHistory int                //tweets remembered per account, the oldest are forgotten
+    NewAccount time.Duration      //accounts this young look fully automated by age,
+    OldAccount time.Duration      //and accounts this old not at all
+    MinFriends int                //the follower ratio only counts once an account follows this many
+}
+
+var DefaultBotConfig = BotConfig{
+    Weights: map[string]float64{
+        SignalCadence:    0.25,
+        SignalSource:     0.2,
+        SignalDuplicates: 0.2,
+        SignalFollowers:  0.15,
+        SignalAge:        0.1,
+        SignalUrls:       0.1,
+    },
+    Threshold:  0.5,
+    MinTweets:  5,
+    MaxHistory: 200,
+    NewAccount: 7 * 24 * time.Hour,
+    OldAccount: 365 * 24 * time.Hour,
+    MinFriends: 100,
+}
+
+//HumanClients are twitter's own apps and the clients people type their tweets into
+var HumanClients = map[string]bool{
+    "Twitter for iPhone":          true,
+    "Twitter for Android":         true,
+    "Twitter for iPad":            true,
+    "Twitter for Android Tablets": true,
+    "Twitter for Mac":             true,
+    "Twitter for Windows":         true,
+    "Twitter Web App":             true,
+    "Twitter Web Client":          true,
+    "Twitter Lite":                true,
+    "Mobile Web (M2)":             true,
+    "TweetDeck":                   true,
+    "Tweetbot for iΟS":            true, //sic, with a greek capital omicron
+    "Tweetbot for Mac":            true,
+    "Twitterrific":                true,
+    "Echofon":                     true,
+}
+
+//AutomationClients post from feeds, schedules and scripts
+var AutomationClients = map[string]bool{
+    "IFTTT":                    true,
+    "dlvr.it":                  true,
+    "twitterfeed":              true,
+    "twittbot.net":             true,
+    "Buffer":                   true,
+    "Hootsuite":                true,
+    "Hootsuite Inc.":           true,
+    "SocialOomph":              true,
+    "Sprout Social":            true,
+    "Zapier.com":               true,
+    "RSS Post":                 true,
+    "Botize":                   true,
+    "TweetAdder":               true,
+    "Cheap Bots, Done Quick!":  true,
+    "Tweet Old Post":           true,
+    "WordPress.com":            true,
+    "Revive Social App":        true,
+    "Twitter Ads Composer":     true,
+    "Twitter for Advertisers.": true,
+}
+
+//activityTweet is what a BotScorer remembers of a tweet
+type activityTweet struct {
+    id     int64
+    at     time.Time
+    text   string //the tweet's terms, so a changed url or mention doesn't hide a repeat
+    source string
+    hasUrl bool
+}
+
+//botProfile is the part of a user snapshot a BotScorer reads
+type botProfile struct {
+    Followers_count int64
+    Friends_count   int64
+    Created_at      string
+}
+
+type accountActivity struct {
+    userId     int64
+    screenName string
+    tweets     []activityTweet
+    profile    *botProfile
+    profileId  int64 //id of the tweet the profile was saved with, the newest wins
+}
+
+//BotScorer rates accounts by their tweets as they are added and their latest profile
+//snapshot. It is safe for concurrent use.
+type BotScorer struct {
+    cfg      BotConfig
+    mu       sync.Mutex
+    accounts map[int64]*accountActivity
+}
+
+func NewBotScorer(cfg BotConfig) *BotScorer {
+    return &BotScorer{cfg: cfg, accounts: make(map[int64]*accountActivity)}
+}
+
+//botTokenizer keeps every word, so only urls, mentions and punctuation are ignored in repeats
+var botTokenizer = &Tokenizer{MinLength: 1, KeepHashtags: true}
+
+func (b *BotScorer) account(userId int64, screenName string) *accountActivity {
+    acc := b.accounts[userId]
+    if acc == nil {
+        acc = &accountActivity{userId: userId}
+        b.accounts[userId] = acc
+    }
+    if screenName != "" {
+        acc.screenName = screenName
+    }
+    return acc
+}
+
+func (acc *accountActivity) setProfile(tweetId int64, object []byte) {
+    if acc.profile != nil && tweetId < acc.profileId {
+        return
+    }
+    var p botProfile
+    if json.Unmarshal(object, &p) == nil {
+        acc.profile = &p
+        acc.profileId = tweetId
+    }
+}
+
+//Add remembers a tweet of its author, and the profile it carries when it is the newest
+//seen. Tweets added again are ignored.
+func (b *BotScorer) Add(tweet *twittertypes.Tweet) {
+    if tweet.User == nil || tweet.Id == nil {
+        return
+    }
+    id := int64(*tweet.Id)
+    created, err := time.Parse(time.RubyDate, tweet.Created_at)
+    if err != nil {
+        created = time.Now()
+    }
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    acc := b.account(tweet.User.Id, tweet.User.Screen_name)
+    for _, t := range acc.tweets {
+        if t.id == id {
+            return
+        }
+    }
+    acc.tweets = append(acc.tweets, activityTweet{
+        id:     id,
+        at:     created,
+        text:   strings.Join(botTokenizer.Terms(tweet.Text), " "),
+        source: tweetstore.SourceName(tweet.Source),
+        hasUrl: len(tweet.Entities.Urls) > 0,
+    })
+    if b.cfg.MaxHistory > 0 && len(acc.tweets) > b.cfg.MaxHistory {
+        sort.Slice(acc.tweets, func(i, j int) bool { return acc.tweets[i].at.Before(acc.tweets[j].at) })
+        acc.tweets = acc.tweets[len(acc.tweets)-b.cfg.MaxHistory:]
+    }
+    if tweet.RawBytes != nil {
+        var src struct {
+            User json.RawMessage
+        }
+        if json.Unmarshal(tweet.RawBytes, &src) == nil && src.User != nil {
+            acc.setProfile(id, src.User)
+        }
+    }
+}
+
+//AddSnapshot gives an account the profile of an archived user snapshot, unless a newer one was added
+func (b *BotScorer) AddSnapshot(s tweetstore.UserSnapshot) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.account(s.UserId, s.ScreenName).setProfile(s.TweetId, s.Object)
+}
+
+//AddFromStore adds the stored tweets created between from and to, and the latest
+//archived snapshot of each of their authors, returning how many tweets were added
+func (b *BotScorer) AddFromStore(store tweetstore.TweetStore, from, to time.Time) (int, error) {
+    q := tweetstore.TweetQuery{From: from, To: to, Limit: tweetstore.MaxQueryLimit}
+    n := 0
+    err := tweetstore.EachTweet(store, q, func(tweet *twittertypes.Tweet) bool {
+        b.Add(tweet)
+        n++
+        return true
+    })
+    if err != nil {
+        return n, err
+    }
+    snapshots, err := store.IntervalUserSnapshots(from, to)
+    if err != nil {
+        return n, err
+    }
+    for _, s := range snapshots {
+        b.AddSnapshot(s)
+    }
+    return n, nil
+}
+
+func clamp01(v float64) float64 {
+    return math.Max(0, math.Min(1, v))
+}
+
+//score rates an account. Signals without enough to go on count as 0, so little
+//activity keeps a score low rather than letting one signal decide it.
+func (b *BotScorer) score(acc *accountActivity) AccountScore {
+    s := AccountScore{UserId: acc.userId, ScreenName: acc.screenName, Tweets: len(acc.tweets), ScoredAt: time.Now().UTC(), Signals: []BotSignal{}}
+    tweets := make([]activityTweet, len(acc.tweets))
+    copy(tweets, acc.tweets)
+    sort.Slice(tweets, func(i, j int) bool { return tweets[i].at.Before(tweets[j].at) })
+    n := len(tweets)
+    add := func(name string, value float64, reason string) {
+        if w := b.cfg.Weights[name]; w > 0 && value > 0 {
+            s.Signals = append(s.Signals, BotSignal{Name: name, Value: value, Weight: w, Reason: reason})
+        }
+    }
+
+    if n >= b.cfg.MinTweets && n > 1 {
+        //people post in bursts, with gaps varying as much as they last; schedules don't
+        var sum, sumSq float64
+        for i := 1; i < n; i++ {
+            gap := tweets[i].at.Sub(tweets[i-1].at).Seconds()
+            sum += gap
+            sumSq += gap * gap
+        }
+        mean := sum / float64(n-1)
+        if mean <= 0 {
+            add(SignalCadence, 1, fmt.Sprintf("posted %d tweets at the same moment", n))
+        } else {
+            cv := math.Sqrt(math.Max(0, sumSq/float64(n-1)-mean*mean)) / mean
+            add(SignalCadence, clamp01(1-cv), fmt.Sprintf("posts every %s on average with little variation (cv %.2f)", time.Duration(mean*float64(time.Second)).Round(time.Second), cv))
+        }
+
+        texts := make(map[string]bool)
+        withText := 0
+        for _, t := range tweets {
+            if t.text != "" {
+                texts[t.text] = true
+                withText++
+            }
+        }
+        if withText > 0 {
+            repeats := withText - len(texts)
+            //half the tweets being repeats is as automated as it gets
+            add(SignalDuplicates, clamp01(2*float64(repeats)/float64(withText)), fmt.Sprintf("%d of %d tweets repeat an earlier text", repeats, withText))
+        }
+    }
+
+    if n > 0 {
+        automated, unknown, urls := 0, 0, 0
+        sources := make(map[string]int)
+        for _, t := range tweets {
+            switch {
+            case AutomationClients[t.source]:
+                automated++
+            case !HumanClients[t.source]:
+                unknown++
+            }
+            sources[t.source]++
+            if t.hasUrl {
+                urls++
+            }
+        }
+        top := topCounts(sources, 1)[0].Key
+        add(SignalSource, (float64(automated)+0.5*float64(unknown))/float64(n),
+            fmt.Sprintf("%.0f%% of tweets from automation or unrecognised clients, mostly %s", 100*float64(automated+unknown)/float64(n), top))
+        share := float64(urls) / float64(n)
+        add(SignalUrls, clamp01(2*share-1), fmt.Sprintf("%.0f%% of tweets link out", 100*share))
+    }
+
+    if p := acc.profile; p != nil {
+        if p.Friends_count >= int64(b.cfg.MinFriends) {
+            //following a hundred times more accounts than follow back is as bad as it gets
+            ratio := float64(p.Friends_count+1) / float64(p.Followers_count+1)
+            add(SignalFollowers, clamp01(math.Log10(ratio)/2), fmt.Sprintf("follows %d accounts and is followed by %d", p.Friends_count, p.Followers_count))
+        }
+        created, err := time.Parse(time.RubyDate, p.Created_at)
+        if err == nil {
+            latest := time.Now()
+            if n > 0 {
+                latest = tweets[n-1].at
+            }
+            age := latest.Sub(created)
+            span := b.cfg.OldAccount - b.cfg.NewAccount
+            if span > 0 {
+                add(SignalAge, clamp01(float64(b.cfg.OldAccount-age)/float64(span)), fmt.Sprintf("account was %d days old at its latest tweet", int(age.Hours()/24)))
+            }
+        }
+    }
+
+    total := 0.0
+    for _, w := range b.cfg.Weights {
+        total += w
+    }
+    for _, sig := range s.Signals {
+        s.Score += sig.Weight * sig.Value
+    }
+    if total > 0 {
+        s.Score /= total
+    }
+    sort.SliceStable(s.Signals, func(i, j int) bool {
+        return s.Signals[i].Weight*s.Signals[i].Value > s.Signals[j].Weight*s.Signals[j].Value
+    })
+    return s
+}
+
+//Score rates one account, false when nothing of it has been added
+func (b *BotScorer) Score(userId int64) (AccountScore, bool) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    acc := b.accounts[userId]
+    if acc == nil {
+        return AccountScore{}, false
+    }
+    return b.score(acc), true
+}
+
+//Automated scores an account and reports whether it is above the threshold
+func (b *BotScorer) Automated(userId int64) (AccountScore, bool) {
+    s, ok := b.Score(userId)
+    return s, ok && s.Score > b.cfg.Threshold
+}
+
+//Scores rates every account that has tweets added, highest score first
+func (b *BotScorer) Scores() []AccountScore {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    scores := make([]AccountScore, 0, len(b.accounts))
+    for _, acc := range b.accounts {
+        if len(acc.tweets) > 0 {
+            scores = append(scores, b.score(acc))
+        }
+    }
+    sort.Slice(scores, func(i, j int) bool {
+        if scores[i].Score != scores[j].Score {
+            return scores[i].Score > scores[j].Score
+        }
+        return scores[i].UserId < scores[j].UserId
+    })
+    return scores
+}
+
+//AccountScores rates the accounts that tweeted between from and to, highest score first
+func (a *Analytics) AccountScores(cfg BotConfig, from, to time.Time) ([]AccountScore, error) {
+    b := NewBotScorer(cfg)
+    _, err := b.AddFromStore(a.Tweetstore, from, to)
+    if err != nil {
+        return nil, err
+    }
+    return b.Scores(), nil
+}
+
+//WriteAccountScoresCSV writes scores as user_id,screen_name,tweets,score,reasons rows,
+//with the reasons of the signals joined by semicolons
+func WriteAccountScoresCSV(w io.Writer, scores []AccountScore) error {
+    cw := csv.NewWriter(w)
+    err := cw.Write([]string{"user_id", "screen_name", "tweets", "score", "reasons"})
+    if err != nil {
+        return err
+    }
+    for _, s := range scores {
+        reasons := make([]string, len(s.Signals))
+        for i, sig := range s.Signals {
+            reasons[i] = sig.Reason
+        }
+        err = cw.Write([]string{strconv.FormatInt(s.UserId, 10), s.ScreenName, strconv.Itoa(s.Tweets), strconv.FormatFloat(s.Score, 'f', 3, 64), strings.Join(reasons, "; ")})
+        if err != nil {
+            return err
+        }
+    }
+    cw.Flush()
+    return cw.Error()
+}
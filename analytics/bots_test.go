@@ -0,0 +1,148 @@
+package analytics
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/fcheslack/tweetlog/tweetstore"
+    "math"
+    "strings"
+    "testing"
+    "time"
+)
+
+//botAccount tweets text(i) from source at each of minutes, after an account created
+//ageDays before its first tweet with friends and followers
+type botAccount struct {
+    name      string
+    source    string
+    minutes   []int
+    text      func(i int) string
+    url       bool
+    friends   int
+    followers int
+    ageDays   int
+}
+
+func (a botAccount) add(t *testing.T, b *BotScorer, userId int64, start time.Time) {
+    for i, m := range a.minutes {
+        id := userId*1000 + int64(i)
+        created := start.Add(time.Duration(m) * time.Minute)
+        urls := `[]`
+        if a.url {
+            urls = fmt.Sprintf(`[{"url":"http://t.co/%d","expanded_url":"http://example.com/%d","display_url":"example.com/%d","indices":[0,0]}]`, id, id, id)
+        }
+        user := fmt.Sprintf(`{"id":%d,"id_str":"%d","screen_name":%q,"friends_count":%d,"followers_count":%d,"created_at":%q}`,
+            userId, userId, a.name, a.friends, a.followers, start.AddDate(0, 0, -a.ageDays).Format(time.RubyDate))
+        source, _ := json.Marshal(`<a href="http://example.com" rel="nofollow">` + a.source + `</a>`)
+        b.Add(jsonTweet(t, fmt.Sprintf(`{"id":%d,"id_str":"%d","text":%q,"source":%s,"created_at":%q,"user":%s,"entities":{"hashtags":[],"urls":%s,"user_mentions":[],"media":[]}}`,
+            id, id, a.text(i), source, created.Format(time.RubyDate), user, urls)))
+    }
+}
+
+func sameText(i int) string {
+    return fmt.Sprintf("New post on the blog @reader%d http://t.co/%d", i, i)
+}
+
+func ownText(i int) string {
+    return fmt.Sprintf("thought number %d of the day", i)
+}
+
+func TestBotScores(t *testing.T) {
+    hourly := []int{0, 60, 120, 180, 240, 300, 360, 420, 480, 540}
+    bursty := []int{0, 1, 2, 120, 121, 600, 601, 602, 2000, 5000}
+    for _, c := range []struct {
+        account   botAccount
+        signals   string //name=value, largest share of the score first
+        automated bool
+    }{
+        {
+            //a repeat with another url and mention is still a repeat
+            botAccount{"feed", "IFTTT", hourly, sameText, true, 2000, 10, 2},
+            "[cadence=1.00 duplicates=1.00 source=1.00 followers=1.00 urls=1.00 age=1.00]", true,
+        },
+        {
+            botAccount{"person", "Twitter for iPhone", bursty, ownText, false, 150, 300, 800},
+            "[]", false,
+        },
+        {
+            //under MinTweets leaves cadence and duplicates unscored
+            botAccount{"quiet", "IFTTT", hourly[:3], sameText, false, 10, 10, 800},
+            "[source=1.00]", false,
+        },
+        {
+            //unrecognised clients count half
+            botAccount{"script", "my script", bursty, ownText, false, 10, 10, 800},
+            "[source=0.50]", false,
+        },
+        {
+            //following a hundred times more accounts than follow back, halfway through its first year
+            botAccount{"follower", "Twitter Web App", bursty, ownText, false, 1000, 9, 182},
+            "[followers=1.00 age=0.50]", false,
+        },
+        {
+            //cycling through four texts, every tweet linking out
+            botAccount{"linker", "Twitter Web App", bursty, func(i int) string { return ownText(i % 4) }, true, 10, 10, 800},
+            "[duplicates=1.00 urls=1.00]", false,
+        },
+    } {
+        b := NewBotScorer(DefaultBotConfig)
+        c.account.add(t, b, 1, time.Now().Add(-100*time.Hour))
+        s, automated := b.Automated(1)
+        var signals []string
+        want := 0.0
+        for _, sig := range s.Signals {
+            signals = append(signals, fmt.Sprintf("%s=%.2f", sig.Name, sig.Value))
+            want += sig.Weight * sig.Value
+            if sig.Reason == "" {
+                t.Errorf("%s: %s signal without a reason", c.account.name, sig.Name)
+            }
+        }
+        if got := "[" + strings.Join(signals, " ") + "]"; got != c.signals {
+            t.Errorf("%s: signals %s, want %s", c.account.name, got, c.signals)
+        }
+        if math.Abs(s.Score-want) > 1e-9 {
+            t.Errorf("%s: score %g, want %g", c.account.name, s.Score, want)
+        }
+        if automated != c.automated {
+            t.Errorf("%s: automated %t with score %.2f, want %t", c.account.name, automated, s.Score, c.automated)
+        }
+        if s.ScreenName != c.account.name || s.Tweets != len(c.account.minutes) {
+            t.Errorf("%s: scored %s with %d tweets", c.account.name, s.ScreenName, s.Tweets)
+        }
+    }
+}
+
+func TestBotScorer(t *testing.T) {
+    b := NewBotScorer(DefaultBotConfig)
+    start := time.Now().Add(-100 * time.Hour)
+    person := botAccount{"person", "Twitter for iPhone", []int{0, 1, 2, 120, 121, 600}, ownText, false, 10, 10, 800}
+    person.add(t, b, 1, start)
+    //tweets added again are ignored
+    person.add(t, b, 1, start)
+    botAccount{"feed", "IFTTT", []int{0, 60, 120, 180, 240, 300}, sameText, true, 10, 10, 800}.add(t, b, 2, start)
+
+    scores := b.Scores()
+    if len(scores) != 2 || scores[0].UserId != 2 || scores[1].UserId != 1 {
+        t.Fatalf("scores %+v, want the feed then the person", scores)
+    }
+    if scores[1].Tweets != 6 {
+        t.Errorf("person scored on %d tweets, want 6", scores[1].Tweets)
+    }
+    if _, ok := b.Score(3); ok {
+        t.Errorf("scored an account with nothing added")
+    }
+
+    //the profile of the newest tweet wins, an older snapshot is ignored
+    profile := func(tweetId int64, friends int) tweetstore.UserSnapshot {
+        object := fmt.Sprintf(`{"id":1,"screen_name":"person","friends_count":%d,"followers_count":0}`, friends)
+        return tweetstore.UserSnapshot{UserId: 1, ScreenName: "person", TweetId: tweetId, Object: []byte(object)}
+    }
+    b.AddSnapshot(profile(1, 5000))
+    if s, _ := b.Score(1); s.Score != scores[1].Score {
+        t.Errorf("an older snapshot changed the score from %g to %g", scores[1].Score, s.Score)
+    }
+    b.AddSnapshot(profile(2000, 5000))
+    if s, _ := b.Score(1); len(s.Signals) == 0 || s.Signals[0].Name != SignalFollowers {
+        t.Errorf("newer snapshot signals %+v, want followers", s.Signals)
+    }
+}
@@ -0,0 +1,127 @@
+package analytics
+
+import (
+    "encoding/csv"
+    "github.com/fcheslack/webtypes/twitter"
+    "github.com/fcheslack/tweetlog/tweetstore"
+    "io"
+    "strconv"
+    "time"
+)
+
+//DefaultClusterLimit is how many clusters a ClusterReport has unless asked otherwise
+const DefaultClusterLimit = 20
+
+//Cluster is a group of near-duplicate tweets, see tweetstore.Cluster
+type Cluster = tweetstore.Cluster
+
+//ClusterQuery asks for the largest clusters of copied text among the tweets created
+//between From and To. Zero values take the defaults.
+type ClusterQuery struct {
+    From     time.Time
+    To       time.Time
+    Interval string //bucket size of each cluster's spread, tweetstore.IntervalHour by default
+    MinSize  int    //fewest tweets of a cluster reported, tweetstore.DefaultClusterSize by default
+    Limit    int    //default DefaultClusterLimit
+    Members  int    //newest member tweets included with each cluster, none if 0
+}
+
+//ClusterSpread is a cluster with its tweets in each bucket of the report and its newest members
+type ClusterSpread struct {
+    Cluster
+    Counts  []int                 `json:"counts"`
+    Members []*twittertypes.Tweet `json:"members,omitempty"`
+}
+
+//ClusterReport is the largest near-duplicate clusters of an interval, largest first, with
+//how they spread over its buckets
+type ClusterReport struct {
+    From     time.Time       `json:"from"`
+    To       time.Time       `json:"to"`
+    Interval string          `json:"interval"`
+    Starts   []time.Time     `json:"starts"`
+    Clusters []ClusterSpread `json:"clusters"`
+}
+
+//Clusters reports the largest clusters of copy-pasted tweets of q's interval, with their
+//counts per bucket and, if q.Members is set, their newest tweets
+func (a *Analytics) Clusters(q ClusterQuery) (*ClusterReport, error) {
+    if q.Interval == "" {
+        q.Interval = tweetstore.IntervalHour
+    }
+    if q.MinSize <= 0 {
+        q.MinSize = tweetstore.DefaultClusterSize
+    }
+    if q.Limit <= 0 {
+        q.Limit = DefaultClusterLimit
+    }
+    clusters, err := a.Tweetstore.IntervalClusters(q.From, q.To, q.MinSize, q.Limit)
+    if err != nil {
+        return nil, err
+    }
+    ids := make([]int64, len(clusters))
+    for i, c := range clusters {
+        ids[i] = c.Id
+    }
+    //an empty list would count every cluster
+    if len(ids) == 0 {
+        ids = append(ids, 0)
+    }
+    series, err := a.Tweetstore.TweetSeries(tweetstore.SeriesQuery{
+        Start:    q.From,
+        End:      q.To,
+        Interval: q.Interval,
+        GroupBy:  tweetstore.GroupCluster,
+        Clusters: ids,
+        Groups:   len(ids),
+    })
+    if err != nil {
+        return nil, err
+    }
+    counts := make(map[string][]int, len(series.Groups))
+    for _, g := range series.Groups {
+        counts[g.Key] = g.Counts
+    }
+
+    r := &ClusterReport{From: q.From.UTC(), To: q.To.UTC(), Interval: q.Interval, Starts: series.Starts, Clusters: make([]ClusterSpread, 0, len(clusters))}
+    for _, c := range clusters {
+        s := ClusterSpread{Cluster: c, Counts: counts[strconv.FormatInt(c.Id, 10)]}
+        if s.Counts == nil {
+            s.Counts = make([]int, len(series.Starts))
+        }
+        if q.Members > 0 {
+            s.Members, err = a.Tweetstore.QueryTweets(tweetstore.TweetQuery{ClusterId: c.Id, From: q.From, To: q.To, Limit: q.Members})
+            if err != nil {
+                return nil, err
+            }
+        }
+        r.Clusters = append(r.Clusters, s)
+    }
+    return r, nil
+}
+
+//WriteClustersCSV writes r as cluster_id,tweets,accounts,first,last,text rows followed
+//by a column of counts for each bucket, headed by its start
+func WriteClustersCSV(w io.Writer, r *ClusterReport) error {
+    cw := csv.NewWriter(w)
+    header := []string{"cluster_id", "tweets", "accounts", "first", "last", "text"}
+    for _, start := range r.Starts {
+        header = append(header, start.Format(time.RFC3339))
+    }
+    err := cw.Write(header)
+    if err != nil {
+        return err
+    }
+    for _, c := range r.Clusters {
+        row := []string{strconv.FormatInt(c.Id, 10), strconv.Itoa(c.Tweets), strconv.Itoa(c.Accounts), c.First.Format(time.RFC3339), c.Last.Format(time.RFC3339), c.Text}
+        for _, n := range c.Counts {
+            row = append(row, strconv.Itoa(n))
+        }
+        err = cw.Write(row)
+        if err != nil {
+            return err
+        }
+    }
+    cw.Flush()
+    return cw.Error()
+}
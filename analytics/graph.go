@@ -0,0 +1,522 @@
+package analytics
+
+import (
+    "encoding/csv"
+    "encoding/xml"
+    "fmt"
+    "github.com/fcheslack/tweetlog/tweetstore"
+    "io"
+    "math"
+    "sort"
+    "strconv"
+    "time"
+)
+
+//Relation types, as recorded in the relations table
+const (
+    RelationMention = "mention"
+    RelationReply   = "reply"
+    RelationRetweet = "retweet"
+    RelationQuote   = "quote"
+)
+
+var RelationTypes = []string{RelationMention, RelationReply, RelationRetweet, RelationQuote}
+
+const (
+    pageRankDamping    = 0.85
+    pageRankIterations = 100
+    pageRankTolerance  = 1e-9
+)
+
+//GraphNode is a user with its metrics. Degrees count distinct neighbours, weights count tweets.
+type GraphNode struct {
+    Id         int64   `json:"id"`
+    ScreenName string  `json:"screen_name"`
+    InDegree   int     `json:"in_degree"`
+    OutDegree  int     `json:"out_degree"`
+    InWeight   int     `json:"in_weight"`
+    OutWeight  int     `json:"out_weight"`
+    PageRank   float64 `json:"pagerank"`
+    Component  int     `json:"component"` //weakly connected component, 0 is the largest
+}
+
+//GraphEdge is every relation from Source to Target, Weight being the total of the per type counts
+type GraphEdge struct {
+    Source   int64 `json:"source"`
+    Target   int64 `json:"target"`
+    Weight   int   `json:"weight"`
+    Mentions int   `json:"mentions"`
+    Replies  int   `json:"replies"`
+    Retweets int   `json:"retweets"`
+    Quotes   int   `json:"quotes"`
+}
+
+//Graph is a weighted, directed who-talks-to-whom graph. Nodes are sorted by PageRank,
+//highest first, and edges by weight.
+type Graph struct {
+    From       time.Time   `json:"from"`
+    To         time.Time   `json:"to"`
+    Nodes      []GraphNode `json:"nodes"`
+    Edges      []GraphEdge `json:"edges"`
+    Components int         `json:"components"`
+}
+
+type graphPair struct {
+    source int64
+    target int64
+}
+
+//BuildGraph turns relation counts into a graph of the relations of the given types, all
+//types when none are given. Users relating to themselves, like replies in their own
+//threads, are left out.
+func BuildGraph(relations []tweetstore.RelationCount, types ...string) *Graph {
+    if len(types) == 0 {
+        types = RelationTypes
+    }
+    include := make(map[string]bool)
+    for _, t := range types {
+        include[t] = true
+    }
+
+    g := &Graph{}
+    index := make(map[int64]int)
+    node := func(id int64, screenName string) int {
+        i, ok := index[id]
+        if !ok {
+            i = len(g.Nodes)
+            index[id] = i
+            g.Nodes = append(g.Nodes, GraphNode{Id: id})
+        }
+        if g.Nodes[i].ScreenName < screenName {
+            g.Nodes[i].ScreenName = screenName
+        }
+        return i
+    }
+    edges := make(map[graphPair]*GraphEdge)
+    for _, r := range relations {
+        if !include[r.Type] || r.SourceUserId == r.TargetUserId {
+            continue
+        }
+        node(r.SourceUserId, r.SourceScreenName)
+        node(r.TargetUserId, r.TargetScreenName)
+        p := graphPair{r.SourceUserId, r.TargetUserId}
+        e := edges[p]
+        if e == nil {
+            e = &GraphEdge{Source: p.source, Target: p.target}
+            edges[p] = e
+        }
+        switch r.Type {
+        case RelationMention:
+            e.Mentions += r.Count
+        case RelationReply:
+            e.Replies += r.Count
+        case RelationRetweet:
+            e.Retweets += r.Count
+        case RelationQuote:
+            e.Quotes += r.Count
+        }
+        e.Weight += r.Count
+    }
+
+    g.Edges = make([]GraphEdge, 0, len(edges))
+    for _, e := range edges {
+        g.Edges = append(g.Edges, *e)
+        s, t := &g.Nodes[index[e.Source]], &g.Nodes[index[e.Target]]
+        s.OutDegree++
+        s.OutWeight += e.Weight
+        t.InDegree++
+        t.InWeight += e.Weight
+    }
+    sort.Slice(g.Edges, func(i, j int) bool {
+        a, b := g.Edges[i], g.Edges[j]
+        if a.Weight != b.Weight {
+            return a.Weight > b.Weight
+        }
+        if a.Source != b.Source {
+            return a.Source < b.Source
+        }
+        return a.Target < b.Target
+    })
+
+    g.pageRank(index)
+    g.components(index)
+    sort.SliceStable(g.Nodes, func(i, j int) bool {
+        if g.Nodes[i].PageRank != g.Nodes[j].PageRank {
+            return g.Nodes[i].PageRank > g.Nodes[j].PageRank
+        }
+        return g.Nodes[i].Id < g.Nodes[j].Id
+    })
+    return g
+}
+
+//pageRank runs weighted PageRank by power iteration. The rank of users who relate to
+//no one is spread evenly over everyone, so the ranks always sum to 1.
+func (g *Graph) pageRank(index map[int64]int) {
+    n := len(g.Nodes)
+    if n == 0 {
+        return
+    }
+    rank := make([]float64, n)
+    next := make([]float64, n)
+    for i := range rank {
+        rank[i] = 1 / float64(n)
+    }
+    for iter := 0; iter < pageRankIterations; iter++ {
+        dangling := 0.0
+        for i := range g.Nodes {
+            if g.Nodes[i].OutWeight == 0 {
+                dangling += rank[i]
+            }
+        }
+        base := (1-pageRankDamping)/float64(n) + pageRankDamping*dangling/float64(n)
+        for i := range next {
+            next[i] = base
+        }
+        for _, e := range g.Edges {
+            s := index[e.Source]
+            next[index[e.Target]] += pageRankDamping * rank[s] * float64(e.Weight) / float64(g.Nodes[s].OutWeight)
+        }
+        delta := 0.0
+        for i := range rank {
+            delta += math.Abs(next[i] - rank[i])
+        }
+        rank, next = next, rank
+        if delta < pageRankTolerance {
+            break
+        }
+    }
+    for i := range g.Nodes {
+        g.Nodes[i].PageRank = rank[i]
+    }
+}
+
+//components labels weakly connected components, numbered from the largest down
+func (g *Graph) components(index map[int64]int) {
+    parent := make([]int, len(g.Nodes))
+    for i := range parent {
+        parent[i] = i
+    }
+    var find func(int) int
+    find = func(i int) int {
+        if parent[i] != i {
+            parent[i] = find(parent[i])
+        }
+        return parent[i]
+    }
+    for _, e := range g.Edges {
+        a, b := find(index[e.Source]), find(index[e.Target])
+        if a != b {
+            parent[a] = b
+        }
+    }
+
+    sizes := make(map[int]int)
+    for i := range g.Nodes {
+        sizes[find(i)]++
+    }
+    roots := make([]int, 0, len(sizes))
+    for root := range sizes {
+        roots = append(roots, root)
+    }
+    //ties go to the component holding the smallest user id, for a stable numbering
+    smallest := make(map[int]int64)
+    for i, n := range g.Nodes {
+        r := find(i)
+        if id, ok := smallest[r]; !ok || n.Id < id {
+            smallest[r] = n.Id
+        }
+    }
+    sort.Slice(roots, func(i, j int) bool {
+        if sizes[roots[i]] != sizes[roots[j]] {
+            return sizes[roots[i]] > sizes[roots[j]]
+        }
+        return smallest[roots[i]] < smallest[roots[j]]
+    })
+    label := make(map[int]int)
+    for i, root := range roots {
+        label[root] = i
+    }
+    for i := range g.Nodes {
+        g.Nodes[i].Component = label[find(i)]
+    }
+    g.Components = len(roots)
+}
+
+//UserGraph builds the graph of relations of the given types made by tweets created between startTime and endTime
+func (a *Analytics) UserGraph(startTime, endTime time.Time, types ...string) (*Graph, error) {
+    relations, err := a.Tweetstore.IntervalRelations(startTime, endTime)
+    if err != nil {
+        return nil, err
+    }
+    g := BuildGraph(relations, types...)
+    g.From = startTime.UTC()
+    g.To = endTime.UTC()
+    return g, nil
+}
+
+//graphAttr names an exported node or edge attribute and its type
+type graphAttr struct {
+    name string
+    typ  string
+}
+
+var graphNodeAttrs = []graphAttr{
+    {"screen_name", "string"},
+    {"in_degree", "int"},
+    {"out_degree", "int"},
+    {"in_weight", "int"},
+    {"out_weight", "int"},
+    {"pagerank", "double"},
+    {"component", "int"},
+}
+
+var graphEdgeAttrs = []graphAttr{
+    {"mentions", "int"},
+    {"replies", "int"},
+    {"retweets", "int"},
+    {"quotes", "int"},
+}
+
+func (n *GraphNode) attrValues() []string {
+    return []string{
+        n.ScreenName,
+        strconv.Itoa(n.InDegree),
+        strconv.Itoa(n.OutDegree),
+        strconv.Itoa(n.InWeight),
+        strconv.Itoa(n.OutWeight),
+        strconv.FormatFloat(n.PageRank, 'g', -1, 64),
+        strconv.Itoa(n.Component),
+    }
+}
+
+func (e *GraphEdge) attrValues() []string {
+    return []string{strconv.Itoa(e.Mentions), strconv.Itoa(e.Replies), strconv.Itoa(e.Retweets), strconv.Itoa(e.Quotes)}
+}
+
+type gexfDoc struct {
+    XMLName xml.Name  `xml:"gexf"`
+    Xmlns   string    `xml:"xmlns,attr"`
+    Version string    `xml:"version,attr"`
+    Meta    gexfMeta  `xml:"meta"`
+    Graph   gexfGraph `xml:"graph"`
+}
+
+type gexfMeta struct {
+    LastModified string `xml:"lastmodifieddate,attr"`
+    Creator      string `xml:"creator"`
+    Description  string `xml:"description"`
+}
+
+type gexfGraph struct {
+    DefaultEdgeType string           `xml:"defaultedgetype,attr"`
+    Mode            string           `xml:"mode,attr"`
+    Attributes      []gexfAttributes `xml:"attributes"`
+    Nodes           []gexfNode       `xml:"nodes>node"`
+    Edges           []gexfEdge       `xml:"edges>edge"`
+}
+
+type gexfAttributes struct {
+    Class      string          `xml:"class,attr"`
+    Attributes []gexfAttribute `xml:"attribute"`
+}
+
+type gexfAttribute struct {
+    Id    string `xml:"id,attr"`
+    Title string `xml:"title,attr"`
+    Type  string `xml:"type,attr"`
+}
+
+type gexfValue struct {
+    For   string `xml:"for,attr"`
+    Value string `xml:"value,attr"`
+}
+
+type gexfNode struct {
+    Id     string      `xml:"id,attr"`
+    Label  string      `xml:"label,attr"`
+    Values []gexfValue `xml:"attvalues>attvalue"`
+}
+
+type gexfEdge struct {
+    Id     string      `xml:"id,attr"`
+    Source string      `xml:"source,attr"`
+    Target string      `xml:"target,attr"`
+    Weight int         `xml:"weight,attr"`
+    Values []gexfValue `xml:"attvalues>attvalue"`
+}
+
+func gexfAttrs(class string, attrs []graphAttr) gexfAttributes {
+    ga := gexfAttributes{Class: class}
+    for _, a := range attrs {
+        typ := a.typ
+        if typ == "int" {
+            typ = "integer"
+        }
+        ga.Attributes = append(ga.Attributes, gexfAttribute{Id: a.name, Title: a.name, Type: typ})
+    }
+    return ga
+}
+
+func gexfValues(attrs []graphAttr, values []string) []gexfValue {
+    gv := make([]gexfValue, len(attrs))
+    for i, a := range attrs {
+        gv[i] = gexfValue{For: a.name, Value: values[i]}
+    }
+    return gv
+}
+
+//WriteGEXF writes g as GEXF 1.3, the native format of Gephi
+func (g *Graph) WriteGEXF(w io.Writer) error {
+    doc := gexfDoc{
+        Xmlns:   "http://gexf.net/1.3",
+        Version: "1.3",
+        Meta: gexfMeta{
+            LastModified: time.Now().UTC().Format("2006-01-02"),
+            Creator:      "tweetlog",
+            Description:  fmt.Sprintf("user relations from %s to %s", g.From.Format(time.RFC3339), g.To.Format(time.RFC3339)),
+        },
+        Graph: gexfGraph{
+            DefaultEdgeType: "directed",
+            Mode:            "static",
+            Attributes:      []gexfAttributes{gexfAttrs("node", graphNodeAttrs), gexfAttrs("edge", graphEdgeAttrs)},
+        },
+    }
+    for i := range g.Nodes {
+        n := &g.Nodes[i]
+        doc.Graph.Nodes = append(doc.Graph.Nodes, gexfNode{
+            Id:     strconv.FormatInt(n.Id, 10),
+            Label:  n.ScreenName,
+            Values: gexfValues(graphNodeAttrs, n.attrValues()),
+        })
+    }
+    for i := range g.Edges {
+        e := &g.Edges[i]
+        doc.Graph.Edges = append(doc.Graph.Edges, gexfEdge{
+            Id:     strconv.Itoa(i),
+            Source: strconv.FormatInt(e.Source, 10),
+            Target: strconv.FormatInt(e.Target, 10),
+            Weight: e.Weight,
+            Values: gexfValues(graphEdgeAttrs, e.attrValues()),
+        })
+    }
+    return writeXML(w, doc)
+}
+
+type graphmlDoc struct {
+    XMLName xml.Name     `xml:"graphml"`
+    Xmlns   string       `xml:"xmlns,attr"`
+    Keys    []graphmlKey `xml:"key"`
+    Graph   graphmlGraph `xml:"graph"`
+}
+
+type graphmlKey struct {
+    Id   string `xml:"id,attr"`
+    For  string `xml:"for,attr"`
+    Name string `xml:"attr.name,attr"`
+    Type string `xml:"attr.type,attr"`
+}
+
+type graphmlGraph struct {
+    Id          string        `xml:"id,attr"`
+    EdgeDefault string        `xml:"edgedefault,attr"`
+    Nodes       []graphmlNode `xml:"node"`
+    Edges       []graphmlEdge `xml:"edge"`
+}
+
+type graphmlData struct {
+    Key   string `xml:"key,attr"`
+    Value string `xml:",chardata"`
+}
+
+type graphmlNode struct {
+    Id   string        `xml:"id,attr"`
+    Data []graphmlData `xml:"data"`
+}
+
+type graphmlEdge struct {
+    Source string        `xml:"source,attr"`
+    Target string        `xml:"target,attr"`
+    Data   []graphmlData `xml:"data"`
+}
+
+func graphmlValues(attrs []graphAttr, values []string) []graphmlData {
+    gd := make([]graphmlData, len(attrs))
+    for i, a := range attrs {
+        gd[i] = graphmlData{Key: a.name, Value: values[i]}
+    }
+    return gd
+}
+
+//WriteGraphML writes g as GraphML, with a weight key on edges
+func (g *Graph) WriteGraphML(w io.Writer) error {
+    doc := graphmlDoc{
+        Xmlns: "http://graphml.graphdrawing.org/xmlns",
+        Graph: graphmlGraph{Id: "relations", EdgeDefault: "directed"},
+    }
+    for _, a := range graphNodeAttrs {
+        doc.Keys = append(doc.Keys, graphmlKey{Id: a.name, For: "node", Name: a.name, Type: a.typ})
+    }
+    doc.Keys = append(doc.Keys, graphmlKey{Id: "weight", For: "edge", Name: "weight", Type: "int"})
+    for _, a := range graphEdgeAttrs {
+        doc.Keys = append(doc.Keys, graphmlKey{Id: a.name, For: "edge", Name: a.name, Type: a.typ})
+    }
+    for i := range g.Nodes {
+        n := &g.Nodes[i]
+        doc.Graph.Nodes = append(doc.Graph.Nodes, graphmlNode{
+            Id:   strconv.FormatInt(n.Id, 10),
+            Data: graphmlValues(graphNodeAttrs, n.attrValues()),
+        })
+    }
+    for i := range g.Edges {
+        e := &g.Edges[i]
+        data := append([]graphmlData{{Key: "weight", Value: strconv.Itoa(e.Weight)}}, graphmlValues(graphEdgeAttrs, e.attrValues())...)
+        doc.Graph.Edges = append(doc.Graph.Edges, graphmlEdge{
+            Source: strconv.FormatInt(e.Source, 10),
+            Target: strconv.FormatInt(e.Target, 10),
+            Data:   data,
+        })
+    }
+    return writeXML(w, doc)
+}
+
+func writeXML(w io.Writer, doc interface{}) error {
+    _, err := io.WriteString(w, xml.Header)
+    if err != nil {
+        return err
+    }
+    enc := xml.NewEncoder(w)
+    enc.Indent("", "  ")
+    err = enc.Encode(doc)
+    if err != nil {
+        return err
+    }
+    _, err = io.WriteString(w, "\n")
+    return err
+}
+
+//WriteEdgeCSV writes g as an edge list with Gephi's Source, Target, Weight columns first,
+//screen names standing for the user ids when known
+func (g *Graph) WriteEdgeCSV(w io.Writer) error {
+    names := make(map[int64]string)
+    for _, n := range g.Nodes {
+        names[n.Id] = n.ScreenName
+        if n.ScreenName == "" {
+            names[n.Id] = strconv.FormatInt(n.Id, 10)
+        }
+    }
+    cw := csv.NewWriter(w)
+    err := cw.Write([]string{"Source", "Target", "Weight", "Type", "source_id", "target_id", "mentions", "replies", "retweets", "quotes"})
+    if err != nil {
+        return err
+    }
+    for i := range g.Edges {
+        e := &g.Edges[i]
+        row := []string{names[e.Source], names[e.Target], strconv.Itoa(e.Weight), "Directed", strconv.FormatInt(e.Source, 10), strconv.FormatInt(e.Target, 10)}
+        err = cw.Write(append(row, e.attrValues()...))
+        if err != nil {
+            return err
+        }
+    }
+    cw.Flush()
+    return cw.Error()
+}
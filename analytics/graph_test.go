@@ -0,0 +1,116 @@
+package analytics
+
+import (
+    "github.com/fcheslack/tweetlog/tweetstore"
+    "math"
+    "reflect"
+    "testing"
+)
+
+//graphRelations is a small fixed corpus: alice, bob and carol talk among themselves,
+//dave mentions erin, and bob and frank reply in their own threads
+var graphRelations = []tweetstore.RelationCount{
+    {SourceUserId: 1, SourceScreenName: "alice", TargetUserId: 2, TargetScreenName: "bob", Type: RelationMention, Count: 3},
+    {SourceUserId: 1, SourceScreenName: "alice", TargetUserId: 2, TargetScreenName: "bob", Type: RelationReply, Count: 2},
+    {SourceUserId: 3, SourceScreenName: "carol", TargetUserId: 2, TargetScreenName: "bob", Type: RelationRetweet, Count: 4},
+    {SourceUserId: 2, SourceScreenName: "bob", TargetUserId: 1, TargetScreenName: "alice", Type: RelationQuote, Count: 1},
+    {SourceUserId: 2, SourceScreenName: "bob", TargetUserId: 2, TargetScreenName: "bob", Type: RelationReply, Count: 5},
+    {SourceUserId: 4, SourceScreenName: "dave", TargetUserId: 5, TargetScreenName: "erin", Type: RelationMention, Count: 1},
+    {SourceUserId: 6, SourceScreenName: "frank", TargetUserId: 6, TargetScreenName: "frank", Type: RelationReply, Count: 2},
+    //a screen name changed within the window, the greatest one stands for the user
+    {SourceUserId: 4, SourceScreenName: "dave_old", TargetUserId: 5, TargetScreenName: "erin", Type: RelationMention, Count: 1},
+}
+
+func TestBuildGraph(t *testing.T) {
+    for _, c := range []struct {
+        name       string
+        types      []string
+        nodes      []GraphNode //in PageRank order, PageRank itself left out
+        edges      []GraphEdge
+        components int
+    }{
+        {
+            "all types", nil,
+            []GraphNode{
+                {Id: 2, ScreenName: "bob", InDegree: 2, OutDegree: 1, InWeight: 9, OutWeight: 1, Component: 0},
+                {Id: 1, ScreenName: "alice", InDegree: 1, OutDegree: 1, InWeight: 1, OutWeight: 5, Component: 0},
+                {Id: 5, ScreenName: "erin", InDegree: 1, OutDegree: 0, InWeight: 2, OutWeight: 0, Component: 1},
+                {Id: 3, ScreenName: "carol", InDegree: 0, OutDegree: 1, InWeight: 0, OutWeight: 4, Component: 0},
+                {Id: 4, ScreenName: "dave_old", InDegree: 0, OutDegree: 1, InWeight: 0, OutWeight: 2, Component: 1},
+            },
+            []GraphEdge{
+                {Source: 1, Target: 2, Weight: 5, Mentions: 3, Replies: 2},
+                {Source: 3, Target: 2, Weight: 4, Retweets: 4},
+                {Source: 4, Target: 5, Weight: 2, Mentions: 2},
+                {Source: 2, Target: 1, Weight: 1, Quotes: 1},
+            },
+            2,
+        },
+        {
+            //two components of two users each, numbered from the smallest user id
+            "mentions", []string{RelationMention},
+            []GraphNode{
+                {Id: 2, ScreenName: "bob", InDegree: 1, InWeight: 3, Component: 0},
+                {Id: 5, ScreenName: "erin", InDegree: 1, InWeight: 2, Component: 1},
+                {Id: 1, ScreenName: "alice", OutDegree: 1, OutWeight: 3, Component: 0},
+                {Id: 4, ScreenName: "dave_old", OutDegree: 1, OutWeight: 2, Component: 1},
+            },
+            []GraphEdge{
+                {Source: 1, Target: 2, Weight: 3, Mentions: 3},
+                {Source: 4, Target: 5, Weight: 2, Mentions: 2},
+            },
+            2,
+        },
+        {
+            //self replies leave bob and frank out
+            "replies", []string{RelationReply},
+            []GraphNode{
+                {Id: 2, ScreenName: "bob", InDegree: 1, InWeight: 2},
+                {Id: 1, ScreenName: "alice", OutDegree: 1, OutWeight: 2},
+            },
+            []GraphEdge{{Source: 1, Target: 2, Weight: 2, Replies: 2}},
+            1,
+        },
+        {
+            "retweets and quotes", []string{RelationRetweet, RelationQuote},
+            []GraphNode{
+                {Id: 1, ScreenName: "alice", InDegree: 1, InWeight: 1},
+                {Id: 2, ScreenName: "bob", InDegree: 1, OutDegree: 1, InWeight: 4, OutWeight: 1},
+                {Id: 3, ScreenName: "carol", OutDegree: 1, OutWeight: 4},
+            },
+            []GraphEdge{
+                {Source: 3, Target: 2, Weight: 4, Retweets: 4},
+                {Source: 2, Target: 1, Weight: 1, Quotes: 1},
+            },
+            1,
+        },
+        {"no relations of the type", []string{"unknown"}, nil, []GraphEdge{}, 0},
+    } {
+        g := BuildGraph(graphRelations, c.types...)
+        if !reflect.DeepEqual(g.Edges, c.edges) {
+            t.Errorf("%s: edges %+v, want %+v", c.name, g.Edges, c.edges)
+        }
+        if g.Components != c.components {
+            t.Errorf("%s: %d components, want %d", c.name, g.Components, c.components)
+        }
+        nodes := make([]GraphNode, len(g.Nodes))
+        sum := 0.0
+        for i, n := range g.Nodes {
+            sum += n.PageRank
+            if i > 0 && n.PageRank > g.Nodes[i-1].PageRank {
+                t.Errorf("%s: node %d ranks %g, above the %g of the node before it", c.name, n.Id, n.PageRank, g.Nodes[i-1].PageRank)
+            }
+            n.PageRank = 0
+            nodes[i] = n
+        }
+        if len(c.nodes) == 0 && len(nodes) == 0 {
+            continue
+        }
+        if !reflect.DeepEqual(nodes, c.nodes) {
+            t.Errorf("%s: nodes %+v, want %+v", c.name, nodes, c.nodes)
+        }
+        if math.Abs(sum-1) > 1e-6 {
+            t.Errorf("%s: PageRank sums to %g, want 1", c.name, sum)
+        }
+    }
+}
@@ -0,0 +1,300 @@
+package analytics
+
+//bundledLexicon is the word, emoticon and emoji valences of the bundled sentiment scorer,
+//a selection of common words rated on VADER's -4 to 4 scale, one "word\tvalence" per line
+const bundledLexicon = `abandon	-1.9
+abandoned	-2.0
+abuse	-3.2
+abused	-2.3
+accept	1.6
+accepted	1.1
+accident	-2.1
+admire	2.1
+adorable	2.2
+afraid	-2.2
+aggressive	-0.6
+agree	1.5
+alarming	-1.6
+amazed	2.2
+amazing	2.8
+angry	-2.3
+annoyed	-1.6
+annoying	-1.7
+anxious	-1.0
+appreciate	2.1
+approve	1.8
+attack	-2.1
+awesome	3.1
+awful	-2.0
+awkward	-0.6
+bad	-2.5
+beautiful	2.9
+best	3.2
+betray	-3.2
+better	1.9
+bitter	-1.8
+blame	-1.4
+bless	1.8
+blessed	2.9
+bored	-1.1
+boring	-1.3
+brave	2.4
+brilliant	2.8
+broken	-2.1
+bullshit	-2.8
+calm	1.3
+care	2.2
+celebrate	2.7
+chaos	-2.7
+charming	2.8
+cheer	2.3
+cheerful	2.5
+clean	1.7
+clever	2.0
+comfort	1.5
+confused	-1.3
+congrats	2.4
+congratulations	2.9
+cool	1.3
+corrupt	-3.0
+crap	-1.6
+crash	-1.7
+crazy	-1.4
+crime	-2.5
+crisis	-3.1
+cruel	-2.8
+cry	-2.1
+cute	2.0
+damage	-2.2
+damn	-1.7
+danger	-2.4
+dangerous	-2.1
+dead	-3.3
+death	-2.9
+delight	2.9
+delighted	2.4
+depressed	-2.3
+depressing	-1.6
+destroy	-2.5
+destroyed	-3.4
+disappointed	-1.9
+disappointing	-2.2
+disaster	-3.1
+disgusting	-2.4
+dislike	-1.6
+dumb	-2.3
+eager	1.5
+easy	1.9
+enjoy	2.2
+enjoyed	2.3
+evil	-3.4
+excellent	2.7
+excited	1.4
+exciting	2.2
+fail	-2.5
+failed	-2.3
+failure	-2.3
+fair	1.3
+fake	-2.1
+fantastic	2.6
+fear	-2.2
+fine	0.8
+fix	1.2
+fool	-1.9
+fraud	-2.8
+free	2.3
+fresh	1.3
+friend	2.2
+friendly	2.2
+fun	2.3
+funny	1.9
+furious	-2.7
+glad	2.0
+good	1.9
+gorgeous	3.0
+grateful	2.0
+great	3.1
+greed	-1.7
+grief	-2.2
+gross	-2.1
+guilty	-1.8
+happy	2.7
+harm	-2.5
+hate	-2.7
+hated	-3.2
+hero	2.6
+hilarious	1.7
+honest	2.3
+hope	1.9
+hopeful	1.6
+horrible	-2.5
+horrific	-3.4
+hurt	-2.4
+idiot	-2.3
+ignore	-1.5
+ill	-1.8
+impressed	2.1
+impressive	2.3
+improve	1.9
+incredible	2.8
+injured	-1.7
+inspiring	2.2
+interesting	1.7
+jealous	-2.0
+joke	1.2
+joy	2.8
+kill	-3.7
+killed	-3.5
+kind	2.4
+laugh	2.6
+lazy	-1.5
+liar	-2.7
+lie	-1.4
+like	2.0
+lol	1.8
+lonely	-1.5
+lose	-1.3
+loser	-2.4
+loss	-1.3
+lost	-1.3
+love	3.2
+loved	2.9
+lovely	2.8
+loving	2.9
+luck	2.0
+lucky	1.8
+mad	-2.2
+mess	-1.5
+miss	-0.6
+mistake	-1.4
+nasty	-2.6
+nice	1.8
+no	-1.2
+offended	-1.4
+ok	1.2
+okay	0.9
+outrage	-2.3
+pain	-2.3
+panic	-2.3
+party	1.7
+peace	2.5
+perfect	2.7
+pity	-1.2
+play	1.4
+pleased	1.9
+pretty	2.2
+problem	-1.7
+protect	1.3
+proud	2.1
+racist	-3.1
+rage	-2.6
+recommend	1.5
+relief	1.3
+respect	2.1
+rich	2.6
+ridiculous	-1.5
+rofl	2.7
+rude	-2.0
+sad	-2.1
+safe	1.9
+scam	-2.7
+scandal	-1.9
+scared	-1.9
+scary	-2.2
+shame	-2.1
+shit	-2.6
+shock	-1.6
+sick	-2.3
+silly	0.1
+smart	1.7
+smile	1.5
+sorry	-0.3
+special	1.7
+strong	2.3
+stupid	-2.4
+success	2.7
+suck	-1.9
+sucks	-1.5
+suffer	-2.5
+super	2.9
+support	1.7
+sweet	2.0
+terrible	-2.1
+terror	-3.0
+thank	1.5
+thanks	1.9
+threat	-2.4
+tired	-1.9
+tragedy	-3.4
+tragic	-3.2
+trouble	-1.7
+trust	2.3
+ugly	-2.3
+unfair	-2.1
+unhappy	-1.8
+upset	-1.6
+useful	1.9
+useless	-1.8
+victory	2.3
+violence	-3.1
+want	0.3
+war	-2.9
+warm	0.9
+weak	-1.9
+welcome	2.0
+win	2.8
+winner	2.8
+wonderful	2.7
+worried	-1.2
+worry	-1.9
+worse	-2.1
+worst	-3.1
+wow	2.8
+wrong	-2.1
+yay	2.4
+yes	1.7
+:)	2.0
+:-)	1.3
+:(	-1.9
+:-(	-1.5
+:d	2.3
+;)	0.9
+;-)	1.0
+:p	1.4
+:/	-1.4
+:'(	-2.2
+<3	1.9
+xd	1.5
+😂	1.9
+🤣	2.0
+😊	2.2
+🙂	1.0
+😀	2.0
+😁	2.0
+😍	2.8
+🥰	2.8
+😘	2.3
+❤	2.7
+💕	2.5
+👍	1.8
+👏	1.9
+🎉	2.3
+🙏	1.5
+🔥	1.2
+💪	1.5
+✨	1.2
+😢	-2.0
+😭	-1.8
+😞	-2.0
+😔	-1.8
+😡	-2.8
+😠	-2.5
+🤬	-3.0
+💔	-2.5
+👎	-1.8
+😱	-1.8
+🤮	-2.5
+😤	-1.5
+🙄	-1.2
+😒	-1.4
+`
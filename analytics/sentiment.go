@@ -0,0 +1,387 @@
+package analytics
+
+import (
+    "bufio"
+    "fmt"
+    "github.com/fcheslack/webtypes/twitter"
+    "github.com/fcheslack/tweetlog/tweetstore"
+    "html"
+    "io"
+    "math"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    "unicode"
+)
+
+//Sentiment is the tone of a text, see tweetstore.Sentiment
+type Sentiment = tweetstore.Sentiment
+
+//SentimentScorer rates the tone of a text. Scores are stored with the scorer's Name,
+//so a scorer whose results change should change its name too.
+type SentimentScorer interface {
+    Name() string
+    Score(text string) Sentiment
+}
+
+var (
+    scorersMu sync.Mutex
+    scorers   = make(map[string]SentimentScorer)
+)
+
+//RegisterSentimentScorer makes a scorer available to SentimentScorerByName
+func RegisterSentimentScorer(s SentimentScorer) {
+    scorersMu.Lock()
+    defer scorersMu.Unlock()
+    if _, dup := scorers[s.Name()]; dup {
+        panic("analytics: RegisterSentimentScorer called twice for " + s.Name())
+    }
+    scorers[s.Name()] = s
+}
+
+//SentimentScorers returns the sorted names of the registered scorers
+func SentimentScorers() []string {
+    scorersMu.Lock()
+    defer scorersMu.Unlock()
+    names := make([]string, 0, len(scorers))
+    for name := range scorers {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+//SentimentScorerByName returns the registered scorer called name
+func SentimentScorerByName(name string) (SentimentScorer, error) {
+    scorersMu.Lock()
+    s, ok := scorers[name]
+    scorersMu.Unlock()
+    if !ok {
+        return nil, fmt.Errorf("unknown sentiment scorer %q (registered: %s)", name, strings.Join(SentimentScorers(), ", "))
+    }
+    return s, nil
+}
+
+//DefaultSentimentScorer is the bundled lexicon scorer, registered as "vader"
+var DefaultSentimentScorer = NewLexiconScorer("vader")
+
+func init() {
+    RegisterSentimentScorer(DefaultSentimentScorer)
+}
+
+//ScoreTweet rates a tweet's text with scorer
+func ScoreTweet(scorer SentimentScorer, tweet *twittertypes.Tweet) tweetstore.TweetSentiment {
+    s := tweetstore.TweetSentiment{Scorer: scorer.Name(), ScoredAt: time.Now().UTC(), Sentiment: scorer.Score(tweet.Text)}
+    if tweet.Id != nil {
+        s.TweetId = int64(*tweet.Id)
+    }
+    return s
+}
+
+//SummarizeSentiment scores tweets with scorer and summarises them
+func SummarizeSentiment(scorer SentimentScorer, tweets []*twittertypes.Tweet) tweetstore.SentimentSummary {
+    var s tweetstore.SentimentSummary
+    for _, tweet := range tweets {
+        c := scorer.Score(tweet.Text).Compound
+        s.Scored++
+        s.Mean += c
+        switch {
+        case c >= tweetstore.SentimentThreshold:
+            s.Positive++
+        case c <= -tweetstore.SentimentThreshold:
+            s.Negative++
+        default:
+            s.Neutral++
+        }
+    }
+    if s.Scored > 0 {
+        s.Mean /= float64(s.Scored)
+    }
+    return s
+}
+
+//ScoreStoredTweets scores the stored tweets created between from and to, saving
+//chunk of them per transaction, and returns how many were scored
+func ScoreStoredTweets(store tweetstore.TweetStore, scorer SentimentScorer, from, to time.Time, chunk int) (int, error) {
+    if chunk <= 0 {
+        chunk = 1000
+    }
+    q := tweetstore.TweetQuery{From: from, To: to, Limit: tweetstore.MaxQueryLimit}
+    n := 0
+    var saveErr error
+    inTx := false
+    err := tweetstore.EachTweet(store, q, func(tweet *twittertypes.Tweet) bool {
+        if !inTx {
+            saveErr = store.BeginTransaction()
+            if saveErr != nil {
+                return false
+            }
+            inTx = true
+        }
+        saveErr = store.SaveSentiment(ScoreTweet(scorer, tweet))
+        if saveErr != nil {
+            return false
+        }
+        n++
+        if n%chunk == 0 {
+            inTx = false
+            saveErr = store.CommitTransaction()
+            return saveErr == nil
+        }
+        return true
+    })
+    if inTx {
+        if saveErr != nil || err != nil {
+            store.RollbackTransaction()
+        } else {
+            saveErr = store.CommitTransaction()
+        }
+    }
+    if err != nil {
+        return n, err
+    }
+    return n, saveErr
+}
+
+//VADER's constants, from Hutto and Gilbert's rule-based model
+const (
+    vaderBoost       = 0.293 //added by a booster word like very
+    vaderCapsBoost   = 0.733 //added when a word is shouted in otherwise lower case text
+    vaderNegation    = -0.74 //scales a word after a negation
+    vaderAlpha       = 15    //normalizes the sum of valences into -1..1
+    vaderExclamation = 0.292 //per !, up to 4
+    vaderQuestion    = 0.18  //per ? when there are several, up to 0.96
+)
+
+//LexiconScorer is a VADER-style scorer: words and emoji carry a valence from -4 to 4,
+//adjusted for boosters, negations, "but", shouting and exclamation marks. It runs
+//fully offline on its bundled lexicon, which LoadLexicon can extend or replace.
+type LexiconScorer struct {
+    name    string
+    Lexicon map[string]float64
+}
+
+//NewLexiconScorer makes a scorer called name with the bundled lexicon
+func NewLexiconScorer(name string) *LexiconScorer {
+    ls := &LexiconScorer{name: name, Lexicon: make(map[string]float64)}
+    ls.LoadLexicon(strings.NewReader(bundledLexicon))
+    return ls
+}
+
+func (ls *LexiconScorer) Name() string {
+    return ls.name
+}
+
+//LoadLexicon adds the words of r to the lexicon, replacing valences already there.
+//Lines are a word, whitespace and a valence, as in VADER's vader_lexicon.txt, whose
+//further columns are ignored.
+func (ls *LexiconScorer) LoadLexicon(r io.Reader) error {
+    scanner := bufio.NewScanner(r)
+    for scanner.Scan() {
+        fields := strings.Fields(scanner.Text())
+        if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+            continue
+        }
+        v, err := strconv.ParseFloat(fields[1], 64)
+        if err != nil {
+            return fmt.Errorf("invalid valence for %q: %s", fields[0], err)
+        }
+        ls.Lexicon[Normalize(fields[0])] = v
+    }
+    return scanner.Err()
+}
+
+var vaderNegations = wordSet(`aint arent cannot cant couldnt darent didnt doesnt ain't aren't can't couldn't
+    daren't didn't doesn't dont hadnt hasnt havent isnt mightnt mustnt neither don't hadn't hasn't haven't isn't
+    mightn't mustn't neednt needn't never none nope nor not nothing nowhere oughtnt shant shouldnt wasnt werent
+    oughtn't shan't shouldn't wasn't weren't without wont wouldnt won't wouldn't rarely seldom despite`)
+
+var vaderBoosters = wordSet(`absolutely amazingly awfully completely considerably decidedly deeply effing enormously
+    entirely especially exceptionally extremely fabulously flipping flippin fricking frickin frigging friggin fully
+    fucking greatly hella highly hugely incredibly intensely majorly more most particularly purely quite really
+    remarkably so substantially thoroughly totally tremendously uber unbelievably unusually utterly very`)
+
+var vaderDampeners = wordSet(`almost barely hardly kinda kindof less little marginally occasionally partly
+    scarcely slightly somewhat sorta sortof`)
+
+func wordSet(words string) map[string]bool {
+    set := make(map[string]bool)
+    for _, w := range strings.Fields(words) {
+        set[w] = true
+    }
+    return set
+}
+
+//sentimentWord is a token of the text with its case kept, for shouting
+type sentimentWord struct {
+    text  string
+    lower string
+}
+
+//sentimentWords splits text on spaces, drops urls, mentions and a retweet prefix and
+//trims punctuation, except from emoticons in the lexicon. Emoji are words of their own.
+func (ls *LexiconScorer) sentimentWords(text string) []sentimentWord {
+    text = retweetPrefix.ReplaceAllString(html.UnescapeString(text), "")
+    var words []sentimentWord
+    add := func(w string) {
+        lower := Normalize(w)
+        if _, ok := ls.Lexicon[lower]; !ok {
+            lower = strings.TrimFunc(lower, unicode.IsPunct)
+            w = strings.TrimFunc(w, unicode.IsPunct)
+        }
+        rs := []rune(lower)
+        if len(rs) > 1 || len(rs) == 1 && isEmoji(rs[0]) {
+            words = append(words, sentimentWord{text: w, lower: lower})
+        }
+    }
+    for _, field := range strings.Fields(text) {
+        if strings.HasPrefix(field, "@") || strings.Contains(field, "://") {
+            continue
+        }
+        start := 0
+        rs := []rune(field)
+        for i, r := range rs {
+            if isEmoji(r) {
+                if i > start {
+                    add(string(rs[start:i]))
+                }
+                add(string(r))
+                start = i + 1
+            }
+        }
+        if start < len(rs) {
+            add(string(rs[start:]))
+        }
+    }
+    return words
+}
+
+func isShouted(w string) bool {
+    hasUpper := false
+    for _, r := range w {
+        if unicode.IsLower(r) {
+            return false
+        }
+        if unicode.IsUpper(r) {
+            hasUpper = true
+        }
+    }
+    return hasUpper
+}
+
+func isNegation(w string) bool {
+    return vaderNegations[w] || strings.Contains(w, "n't")
+}
+
+//Score rates text as VADER does, with the same normalisation of the valence sum
+func (ls *LexiconScorer) Score(text string) Sentiment {
+    words := ls.sentimentWords(text)
+    shouted := 0
+    for _, w := range words {
+        if isShouted(w.text) {
+            shouted++
+        }
+    }
+    //shouting only stands out when not everything is shouted
+    capsDiff := shouted > 0 && shouted < len(words)
+
+    valences := make([]float64, len(words))
+    for i, w := range words {
+        v, ok := ls.Lexicon[w.lower]
+        if !ok || vaderBoosters[w.lower] || vaderDampeners[w.lower] {
+            continue
+        }
+        if capsDiff && isShouted(w.text) {
+            v += math.Copysign(vaderCapsBoost, v)
+        }
+        //the three words before can boost, dampen or negate it, less the further they are
+        for back := 1; back <= 3 && i-back >= 0; back++ {
+            prev := words[i-back]
+            if _, inLexicon := ls.Lexicon[prev.lower]; inLexicon {
+                continue
+            }
+            scalar := 0.0
+            if vaderBoosters[prev.lower] {
+                scalar = vaderBoost
+            } else if vaderDampeners[prev.lower] {
+                scalar = -vaderBoost
+            }
+            if scalar != 0 && capsDiff && isShouted(prev.text) {
+                scalar += vaderCapsBoost * math.Copysign(1, scalar)
+            }
+            if v < 0 {
+                scalar = -scalar
+            }
+            if back == 2 {
+                scalar *= 0.95
+            } else if back == 3 {
+                scalar *= 0.9
+            }
+            v += scalar
+            if isNegation(prev.lower) {
+                v *= vaderNegation
+            }
+        }
+        valences[i] = v
+    }
+
+    //what follows a "but" outweighs what comes before it
+    for i, w := range words {
+        if w.lower != "but" {
+            continue
+        }
+        for j := range valences {
+            if j < i {
+                valences[j] *= 0.5
+            } else if j > i {
+                valences[j] *= 1.5
+            }
+        }
+        break
+    }
+
+    emphasis := math.Min(float64(strings.Count(text, "!")), 4) * vaderExclamation
+    if q := strings.Count(text, "?"); q > 1 {
+        emphasis += math.Min(float64(q)*vaderQuestion, 0.96)
+    }
+
+    sum := 0.0
+    var pos, neg float64
+    neu := 0
+    for _, v := range valences {
+        sum += v
+        switch {
+        case v > 0:
+            pos += v + 1
+        case v < 0:
+            neg += v - 1
+        default:
+            neu++
+        }
+    }
+    var s Sentiment
+    if sum == 0 {
+        if len(words) > 0 {
+            s.Neutral = 1
+        }
+        return s
+    }
+    if sum > 0 {
+        sum += emphasis
+    } else {
+        sum -= emphasis
+    }
+    s.Compound = math.Max(-1, math.Min(1, sum/math.Sqrt(sum*sum+vaderAlpha)))
+    if pos > math.Abs(neg) {
+        pos += emphasis
+    } else if pos < math.Abs(neg) {
+        neg -= emphasis
+    }
+    total := pos + math.Abs(neg) + float64(neu)
+    s.Positive = pos / total
+    s.Negative = math.Abs(neg) / total
+    s.Neutral = float64(neu) / total
+    return s
+}
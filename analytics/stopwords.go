@@ -0,0 +1,79 @@
+package analytics
+
+import (
+    "bufio"
+    "io"
+    "strings"
+)
+
+//stopwordLists are common words of each language, as Normalize writes them.
+//The "tweet" list is twitter's own noise and is always included.
+var stopwordLists = map[string]string{
+    "tweet": `rt via amp http https gt lt`,
+    "en": `a about above after again against all am an and any are aren't as at be because been before being
+        below between both but by can can't cannot could couldn't did didn't do does doesn't doing don't down during
+        each few for from further get got had hadn't has hasn't have haven't having he he'd he'll he's her here here's
+        hers herself him himself his how how's i i'd i'll i'm i've if in into is isn't it it's its itself just let's like
+        me more most much mustn't my myself no nor not now of off on once one only or other ought our ours ourselves out
+        over own same shan't she she'd she'll she's should shouldn't so some such than that that's the their theirs
+        them themselves then there there's these they they'd they'll they're they've this those through to too under
+        until up us very was wasn't we we'd we'll we're we've were weren't what what's when when's where where's which
+        while who who's whom why why's will with won't would wouldn't you you'd you'll you're you've your yours
+        yourself yourselves im dont cant`,
+    "es": `a al algo algunos ante antes como con contra cual cuando de del desde donde durante e el ella ellas ellos
+        en entre era es esa esas ese eso esos esta estaba estado estan estar este esto estos fue fueron ha han hasta
+        hay la las le les lo los mas me mi mis mucho muy más mí nada ni no nos nosotros o otra otro para pero poco por
+        porque que quien se sea ser si sido sin sobre son su sus también te tiene todo todos tu tus un una uno unos y
+        ya yo él está están qué`,
+    "fr": `a ai au aux avec avait ce ces c'est cette comme dans de des du elle en est et eu il ils j'ai je l'a la le
+        les leur lui ma mais me mes moi mon même n'est ne nos notre nous on ou où par pas pour qu'il que qui sa se ses
+        son sont sur ta te tes toi ton tous tout très tu un une vos votre vous y à été être`,
+    "de": `aber als am an auch auf aus bei bin bis bist da dann das dass dem den der des die dies diese dieser du
+        durch ein eine einem einen einer eines er es für hat hatte ich ihr im in ist ja kann kein man mein mich mit
+        nach nicht noch nur oder sein sich sie sind so um und uns von vor war was wenn wer wie wir wird zu zum zur
+        über`,
+    "pt": `a ao aos as até com como da das de do dos e ela ele eles em entre era essa esse esta este eu foi for há
+        isso já lhe mais mas me meu minha muito na nas nem no nos não o os ou para pela pelo por que se sem seu sua são
+        também te tem um uma você à é`,
+    "it": `a ad al alla alle anche che chi ci come con da dal dalla degli dei del della di e è gli ha hanno ho i il in
+        io la le lei lo loro lui ma mi mio nel nella noi non o per più questa questo se si sono su sua suo sul sulla
+        ti tu un una uno voi`,
+    "nl": `aan al als bij dan dat de die dit door een en er haar had heb heeft hem het hij hoe ik in is je kan maar
+        me met mij na naar niet nog nu of om ook op over te tot u uit van veel voor was wat we wel wie wij zal ze zich
+        zij zijn zo`,
+}
+
+//StopwordLanguages are the languages Stopwords has lists for
+var StopwordLanguages = []string{"en", "es", "fr", "de", "pt", "it", "nl"}
+
+//Stopwords is the set of stopwords of langs, every language when none are given, plus
+//retweet and url noise. Unknown languages are ignored.
+func Stopwords(langs ...string) map[string]bool {
+    if len(langs) == 0 {
+        langs = StopwordLanguages
+    }
+    words := make(map[string]bool)
+    for _, lang := range append([]string{"tweet"}, langs...) {
+        for _, w := range strings.Fields(stopwordLists[lang]) {
+            words[w] = true
+        }
+    }
+    return words
+}
+
+//AddStopwords adds the words of r, one per line, to the tokenizer's stopwords.
+//Blank lines and lines starting with # are skipped.
+func (t *Tokenizer) AddStopwords(r io.Reader) error {
+    if t.Stopwords == nil {
+        t.Stopwords = make(map[string]bool)
+    }
+    scanner := bufio.NewScanner(r)
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        t.Stopwords[Normalize(line)] = true
+    }
+    return scanner.Err()
+}
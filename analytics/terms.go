@@ -0,0 +1,164 @@
+package analytics
+
+import (
+    "encoding/csv"
+    "github.com/fcheslack/webtypes/twitter"
+    "github.com/fcheslack/tweetlog/tweetstore"
+    "io"
+    "sort"
+    "strconv"
+    "time"
+)
+
+//MaxNGram is the longest term sequence a TermCounter counts
+const MaxNGram = 3
+
+//TermReport is the most frequent terms, bigrams and trigrams of a set of tweets
+type TermReport struct {
+    From       time.Time `json:"from"`
+    To         time.Time `json:"to"`
+    ScreenName string    `json:"screen_name,omitempty"`
+    Track      string    `json:"track,omitempty"`
+    Lang       string    `json:"lang,omitempty"`
+    Tweets     int       `json:"tweets"`
+    Terms      []Count   `json:"terms"`
+    Bigrams    []Count   `json:"bigrams,omitempty"`
+    Trigrams   []Count   `json:"trigrams,omitempty"`
+}
+
+//TermCounter counts the terms and term sequences, up to n long, of tweets as they are added
+type TermCounter struct {
+    tok    *Tokenizer
+    n      int
+    tweets int
+    counts [MaxNGram]map[string]int
+    seen   map[int64]bool
+}
+
+func NewTermCounter(tok *Tokenizer, n int) *TermCounter {
+    if n < 1 {
+        n = 1
+    }
+    if n > MaxNGram {
+        n = MaxNGram
+    }
+    c := &TermCounter{tok: tok, n: n, seen: make(map[int64]bool)}
+    for i := 0; i < n; i++ {
+        c.counts[i] = make(map[string]int)
+    }
+    return c
+}
+
+//Add counts a tweet's terms. Tweets added again are ignored.
+func (c *TermCounter) Add(tweet *twittertypes.Tweet) {
+    if tweet.Id != nil {
+        id := int64(*tweet.Id)
+        if c.seen[id] {
+            return
+        }
+        c.seen[id] = true
+    }
+    c.tweets++
+    segments := c.tok.Segments(tweet.Text)
+    for i := 0; i < c.n; i++ {
+        for _, gram := range NGrams(segments, i+1) {
+            c.counts[i][gram]++
+        }
+    }
+}
+
+//topCounts is the limit largest counts of m, ties in key order
+func topCounts(m map[string]int, limit int) []Count {
+    counts := make([]Count, 0, len(m))
+    for k, n := range m {
+        counts = append(counts, Count{Key: k, Count: n})
+    }
+    sort.Slice(counts, func(i, j int) bool {
+        if counts[i].Count != counts[j].Count {
+            return counts[i].Count > counts[j].Count
+        }
+        return counts[i].Key < counts[j].Key
+    })
+    if len(counts) > limit {
+        counts = counts[:limit]
+    }
+    return counts
+}
+
+//Report is the limit most frequent of each n-gram length counted
+func (c *TermCounter) Report(limit int) *TermReport {
+    r := &TermReport{Tweets: c.tweets, Terms: topCounts(c.counts[0], limit)}
+    if c.n > 1 {
+        r.Bigrams = topCounts(c.counts[1], limit)
+    }
+    if c.n > 2 {
+        r.Trigrams = topCounts(c.counts[2], limit)
+    }
+    return r
+}
+
+//TermQuery selects the tweets TopTerms counts. Track is matched by full text search.
+type TermQuery struct {
+    From       time.Time
+    To         time.Time
+    ScreenName string
+    Track      string
+    Lang       string
+    N          int //longest n-gram, 1 to MaxNGram
+    Limit      int
+}
+
+//TopTerms counts the terms of the stored tweets q selects, tokenized by tok
+func (a *Analytics) TopTerms(tok *Tokenizer, q TermQuery) (*TermReport, error) {
+    c := NewTermCounter(tok, q.N)
+    tq := tweetstore.TweetQuery{From: q.From, To: q.To, ScreenName: q.ScreenName, Lang: q.Lang, Text: q.Track, Limit: tweetstore.MaxQueryLimit}
+    err := tweetstore.EachTweet(a.Tweetstore, tq, func(tweet *twittertypes.Tweet) bool {
+        c.Add(tweet)
+        return true
+    })
+    if err != nil {
+        return nil, err
+    }
+    r := c.Report(q.Limit)
+    r.From = q.From.UTC()
+    r.To = q.To.UTC()
+    r.ScreenName = q.ScreenName
+    r.Track = q.Track
+    r.Lang = q.Lang
+    return r, nil
+}
+
+//TopTermsByTrack is TopTerms for each track term in turn
+func (a *Analytics) TopTermsByTrack(tok *Tokenizer, q TermQuery, track []string) ([]*TermReport, error) {
+    reports := make([]*TermReport, 0, len(track))
+    for _, term := range track {
+        q.Track = term
+        r, err := a.TopTerms(tok, q)
+        if err != nil {
+            return nil, err
+        }
+        reports = append(reports, r)
+    }
+    return reports, nil
+}
+
+//WriteTermsCSV writes the n-grams of reports as screen_name,track,n,ngram,count rows
+func WriteTermsCSV(w io.Writer, reports ...*TermReport) error {
+    cw := csv.NewWriter(w)
+    err := cw.Write([]string{"screen_name", "track", "n", "ngram", "count"})
+    if err != nil {
+        return err
+    }
+    for _, r := range reports {
+        for n, counts := range [][]Count{r.Terms, r.Bigrams, r.Trigrams} {
+            for _, c := range counts {
+                err = cw.Write([]string{r.ScreenName, r.Track, strconv.Itoa(n + 1), c.Key, strconv.Itoa(c.Count)})
+                if err != nil {
+                    return err
+                }
+            }
+        }
+    }
+    cw.Flush()
+    return cw.Error()
+}
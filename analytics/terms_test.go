@@ -0,0 +1,44 @@
+package analytics
+
+import (
+    "fmt"
+    "testing"
+)
+
+func TestTermCounter(t *testing.T) {
+    texts := []string{
+        "New York City tonight",
+        "RT @bob: new york city tonight",
+        "big apple, new york",
+        "tonight in new york http://t.co/x",
+    }
+    c := NewTermCounter(NewTokenizer("en"), 5)
+    for i, text := range texts {
+        c.Add(jsonTweet(t, fmt.Sprintf(`{"id":%d,"id_str":"%d","text":%q}`, i+1, i+1, text)))
+    }
+    //a tweet added again is counted once
+    c.Add(jsonTweet(t, `{"id":1,"id_str":"1","text":"New York City tonight"}`))
+
+    r := c.Report(3)
+    if r.Tweets != len(texts) {
+        t.Errorf("%d tweets, want %d", r.Tweets, len(texts))
+    }
+    for _, list := range []struct {
+        name   string
+        counts []Count
+        want   string
+    }{
+        {"terms", r.Terms, "[{new 4} {york 4} {tonight 3}]"},
+        {"bigrams", r.Bigrams, "[{new york 4} {city tonight 2} {york city 2}]"},
+        {"trigrams", r.Trigrams, "[{new york city 2} {york city tonight 2}]"},
+    } {
+        if got := fmt.Sprint(list.counts); got != list.want {
+            t.Errorf("%s %s, want %s", list.name, got, list.want)
+        }
+    }
+
+    //under 1, n counts terms only
+    if r := NewTermCounter(NewTokenizer("en"), 0).Report(3); r.Bigrams != nil || r.Trigrams != nil {
+        t.Errorf("unigram report %+v", r)
+    }
+}
@@ -0,0 +1,202 @@
+package analytics
+
+import (
+    "html"
+    "regexp"
+    "strings"
+    "unicode"
+)
+
+//Tokenizer splits tweet text into terms. It drops urls, mentions and a leading
+//RT @user: and folds case, fullwidth forms and invisible characters. Words are
+//runs of letters, numbers and marks; Chinese and Japanese text, written without
+//spaces, becomes overlapping character bigrams; an emoji sequence is one term.
+type Tokenizer struct {
+    Stopwords    map[string]bool
+    MinLength    int  //shorter words are dropped like stopwords
+    KeepHashtags bool //hashtags are terms, with their #, rather than dropped
+}
+
+//NewTokenizer makes a Tokenizer dropping the stopwords of langs, see Stopwords
+func NewTokenizer(langs ...string) *Tokenizer {
+    return &Tokenizer{Stopwords: Stopwords(langs...), MinLength: 2}
+}
+
+var retweetPrefix = regexp.MustCompile(`^RT @\w+:?\s*`)
+
+//normalizeRune folds fullwidth ascii and curly apostrophes to ascii and lower cases r.
+//It returns -1 for runes that are dropped: zero width spaces, soft hyphens and emoji
+//variation selectors.
+func normalizeRune(r rune) rune {
+    switch {
+    case r >= 0xFF01 && r <= 0xFF5E:
+        r -= 0xFF01 - 0x21
+    case r == 0x3000:
+        return ' '
+    case r == 0x2019 || r == 0x2018 || r == 0x02BC:
+        return '\''
+    case r == 0x200B || r == 0x200C || r == 0x2060 || r == 0xFEFF || r == 0x00AD || r == 0xFE0E || r == 0xFE0F:
+        return -1
+    }
+    return unicode.ToLower(r)
+}
+
+//Normalize is the text as tokens see it
+func Normalize(text string) string {
+    return strings.Map(normalizeRune, text)
+}
+
+//isCJK is true for scripts written without spaces between words
+func isCJK(r rune) bool {
+    return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana) || r == 0x30FC
+}
+
+func isRegionalIndicator(r rune) bool {
+    return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+func isEmojiModifier(r rune) bool {
+    return r >= 0x1F3FB && r <= 0x1F3FF
+}
+
+//isEmoji is true for pictographic symbols, not for letters, digits or punctuation
+func isEmoji(r rune) bool {
+    return r >= 0x2190 && unicode.Is(unicode.So, r) || isRegionalIndicator(r)
+}
+
+func isWordRune(r rune) bool {
+    return (unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Mc, r)) && !isCJK(r)
+}
+
+//tokenizer is the state of splitting one text
+type tokenizer struct {
+    t        *Tokenizer
+    segments [][]string
+    cur      []string
+}
+
+//split ends the current segment, so no n-gram spans what came between
+func (s *tokenizer) split() {
+    if len(s.cur) > 0 {
+        s.segments = append(s.segments, s.cur)
+        s.cur = nil
+    }
+}
+
+func (s *tokenizer) word(w string) {
+    if len([]rune(w)) < s.t.MinLength || s.t.Stopwords[w] {
+        s.split()
+        return
+    }
+    s.cur = append(s.cur, w)
+}
+
+//alone adds a term as a segment of its own
+func (s *tokenizer) alone(term string) {
+    s.split()
+    s.segments = append(s.segments, []string{term})
+}
+
+//Segments splits text into runs of terms. Dropped text, punctuation and stopwords
+//end a run, so n-grams are only made within one.
+func (t *Tokenizer) Segments(text string) [][]string {
+    s := &tokenizer{t: t}
+    text = Normalize(retweetPrefix.ReplaceAllString(html.UnescapeString(text), ""))
+    for _, field := range strings.Fields(text) {
+        if strings.HasPrefix(field, "@") || strings.HasPrefix(field, "www.") || strings.Contains(field, "://") {
+            s.split()
+            continue
+        }
+        s.field([]rune(field))
+    }
+    s.split()
+    return s.segments
+}
+
+func (s *tokenizer) field(rs []rune) {
+    for i := 0; i < len(rs); {
+        r := rs[i]
+        switch {
+        case r == '#' && i+1 < len(rs) && (isWordRune(rs[i+1]) || isCJK(rs[i+1]) || rs[i+1] == '_'):
+            j := i + 1
+            for j < len(rs) && (isWordRune(rs[j]) || isCJK(rs[j]) || rs[j] == '_') {
+                j++
+            }
+            if s.t.KeepHashtags {
+                s.alone(string(rs[i:j]))
+            } else {
+                s.split()
+            }
+            i = j
+        case isWordRune(r):
+            j := i + 1
+            for j < len(rs) {
+                //apostrophes and hyphens join letters, as in don't and e-mail
+                if (rs[j] == '\'' || rs[j] == '-') && j+1 < len(rs) && isWordRune(rs[j+1]) {
+                    j += 2
+                    continue
+                }
+                if !isWordRune(rs[j]) {
+                    break
+                }
+                j++
+            }
+            s.word(string(rs[i:j]))
+            i = j
+        case isCJK(r):
+            j := i + 1
+            for j < len(rs) && isCJK(rs[j]) {
+                j++
+            }
+            if j-i == 1 {
+                s.alone(string(rs[i:j]))
+            }
+            for k := i; k+1 < j; k++ {
+                s.alone(string(rs[k : k+2]))
+            }
+            i = j
+        case isEmoji(r):
+            j := i + 1
+            if isRegionalIndicator(r) && j < len(rs) && isRegionalIndicator(rs[j]) {
+                j++
+            }
+            for j < len(rs) {
+                if isEmojiModifier(rs[j]) {
+                    j++
+                } else if rs[j] == 0x200D && j+1 < len(rs) && isEmoji(rs[j+1]) {
+                    j += 2
+                } else {
+                    break
+                }
+            }
+            s.alone(string(rs[i:j]))
+            i = j
+        case unicode.IsPunct(r):
+            s.split()
+            i++
+        default:
+            //symbols like $ and +, and joiners left over, separate words without ending a run
+            i++
+        }
+    }
+}
+
+//Terms is the terms of text in order
+func (t *Tokenizer) Terms(text string) []string {
+    var terms []string
+    for _, seg := range t.Segments(text) {
+        terms = append(terms, seg...)
+    }
+    return terms
+}
+
+//NGrams is the n term sequences of segments, joined with spaces
+func NGrams(segments [][]string, n int) []string {
+    var grams []string
+    for _, seg := range segments {
+        for i := 0; i+n <= len(seg); i++ {
+            grams = append(grams, strings.Join(seg[i:i+n], " "))
+        }
+    }
+    return grams
+}
@@ -0,0 +1,102 @@
+package analytics
+
+import (
+    "fmt"
+    "strings"
+    "testing"
+)
+
+func TestSegments(t *testing.T) {
+    en := NewTokenizer("en")
+    hashtags := NewTokenizer("en")
+    hashtags.KeepHashtags = true
+    for _, c := range []struct {
+        name string
+        tok  *Tokenizer
+        text string
+        want [][]string
+    }{
+        {"retweet prefix", en, "RT @bob: Hello World", [][]string{{"hello", "world"}}},
+        {"mentions and urls", en, "hi @bob see http://t.co/x www.example.com today", [][]string{{"hi"}, {"see"}, {"today"}}},
+        {"fullwidth", en, "ＨＥＬＬＯ\u3000Ｗｏｒｌｄ", [][]string{{"hello", "world"}}},
+        {"invisible characters", en, "zero\u200bwidth soft\u00adhyphen", [][]string{{"zerowidth", "softhyphen"}}},
+        {"punctuation", en, "New York, big apple!", [][]string{{"new", "york"}, {"big", "apple"}}},
+        {"html entities", en, "fish &amp; chips", [][]string{{"fish"}, {"chips"}}},
+        {"stopwords and short words", en, "a b go to the x park", [][]string{{"go"}, {"park"}}},
+        {"apostrophes and hyphens", en, "Don’t e-mail Bob's pre- and post-", [][]string{{"e-mail", "bob's", "pre"}, {"post"}}},
+        {"cjk bigrams", en, "東京タワー", [][]string{{"東京"}, {"京タ"}, {"タワ"}, {"ワー"}}},
+        {"single cjk character", en, "猫 cat", [][]string{{"猫"}, {"cat"}}},
+        {"emoji", en, "love 👍🏽 ❤\ufe0f 🇫🇷 👩\u200d💻", [][]string{{"love"}, {"👍🏽"}, {"❤"}, {"🇫🇷"}, {"👩\u200d💻"}}},
+        {"hashtags dropped", en, "big #Launch day", [][]string{{"big"}, {"day"}}},
+        {"hashtags kept", hashtags, "big #Launch day #東京", [][]string{{"big"}, {"#launch"}, {"day"}, {"#東京"}}},
+        {"other language", NewTokenizer("fr"), "le chat et the dog via", [][]string{{"chat"}, {"the", "dog"}}},
+        {"no stopwords", &Tokenizer{}, "RT @bob: the a rt", [][]string{{"the", "a", "rt"}}},
+        {"nothing left", en, "RT @bob: the http://t.co/x", nil},
+    } {
+        got := c.tok.Segments(c.text)
+        if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", c.want) {
+            t.Errorf("%s: segments of %q %q, want %q", c.name, c.text, got, c.want)
+        }
+    }
+}
+
+func TestNGrams(t *testing.T) {
+    segments := NewTokenizer("en").Segments("new york city, big apple")
+    for _, c := range []struct {
+        n    int
+        want []string
+    }{
+        {1, []string{"new", "york", "city", "big", "apple"}},
+        //no n-gram spans the comma
+        {2, []string{"new york", "york city", "big apple"}},
+        {3, []string{"new york city"}},
+        {4, nil},
+    } {
+        got := NGrams(segments, c.n)
+        if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", c.want) {
+            t.Errorf("%d-grams %q, want %q", c.n, got, c.want)
+        }
+    }
+}
+
+func TestStopwords(t *testing.T) {
+    for _, c := range []struct {
+        langs []string
+        in    []string
+        out   []string
+    }{
+        {[]string{"en"}, []string{"the", "don't", "rt", "amp"}, []string{"le", "und"}},
+        {[]string{"fr", "de"}, []string{"le", "und", "via"}, []string{"the"}},
+        //every language when none are given
+        {nil, []string{"the", "le", "und", "het", "rt"}, []string{"launch"}},
+        //unknown languages only have the tweet list
+        {[]string{"xx"}, []string{"rt", "https"}, []string{"the"}},
+    } {
+        words := Stopwords(c.langs...)
+        for _, w := range c.in {
+            if !words[w] {
+                t.Errorf("%v: %q is not a stopword", c.langs, w)
+            }
+        }
+        for _, w := range c.out {
+            if words[w] {
+                t.Errorf("%v: %q is a stopword", c.langs, w)
+            }
+        }
+    }
+}
+
+func TestAddStopwords(t *testing.T) {
+    tok := &Tokenizer{}
+    err := tok.AddStopwords(strings.NewReader("# team names\nＧＯＰ\n\n  Launch  \n"))
+    if err != nil {
+        t.Fatalf("adding stopwords: %s", err)
+    }
+    if len(tok.Stopwords) != 2 || !tok.Stopwords["gop"] || !tok.Stopwords["launch"] {
+        t.Errorf("stopwords %v, want gop and launch", tok.Stopwords)
+    }
+    terms := tok.Terms("GOP launch party")
+    if fmt.Sprint(terms) != "[party]" {
+        t.Errorf("terms %v, want [party]", terms)
+    }
+}
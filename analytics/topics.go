@@ -0,0 +1,370 @@
+package analytics
+
+import (
+    "encoding/csv"
+    "github.com/fcheslack/webtypes/twitter"
+    "github.com/fcheslack/tweetlog/tweetstore"
+    "io"
+    "math"
+    "sort"
+    "strconv"
+    "time"
+)
+
+//Co-occurrence scores
+const (
+    ScorePMI     = "pmi"     //log2 of how much more often a pair occurs together than by chance
+    ScoreNPMI    = "npmi"    //pmi normalised to -1..1
+    ScoreJaccard = "jaccard" //tweets with both over tweets with either
+)
+
+//TopicConfig sets what a TopicCounter pairs and how pairs are grouped into topics
+type TopicConfig struct {
+    Terms        bool    //also pair words of the tweet text, not only hashtags
+    Score        string  //ScorePMI, ScoreNPMI or ScoreJaccard
+    MinCount     int     //keys in fewer tweets are left out
+    MinPairCount int     //pairs seen together in fewer tweets are left out
+    MaxKeys      int     //only the most frequent keys are paired
+    Threshold    float64 //pairs scoring lower don't join topics, 0 for the score's default
+    MaxTopicSize int     //topics stop growing at this many keys
+}
+
+var DefaultTopicConfig = TopicConfig{
+    Score:        ScoreNPMI,
+    MinCount:     3,
+    MinPairCount: 2,
+    MaxKeys:      300,
+    MaxTopicSize: 15,
+}
+
+//defaultTopicThresholds are the scores a pair needs to link its keys into a topic
+var defaultTopicThresholds = map[string]float64{
+    ScorePMI:     1, //twice as often as chance
+    ScoreNPMI:    0.25,
+    ScoreJaccard: 0.1,
+}
+
+//TopicKey is a hashtag, written with its #, or a term, with the number of tweets it
+//is in and the index of its topic in the report, -1 when it is in none
+type TopicKey struct {
+    Key   string `json:"key"`
+    Count int    `json:"count"`
+    Topic int    `json:"topic"`
+}
+
+//KeyPair is how often two keys are in the same tweet and how strongly that ties them
+type KeyPair struct {
+    A     string  `json:"a"`
+    B     string  `json:"b"`
+    Count int     `json:"count"`
+    Score float64 `json:"score"`
+}
+
+//Topic is a group of keys that tend to be used together. Score is the mean score
+//of the pairs linking them and Tweets the sum of their counts.
+type Topic struct {
+    Id     int      `json:"id"`
+    Keys   []string `json:"keys"`
+    Tweets int      `json:"tweets"`
+    Score  float64  `json:"score"`
+}
+
+//TopicReport is the key co-occurrences of a set of tweets. Keys are sorted by count,
+//pairs by score and topics by tweets.
+type TopicReport struct {
+    From   time.Time  `json:"from"`
+    To     time.Time  `json:"to"`
+    Score  string     `json:"score"`
+    Tweets int        `json:"tweets"`
+    Keys   []TopicKey `json:"keys"`
+    Pairs  []KeyPair  `json:"pairs"`
+    Topics []Topic    `json:"topics"`
+}
+
+//TopicCounter collects the keys of tweets as they are added and reports how they co-occur.
+//Unlike a TrendDetector it is not safe for concurrent use.
+type TopicCounter struct {
+    cfg    TopicConfig
+    counts map[string]int
+    sets   [][]string
+    seen   map[int64]bool
+}
+
+func NewTopicCounter(cfg TopicConfig) *TopicCounter {
+    if _, ok := defaultTopicThresholds[cfg.Score]; !ok {
+        cfg.Score = DefaultTopicConfig.Score
+    }
+    if cfg.Threshold == 0 {
+        cfg.Threshold = defaultTopicThresholds[cfg.Score]
+    }
+    if cfg.MinCount <= 0 {
+        cfg.MinCount = DefaultTopicConfig.MinCount
+    }
+    if cfg.MinPairCount <= 0 {
+        cfg.MinPairCount = DefaultTopicConfig.MinPairCount
+    }
+    if cfg.MaxKeys <= 0 {
+        cfg.MaxKeys = DefaultTopicConfig.MaxKeys
+    }
+    if cfg.MaxTopicSize <= 1 {
+        cfg.MaxTopicSize = DefaultTopicConfig.MaxTopicSize
+    }
+    return &TopicCounter{cfg: cfg, counts: make(map[string]int), seen: make(map[int64]bool)}
+}
+
+//Add counts a tweet's keys. Tweets added again are ignored.
+func (c *TopicCounter) Add(tweet *twittertypes.Tweet) {
+    if tweet.Id != nil {
+        id := int64(*tweet.Id)
+        if c.seen[id] {
+            return
+        }
+        c.seen[id] = true
+    }
+    var set []string
+    for k := range trendKeys(tweet) {
+        key := k.key
+        switch k.kind {
+        case TrendHashtag:
+            key = "#" + key
+        case TrendTerm:
+            if !c.cfg.Terms {
+                continue
+            }
+        default:
+            continue
+        }
+        set = append(set, key)
+        c.counts[key]++
+    }
+    c.sets = append(c.sets, set)
+}
+
+//AddFromStore counts the stored tweets created between from and to and returns how many were added
+func (c *TopicCounter) AddFromStore(store tweetstore.TweetStore, from, to time.Time) (int, error) {
+    q := tweetstore.TweetQuery{From: from, To: to, Limit: tweetstore.MaxQueryLimit}
+    n := 0
+    err := tweetstore.EachTweet(store, q, func(tweet *twittertypes.Tweet) bool {
+        c.Add(tweet)
+        n++
+        return true
+    })
+    return n, err
+}
+
+func (c *TopicCounter) score(ca, cb, cab, n int) float64 {
+    switch c.cfg.Score {
+    case ScoreJaccard:
+        return float64(cab) / float64(ca+cb-cab)
+    default:
+        pmi := math.Log2(float64(cab) * float64(n) / (float64(ca) * float64(cb)))
+        if c.cfg.Score == ScorePMI {
+            return pmi
+        }
+        if cab == n {
+            return 1
+        }
+        return pmi / -math.Log2(float64(cab)/float64(n))
+    }
+}
+
+//Report scores the pairs of the most frequent keys and groups them into topics
+func (c *TopicCounter) Report() *TopicReport {
+    r := &TopicReport{Score: c.cfg.Score, Tweets: len(c.sets), Keys: []TopicKey{}, Pairs: []KeyPair{}, Topics: []Topic{}}
+    for k, n := range c.counts {
+        if n >= c.cfg.MinCount {
+            r.Keys = append(r.Keys, TopicKey{Key: k, Count: n, Topic: -1})
+        }
+    }
+    sort.Slice(r.Keys, func(i, j int) bool {
+        if r.Keys[i].Count != r.Keys[j].Count {
+            return r.Keys[i].Count > r.Keys[j].Count
+        }
+        return r.Keys[i].Key < r.Keys[j].Key
+    })
+    if len(r.Keys) > c.cfg.MaxKeys {
+        r.Keys = r.Keys[:c.cfg.MaxKeys]
+    }
+    index := make(map[string]int)
+    for i, k := range r.Keys {
+        index[k.Key] = i
+    }
+
+    pairCounts := make(map[[2]int]int)
+    for _, set := range c.sets {
+        ids := make([]int, 0, len(set))
+        for _, k := range set {
+            if i, ok := index[k]; ok {
+                ids = append(ids, i)
+            }
+        }
+        sort.Ints(ids)
+        for x := 0; x < len(ids); x++ {
+            for y := x + 1; y < len(ids); y++ {
+                pairCounts[[2]int{ids[x], ids[y]}]++
+            }
+        }
+    }
+    for p, cab := range pairCounts {
+        if cab < c.cfg.MinPairCount {
+            continue
+        }
+        a, b := r.Keys[p[0]], r.Keys[p[1]]
+        r.Pairs = append(r.Pairs, KeyPair{A: a.Key, B: b.Key, Count: cab, Score: c.score(a.Count, b.Count, cab, r.Tweets)})
+    }
+    sort.Slice(r.Pairs, func(i, j int) bool {
+        a, b := r.Pairs[i], r.Pairs[j]
+        if a.Score != b.Score {
+            return a.Score > b.Score
+        }
+        if a.Count != b.Count {
+            return a.Count > b.Count
+        }
+        if a.A != b.A {
+            return a.A < b.A
+        }
+        return a.B < b.B
+    })
+
+    c.cluster(r, index)
+    return r
+}
+
+//cluster groups keys by average linkage: going through pairs over the threshold,
+//strongest first, the topics of the two keys are merged when the mean score of all
+//pairs across them is over the threshold too, pairs never seen together scoring 0.
+//That keeps a few tweets mixing two topics from chaining them into one.
+func (c *TopicCounter) cluster(r *TopicReport, index map[string]int) {
+    scores := make(map[[2]int]float64)
+    for _, p := range r.Pairs {
+        a, b := index[p.A], index[p.B]
+        scores[[2]int{a, b}] = p.Score
+        scores[[2]int{b, a}] = p.Score
+    }
+    parent := make([]int, len(r.Keys))
+    members := make([][]int, len(r.Keys))
+    for i := range parent {
+        parent[i] = i
+        members[i] = []int{i}
+    }
+    var find func(int) int
+    find = func(i int) int {
+        if parent[i] != i {
+            parent[i] = find(parent[i])
+        }
+        return parent[i]
+    }
+    for _, p := range r.Pairs {
+        if p.Score < c.cfg.Threshold {
+            break
+        }
+        a, b := find(index[p.A]), find(index[p.B])
+        if a == b || len(members[a])+len(members[b]) > c.cfg.MaxTopicSize {
+            continue
+        }
+        total := 0.0
+        for _, x := range members[a] {
+            for _, y := range members[b] {
+                total += scores[[2]int{x, y}]
+            }
+        }
+        if total/float64(len(members[a])*len(members[b])) < c.cfg.Threshold {
+            continue
+        }
+        parent[a] = b
+        members[b] = append(members[b], members[a]...)
+        members[a] = nil
+    }
+
+    topics := make(map[int]*Topic)
+    var roots []int
+    for i, k := range r.Keys {
+        root := find(i)
+        if len(members[root]) < 2 {
+            continue
+        }
+        t := topics[root]
+        if t == nil {
+            t = &Topic{}
+            topics[root] = t
+            roots = append(roots, root)
+        }
+        t.Keys = append(t.Keys, k.Key)
+        t.Tweets += k.Count
+    }
+    linked := make(map[int]int)
+    for _, p := range r.Pairs {
+        a, b := find(index[p.A]), find(index[p.B])
+        if a == b && topics[a] != nil {
+            topics[a].Score += p.Score
+            linked[a]++
+        }
+    }
+    sort.SliceStable(roots, func(i, j int) bool {
+        return topics[roots[i]].Tweets > topics[roots[j]].Tweets
+    })
+    for id, root := range roots {
+        t := topics[root]
+        t.Id = id
+        if linked[root] > 0 {
+            t.Score /= float64(linked[root])
+        }
+        r.Topics = append(r.Topics, *t)
+        for _, k := range t.Keys {
+            r.Keys[index[k]].Topic = id
+        }
+    }
+}
+
+//Topics reports the co-occurrence of hashtags, and terms if cfg.Terms, in a.Tweets
+func (a *Analytics) Topics(cfg TopicConfig) *TopicReport {
+    c := NewTopicCounter(cfg)
+    for _, t := range a.Tweets {
+        c.Add(t)
+    }
+    return c.Report()
+}
+
+//WritePairsCSV writes the scored key pairs, strongest first
+func (r *TopicReport) WritePairsCSV(w io.Writer) error {
+    counts := make(map[string]int)
+    for _, k := range r.Keys {
+        counts[k.Key] = k.Count
+    }
+    cw := csv.NewWriter(w)
+    err := cw.Write([]string{"a", "b", "count_a", "count_b", "count_ab", r.Score})
+    if err != nil {
+        return err
+    }
+    for _, p := range r.Pairs {
+        err = cw.Write([]string{p.A, p.B, strconv.Itoa(counts[p.A]), strconv.Itoa(counts[p.B]), strconv.Itoa(p.Count), strconv.FormatFloat(p.Score, 'f', 4, 64)})
+        if err != nil {
+            return err
+        }
+    }
+    cw.Flush()
+    return cw.Error()
+}
+
+//WriteTopicsCSV writes one row per key of each topic, biggest topic first
+func (r *TopicReport) WriteTopicsCSV(w io.Writer) error {
+    counts := make(map[string]int)
+    for _, k := range r.Keys {
+        counts[k.Key] = k.Count
+    }
+    cw := csv.NewWriter(w)
+    err := cw.Write([]string{"topic", "key", "count", "topic_tweets", "topic_score"})
+    if err != nil {
+        return err
+    }
+    for _, t := range r.Topics {
+        for _, k := range t.Keys {
+            err = cw.Write([]string{strconv.Itoa(t.Id), k, strconv.Itoa(counts[k]), strconv.Itoa(t.Tweets), strconv.FormatFloat(t.Score, 'f', 4, 64)})
+            if err != nil {
+                return err
+            }
+        }
+    }
+    cw.Flush()
+    return cw.Error()
+}
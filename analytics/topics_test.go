@@ -0,0 +1,116 @@
+package analytics
+
+import (
+    "fmt"
+    "github.com/fcheslack/webtypes/twitter"
+    "strings"
+    "testing"
+)
+
+//topicTweets is a small fixed corpus of a food topic, a bigger sports topic, a tweet
+//mixing the two, weather tweets with nothing else tagged, and a tweet added twice
+func topicTweets(t *testing.T) []*twittertypes.Tweet {
+    var texts []string
+    for i := 0; i < 4; i++ {
+        texts = append(texts, "#pizza #pasta dinner")
+    }
+    for i := 0; i < 5; i++ {
+        texts = append(texts, "#goal #match stadium")
+    }
+    texts = append(texts, "#pizza #goal", "#weather rain", "#weather rain", "#weather rain")
+
+    var tweets []*twittertypes.Tweet
+    for i, text := range texts {
+        var tags []string
+        for _, word := range strings.Fields(text) {
+            if strings.HasPrefix(word, "#") {
+                tags = append(tags, fmt.Sprintf(`{"text":%q,"indices":[0,0]}`, word[1:]))
+            }
+        }
+        tweets = append(tweets, jsonTweet(t, fmt.Sprintf(`{"id":%d,"id_str":"%d","text":%q,"entities":{"hashtags":[%s],"urls":[],"user_mentions":[],"media":[]}}`,
+            i+1, i+1, text, strings.Join(tags, ","))))
+    }
+    return append(tweets, tweets[0])
+}
+
+func TestTopics(t *testing.T) {
+    for _, c := range []struct {
+        name   string
+        cfg    TopicConfig
+        keys   []string //key:count:topic, by count
+        pairs  []string //a b count, by score
+        topics []string //keys, by tweets
+    }{
+        {
+            //the one tweet with #pizza and #goal is under MinPairCount, #weather pairs with nothing
+            "hashtags", DefaultTopicConfig,
+            []string{"#goal:6:0", "#match:5:0", "#pizza:5:1", "#pasta:4:1", "#weather:3:-1"},
+            []string{"#pizza #pasta 4", "#goal #match 5"},
+            []string{"[#goal #match]", "[#pizza #pasta]"},
+        },
+        {
+            //jaccard ranks sports above food, unlike npmi
+            "jaccard", TopicConfig{Score: ScoreJaccard},
+            []string{"#goal:6:0", "#match:5:0", "#pizza:5:1", "#pasta:4:1", "#weather:3:-1"},
+            []string{"#goal #match 5", "#pizza #pasta 4"},
+            []string{"[#goal #match]", "[#pizza #pasta]"},
+        },
+        {
+            //#pizza and #goal are paired, too weakly to link their topics
+            "min pair count", TopicConfig{MinPairCount: 1},
+            []string{"#goal:6:0", "#match:5:0", "#pizza:5:1", "#pasta:4:1", "#weather:3:-1"},
+            []string{"#pizza #pasta 4", "#goal #match 5", "#goal #pizza 1"},
+            []string{"[#goal #match]", "[#pizza #pasta]"},
+        },
+        {
+            "min count", TopicConfig{MinCount: 5},
+            []string{"#goal:6:0", "#match:5:0", "#pizza:5:-1"},
+            []string{"#goal #match 5"},
+            []string{"[#goal #match]"},
+        },
+        {
+            "terms", TopicConfig{Terms: true},
+            []string{"#goal:6:0", "#match:5:0", "#pizza:5:1", "stadium:5:0", "#pasta:4:1", "dinner:4:1", "#weather:3:2", "rain:3:2"},
+            []string{"#match stadium 5", "#pasta dinner 4", "#weather rain 3", "#pizza #pasta 4", "#pizza dinner 4", "#goal #match 5", "#goal stadium 5"},
+            []string{"[#goal #match stadium]", "[#pizza #pasta dinner]", "[#weather rain]"},
+        },
+        {
+            //the strongest pairs fill each topic, leaving #goal and #pizza out
+            "max topic size", TopicConfig{Terms: true, MaxTopicSize: 2},
+            []string{"#goal:6:-1", "#match:5:0", "#pizza:5:-1", "stadium:5:0", "#pasta:4:1", "dinner:4:1", "#weather:3:2", "rain:3:2"},
+            nil,
+            []string{"[#match stadium]", "[#pasta dinner]", "[#weather rain]"},
+        },
+    } {
+        counter := NewTopicCounter(c.cfg)
+        for _, tweet := range topicTweets(t) {
+            counter.Add(tweet)
+        }
+        r := counter.Report()
+        if r.Tweets != 13 {
+            t.Errorf("%s: %d tweets, want 13", c.name, r.Tweets)
+        }
+        var keys, pairs, topics []string
+        for _, k := range r.Keys {
+            keys = append(keys, fmt.Sprintf("%s:%d:%d", k.Key, k.Count, k.Topic))
+        }
+        for _, p := range r.Pairs {
+            pairs = append(pairs, fmt.Sprintf("%s %s %d", p.A, p.B, p.Count))
+        }
+        for i, topic := range r.Topics {
+            if topic.Id != i {
+                t.Errorf("%s: topic %d has id %d", c.name, i, topic.Id)
+            }
+            topics = append(topics, fmt.Sprint(topic.Keys))
+        }
+        if fmt.Sprint(keys) != fmt.Sprint(c.keys) {
+            t.Errorf("%s: keys %v, want %v", c.name, keys, c.keys)
+        }
+        if c.pairs != nil && fmt.Sprint(pairs) != fmt.Sprint(c.pairs) {
+            t.Errorf("%s: pairs %v, want %v", c.name, pairs, c.pairs)
+        }
+        if fmt.Sprint(topics) != fmt.Sprint(c.topics) {
+            t.Errorf("%s: topics %v, want %v", c.name, topics, c.topics)
+        }
+    }
+}
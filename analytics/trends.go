@@ -0,0 +1,287 @@
+package analytics
+
+import (
+    "github.com/fcheslack/webtypes/twitter"
+    "github.com/fcheslack/tweetlog/tweetstore"
+    "math"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+//Trend kinds
+const (
+    TrendHashtag = "hashtag"
+    TrendUrl     = "url"
+    TrendTerm    = "term"
+)
+
+//TrendConfig sets the windows a TrendDetector compares. Counts in the last Window
+//are compared to the rate over the Baseline before it, kept in Slot sized buckets.
+type TrendConfig struct {
+    Slot     time.Duration
+    Window   time.Duration
+    Baseline time.Duration
+    MinCount int //a rising key needs this many tweets in the window, a falling one this many expected
+}
+
+var DefaultTrendConfig = TrendConfig{
+    Slot:     5 * time.Minute,
+    Window:   time.Hour,
+    Baseline: 24 * time.Hour,
+    MinCount: 5,
+}
+
+//Trend is a key whose count in the window is unusual for its baseline rate.
+//Score is a Poisson z-score: how many standard deviations Count is from Expected.
+type Trend struct {
+    Kind     string  `json:"kind"`
+    Key      string  `json:"key"`
+    Count    int     `json:"count"`
+    Expected float64 `json:"expected"`
+    Score    float64 `json:"score"`
+}
+
+//TrendReport is the rising and falling keys as of a time, strongest first
+type TrendReport struct {
+    AsOf     time.Time `json:"as_of"`
+    Window   string    `json:"window"`
+    Baseline string    `json:"baseline"`
+    Rising   []Trend   `json:"rising"`
+    Falling  []Trend   `json:"falling"`
+}
+
+type trendKey struct {
+    kind string
+    key  string
+}
+
+//trendSlot is the counts of one Slot, and the tweets counted so re-saved tweets aren't counted twice
+type trendSlot struct {
+    counts map[trendKey]int
+    seen   map[int64]bool
+}
+
+//TrendDetector counts hashtags, urls and words of tweets as they are added and
+//reports which are rising or falling. It is safe for concurrent use.
+type TrendDetector struct {
+    cfg      TrendConfig
+    mu       sync.Mutex
+    slots    map[int64]*trendSlot
+    earliest int64 //first slot with anything in it, to size a baseline still filling up
+}
+
+func NewTrendDetector(cfg TrendConfig) *TrendDetector {
+    if cfg.Slot <= 0 {
+        cfg.Slot = DefaultTrendConfig.Slot
+    }
+    if cfg.Window < cfg.Slot {
+        cfg.Window = cfg.Slot
+    }
+    if cfg.Baseline < cfg.Window {
+        cfg.Baseline = cfg.Window
+    }
+    if cfg.MinCount <= 0 {
+        cfg.MinCount = DefaultTrendConfig.MinCount
+    }
+    return &TrendDetector{cfg: cfg, slots: make(map[int64]*trendSlot)}
+}
+
+func (d *TrendDetector) slot(t time.Time) int64 {
+    return t.UnixNano() / int64(d.cfg.Slot)
+}
+
+func (d *TrendDetector) span() int64 {
+    return int64((d.cfg.Window + d.cfg.Baseline) / d.cfg.Slot)
+}
+
+//trendTokenizer finds the terms of tweets. Short words are too ambiguous to trend.
+var trendTokenizer = &Tokenizer{Stopwords: Stopwords(), MinLength: 3}
+
+//trendKeys is the set of keys a tweet counts once towards
+func trendKeys(tweet *twittertypes.Tweet) map[trendKey]bool {
+    keys := make(map[trendKey]bool)
+    for _, ht := range tweet.Entities.Hashtags {
+        keys[trendKey{TrendHashtag, strings.ToLower(ht.Text)}] = true
+    }
+    for _, u := range tweet.Entities.Urls {
+        if u.Expanded_url != "" {
+            keys[trendKey{TrendUrl, string(u.Expanded_url)}] = true
+        }
+    }
+    for _, term := range trendTokenizer.Terms(tweet.Text) {
+        keys[trendKey{TrendTerm, term}] = true
+    }
+    return keys
+}
+
+//Add counts a tweet at the time it was created. Tweets older than the baseline are ignored.
+func (d *TrendDetector) Add(tweet *twittertypes.Tweet) {
+    created, err := time.Parse(time.RubyDate, tweet.Created_at)
+    if err != nil {
+        created = time.Now()
+    }
+    d.AddAt(tweet, created)
+}
+
+//AddAt counts a tweet at t. A tweet already counted at t is skipped.
+func (d *TrendDetector) AddAt(tweet *twittertypes.Tweet, t time.Time) {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    s := d.slot(t)
+    if s <= d.slot(time.Now())-d.span() {
+        return
+    }
+    slot := d.slots[s]
+    if slot == nil {
+        slot = &trendSlot{counts: make(map[trendKey]int), seen: make(map[int64]bool)}
+        d.slots[s] = slot
+        if d.earliest == 0 || s < d.earliest {
+            d.earliest = s
+        }
+    }
+    if tweet.Id != nil {
+        if slot.seen[int64(*tweet.Id)] {
+            return
+        }
+        slot.seen[int64(*tweet.Id)] = true
+    }
+    for k := range trendKeys(tweet) {
+        slot.counts[k]++
+    }
+}
+
+//prune drops slots that have left the baseline
+func (d *TrendDetector) prune(now int64) {
+    oldest := now - d.span() + 1
+    for s := range d.slots {
+        if s < oldest {
+            delete(d.slots, s)
+        }
+    }
+    if d.earliest < oldest {
+        d.earliest = oldest
+    }
+}
+
+//Trends reports up to limit rising and falling keys of kind, or of every kind when kind is empty
+func (d *TrendDetector) Trends(now time.Time, kind string, limit int) TrendReport {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    nowSlot := d.slot(now)
+    d.prune(nowSlot)
+    windowSlots := int64(d.cfg.Window / d.cfg.Slot)
+    windowStart := nowSlot - windowSlots + 1
+
+    report := TrendReport{
+        AsOf:     now.UTC(),
+        Window:   d.cfg.Window.String(),
+        Baseline: d.cfg.Baseline.String(),
+        Rising:   []Trend{},
+        Falling:  []Trend{},
+    }
+    //a baseline still filling up is scaled by the part of it that has been seen
+    baselineSlots := windowStart - d.earliest
+    if max := int64(d.cfg.Baseline / d.cfg.Slot); baselineSlots > max {
+        baselineSlots = max
+    }
+    if baselineSlots <= 0 {
+        return report
+    }
+
+    current := make(map[trendKey]int)
+    baseline := make(map[trendKey]int)
+    for s, slot := range d.slots {
+        counts := slot.counts
+        switch {
+        case s > nowSlot:
+            continue
+        case s >= windowStart:
+            for k, c := range counts {
+                if kind == "" || k.kind == kind {
+                    current[k] += c
+                }
+            }
+        case s >= windowStart-baselineSlots:
+            for k, c := range counts {
+                if kind == "" || k.kind == kind {
+                    baseline[k] += c
+                }
+            }
+        }
+    }
+
+    scale := float64(windowSlots) / float64(baselineSlots)
+    score := func(k trendKey) Trend {
+        expected := float64(baseline[k]) * scale
+        c := current[k]
+        //the pseudocount keeps keys never seen before from scoring infinitely
+        return Trend{Kind: k.kind, Key: k.key, Count: c, Expected: expected, Score: (float64(c) - expected) / math.Sqrt(expected+1)}
+    }
+    for k, c := range current {
+        if c < d.cfg.MinCount {
+            continue
+        }
+        if t := score(k); t.Score > 0 {
+            report.Rising = append(report.Rising, t)
+        }
+    }
+    for k, b := range baseline {
+        if float64(b)*scale < float64(d.cfg.MinCount) {
+            continue
+        }
+        if t := score(k); t.Score < 0 {
+            report.Falling = append(report.Falling, t)
+        }
+    }
+    sortTrends(report.Rising, 1)
+    sortTrends(report.Falling, -1)
+    if limit > 0 && len(report.Rising) > limit {
+        report.Rising = report.Rising[:limit]
+    }
+    if limit > 0 && len(report.Falling) > limit {
+        report.Falling = report.Falling[:limit]
+    }
+    return report
+}
+
+//sortTrends orders by score, strongest first for the sign of direction, then by kind and key so reports are stable
+func sortTrends(trends []Trend, direction float64) {
+    sort.Slice(trends, func(i, j int) bool {
+        a, b := trends[i], trends[j]
+        if a.Score != b.Score {
+            return a.Score*direction > b.Score*direction
+        }
+        if a.Kind != b.Kind {
+            return a.Kind < b.Kind
+        }
+        return a.Key < b.Key
+    })
+}
+
+//AddFromStore counts the stored tweets still inside the baseline, up to maxId
+//when it is not 0, and returns how many were added
+func (d *TrendDetector) AddFromStore(store tweetstore.TweetStore, maxId int64) (int, error) {
+    q := tweetstore.TweetQuery{
+        From:  time.Now().Add(-(d.cfg.Window + d.cfg.Baseline)),
+        MaxId: maxId,
+        Limit: tweetstore.MaxQueryLimit,
+    }
+    n := 0
+    err := tweetstore.EachTweet(store, q, func(tweet *twittertypes.Tweet) bool {
+        d.Add(tweet)
+        n++
+        return true
+    })
+    return n, err
+}
+
+//Trends runs a detector over a.Tweets
+func (a *Analytics) Trends(cfg TrendConfig, now time.Time, kind string, limit int) TrendReport {
+    d := NewTrendDetector(cfg)
+    for _, t := range a.Tweets {
+        d.Add(t)
+    }
+    return d.Trends(now, kind, limit)
+}
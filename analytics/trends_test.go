@@ -0,0 +1,115 @@
+package analytics
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/fcheslack/webtypes/twitter"
+    "testing"
+    "time"
+)
+
+//jsonTweet is the tweet of a stored json line, which keeps the line in RawBytes
+func jsonTweet(t *testing.T, line string) *twittertypes.Tweet {
+    t.Helper()
+    tweet := &twittertypes.Tweet{}
+    err := json.Unmarshal([]byte(line), tweet)
+    if err != nil {
+        t.Fatalf("unmarshalling %s: %s", line, err)
+    }
+    tweet.RawBytes = []byte(line)
+    return tweet
+}
+
+//trendAdd adds a tweet tagged tag at each of minutesAgo, with a new id each time
+//unless id is set
+type trendAdd struct {
+    tag        string
+    minutesAgo []int
+    id         int64
+}
+
+//every lists the minutes ago from first down to last, step apart, n times each
+func every(first, last, step, n int) []int {
+    var minutes []int
+    for m := first; m >= last; m -= step {
+        for i := 0; i < n; i++ {
+            minutes = append(minutes, m)
+        }
+    }
+    return minutes
+}
+
+func TestTrends(t *testing.T) {
+    cfg := TrendConfig{Slot: time.Minute, Window: 10 * time.Minute, Baseline: time.Hour, MinCount: 3}
+    //one tweet every ten minutes from an hour ago, about one expected in the window
+    steady := trendAdd{tag: "steady", minutesAgo: every(65, 5, 10, 1)}
+    for _, c := range []struct {
+        name    string
+        adds    []trendAdd
+        rising  []string
+        falling []string
+    }{
+        {"steady", []trendAdd{steady}, nil, nil},
+        {"rising", []trendAdd{steady, {tag: "surge", minutesAgo: every(8, 1, 1, 1)}}, []string{"surge"}, nil},
+        {"rising from a baseline", []trendAdd{steady, {tag: "growing", minutesAgo: append(every(65, 15, 10, 1), every(8, 1, 1, 2)...)}}, []string{"growing"}, nil},
+        {"falling", []trendAdd{steady, {tag: "fading", minutesAgo: every(65, 15, 10, 4)}}, nil, []string{"fading"}},
+        {"too few to rise", []trendAdd{steady, {tag: "blip", minutesAgo: every(3, 2, 1, 1)}}, nil, nil},
+        //the same tweet saved again, as on a restart, counts once
+        {"re-saved tweet", []trendAdd{steady, {tag: "resaved", minutesAgo: every(2, 2, 1, 8), id: 9999}}, nil, nil},
+        {"older than the baseline", []trendAdd{steady, {tag: "ancient", minutesAgo: every(200, 100, 10, 5)}}, nil, nil},
+    } {
+        d := NewTrendDetector(cfg)
+        now := time.Now()
+        id := int64(1)
+        for _, a := range c.adds {
+            for _, m := range a.minutesAgo {
+                tweetId := a.id
+                if tweetId == 0 {
+                    tweetId = id
+                    id++
+                }
+                created := now.Add(-time.Duration(m)*time.Minute + time.Second)
+                tweet := jsonTweet(t, fmt.Sprintf(`{"id":%d,"id_str":"%d","text":"#%s","created_at":%q,"entities":{"hashtags":[{"text":%q,"indices":[0,0]}],"urls":[],"user_mentions":[],"media":[]}}`,
+                    tweetId, tweetId, a.tag, created.Format(time.RubyDate), a.tag))
+                d.AddAt(tweet, created)
+            }
+        }
+        r := d.Trends(now, TrendHashtag, 10)
+        for _, list := range []struct {
+            name   string
+            trends []Trend
+            want   []string
+        }{{"rising", r.Rising, c.rising}, {"falling", r.Falling, c.falling}} {
+            keys := make([]string, 0, len(list.trends))
+            for _, tr := range list.trends {
+                keys = append(keys, tr.Key)
+                if tr.Kind != TrendHashtag {
+                    t.Errorf("%s: %s trend of kind %s", c.name, list.name, tr.Kind)
+                }
+                if list.name == "rising" && (tr.Score <= 0 || float64(tr.Count) <= tr.Expected) {
+                    t.Errorf("%s: rising %+v", c.name, tr)
+                }
+                if list.name == "falling" && (tr.Score >= 0 || float64(tr.Count) >= tr.Expected) {
+                    t.Errorf("%s: falling %+v", c.name, tr)
+                }
+            }
+            if fmt.Sprint(keys) != fmt.Sprint(list.want) {
+                t.Errorf("%s: %s %v, want %v", c.name, list.name, keys, list.want)
+            }
+        }
+    }
+}
+
+//hashtags, urls and terms are counted as separate kinds, each once per tweet
+func TestTrendKeys(t *testing.T) {
+    tweet := jsonTweet(t, `{"id":1,"id_str":"1","text":"Launch launch day #Launch http://t.co/x","entities":{"hashtags":[{"text":"Launch","indices":[0,0]},{"text":"launch","indices":[0,0]}],"urls":[{"url":"http://t.co/x","expanded_url":"http://example.com/launch","display_url":"example.com/launch","indices":[0,0]}],"user_mentions":[],"media":[]}}`)
+    keys := trendKeys(tweet)
+    for _, k := range []trendKey{{TrendHashtag, "launch"}, {TrendUrl, "http://example.com/launch"}, {TrendTerm, "launch"}, {TrendTerm, "day"}} {
+        if !keys[k] {
+            t.Errorf("no %s key %q in %v", k.kind, k.key, keys)
+        }
+    }
+    if len(keys) != 4 {
+        t.Errorf("keys %v, want 4", keys)
+    }
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "github.com/fcheslack/tweetlog/tweetstore"
+    "io/ioutil"
+    "strconv"
+    "time"
+)
+
+//DehydrateManifest is written next to a dehydrated id list so the dataset can be
+//described and verified without sharing any tweet content
+type DehydrateManifest struct {
+    IdsFile          string
+    Query            tweetstore.DehydrateQuery
+    StartTime        string `json:",omitempty"`
+    EndTime          string `json:",omitempty"`
+    Count            int
+    CollectionMethod CollectionMethod
+    SHA256           string
+    Created          string
+}
+
+//CollectionMethodSource describes how the archive collects tweets, for manifests
+const CollectionMethodSource = "twitter user stream with track terms, backfilled from REST search and timelines"
+
+//CollectionMethod is how the dehydrated tweets were collected: the track terms and
+//screen name in effect, after any command line overrides of the ArchiveConfig
+type CollectionMethod struct {
+    Source     string
+    Track      []string
+    ScreenName string `json:",omitempty"`
+}
+
+//Write the ids of tweets matching q to outfile, one per line, and a manifest to outfile.manifest.json
+func Dehydrate(store tweetstore.TweetStore, q tweetstore.DehydrateQuery, method CollectionMethod, outfile string) (*DehydrateManifest, error) {
+    ids, err := store.DehydrateIds(q)
+    if err != nil {
+        return nil, err
+    }
+
+    var buf bytes.Buffer
+    for _, id := range ids {
+        buf.WriteString(strconv.FormatInt(id, 10))
+        buf.WriteByte('\n')
+    }
+    err = ioutil.WriteFile(outfile, buf.Bytes(), 0644)
+    if err != nil {
+        fmt.Printf("Error writing dehydrated ids: %s\n", err)
+        return nil, err
+    }
+    sum := sha256.Sum256(buf.Bytes())
+
+    manifest := &DehydrateManifest{
+        IdsFile:          outfile,
+        Query:            q,
+        Count:            len(ids),
+        CollectionMethod: method,
+        SHA256:           hex.EncodeToString(sum[:]),
+        Created:          time.Now().UTC().Format(time.RFC3339),
+    }
+    if !q.StartTime.IsZero() {
+        manifest.StartTime = q.StartTime.UTC().Format(time.RFC3339)
+    }
+    if !q.EndTime.IsZero() {
+        manifest.EndTime = q.EndTime.UTC().Format(time.RFC3339)
+    }
+
+    j, err := json.MarshalIndent(manifest, "", "    ")
+    if err != nil {
+        fmt.Printf("Error marshalling dehydrate manifest: %s\n", err)
+        return nil, err
+    }
+    err = ioutil.WriteFile(outfile+".manifest.json", j, 0644)
+    if err != nil {
+        fmt.Printf("Error writing dehydrate manifest: %s\n", err)
+        return nil, err
+    }
+    return manifest, nil
+}
+
+//Parse a command line time given either as RFC3339 or as a plain date
+func ParseTimeArg(s string) (time.Time, error) {
+    return ParseTimeArgIn(s, time.UTC)
+}
+
+//ParseTimeArgIn is ParseTimeArg with YYYY-MM-DD dates taken as midnight in loc
+func ParseTimeArgIn(s string, loc *time.Location) (time.Time, error) {
+    if s == "" {
+        return time.Time{}, nil
+    }
+    t, err := time.Parse(time.RFC3339, s)
+    if err == nil {
+        return t, nil
+    }
+    return time.ParseInLocation("2006-01-02", s, loc)
+}
@@ -0,0 +1,132 @@
+package langid
+
+//corpus is the sample text the latin script profiles are built from. Every language
+//says the same everyday, tweet-like things, so topics don't tell them apart, only
+//the way each language spells them does.
+var corpus = map[string]string{
+    "en": `The weather is really nice today and I think we should go to the park after work. Did you see the
+        game last night? What a great match, I could not believe the final score. Thanks everyone for coming to
+        the meeting this morning, we will share the slides with you soon. I have been waiting for this new album
+        for years and it is finally here. Breaking news: the government announced new measures to help people
+        who lost their jobs. Please share this with your friends and family. My phone battery always dies when I
+        need it the most. Happy birthday to my best friend, love you so much! We are looking for volunteers to
+        help with the event next weekend. Can anyone recommend a good book to read on holiday? The traffic in
+        the city was terrible this evening, it took me two hours to get home. Don't forget to vote tomorrow,
+        every voice matters. This is the best coffee shop in town, you should try their cakes. I just watched
+        that movie and honestly it was much better than I expected. People are talking about the election
+        results all over the country.`,
+    "es": `Hoy hace muy buen tiempo y creo que deberíamos ir al parque después del trabajo. ¿Viste el partido
+        anoche? Qué gran partido, no me podía creer el resultado final. Gracias a todos por venir a la reunión de
+        esta mañana, pronto compartiremos las diapositivas con ustedes. Llevo años esperando este nuevo disco y
+        por fin está aquí. Última hora: el gobierno anunció nuevas medidas para ayudar a las personas que
+        perdieron su empleo. Por favor comparte esto con tus amigos y tu familia. La batería de mi teléfono
+        siempre se acaba cuando más la necesito. ¡Feliz cumpleaños a mi mejor amiga, te quiero mucho! Estamos
+        buscando voluntarios para ayudar con el evento del próximo fin de semana. ¿Alguien me recomienda un buen
+        libro para leer en vacaciones? El tráfico en la ciudad estaba fatal esta tarde, tardé dos horas en
+        llegar a casa. No olvides votar mañana, cada voz cuenta. Esta es la mejor cafetería de la ciudad, tienes
+        que probar sus pasteles. Acabo de ver esa película y la verdad es que fue mucho mejor de lo que
+        esperaba. La gente está hablando de los resultados de las elecciones en todo el país.`,
+    "fr": `Il fait vraiment beau aujourd'hui et je pense qu'on devrait aller au parc après le travail. Tu as vu
+        le match hier soir ? Quel beau match, je n'arrivais pas à croire le score final. Merci à tous d'être
+        venus à la réunion ce matin, nous allons bientôt partager les diapositives avec vous. J'attends ce nouvel
+        album depuis des années et il est enfin là. Dernière minute : le gouvernement a annoncé de nouvelles
+        mesures pour aider les personnes qui ont perdu leur emploi. Partagez ceci avec vos amis et votre
+        famille. La batterie de mon téléphone se vide toujours quand j'en ai le plus besoin. Joyeux anniversaire
+        à ma meilleure amie, je t'aime tellement ! Nous cherchons des bénévoles pour nous aider avec l'événement
+        du week-end prochain. Quelqu'un peut me conseiller un bon livre à lire pendant les vacances ? La
+        circulation en ville était horrible ce soir, j'ai mis deux heures pour rentrer chez moi. N'oubliez pas
+        de voter demain, chaque voix compte. C'est le meilleur café de la ville, vous devez goûter leurs
+        gâteaux. Je viens de voir ce film et franchement il était bien meilleur que ce que j'attendais. Les gens
+        parlent des résultats des élections dans tout le pays.`,
+    "de": `Das Wetter ist heute wirklich schön und ich finde, wir sollten nach der Arbeit in den Park gehen.
+        Hast du gestern Abend das Spiel gesehen? Was für ein tolles Spiel, ich konnte das Endergebnis kaum
+        glauben. Danke an alle, die heute Morgen zum Treffen gekommen sind, wir schicken euch die Folien bald.
+        Ich warte seit Jahren auf dieses neue Album und jetzt ist es endlich da. Eilmeldung: Die Regierung hat
+        neue Maßnahmen angekündigt, um Menschen zu helfen, die ihre Arbeit verloren haben. Bitte teilt das mit
+        euren Freunden und eurer Familie. Der Akku meines Handys ist immer leer, wenn ich es am meisten brauche.
+        Alles Gute zum Geburtstag an meine beste Freundin, ich hab dich so lieb! Wir suchen Freiwillige, die uns
+        bei der Veranstaltung am nächsten Wochenende helfen. Kann mir jemand ein gutes Buch für den Urlaub
+        empfehlen? Der Verkehr in der Stadt war heute Abend schrecklich, ich habe zwei Stunden nach Hause
+        gebraucht. Vergesst nicht, morgen wählen zu gehen, jede Stimme zählt. Das ist das beste Café der Stadt,
+        ihr müsst unbedingt den Kuchen probieren. Ich habe gerade den Film gesehen und ehrlich gesagt war er
+        viel besser als erwartet. Im ganzen Land reden die Leute über die Ergebnisse der Wahl.`,
+    "pt": `O tempo está muito bom hoje e acho que devíamos ir ao parque depois do trabalho. Você viu o jogo
+        ontem à noite? Que jogo incrível, não consegui acreditar no resultado final. Obrigado a todos por virem à
+        reunião hoje de manhã, vamos compartilhar os slides com vocês em breve. Estou esperando esse novo álbum
+        há anos e finalmente ele chegou. Urgente: o governo anunciou novas medidas para ajudar as pessoas que
+        perderam o emprego. Por favor compartilhe isso com seus amigos e sua família. A bateria do meu celular
+        sempre acaba quando eu mais preciso. Feliz aniversário para a minha melhor amiga, te amo muito! Estamos
+        procurando voluntários para ajudar no evento do próximo fim de semana. Alguém pode recomendar um bom
+        livro para ler nas férias? O trânsito na cidade estava horrível hoje à noite, demorei duas horas para
+        chegar em casa. Não esqueça de votar amanhã, cada voto conta. Essa é a melhor cafeteria da cidade, vocês
+        precisam provar os bolos. Acabei de assistir esse filme e sinceramente foi muito melhor do que eu
+        esperava. As pessoas estão falando sobre o resultado das eleições em todo o país.`,
+    "it": `Oggi il tempo è davvero bello e penso che dovremmo andare al parco dopo il lavoro. Hai visto la
+        partita ieri sera? Che bella partita, non riuscivo a credere al risultato finale. Grazie a tutti per
+        essere venuti alla riunione di stamattina, condivideremo presto le slide con voi. Aspetto questo nuovo
+        album da anni e finalmente è arrivato. Ultima ora: il governo ha annunciato nuove misure per aiutare le
+        persone che hanno perso il lavoro. Per favore condividete questo con i vostri amici e la vostra
+        famiglia. La batteria del mio telefono si scarica sempre quando ne ho più bisogno. Buon compleanno alla
+        mia migliore amica, ti voglio tanto bene! Stiamo cercando volontari per aiutarci con l'evento del
+        prossimo fine settimana. Qualcuno mi consiglia un buon libro da leggere in vacanza? Il traffico in città
+        stasera era terribile, ci ho messo due ore per tornare a casa. Non dimenticate di votare domani, ogni
+        voce conta. Questo è il miglior bar della città, dovete assaggiare i loro dolci. Ho appena visto quel
+        film e sinceramente era molto meglio di quanto mi aspettassi. La gente parla dei risultati delle
+        elezioni in tutto il paese.`,
+    "nl": `Het is vandaag echt mooi weer en ik denk dat we na het werk naar het park moeten gaan. Heb je
+        gisteravond de wedstrijd gezien? Wat een geweldige wedstrijd, ik kon de eindstand bijna niet geloven.
+        Bedankt allemaal voor het komen naar de vergadering vanochtend, we delen de slides binnenkort met jullie.
+        Ik wacht al jaren op dit nieuwe album en eindelijk is het er. Laatste nieuws: de regering heeft nieuwe
+        maatregelen aangekondigd om mensen te helpen die hun baan zijn kwijtgeraakt. Deel dit alsjeblieft met je
+        vrienden en familie. De batterij van mijn telefoon is altijd leeg als ik hem het meest nodig heb.
+        Gefeliciteerd met je verjaardag, beste vriendin, ik hou zoveel van je! We zoeken vrijwilligers om te
+        helpen bij het evenement van volgend weekend. Kan iemand een goed boek aanraden om op vakantie te lezen?
+        Het verkeer in de stad was vanavond verschrikkelijk, ik deed er twee uur over om thuis te komen. Vergeet
+        morgen niet te stemmen, elke stem telt. Dit is het beste koffiehuis van de stad, je moet hun taart
+        proberen. Ik heb net die film gezien en eerlijk gezegd was hij veel beter dan ik had verwacht. In het
+        hele land praten mensen over de uitslag van de verkiezingen.`,
+    "sv": `Vädret är verkligen fint idag och jag tycker att vi ska gå till parken efter jobbet. Såg du matchen
+        igår kväll? Vilken fantastisk match, jag kunde inte tro på slutresultatet. Tack alla för att ni kom till
+        mötet i morse, vi delar bilderna med er snart. Jag har väntat på det här nya albumet i flera år och nu är
+        det äntligen här. Senaste nytt: regeringen har meddelat nya åtgärder för att hjälpa människor som har
+        förlorat sina jobb. Dela gärna det här med dina vänner och din familj. Batteriet i min telefon tar
+        alltid slut när jag behöver den som mest. Grattis på födelsedagen till min bästa vän, jag älskar dig så
+        mycket! Vi söker volontärer som kan hjälpa till med evenemanget nästa helg. Kan någon rekommendera en bra
+        bok att läsa på semestern? Trafiken i stan var hemsk i kväll, det tog mig två timmar att komma hem. Glöm
+        inte att rösta i morgon, varje röst räknas. Det här är stadens bästa kafé, ni måste prova deras kakor.
+        Jag såg precis den filmen och ärligt talat var den mycket bättre än jag hade trott. Folk i hela landet
+        pratar om valresultatet.`,
+    "tr": `Bugün hava gerçekten çok güzel ve bence işten sonra parka gitmeliyiz. Dün akşamki maçı izledin mi?
+        Ne harika bir maçtı, son skora inanamadım. Bu sabah toplantıya gelen herkese teşekkürler, sunumu yakında
+        sizinle paylaşacağız. Bu yeni albümü yıllardır bekliyordum ve sonunda çıktı. Son dakika: hükümet işini
+        kaybeden insanlara yardım etmek için yeni önlemler açıkladı. Lütfen bunu arkadaşlarınız ve ailenizle
+        paylaşın. Telefonumun şarjı hep en çok ihtiyacım olduğunda bitiyor. En iyi arkadaşımın doğum günü kutlu
+        olsun, seni çok seviyorum! Gelecek hafta sonu etkinlikte yardım edecek gönüllüler arıyoruz. Tatilde
+        okumak için iyi bir kitap önerebilecek var mı? Bu akşam şehirde trafik berbattı, eve gitmem iki saat
+        sürdü. Yarın oy vermeyi unutmayın, her ses önemli. Burası şehrin en iyi kahvecisi, pastalarını mutlaka
+        denemelisiniz. O filmi yeni izledim ve açıkçası beklediğimden çok daha iyiydi. Bütün ülkede insanlar
+        seçim sonuçlarını konuşuyor.`,
+    "id": `Cuaca hari ini sangat cerah dan aku pikir kita harus pergi ke taman setelah kerja. Kamu nonton
+        pertandingan tadi malam? Pertandingan yang luar biasa, aku tidak percaya dengan skor akhirnya. Terima
+        kasih semuanya sudah datang ke rapat tadi pagi, kami akan segera membagikan slide kepada kalian. Aku
+        sudah menunggu album baru ini selama bertahun-tahun dan akhirnya keluar juga. Berita terkini: pemerintah
+        mengumumkan langkah baru untuk membantu orang yang kehilangan pekerjaan. Tolong bagikan ini dengan teman
+        dan keluarga kalian. Baterai ponselku selalu habis saat aku paling membutuhkannya. Selamat ulang tahun
+        untuk sahabatku, aku sayang banget sama kamu! Kami sedang mencari relawan untuk membantu acara akhir
+        pekan depan. Ada yang bisa merekomendasikan buku yang bagus untuk dibaca saat liburan? Lalu lintas di
+        kota sangat macet sore ini, butuh dua jam untuk sampai di rumah. Jangan lupa memilih besok, setiap suara
+        itu penting. Ini kedai kopi terbaik di kota, kalian harus coba kuenya. Aku baru saja menonton film itu
+        dan jujur jauh lebih bagus dari yang aku harapkan. Orang-orang di seluruh negeri sedang membicarakan
+        hasil pemilu.`,
+    "pl": `Pogoda jest dzisiaj naprawdę piękna i myślę, że po pracy powinniśmy iść do parku. Widziałeś wczoraj
+        mecz? Co za wspaniały mecz, nie mogłem uwierzyć w końcowy wynik. Dziękuję wszystkim za przyjście na
+        dzisiejsze spotkanie, wkrótce udostępnimy wam slajdy. Czekałem na ten nowy album od lat i w końcu jest.
+        Pilne: rząd ogłosił nowe działania, aby pomóc ludziom, którzy stracili pracę. Proszę, udostępnijcie to
+        swoim znajomym i rodzinie. Bateria w moim telefonie zawsze się kończy, kiedy najbardziej jej potrzebuję.
+        Wszystkiego najlepszego z okazji urodzin dla mojej najlepszej przyjaciółki, bardzo cię kocham! Szukamy
+        wolontariuszy do pomocy przy wydarzeniu w przyszły weekend. Czy ktoś może polecić dobrą książkę do
+        czytania na wakacjach? Korki w mieście były dziś wieczorem okropne, jechałem do domu dwie godziny. Nie
+        zapomnijcie jutro zagłosować, każdy głos się liczy. To najlepsza kawiarnia w mieście, musicie spróbować
+        ich ciast. Właśnie obejrzałem ten film i szczerze mówiąc był dużo lepszy, niż się spodziewałem. Ludzie w
+        całym kraju rozmawiają o wynikach wyborów.`,
+}
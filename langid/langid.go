@@ -0,0 +1,254 @@
+package langid
+
+import (
+    "math"
+    "regexp"
+    "sort"
+    "strings"
+    "unicode"
+)
+
+//Undetermined is the code for text too short or too mixed to tell, as twitter writes it
+const Undetermined = "und"
+
+//MinConfidence is how sure Detect must be of a latin script language to name it
+const MinConfidence = 0.7
+
+//minLetters is the least latin script text worth comparing with the profiles
+const minLetters = 8
+
+//maxGram is the longest character sequence in a profile
+const maxGram = 3
+
+//Result is a detected language and how sure Detect is of it, from 0 to 1
+type Result struct {
+    Lang       string
+    Confidence float64
+}
+
+//scriptLangs name the language of text in a script only one language, or one by far,
+//is tweeted in. Refinements for the scripts shared by several are in scriptLang.
+var scriptLangs = []struct {
+    script *unicode.RangeTable
+    lang   string
+}{
+    {unicode.Hangul, "ko"},
+    {unicode.Hiragana, "ja"},
+    {unicode.Katakana, "ja"},
+    {unicode.Han, "zh"},
+    {unicode.Cyrillic, "ru"},
+    {unicode.Arabic, "ar"},
+    {unicode.Hebrew, "he"},
+    {unicode.Greek, "el"},
+    {unicode.Thai, "th"},
+    {unicode.Devanagari, "hi"},
+    {unicode.Bengali, "bn"},
+    {unicode.Tamil, "ta"},
+    {unicode.Armenian, "hy"},
+    {unicode.Georgian, "ka"},
+}
+
+//aliases are the old codes twitter still sends for some languages
+var aliases = map[string]string{
+    "in": "id",
+    "iw": "he",
+    "ji": "yi",
+}
+
+//Normalize lower cases a language code, drops its region and replaces old codes,
+//so twitter's codes and Detect's can be compared
+func Normalize(code string) string {
+    code = strings.ToLower(strings.TrimSpace(code))
+    if i := strings.IndexAny(code, "-_"); i > 0 {
+        code = code[:i]
+    }
+    if alias, ok := aliases[code]; ok {
+        return alias
+    }
+    return code
+}
+
+//profile is the character n-gram counts of one language's sample text
+type profile struct {
+    lang   string
+    counts [maxGram]map[string]int
+    totals [maxGram]int
+}
+
+var profiles []*profile
+
+//vocab is the number of distinct n-grams of each length over all profiles, for smoothing
+var vocab [maxGram]int
+
+func init() {
+    seen := [maxGram]map[string]bool{}
+    for n := range seen {
+        seen[n] = make(map[string]bool)
+    }
+    for lang, text := range corpus {
+        p := &profile{lang: lang}
+        for n := range p.counts {
+            p.counts[n] = make(map[string]int)
+        }
+        for _, w := range words(text) {
+            for n, grams := range wordGrams(w) {
+                for _, g := range grams {
+                    p.counts[n][g]++
+                    p.totals[n]++
+                    seen[n][g] = true
+                }
+            }
+        }
+        profiles = append(profiles, p)
+    }
+    sort.Slice(profiles, func(i, j int) bool { return profiles[i].lang < profiles[j].lang })
+    for n := range vocab {
+        vocab[n] = len(seen[n])
+    }
+}
+
+//Languages is the sorted codes Detect can return, besides Undetermined
+func Languages() []string {
+    set := map[string]bool{"uk": true, "fa": true, "ur": true}
+    for _, p := range profiles {
+        set[p.lang] = true
+    }
+    for _, s := range scriptLangs {
+        set[s.lang] = true
+    }
+    langs := make([]string, 0, len(set))
+    for lang := range set {
+        langs = append(langs, lang)
+    }
+    sort.Strings(langs)
+    return langs
+}
+
+//noise is what tweets share whatever their language: a retweet prefix, urls, mentions
+//and hashtags, which are often english in other languages' tweets
+var noise = regexp.MustCompile(`^RT @\w+:?|https?://\S+|www\.\S+|[@#][\p{L}\p{N}_]+`)
+
+//words is the lower cased runs of letters of text
+func words(text string) []string {
+    return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+        return !unicode.IsLetter(r) && !unicode.Is(unicode.Mn, r)
+    })
+}
+
+//wordGrams is the 1 to maxGram long character sequences of a word padded with spaces,
+//so the start and end of words count too
+func wordGrams(w string) [maxGram][]string {
+    var grams [maxGram][]string
+    rs := []rune(" " + w + " ")
+    for n := 1; n <= maxGram; n++ {
+        for i := 0; i+n <= len(rs); i++ {
+            g := string(rs[i : i+n])
+            if g != " " {
+                grams[n-1] = append(grams[n-1], g)
+            }
+        }
+    }
+    return grams
+}
+
+//scriptLang refines the language of a script shared by several languages by the
+//letters only some of them use
+func scriptLang(lang string, text string) string {
+    switch lang {
+    case "zh":
+        //japanese mixes kanji with kana; it is counted apart, but any kana is telling
+        for _, r := range text {
+            if unicode.In(r, unicode.Hiragana, unicode.Katakana) {
+                return "ja"
+            }
+        }
+    case "ru":
+        if strings.ContainsAny(text, "іїєґІЇЄҐ") {
+            return "uk"
+        }
+    case "ar":
+        if strings.ContainsAny(text, "ٹڈڑںے") {
+            return "ur"
+        }
+        if strings.ContainsAny(text, "پچژگکی") {
+            return "fa"
+        }
+    }
+    return lang
+}
+
+//Detect identifies the language of a tweet's text. Text in a script of its own is
+//named by the script; latin script text is scored against character n-gram profiles
+//of the bundled sample text, and is Undetermined when short or unclear.
+func Detect(text string) Result {
+    text = noise.ReplaceAllString(text, " ")
+    latin := 0
+    scripts := make(map[string]int)
+    for _, r := range text {
+        if !unicode.IsLetter(r) {
+            continue
+        }
+        if unicode.Is(unicode.Latin, r) {
+            latin++
+            continue
+        }
+        for _, s := range scriptLangs {
+            if unicode.Is(s.script, r) {
+                scripts[s.lang]++
+                break
+            }
+        }
+    }
+    best, most := "", 0
+    for lang, n := range scripts {
+        if n > most || n == most && lang < best {
+            best, most = lang, n
+        }
+    }
+    if best == "ja" || best == "zh" {
+        //kana and kanji are both japanese
+        most = scripts["ja"] + scripts["zh"]
+    }
+    if most > 0 && most >= latin {
+        return Result{Lang: scriptLang(best, text), Confidence: float64(most) / float64(latin+most)}
+    }
+    if latin < minLetters {
+        return Result{Lang: Undetermined}
+    }
+    return detectLatin(words(text))
+}
+
+//detectLatin scores words against every profile by naive bayes over their n-grams,
+//with add one smoothing, and turns the scores into probabilities
+func detectLatin(ws []string) Result {
+    scores := make([]float64, len(profiles))
+    grams := 0
+    for _, w := range ws {
+        for n, ngrams := range wordGrams(w) {
+            for _, g := range ngrams {
+                grams++
+                for i, p := range profiles {
+                    scores[i] += math.Log(float64(p.counts[n][g]+1) / float64(p.totals[n]+vocab[n]))
+                }
+            }
+        }
+    }
+    if grams == 0 {
+        return Result{Lang: Undetermined}
+    }
+    best := 0
+    for i := range scores {
+        if scores[i] > scores[best] {
+            best = i
+        }
+    }
+    sum := 0.0
+    for i := range scores {
+        sum += math.Exp(scores[i] - scores[best])
+    }
+    confidence := 1 / sum
+    if confidence < MinConfidence {
+        return Result{Lang: Undetermined, Confidence: confidence}
+    }
+    return Result{Lang: profiles[best].lang, Confidence: confidence}
+}
@@ -0,0 +1,84 @@
+package langid
+
+import (
+    "testing"
+)
+
+func TestDetect(t *testing.T) {
+    for _, c := range []struct {
+        name string
+        text string
+        lang string
+    }{
+        {"hangul", "안녕하세요 여러분 좋은 아침이에요", "ko"},
+        {"kana", "こんにちは、元気ですか", "ja"},
+        {"kanji", "東京都庁展望室", "zh"},
+        //mostly kanji, but the one kana makes it japanese
+        {"kanji with kana", "東京都庁の展望室", "ja"},
+        {"han", "我们今天去北京看朋友", "zh"},
+        {"cyrillic", "Привет, как у тебя дела сегодня?", "ru"},
+        {"ukrainian letters", "Привіт, як у тебе справи сьогодні?", "uk"},
+        {"arabic", "مرحبا بكم في المدينة الجميلة", "ar"},
+        {"persian letters", "سلام، حال شما چطور است؟", "fa"},
+        {"urdu letters", "آپ کیسے ہیں؟ میں ٹھیک ہوں", "ur"},
+        {"hebrew", "שלום לכולם, מה שלומכם היום?", "he"},
+        {"greek", "Καλημέρα σε όλους τους φίλους", "el"},
+        {"thai", "สวัสดีครับ วันนี้อากาศดีมาก", "th"},
+        {"english", "I really think we should go to the beach this weekend with everyone", "en"},
+        {"spanish", "Creo que deberíamos ir a la playa este fin de semana con todos", "es"},
+        {"french", "Je pense que nous devrions aller à la plage ce week-end avec tout le monde", "fr"},
+        {"german", "Ich glaube, wir sollten dieses Wochenende mit allen an den Strand fahren", "de"},
+        //a retweet prefix, mentions, hashtags and urls don't count
+        {"noise", "RT @someone_english: Ich glaube, wir sollten dieses Wochenende fahren #weekend http://t.co/abc", "de"},
+        {"only noise", "RT @someone: @everyone #hashtag http://t.co/abc www.example.com", Undetermined},
+        {"short latin", "ok lol", Undetermined},
+        {"digits and punctuation", "12:30 !!! 100%", Undetermined},
+        {"empty", "", Undetermined},
+        //more latin letters than kanji, but too few of them to tell
+        {"short mixed", "hello 東京", Undetermined},
+    } {
+        r := Detect(c.text)
+        if r.Lang != c.lang {
+            t.Errorf("%s: %q detected as %s (%.2f), want %s", c.name, c.text, r.Lang, r.Confidence, c.lang)
+        }
+        if r.Lang != Undetermined && (r.Confidence <= 0 || r.Confidence > 1) {
+            t.Errorf("%s: confidence %g", c.name, r.Confidence)
+        }
+    }
+}
+
+func TestNormalize(t *testing.T) {
+    for code, want := range map[string]string{
+        "en":     "en",
+        " EN-gb": "en",
+        "pt_BR":  "pt",
+        "in":     "id",
+        "iw":     "he",
+        "ji":     "yi",
+        "und":    "und",
+        "":       "",
+    } {
+        if got := Normalize(code); got != want {
+            t.Errorf("Normalize(%q) = %q, want %q", code, got, want)
+        }
+    }
+}
+
+func TestLanguages(t *testing.T) {
+    langs := Languages()
+    set := make(map[string]bool)
+    for i, lang := range langs {
+        if i > 0 && langs[i-1] >= lang {
+            t.Errorf("languages not sorted and distinct: %v", langs)
+        }
+        set[lang] = true
+    }
+    for _, lang := range []string{"en", "de", "ja", "zh", "ru", "uk", "ar", "fa", "ur"} {
+        if !set[lang] {
+            t.Errorf("no %s in %v", lang, langs)
+        }
+    }
+    if set[Undetermined] {
+        t.Errorf("%s in %v", Undetermined, langs)
+    }
+}
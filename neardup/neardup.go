@@ -0,0 +1,151 @@
+package neardup
+
+import (
+    "encoding/binary"
+    "hash/fnv"
+    "regexp"
+    "strings"
+    "unicode"
+)
+
+//NumHashes is the length of a Signature, split into NumBands bands of bandRows hashes
+const NumHashes = 64
+
+//NumBands is how many band keys Bands returns. Two texts share at least one band with
+//probability 1-(1-s^4)^16 for similarity s: about 0.64 at s=0.5 and 0.99 at s=0.7.
+const NumBands = 16
+
+const bandRows = NumHashes / NumBands
+
+//Threshold is the least estimated similarity of two texts in the same cluster
+const Threshold = 0.6
+
+//shingleSize is the length in characters of the overlapping pieces texts are compared by
+const shingleSize = 5
+
+//minLength is the shortest normalized text worth signing; short replies like "thank you"
+//are repeated everywhere without being copied
+const minLength = 30
+
+//Signature is the MinHash of a text's shingles: for each of NumHashes hash functions, the
+//least hash of any shingle. The share of equal positions estimates the jaccard similarity.
+type Signature []uint32
+
+//seeds mix each shingle hash into one of the NumHashes hash functions. They are fixed,
+//since signatures are stored and compared across runs.
+var seeds [NumHashes]uint64
+
+func init() {
+    s := uint64(0x7765657473)
+    for i := range seeds {
+        s += 0x9e3779b97f4a7c15
+        seeds[i] = mix(s)
+    }
+}
+
+//mix is the splitmix64 finalizer
+func mix(x uint64) uint64 {
+    x ^= x >> 30
+    x *= 0xbf58476d1ce4e5b9
+    x ^= x >> 27
+    x *= 0x94d049bb133111eb
+    x ^= x >> 31
+    return x
+}
+
+//noise is what copies change without changing the message: a retweet prefix, urls,
+//which are shortened differently every time, and mentions
+var noise = regexp.MustCompile(`^RT @\w+:?|https?://\S+|www\.\S+|@\w+`)
+
+//Normalize reduces text to lower cased words of letters and digits separated by single
+//spaces, without the parts of a tweet that vary between copies
+func Normalize(text string) string {
+    text = noise.ReplaceAllString(text, " ")
+    ws := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+        return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.Is(unicode.Mn, r)
+    })
+    return strings.Join(ws, " ")
+}
+
+//Sign returns the signature of a tweet's text, or nil when it is too short to tell copies apart
+func Sign(text string) Signature {
+    rs := []rune(Normalize(text))
+    if len(rs) < minLength {
+        return nil
+    }
+    sig := make(Signature, NumHashes)
+    for i := range sig {
+        sig[i] = ^uint32(0)
+    }
+    seen := make(map[uint64]bool, len(rs))
+    for i := 0; i+shingleSize <= len(rs); i++ {
+        h := fnv.New64a()
+        h.Write([]byte(string(rs[i : i+shingleSize])))
+        sh := h.Sum64()
+        if seen[sh] {
+            continue
+        }
+        seen[sh] = true
+        for j, seed := range seeds {
+            if v := uint32(mix(sh ^ seed)); v < sig[j] {
+                sig[j] = v
+            }
+        }
+    }
+    return sig
+}
+
+//Similarity estimates the jaccard similarity of the texts signed a and b, from 0 to 1
+func Similarity(a, b Signature) float64 {
+    if len(a) != NumHashes || len(b) != NumHashes {
+        return 0
+    }
+    same := 0
+    for i := range a {
+        if a[i] == b[i] {
+            same++
+        }
+    }
+    return float64(same) / NumHashes
+}
+
+//Bands hashes each band of bandRows hashes, with its position, into a key. Texts sharing
+//any band key are candidates for the same cluster.
+func (s Signature) Bands() []int64 {
+    if len(s) != NumHashes {
+        return nil
+    }
+    keys := make([]int64, NumBands)
+    buf := make([]byte, 4)
+    for b := range keys {
+        h := fnv.New64a()
+        h.Write([]byte{byte(b)})
+        for _, v := range s[b*bandRows : (b+1)*bandRows] {
+            binary.LittleEndian.PutUint32(buf, v)
+            h.Write(buf)
+        }
+        keys[b] = int64(h.Sum64())
+    }
+    return keys
+}
+
+//Bytes encodes s for storage
+func (s Signature) Bytes() []byte {
+    b := make([]byte, 4*len(s))
+    for i, v := range s {
+        binary.LittleEndian.PutUint32(b[4*i:], v)
+    }
+    return b
+}
+
+//Decode reads a signature written by Bytes, nil if b is not one
+func Decode(b []byte) Signature {
+    if len(b) != 4*NumHashes {
+        return nil
+    }
+    s := make(Signature, NumHashes)
+    for i := range s {
+        s[i] = binary.LittleEndian.Uint32(b[4*i:])
+    }
+    return s
+}
@@ -0,0 +1,91 @@
+package neardup
+
+import (
+    "reflect"
+    "testing"
+)
+
+const original = "The city council voted tonight to close the main bridge for repairs starting next week"
+
+func TestNormalize(t *testing.T) {
+    for text, want := range map[string]string{
+        original: "the city council voted tonight to close the main bridge for repairs starting next week",
+        "RT @news: Breaking!! Bridge   CLOSED... http://t.co/abc www.example.com @mayor": "breaking bridge closed",
+        "Café déjà vu, 2 times": "café déjà vu 2 times",
+        "@someone http://t.co/x": "",
+    } {
+        if got := Normalize(text); got != want {
+            t.Errorf("Normalize(%q) = %q, want %q", text, got, want)
+        }
+    }
+}
+
+func TestSign(t *testing.T) {
+    sig := Sign(original)
+    if len(sig) != NumHashes {
+        t.Fatalf("signature of %d hashes, want %d", len(sig), NumHashes)
+    }
+    for _, c := range []struct {
+        name string
+        text string
+        min  float64 //least similarity to the original
+        max  float64
+    }{
+        {"identical", original, 1, 1},
+        {"retweet", "RT @citynews: " + original, 1, 1},
+        {"url and mention", "@mayor " + original + " http://t.co/abcdef", 1, 1},
+        {"case and punctuation", "THE CITY COUNCIL voted tonight, to close the main bridge for repairs -- starting next week!", 1, 1},
+        {"small edit", "The city council voted tonight to close the main bridge for repairs starting next month", Threshold, 1},
+        {"different", "Looking forward to the football game on saturday with all my friends and family", 0, 0.2},
+    } {
+        other := Sign(c.text)
+        s := Similarity(sig, other)
+        if s < c.min || s > c.max {
+            t.Errorf("%s: similarity %.2f, want %.2f to %.2f", c.name, s, c.min, c.max)
+        }
+        if c.min == 1 && !reflect.DeepEqual(sig.Bands(), other.Bands()) {
+            t.Errorf("%s: bands differ from the original's", c.name)
+        }
+        if c.min >= Threshold && !shareBand(sig.Bands(), other.Bands()) {
+            t.Errorf("%s: no band in common", c.name)
+        }
+    }
+
+    //short replies are too common to tell copies apart
+    for _, text := range []string{"", "thank you!", "RT @someone: so true http://t.co/x", "@a @b @c http://t.co/1234567890123456789012345"} {
+        if sig := Sign(text); sig != nil {
+            t.Errorf("signature of short text %q", text)
+        }
+    }
+}
+
+func shareBand(a, b []int64) bool {
+    for i := range a {
+        if a[i] == b[i] {
+            return true
+        }
+    }
+    return false
+}
+
+func TestSimilarityOfInvalidSignatures(t *testing.T) {
+    sig := Sign(original)
+    for _, other := range []Signature{nil, sig[:NumHashes-1]} {
+        if s := Similarity(sig, other); s != 0 {
+            t.Errorf("similarity %g to a signature of %d hashes", s, len(other))
+        }
+        if other.Bands() != nil {
+            t.Errorf("bands of a signature of %d hashes", len(other))
+        }
+    }
+}
+
+func TestBytes(t *testing.T) {
+    sig := Sign(original)
+    if got := Decode(sig.Bytes()); !reflect.DeepEqual(got, sig) {
+        t.Errorf("decoded %v, want %v", got, sig)
+    }
+    if got := Decode(sig.Bytes()[1:]); got != nil {
+        t.Errorf("decoded %d bytes into %v", len(sig.Bytes())-1, got)
+    }
+}
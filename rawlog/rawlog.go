@@ -0,0 +1,302 @@
+package rawlog
+
+import (
+    "bufio"
+    "bytes"
+    "compress/gzip"
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+//segment files are named for the UTC hour their lines were received in, with a restart
+//number for each file after the first of an hour, eg 2006-01-02T15.1.jsonl.gz
+const segmentLayout = "2006-01-02T15"
+const segmentSuffix = ".jsonl.gz"
+
+//SyncPolicy controls how often captured lines are forced to disk
+type SyncPolicy int
+
+const (
+    SyncNone     SyncPolicy = iota //leave flushing to gzip and the OS
+    SyncSegment                    //fsync when a segment is rotated or closed
+    SyncInterval                   //flush and fsync new lines every Writer.SyncInterval, even if the stream goes quiet
+    SyncAlways                     //flush and fsync after every line
+)
+
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+    switch s {
+    case "none", "":
+        return SyncNone, nil
+    case "segment":
+        return SyncSegment, nil
+    case "interval":
+        return SyncInterval, nil
+    case "always":
+        return SyncAlways, nil
+    }
+    return SyncNone, fmt.Errorf("rawlog: unknown sync policy %q (none, segment, interval, always)", s)
+}
+
+//Record is one captured stream line and the time it was received. The line is stored
+//base64 encoded, byte for byte, so invalid UTF-8 isn't replaced on the way to disk.
+type Record struct {
+    ReceivedAt time.Time `json:"received_at"`
+    Line       []byte    `json:"raw"`
+}
+
+//Writer appends raw stream lines to hourly, gzip compressed JSONL segment files in Dir.
+//A segment reopened after a restart gets a new numbered file rather than appending to
+//one a crash may have cut off mid gzip member, which would hide everything after it.
+type Writer struct {
+    Dir          string
+    Policy       SyncPolicy
+    SyncInterval time.Duration
+
+    mu      sync.Mutex
+    file    *os.File
+    gz      *gzip.Writer
+    segment string
+    dirty   bool          //lines written since the last sync
+    done    chan struct{} //stops the SyncInterval ticker
+}
+
+func NewWriter(dir string, policy SyncPolicy, syncInterval time.Duration) (*Writer, error) {
+    err := os.MkdirAll(dir, 0755)
+    if err != nil {
+        return nil, err
+    }
+    if syncInterval <= 0 {
+        syncInterval = time.Second
+    }
+    w := &Writer{Dir: dir, Policy: policy, SyncInterval: syncInterval}
+    if policy == SyncInterval {
+        w.done = make(chan struct{})
+        go w.syncEvery(syncInterval, w.done)
+    }
+    return w, nil
+}
+
+//syncEvery flushes lines written since the last tick, so the last lines before a lull
+//in the stream don't sit in the gzip buffer until the next one arrives
+func (w *Writer) syncEvery(interval time.Duration, done chan struct{}) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-done:
+            return
+        case <-ticker.C:
+            w.mu.Lock()
+            if w.gz != nil && w.dirty {
+                err := w.sync()
+                if err != nil {
+                    fmt.Printf("Error syncing raw capture segment %s: %s\n", w.segment, err)
+                }
+            }
+            w.mu.Unlock()
+        }
+    }
+}
+
+//WriteLine captures line as received now
+func (w *Writer) WriteLine(line []byte) error {
+    return w.Write(time.Now(), line)
+}
+
+func (w *Writer) Write(receivedAt time.Time, line []byte) error {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+
+    segment := receivedAt.UTC().Format(segmentLayout)
+    if w.gz == nil || segment != w.segment {
+        err := w.rotate(segment)
+        if err != nil {
+            return err
+        }
+    }
+
+    j, err := json.Marshal(Record{ReceivedAt: receivedAt.UTC(), Line: line})
+    if err != nil {
+        return err
+    }
+    j = append(j, '\n')
+    _, err = w.gz.Write(j)
+    if err != nil {
+        return err
+    }
+    w.dirty = true
+
+    if w.Policy == SyncAlways {
+        return w.sync()
+    }
+    return nil
+}
+
+//Close flushes and closes the current segment and stops the SyncInterval ticker
+func (w *Writer) Close() error {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    if w.done != nil {
+        close(w.done)
+        w.done = nil
+    }
+    return w.closeSegment()
+}
+
+func (w *Writer) rotate(segment string) error {
+    err := w.closeSegment()
+    if err != nil {
+        fmt.Printf("Error closing raw capture segment %s: %s\n", w.segment, err)
+    }
+    var f *os.File
+    for n := 0; ; n++ {
+        name := segment + segmentSuffix
+        if n > 0 {
+            name = fmt.Sprintf("%s.%d%s", segment, n, segmentSuffix)
+        }
+        f, err = os.OpenFile(filepath.Join(w.Dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+        if err == nil {
+            break
+        }
+        if !os.IsExist(err) {
+            return err
+        }
+    }
+    w.file = f
+    w.gz = gzip.NewWriter(f)
+    w.segment = segment
+    return nil
+}
+
+func (w *Writer) sync() error {
+    w.dirty = false
+    err := w.gz.Flush()
+    if err != nil {
+        return err
+    }
+    return w.file.Sync()
+}
+
+func (w *Writer) closeSegment() error {
+    if w.gz == nil {
+        return nil
+    }
+    err := w.gz.Close()
+    if err == nil && w.Policy != SyncNone {
+        err = w.file.Sync()
+    }
+    cerr := w.file.Close()
+    if err == nil {
+        err = cerr
+    }
+    w.gz = nil
+    w.file = nil
+    w.dirty = false
+    return err
+}
+
+//segmentOrder splits a segment file name into its hour and restart number, 0 for the
+//first file of the hour
+func segmentOrder(path string) (string, int) {
+    name := strings.TrimSuffix(filepath.Base(path), segmentSuffix)
+    if i := strings.LastIndex(name, "."); i >= 0 {
+        if n, err := strconv.Atoi(name[i+1:]); err == nil {
+            return name[:i], n
+        }
+    }
+    return name, 0
+}
+
+//Segments returns the segment files under each path in time order.
+//A path may be a segment file or a directory of them.
+func Segments(paths ...string) ([]string, error) {
+    segments := make([]string, 0)
+    for _, path := range paths {
+        info, err := os.Stat(path)
+        if err != nil {
+            return nil, err
+        }
+        if !info.IsDir() {
+            segments = append(segments, path)
+            continue
+        }
+        matches, err := filepath.Glob(filepath.Join(path, "*"+segmentSuffix))
+        if err != nil {
+            return nil, err
+        }
+        segments = append(segments, matches...)
+    }
+    sort.Slice(segments, func(i, j int) bool {
+        hi, ni := segmentOrder(segments[i])
+        hj, nj := segmentOrder(segments[j])
+        if hi != hj {
+            return hi < hj
+        }
+        return ni < nj
+    })
+    return segments, nil
+}
+
+//ReadSegment calls fn for every record in a segment file. A segment that was cut off
+//mid-write (eg by a crash) is read up to the last complete line; the Writer never
+//appends to it again, so nothing is written past the cut.
+func ReadSegment(path string, fn func(Record) error) error {
+    f, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    gz, err := gzip.NewReader(f)
+    if err != nil {
+        return err
+    }
+    defer gz.Close()
+
+    reader := bufio.NewReader(gz)
+    for {
+        line, err := reader.ReadBytes('\n')
+        if err != nil {
+            if err == io.EOF || err == io.ErrUnexpectedEOF {
+                return nil
+            }
+            return err
+        }
+        var record Record
+        uerr := json.Unmarshal(line, &record)
+        if uerr != nil {
+            fmt.Printf("Error unmarshalling raw capture record in %s: %s\n", path, uerr)
+            continue
+        }
+        err = fn(record)
+        if err != nil {
+            return err
+        }
+    }
+}
+
+//Replay sends every captured line in segments, in order, to linechan
+func Replay(segments []string, linechan chan []byte) (int, error) {
+    count := 0
+    for _, segment := range segments {
+        err := ReadSegment(segment, func(r Record) error {
+            if len(bytes.TrimSpace(r.Line)) == 0 {
+                return nil
+            }
+            linechan <- r.Line
+            count++
+            return nil
+        })
+        if err != nil {
+            return count, fmt.Errorf("rawlog: replaying %s: %s", segment, err)
+        }
+    }
+    return count, nil
+}
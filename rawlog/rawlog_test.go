@@ -0,0 +1,144 @@
+package rawlog
+
+import (
+    "bytes"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func readLines(t *testing.T, dir string) []string {
+    t.Helper()
+    segments, err := Segments(dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    lines := make([]string, 0)
+    for _, segment := range segments {
+        err = ReadSegment(segment, func(r Record) error {
+            lines = append(lines, string(r.Line))
+            return nil
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    return lines
+}
+
+//a restart in the same hour after a crash must not hide the lines written after it
+func TestRestartAfterCrash(t *testing.T) {
+    dir := t.TempDir()
+    hour := time.Date(2026, 10, 19, 14, 0, 0, 0, time.UTC)
+
+    crashed, err := NewWriter(dir, SyncAlways, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = crashed.Write(hour.Add(time.Minute), []byte(`{"id":1}`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    //flushed but never closed, so the gzip member has no trailer
+    crashed.file.Close()
+
+    for n, line := range []string{`{"id":2}`, `{"id":3}`} {
+        w, err := NewWriter(dir, SyncAlways, 0)
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = w.Write(hour.Add(time.Duration(n+2)*time.Minute), []byte(line))
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = w.Close()
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    segments, err := Segments(dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []string{"2026-10-19T14.jsonl.gz", "2026-10-19T14.1.jsonl.gz", "2026-10-19T14.2.jsonl.gz"}
+    if len(segments) != len(want) {
+        t.Fatalf("got segments %v, want %v", segments, want)
+    }
+    for i := range want {
+        if filepath.Base(segments[i]) != want[i] {
+            t.Errorf("segment %d is %s, want %s", i, filepath.Base(segments[i]), want[i])
+        }
+    }
+
+    lines := readLines(t, dir)
+    wantLines := []string{`{"id":1}`, `{"id":2}`, `{"id":3}`}
+    if len(lines) != len(wantLines) {
+        t.Fatalf("got lines %v, want %v", lines, wantLines)
+    }
+    for i := range wantLines {
+        if lines[i] != wantLines[i] {
+            t.Errorf("line %d is %s, want %s", i, lines[i], wantLines[i])
+        }
+    }
+}
+
+//lines must reach the file within SyncInterval even when no further line arrives
+func TestSyncIntervalFlushesWhenIdle(t *testing.T) {
+    dir := t.TempDir()
+    w, err := NewWriter(dir, SyncInterval, 10*time.Millisecond)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer w.Close()
+    err = w.WriteLine([]byte(`{"id":1}`))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    deadline := time.Now().Add(2 * time.Second)
+    for {
+        lines := readLines(t, dir)
+        if len(lines) == 1 && lines[0] == `{"id":1}` {
+            return
+        }
+        if time.Now().After(deadline) {
+            t.Fatalf("line not flushed by the sync ticker, read %v", lines)
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+//lines are replayed byte for byte
+func TestLinesRoundTrip(t *testing.T) {
+    dir := t.TempDir()
+    w, err := NewWriter(dir, SyncNone, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    invalid := []byte("{\"text\":\"caf\xe9\"}")
+    err = w.WriteLine(invalid)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = w.Close()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    segments, err := Segments(dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    linechan := make(chan []byte, 10)
+    n, err := Replay(segments, linechan)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n != 1 {
+        t.Fatalf("replayed %d lines, want 1", n)
+    }
+    if got := <-linechan; !bytes.Equal(got, invalid) {
+        t.Errorf("line is %q, want %q", got, invalid)
+    }
+}
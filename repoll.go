@@ -0,0 +1,80 @@
+package main
+
+import (
+    "fmt"
+    "github.com/fcheslack/tweetlog/tweetstore"
+    "sort"
+    "time"
+)
+
+//RepollSchedule decides when the engagement metrics of recent tweets are refreshed.
+//A tweet is due once its last observation is older than a fraction of its age, so
+//new tweets are polled often and the interval stretches out as they get older.
+type RepollSchedule struct {
+    MinInterval time.Duration //never poll a tweet more often than this
+    AgeFraction float64       //poll again after this fraction of the tweet's age has passed
+    Horizon     time.Duration //stop polling tweets older than this
+}
+
+var DefaultRepollSchedule = RepollSchedule{
+    MinInterval: 5 * time.Minute,
+    AgeFraction: 0.25,
+    Horizon:     7 * 24 * time.Hour,
+}
+
+//Due reports whether c should be polled again at now
+func (rs RepollSchedule) Due(c tweetstore.RepollCandidate, now time.Time) bool {
+    age := now.Sub(c.CreatedAt)
+    if age > rs.Horizon {
+        return false
+    }
+    if c.LastObserved.IsZero() {
+        return true
+    }
+    interval := time.Duration(float64(age) * rs.AgeFraction)
+    if interval < rs.MinInterval {
+        interval = rs.MinInterval
+    }
+    return now.Sub(c.LastObserved) >= interval
+}
+
+//RepollMetrics looks up due tweets every checkEvery and sends the refreshed tweet json to
+//repollchan, so ProcessLines stays the only writer and records a new metrics snapshot for each.
+func RepollMetrics(store tweetstore.TweetStore, schedule RepollSchedule, checkEvery time.Duration, repollchan chan []byte) {
+    for {
+        now := time.Now()
+        candidates := store.RepollCandidates(now.Add(-schedule.Horizon))
+        due := make([]tweetstore.RepollCandidate, 0, len(candidates))
+        for _, c := range candidates {
+            if schedule.Due(c, now) {
+                due = append(due, c)
+            }
+        }
+        //least recently observed first, so a rate limit doesn't starve older tweets
+        sort.Slice(due, func(i, j int) bool {
+            return due[i].LastObserved.Before(due[j].LastObserved)
+        })
+        fmt.Printf("Repoll: %d of %d recent tweets due for metrics refresh\n", len(due), len(candidates))
+
+        for start := 0; start < len(due); start += 100 {
+            end := start + 100
+            if end > len(due) {
+                end = len(due)
+            }
+            ids := make([]int64, 0, end-start)
+            for _, c := range due[start:end] {
+                ids = append(ids, c.TweetId)
+            }
+            statuses, err := tr.LookupStatuses(ids)
+            if err != nil {
+                fmt.Printf("Error looking up tweets for repoll: %s\n", err)
+                break
+            }
+            for _, status := range statuses {
+                repollchan <- []byte(status)
+            }
+        }
+
+        <-time.After(checkEvery)
+    }
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+    "fmt"
+    "github.com/fcheslack/tweetlog/tweetstore"
+    "io/ioutil"
+    "os"
+    "os/signal"
+    "strconv"
+    "strings"
+    "time"
+)
+
+//Reprocess re-derives entity and derived tables from stored tweets in id order, one chunk
+//per transaction. The last finished id is written to checkpoint after every chunk so an
+//interrupted run picks up where it stopped. Interrupting with ctrl-c finishes the current chunk first.
+func Reprocess(store tweetstore.TweetStore, afterId int64, chunkSize int, checkpoint string) error {
+    if afterId == 0 && checkpoint != "" {
+        b, err := ioutil.ReadFile(checkpoint)
+        if err == nil {
+            afterId, err = strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+            if err != nil {
+                return fmt.Errorf("bad reprocess checkpoint %s: %s", checkpoint, err)
+            }
+            fmt.Printf("Resuming reprocess after tweetid %d\n", afterId)
+        }
+    }
+
+    interrupt := make(chan os.Signal, 1)
+    signal.Notify(interrupt, os.Interrupt)
+    defer signal.Stop(interrupt)
+
+    total := store.CountTweetsAfterId(afterId)
+    done := 0
+    start := time.Now()
+    for {
+        select {
+        case <-interrupt:
+            fmt.Printf("Reprocess interrupted after tweetid %d, run again to resume\n", afterId)
+            return nil
+        default:
+        }
+
+        lastId, n, err := store.ReprocessChunk(afterId, chunkSize)
+        if err != nil {
+            return err
+        }
+        if lastId == afterId {
+            break
+        }
+        afterId = lastId
+        done += n
+        if checkpoint != "" {
+            err = ioutil.WriteFile(checkpoint, []byte(strconv.FormatInt(afterId, 10)+"\n"), 0644)
+            if err != nil {
+                fmt.Printf("Error writing reprocess checkpoint: %s\n", err)
+            }
+        }
+        rate := float64(done) / time.Since(start).Seconds()
+        fmt.Printf("Reprocessed %d/%d tweets (%.0f/s), through tweetid %d\n", done, total, rate, afterId)
+    }
+
+    if checkpoint != "" {
+        os.Remove(checkpoint)
+    }
+    fmt.Printf("Reprocess finished: %d tweets in %s\n", done, time.Since(start))
+    return nil
+}
@@ -7,14 +7,15 @@ import (
     "flag"
     "fmt"
     //    "github.com/araddon/httpstream"
-    "database/sql"
     "github.com/fcheslack/webtypes/twitter"
     "github.com/kurrik/oauth1a"
+    "github.com/fcheslack/tweetlog/analytics"
+    "github.com/fcheslack/tweetlog/rawlog"
+    "github.com/fcheslack/tweetlog/serve"
     "github.com/fcheslack/tweetlog/tweetstore"
-    _ "github.com/mattn/go-sqlite3"
     "io/ioutil"
     "net/http"
-    //    "os"
+    "os"
     "strings"
     "time"
 )
@@ -22,20 +23,62 @@ import (
 var _ = ioutil.ReadAll //DEBUG
 var _ = flag.Parse     //DEBUG
 
-var ts = tweetstore.SqliteTweetStore{}
+var ts tweetstore.TweetStore
 var tr = TwitterClient{}
+var rawCapture *rawlog.Writer //set when raw stream lines should be captured before parsing
+var trendDetector *analytics.TrendDetector //set when trends are reported while streaming
+var sentimentScorer analytics.SentimentScorer //set when tweets are scored as they are saved
+var botScorer *analytics.BotScorer //set when tweets of likely automated accounts are dropped while streaming
 
 var (
     dbname        *string = flag.String("dbname", "", "SQLite3 DB")
+    dsnarg        *string = flag.String("dsn", "", "Tweet store DSN, eg sqlite3://tweets.db or postgres://user@host/db")
     trackarg      *string = flag.String("track", "", "Search Terms")
     screennamearg *string = flag.String("screen_name", "", "Screen name for user timeline")
     configfile    *string = flag.String("config", "archiveconfig.json", "Path to configuration file")
+    searcharg     *string = flag.String("search", "", "Text search for dehydrate")
+    hashtagarg    *string = flag.String("hashtag", "", "Hashtag for dehydrate")
+    fromarg       *string = flag.String("from", "", "Start time (RFC3339 or YYYY-MM-DD)")
+    toarg         *string = flag.String("to", "", "End time (RFC3339 or YYYY-MM-DD)")
+    outarg        *string = flag.String("out", "tweetids.txt", "Output file")
+    capturedir    *string = flag.String("capture", "", "Directory for raw stream capture segments (disabled if empty)")
+    capturesync   *string = flag.String("capturesync", "segment", "Raw capture fsync policy: none, segment, interval or always")
+    captureevery  *time.Duration = flag.Duration("capturesyncinterval", time.Second, "Raw capture fsync interval for the interval policy")
+    chunkarg      *int    = flag.Int("chunk", 1000, "Tweets per transaction for reprocess")
+    afterarg      *int64  = flag.Int64("after", 0, "Only reprocess tweets after this tweetid")
+    checkpointarg *string = flag.String("checkpoint", "reprocess.checkpoint", "File recording reprocess progress")
+    repollarg     *bool   = flag.Bool("repoll", false, "Refresh engagement metrics of recent tweets while streaming")
+    serveaddr     *string = flag.String("serve", "", "Also serve the archive and a live feed on this address while streaming, eg :10001")
+    noauth        *bool   = flag.Bool("noauth", false, "Serve without requiring API tokens")
+    corsarg       *string = flag.String("cors", "", "Comma separated origins allowed cross-origin requests to the server, or *")
+    tokennamearg  *string = flag.String("tokenname", "", "Name recorded with an issued API token")
+    scopesarg     *string = flag.String("scopes", tweetstore.ScopePublicSearches, "Comma separated scopes of an issued API token: read-public-searches, read-home-timeline")
+    trendsarg     *time.Duration = flag.Duration("trends", 0, "Print rising and falling trends at this interval while streaming (disabled if 0)")
+    kindarg       *string = flag.String("kind", "", "Only report trends of this kind: hashtag, url or term")
+    intervalarg   *string = flag.String("interval", tweetstore.IntervalHour, "Series bucket size: minute, hour, day or week")
+    tzarg         *string = flag.String("tz", "UTC", "Time zone series buckets are aligned in, eg America/New_York")
+    groupbyarg    *string = flag.String("groupby", "", "Group a series by hashtag, user, term, source, lang or cluster")
+    groupsarg     *int    = flag.Int("groups", tweetstore.DefaultSeriesGroups, "Number of largest groups kept in a series")
+    formatarg     *string = flag.String("format", "gexf", "User graph export format: gexf, graphml or csv")
+    relationsarg  *string = flag.String("relations", "mention,reply,retweet,quote", "Comma separated relation types in the user graph")
+    scorearg      *string = flag.String("score", analytics.ScoreNPMI, "Hashtag co-occurrence score: pmi, npmi or jaccard")
+    termsarg      *bool   = flag.Bool("terms", false, "Pair words of the tweet text as well as hashtags in co-occurrences and topics")
+    ngramsarg     *int    = flag.Int("ngrams", 2, "Longest n-gram counted by terms, 1 to 3")
+    toparg        *int    = flag.Int("top", 20, "Number of most frequent terms and n-grams, or largest clusters, reported")
+    langsarg      *string = flag.String("langs", "", "Comma separated languages whose stopwords are dropped from terms, all known if empty")
+    stopwordsarg  *string = flag.String("stopwords", "", "File of extra stopwords for terms, one per line")
+    sentimentarg  *string = flag.String("sentiment", "", "Sentiment scorer for tweets as they are saved (disabled if empty), and for the sentiment command, eg vader")
+    lexiconarg    *string = flag.String("lexicon", "", "VADER format lexicon file extending the vader scorer's bundled lexicon")
+    seriessentarg *bool   = flag.Bool("withsentiment", false, "Add the mean sentiment score of each bucket to a series")
+    excludebots   *float64 = flag.Float64("excludebots", 0, "Drop tweets of accounts with a bot score above this while streaming (disabled if 0), eg 0.5")
+    langarg       *string = flag.String("lang", "", "Only tweets in this language for dehydrate and terms, eg en, as twitter codes it or as detected")
 )
 
 type ArchiveConfig struct {
     Track        []string
     ScreenName   string
     DBName       string
+    DSN          string //selects the tweetstore backend, falls back to DBName as a SQLite file
     AccessToken  string `json:"token"`
     AccessSecret string `json:"secret"`
 }
@@ -68,13 +111,41 @@ func main() {
         archiveConfig.DBName = *dbname
     }
 
-    db, err := sql.Open("sqlite3", archiveConfig.DBName)
+    if *dsnarg != "" {
+        archiveConfig.DSN = *dsnarg
+    }
+    if archiveConfig.DSN == "" {
+        archiveConfig.DSN = archiveConfig.DBName
+    }
+
+    var err error
+    ts, err = tweetstore.Open(archiveConfig.DSN)
     if err != nil {
-        fmt.Println("Error opening sqlite3: %s\n", err)
+        fmt.Printf("Error opening tweet store: %s\n", err)
         return
     }
-    defer db.Close()
-    ts.Initialize(db)
+    defer ts.Close()
+
+    if *lexiconarg != "" {
+        f, err := os.Open(*lexiconarg)
+        if err != nil {
+            fmt.Printf("Error opening lexicon: %s\n", err)
+            return
+        }
+        err = analytics.DefaultSentimentScorer.LoadLexicon(f)
+        f.Close()
+        if err != nil {
+            fmt.Printf("Error reading lexicon: %s\n", err)
+            return
+        }
+    }
+    if *sentimentarg != "" {
+        sentimentScorer, err = analytics.SentimentScorerByName(*sentimentarg)
+        if err != nil {
+            fmt.Printf("Error choosing sentiment scorer: %s\n", err)
+            return
+        }
+    }
 
     httpClient := new(http.Client)
 
@@ -149,17 +220,451 @@ func main() {
 
         //update lastId to keep track between first round of filling and streaming start
         lastId = ts.LatestTweetId()
+        if *capturedir != "" {
+            policy, err := rawlog.ParseSyncPolicy(*capturesync)
+            if err != nil {
+                fmt.Printf("%s\n", err)
+                return
+            }
+            rawCapture, err = rawlog.NewWriter(*capturedir, policy, *captureevery)
+            if err != nil {
+                fmt.Printf("Error starting raw capture: %s\n", err)
+                return
+            }
+            defer rawCapture.Close()
+            fmt.Printf("Capturing raw stream to %s\n", *capturedir)
+        }
         //start a streaming connection
         fmt.Printf("Streaming\n")
         //make channel to accept twitter streaming lines
         lc := make(chan []byte, 100)
         //tell twitter client to start the stream, and reconnect while it can
         go tr.MaintainUserStream(track, lc)
+        var rc chan []byte
+        if *repollarg {
+            rc = make(chan []byte, 100)
+            go RepollMetrics(ts, DefaultRepollSchedule, time.Minute, rc)
+        }
+        if *trendsarg > 0 {
+            trendDetector = analytics.NewTrendDetector(analytics.DefaultTrendConfig)
+            n, err := trendDetector.AddFromStore(ts, lastId)
+            if err != nil {
+                fmt.Printf("Error loading tweets for trends: %s\n", err)
+            }
+            fmt.Printf("%d stored tweets loaded for trends\n", n)
+            go func() {
+                for range time.Tick(*trendsarg) {
+                    PrintTrends(trendDetector.Trends(time.Now(), *kindarg, 10))
+                }
+            }()
+        }
+        if *excludebots > 0 {
+            //accounts are judged on their last week, and on what they post from now on
+            cfg := analytics.DefaultBotConfig
+            cfg.Threshold = *excludebots
+            botScorer = analytics.NewBotScorer(cfg)
+            n, err := botScorer.AddFromStore(ts, time.Now().AddDate(0, 0, -7), time.Now())
+            if err != nil {
+                fmt.Printf("Error loading tweets for bot scores: %s\n", err)
+            }
+            fmt.Printf("%d stored tweets loaded for bot scores\n", n)
+        }
+        if *serveaddr != "" {
+            //in the same process the server is pushed tweets as they are saved
+            opts := tweetserver.Options{Address: *serveaddr, NoAuth: *noauth, PublicTrack: track}
+            if *corsarg != "" {
+                opts.CORSOrigins = strings.Split(*corsarg, ",")
+            }
+            tweetServer := tweetserver.NewTweetServer(ts, opts)
+            go func() {
+                err := tweetServer.ListenAndServe()
+                fmt.Printf("Error serving archive: %s\n", err)
+            }()
+        }
         //process the twitter streaming lines that come through
-        ProcessLines(lc)
+        ProcessLines(lc, rc)
 
         //TODO: last round of REST requests to make sure we didnt miss anything
 
+    case command == "dehydrate":
+        q := tweetstore.DehydrateQuery{
+            Search:     *searcharg,
+            Hashtag:    *hashtagarg,
+            ScreenName: *screennamearg,
+            Lang:       *langarg,
+        }
+        q.StartTime, err = ParseTimeArg(*fromarg)
+        if err != nil {
+            fmt.Printf("Error parsing from time: %s\n", err)
+            return
+        }
+        q.EndTime, err = ParseTimeArg(*toarg)
+        if err != nil {
+            fmt.Printf("Error parsing to time: %s\n", err)
+            return
+        }
+        method := CollectionMethod{Source: CollectionMethodSource, Track: track, ScreenName: screenname}
+        manifest, err := Dehydrate(ts, q, method, *outarg)
+        if err != nil {
+            fmt.Printf("Error dehydrating: %s\n", err)
+            return
+        }
+        fmt.Printf("%d tweet ids written to %s (sha256 %s)\n", manifest.Count, *outarg, manifest.SHA256)
+    case command == "replay":
+        //feed captured raw stream segments back through ProcessLines
+        paths := flag.Args()[1:]
+        if len(paths) == 0 && *capturedir != "" {
+            paths = []string{*capturedir}
+        }
+        segments, err := rawlog.Segments(paths...)
+        if err != nil {
+            fmt.Printf("Error finding raw capture segments: %s\n", err)
+            return
+        }
+        fmt.Printf("Replaying %d segments\n", len(segments))
+        lc := make(chan []byte, 100)
+        done := make(chan bool)
+        go func() {
+            ProcessLines(lc, nil)
+            done <- true
+        }()
+        count, err := rawlog.Replay(segments, lc)
+        close(lc)
+        <-done
+        if err != nil {
+            fmt.Printf("Error replaying: %s\n", err)
+        }
+        fmt.Printf("%d lines replayed\n", count)
+    case command == "reprocess":
+        //rebuild entity and derived tables from the stored fulltweet json
+        err := Reprocess(ts, *afterarg, *chunkarg, *checkpointarg)
+        if err != nil {
+            fmt.Printf("Error reprocessing: %s\n", err)
+        }
+    case command == "repoll":
+        //only refresh engagement metrics of recent tweets, without streaming
+        rc := make(chan []byte, 100)
+        go RepollMetrics(ts, DefaultRepollSchedule, time.Minute, rc)
+        ProcessLines(nil, rc)
+    case command == "trends":
+        //rising and falling hashtags, urls and terms of the last hour against the day before
+        d := analytics.NewTrendDetector(analytics.DefaultTrendConfig)
+        _, err := d.AddFromStore(ts, 0)
+        if err != nil {
+            fmt.Printf("Error loading tweets for trends: %s\n", err)
+            return
+        }
+        PrintTrends(d.Trends(time.Now(), *kindarg, 20))
+    case command == "series":
+        //tweet volume per aligned bucket and group as csv on stdout, the last week by default
+        loc, err := time.LoadLocation(*tzarg)
+        if err != nil {
+            fmt.Printf("Error loading time zone: %s\n", err)
+            return
+        }
+        q := tweetstore.SeriesQuery{
+            Interval:  *intervalarg,
+            Location:  loc,
+            GroupBy:   *groupbyarg,
+            Terms:     track,
+            Groups:    *groupsarg,
+            Sentiment: *seriessentarg,
+        }
+        q.Start, err = ParseTimeArgIn(*fromarg, loc)
+        if err != nil {
+            fmt.Printf("Error parsing from time: %s\n", err)
+            return
+        }
+        q.End, err = ParseTimeArgIn(*toarg, loc)
+        if err != nil {
+            fmt.Printf("Error parsing to time: %s\n", err)
+            return
+        }
+        if q.End.IsZero() {
+            q.End = time.Now()
+        }
+        if q.Start.IsZero() {
+            q.Start = q.End.AddDate(0, 0, -7)
+        }
+        series, err := ts.TweetSeries(q)
+        if err != nil {
+            fmt.Printf("Error getting tweet series: %s\n", err)
+            return
+        }
+        err = WriteSeriesCSV(os.Stdout, series)
+        if err != nil {
+            fmt.Printf("Error writing series: %s\n", err)
+        }
+    case command == "sentiment":
+        //score the stored tweets of the last day by default, replacing earlier scores
+        start, err := ParseTimeArg(*fromarg)
+        if err != nil {
+            fmt.Printf("Error parsing from time: %s\n", err)
+            return
+        }
+        end, err := ParseTimeArg(*toarg)
+        if err != nil {
+            fmt.Printf("Error parsing to time: %s\n", err)
+            return
+        }
+        if end.IsZero() {
+            end = time.Now()
+        }
+        if start.IsZero() {
+            start = end.Add(-24 * time.Hour)
+        }
+        scorer := sentimentScorer
+        if scorer == nil {
+            scorer = analytics.DefaultSentimentScorer
+        }
+        n, err := analytics.ScoreStoredTweets(ts, scorer, start, end, *chunkarg)
+        fmt.Printf("Scored %d tweets with %s\n", n, scorer.Name())
+        if err != nil {
+            fmt.Printf("Error scoring tweets: %s\n", err)
+        }
+    case command == "botscores":
+        //score the accounts that tweeted in the last week by default, store the scores
+        //so queries can leave their tweets out, and write them as csv to stdout
+        start, err := ParseTimeArg(*fromarg)
+        if err != nil {
+            fmt.Printf("Error parsing from time: %s\n", err)
+            return
+        }
+        end, err := ParseTimeArg(*toarg)
+        if err != nil {
+            fmt.Printf("Error parsing to time: %s\n", err)
+            return
+        }
+        if end.IsZero() {
+            end = time.Now()
+        }
+        if start.IsZero() {
+            start = end.AddDate(0, 0, -7)
+        }
+        a := &analytics.Analytics{Tweetstore: ts}
+        scores, err := a.AccountScores(analytics.DefaultBotConfig, start, end)
+        if err != nil {
+            fmt.Printf("Error scoring accounts: %s\n", err)
+            return
+        }
+        err = ts.BeginTransaction()
+        if err != nil {
+            fmt.Printf("Error starting transaction: %s\n", err)
+            return
+        }
+        for _, s := range scores {
+            err = ts.SaveAccountScore(s)
+            if err != nil {
+                ts.RollbackTransaction()
+                fmt.Printf("Error saving account score: %s\n", err)
+                return
+            }
+        }
+        err = ts.CommitTransaction()
+        if err != nil {
+            fmt.Printf("Error commiting account scores: %s\n", err)
+            return
+        }
+        err = analytics.WriteAccountScoresCSV(os.Stdout, scores)
+        if err != nil {
+            fmt.Printf("Error writing account scores: %s\n", err)
+        }
+    case command == "graph":
+        //the user relation graph of the last week by default, written to stdout for gephi
+        start, err := ParseTimeArg(*fromarg)
+        if err != nil {
+            fmt.Printf("Error parsing from time: %s\n", err)
+            return
+        }
+        end, err := ParseTimeArg(*toarg)
+        if err != nil {
+            fmt.Printf("Error parsing to time: %s\n", err)
+            return
+        }
+        if end.IsZero() {
+            end = time.Now()
+        }
+        if start.IsZero() {
+            start = end.AddDate(0, 0, -7)
+        }
+        a := &analytics.Analytics{Tweetstore: ts}
+        graph, err := a.UserGraph(start, end, strings.Split(*relationsarg, ",")...)
+        if err != nil {
+            fmt.Printf("Error building user graph: %s\n", err)
+            return
+        }
+        switch *formatarg {
+        case "gexf":
+            err = graph.WriteGEXF(os.Stdout)
+        case "graphml":
+            err = graph.WriteGraphML(os.Stdout)
+        case "csv":
+            err = graph.WriteEdgeCSV(os.Stdout)
+        default:
+            err = fmt.Errorf("unknown format %q", *formatarg)
+        }
+        if err != nil {
+            fmt.Printf("Error writing user graph: %s\n", err)
+        }
+    case command == "cooccurrence", command == "topics":
+        //scored hashtag pairs, or the topics grouping them, of the last day by default as csv on stdout
+        start, err := ParseTimeArg(*fromarg)
+        if err != nil {
+            fmt.Printf("Error parsing from time: %s\n", err)
+            return
+        }
+        end, err := ParseTimeArg(*toarg)
+        if err != nil {
+            fmt.Printf("Error parsing to time: %s\n", err)
+            return
+        }
+        if end.IsZero() {
+            end = time.Now()
+        }
+        if start.IsZero() {
+            start = end.Add(-24 * time.Hour)
+        }
+        cfg := analytics.DefaultTopicConfig
+        cfg.Score = *scorearg
+        cfg.Terms = *termsarg
+        c := analytics.NewTopicCounter(cfg)
+        _, err = c.AddFromStore(ts, start, end)
+        if err != nil {
+            fmt.Printf("Error loading tweets for topics: %s\n", err)
+            return
+        }
+        report := c.Report()
+        if command == "topics" {
+            err = report.WriteTopicsCSV(os.Stdout)
+        } else {
+            err = report.WritePairsCSV(os.Stdout)
+        }
+        if err != nil {
+            fmt.Printf("Error writing %s: %s\n", command, err)
+        }
+    case command == "terms":
+        //top terms and n-grams of the last day by default as csv on stdout, of -screen_name, -lang
+        //and the -search text if given, or for each track term with -groupby term
+        start, err := ParseTimeArg(*fromarg)
+        if err != nil {
+            fmt.Printf("Error parsing from time: %s\n", err)
+            return
+        }
+        end, err := ParseTimeArg(*toarg)
+        if err != nil {
+            fmt.Printf("Error parsing to time: %s\n", err)
+            return
+        }
+        if end.IsZero() {
+            end = time.Now()
+        }
+        if start.IsZero() {
+            start = end.Add(-24 * time.Hour)
+        }
+        var langs []string
+        if *langsarg != "" {
+            langs = strings.Split(*langsarg, ",")
+        }
+        tok := analytics.NewTokenizer(langs...)
+        if *stopwordsarg != "" {
+            f, err := os.Open(*stopwordsarg)
+            if err != nil {
+                fmt.Printf("Error opening stopwords: %s\n", err)
+                return
+            }
+            err = tok.AddStopwords(f)
+            f.Close()
+            if err != nil {
+                fmt.Printf("Error reading stopwords: %s\n", err)
+                return
+            }
+        }
+        q := analytics.TermQuery{From: start, To: end, ScreenName: *screennamearg, Track: *searcharg, Lang: *langarg, N: *ngramsarg, Limit: *toparg}
+        a := &analytics.Analytics{Tweetstore: ts}
+        var reports []*analytics.TermReport
+        if *groupbyarg == tweetstore.GroupTerm {
+            reports, err = a.TopTermsByTrack(tok, q, track)
+        } else {
+            var r *analytics.TermReport
+            r, err = a.TopTerms(tok, q)
+            reports = append(reports, r)
+        }
+        if err != nil {
+            fmt.Printf("Error counting terms: %s\n", err)
+            return
+        }
+        err = analytics.WriteTermsCSV(os.Stdout, reports...)
+        if err != nil {
+            fmt.Printf("Error writing terms: %s\n", err)
+        }
+    case command == "clusters":
+        //the largest clusters of copy-pasted tweets of the last day by default, with their
+        //counts per -interval bucket, as csv on stdout
+        start, err := ParseTimeArg(*fromarg)
+        if err != nil {
+            fmt.Printf("Error parsing from time: %s\n", err)
+            return
+        }
+        end, err := ParseTimeArg(*toarg)
+        if err != nil {
+            fmt.Printf("Error parsing to time: %s\n", err)
+            return
+        }
+        if end.IsZero() {
+            end = time.Now()
+        }
+        if start.IsZero() {
+            start = end.Add(-24 * time.Hour)
+        }
+        a := &analytics.Analytics{Tweetstore: ts}
+        report, err := a.Clusters(analytics.ClusterQuery{From: start, To: end, Interval: *intervalarg, Limit: *toparg})
+        if err != nil {
+            fmt.Printf("Error finding clusters: %s\n", err)
+            return
+        }
+        err = analytics.WriteClustersCSV(os.Stdout, report)
+        if err != nil {
+            fmt.Printf("Error writing clusters: %s\n", err)
+        }
+    case command == "issuetoken":
+        secret, token, err := tweetstore.NewApiToken(*tokennamearg, strings.Split(*scopesarg, ","))
+        if err != nil {
+            fmt.Printf("Error issuing token: %s\n", err)
+            return
+        }
+        err = ts.SaveApiToken(token)
+        if err != nil {
+            fmt.Printf("Error saving token: %s\n", err)
+            return
+        }
+        fmt.Printf("Issued token %s (%s) with scopes %s\n", token.Id, token.Name, strings.Join(token.Scopes, ","))
+        fmt.Printf("%s\n", secret)
+        fmt.Printf("The token is only shown once, store it now.\n")
+    case command == "revoketoken":
+        id := flag.Arg(1)
+        revoked, err := ts.RevokeApiToken(id, time.Now())
+        if err != nil {
+            fmt.Printf("Error revoking token: %s\n", err)
+            return
+        }
+        if !revoked {
+            fmt.Printf("No valid token with id %q\n", id)
+            return
+        }
+        fmt.Printf("Revoked token %s\n", id)
+    case command == "listtokens":
+        tokens, err := ts.ApiTokens()
+        if err != nil {
+            fmt.Printf("Error listing tokens: %s\n", err)
+            return
+        }
+        for _, t := range tokens {
+            status := "valid"
+            if t.Revoked() {
+                status = "revoked " + t.RevokedAt.Format(time.RFC3339)
+            }
+            fmt.Printf("%s  %-20s  %-40s  issued %s  %s\n", t.Id, t.Name, strings.Join(t.Scopes, ","), t.CreatedAt.Format(time.RFC3339), status)
+        }
     }
     /*
        results := tr.FillSearch([]string{"thatcamp"}, nil)
@@ -171,27 +676,85 @@ func main() {
     return
 }
 
-func ProcessLines(linechan chan []byte) {
+//ProcessLines handles stream lines from linechan until it is closed. Lines from repollchan
+//are refreshed copies of tweets already stored, so only their metrics are saved; they
+//aren't scored or counted for trends a second time. Either channel may be nil.
+func ProcessLines(linechan chan []byte, repollchan chan []byte) {
     for {
-        line := <-linechan
-        msg := TryTwitterTypes(line)
+        select {
+        case line, ok := <-linechan:
+            if !ok {
+                return
+            }
+            ProcessLine(line)
+        case line := <-repollchan:
+            ProcessRepoll(line)
+        }
+    }
+}
 
-        switch msg := msg.(type) {
-        case *twittertypes.Tweet:
-            //fmt.Printf("Successfully unmarshalled tweet\n")
-            now := time.Now()
-            fmt.Printf("%s %s: %s - %s\n\n", now.Format("03:04:05"), msg.User.Screen_name, msg.Text, msg.Source)
-            msg.RawBytes = line
-            err := ts.SaveTweet(msg)
+func ProcessLine(line []byte) {
+    msg := TryTwitterTypes(line)
+
+    switch msg := msg.(type) {
+    case *twittertypes.Tweet:
+        //fmt.Printf("Successfully unmarshalled tweet\n")
+        now := time.Now()
+        fmt.Printf("%s %s: %s - %s\n\n", now.Format("03:04:05"), msg.User.Screen_name, msg.Text, msg.Source)
+        msg.RawBytes = line
+        if botScorer != nil {
+            botScorer.Add(msg)
+            if score, automated := botScorer.Automated(msg.User.Id); automated {
+                fmt.Printf("Dropped tweet of likely automated account %s (score %.2f)\n", msg.User.Screen_name, score.Score)
+                return
+            }
+        }
+        err := ts.SaveTweet(msg)
+        if err != nil {
+            fmt.Printf("Error saving tweet: %s\n", err)
+            return
+        }
+        if sentimentScorer != nil {
+            err = ts.SaveSentiment(analytics.ScoreTweet(sentimentScorer, msg))
             if err != nil {
-                fmt.Printf("Tweet saved.\n")
+                fmt.Printf("Error saving sentiment: %s\n", err)
             }
-        case *twittertypes.FriendList:
-            fmt.Printf("Got Friendlist\n")
-        case *twittertypes.Event:
-            fmt.Printf("Got Event: %s\n", msg.Event)
-        default:
-            fmt.Printf("Unhandled type\n")
+        }
+        if trendDetector != nil {
+            trendDetector.Add(msg)
+        }
+    case *twittertypes.FriendList:
+        fmt.Printf("Got Friendlist\n")
+    case *twittertypes.Event:
+        fmt.Printf("Got Event: %s\n", msg.Event)
+    default:
+        fmt.Printf("Unhandled type\n")
+    }
+}
+
+//ProcessRepoll records a new metrics snapshot from a repolled tweet
+func ProcessRepoll(line []byte) {
+    tweet, ok := TryTwitterTypes(line).(*twittertypes.Tweet)
+    if !ok {
+        fmt.Printf("Repolled line is not a tweet\n")
+        return
+    }
+    tweet.RawBytes = line
+    err := ts.SaveMetrics(tweet, time.Now())
+    if err != nil {
+        fmt.Printf("Error saving repolled metrics: %s\n", err)
+    }
+}
+
+func PrintTrends(report analytics.TrendReport) {
+    fmt.Printf("Trends as of %s, last %s against the %s before\n", report.AsOf.Format(time.RFC3339), report.Window, report.Baseline)
+    for _, list := range []struct {
+        name   string
+        trends []analytics.Trend
+    }{{"Rising", report.Rising}, {"Falling", report.Falling}} {
+        fmt.Printf("%s:\n", list.name)
+        for _, t := range list.trends {
+            fmt.Printf("  %6.1f  %-7s  %s (%d, expected %.1f)\n", t.Score, t.Kind, t.Key, t.Count, t.Expected)
         }
     }
 }
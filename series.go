@@ -0,0 +1,43 @@
+package main
+
+import (
+    "encoding/csv"
+    "github.com/fcheslack/tweetlog/tweetstore"
+    "io"
+    "strconv"
+    "time"
+)
+
+//WriteSeriesCSV writes s as bucket_start,group,count rows, one per bucket and group,
+//with bucket starts in the series' location. A series with sentiment has a sentiment
+//column too, empty for buckets without scored tweets.
+func WriteSeriesCSV(w io.Writer, s *tweetstore.Series) error {
+    cw := csv.NewWriter(w)
+    withSentiment := len(s.Groups) > 0 && s.Groups[0].Sentiment != nil
+    header := []string{"bucket_start", "group", "count"}
+    if withSentiment {
+        header = append(header, "sentiment")
+    }
+    err := cw.Write(header)
+    if err != nil {
+        return err
+    }
+    for i, start := range s.Starts {
+        for _, g := range s.Groups {
+            row := []string{start.Format(time.RFC3339), g.Key, strconv.Itoa(g.Counts[i])}
+            if withSentiment {
+                mean := ""
+                if g.Sentiment[i] != nil {
+                    mean = strconv.FormatFloat(*g.Sentiment[i], 'f', 4, 64)
+                }
+                row = append(row, mean)
+            }
+            err = cw.Write(row)
+            if err != nil {
+                return err
+            }
+        }
+    }
+    cw.Flush()
+    return cw.Error()
+}
@@ -0,0 +1,137 @@
+package tweetserver
+
+import (
+    "context"
+    "fmt"
+    "github.com/fcheslack/webtypes/twitter"
+    "github.com/fcheslack/tweetlog/tweetstore"
+    "net/http"
+    "strings"
+)
+
+//tweetFilter reports whether a client may read a tweet. A nil tweetFilter allows everything.
+type tweetFilter func(*twittertypes.Tweet) bool
+
+type tokenKey struct{}
+
+//tokenFrom returns the token a request was authenticated with, nil when auth is off
+func tokenFrom(req *http.Request) *tweetstore.ApiToken {
+    t, _ := req.Context().Value(tokenKey{}).(*tweetstore.ApiToken)
+    return t
+}
+
+//presentedToken reads a bearer token from the Authorization header or, for websocket
+//and EventSource clients that can't set headers, the access_token parameter, or
+//for the html UI the cookie set by signing in
+func presentedToken(req *http.Request) string {
+    auth := req.Header.Get("Authorization")
+    if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+        return strings.TrimSpace(auth[7:])
+    }
+    if token := req.URL.Query().Get("access_token"); token != "" {
+        return token
+    }
+    if c, err := req.Cookie(uiTokenCookie); err == nil {
+        return c.Value
+    }
+    return ""
+}
+
+//needsToken is false for the pages a browser must reach before signing in
+func needsToken(req *http.Request) bool {
+    return req.URL.Path != "/ui/login" && !strings.HasPrefix(req.URL.Path, "/ui/static/")
+}
+
+//uiRequest reports whether an unauthenticated request should be sent to the sign in page rather than refused
+func uiRequest(req *http.Request) bool {
+    return req.Method == "GET" && (req.URL.Path == "/" || strings.HasPrefix(req.URL.Path, "/ui/"))
+}
+
+func writeAuthError(rw http.ResponseWriter, status int, challenge string, err error) {
+    rw.Header().Set("WWW-Authenticate", challenge)
+    writeJSONError(rw, status, err)
+}
+
+//authenticate checks the request's token and returns the request carrying it
+func (ts *TweetServer) authenticate(rw http.ResponseWriter, req *http.Request) (*http.Request, bool) {
+    if ts.noAuth || !needsToken(req) {
+        return req, true
+    }
+    secret := presentedToken(req)
+    if secret == "" && uiRequest(req) {
+        http.Redirect(rw, req, "/ui/login", http.StatusSeeOther)
+        return req, false
+    }
+    if secret == "" {
+        writeAuthError(rw, http.StatusUnauthorized, `Bearer realm="tweetlog"`, fmt.Errorf("missing bearer token"))
+        return req, false
+    }
+    token, err := ts.TweetStore.ApiTokenByHash(tweetstore.HashApiToken(secret))
+    if err != nil {
+        writeJSONError(rw, http.StatusInternalServerError, fmt.Errorf("checking token failed"))
+        return req, false
+    }
+    if (token == nil || token.Revoked()) && uiRequest(req) {
+        http.Redirect(rw, req, "/ui/login", http.StatusSeeOther)
+        return req, false
+    }
+    if token == nil || token.Revoked() {
+        writeAuthError(rw, http.StatusUnauthorized, `Bearer realm="tweetlog", error="invalid_token"`, fmt.Errorf("invalid or revoked token"))
+        return req, false
+    }
+    if !token.HasScope(tweetstore.ScopeHomeTimeline) && !token.HasScope(tweetstore.ScopePublicSearches) {
+        writeAuthError(rw, http.StatusForbidden, `Bearer realm="tweetlog", error="insufficient_scope"`, fmt.Errorf("token has no read scope"))
+        return req, false
+    }
+    return req.WithContext(context.WithValue(req.Context(), tokenKey{}, token)), true
+}
+
+//cors sets the CORS headers for allowed origins and answers preflight requests,
+//returning false when the request has been handled
+func (ts *TweetServer) cors(rw http.ResponseWriter, req *http.Request) bool {
+    origin := req.Header.Get("Origin")
+    if origin == "" {
+        return true
+    }
+    rw.Header().Add("Vary", "Origin")
+    if !ts.corsOrigins["*"] && !ts.corsOrigins[origin] {
+        return true
+    }
+    rw.Header().Set("Access-Control-Allow-Origin", origin)
+    rw.Header().Set("Access-Control-Expose-Headers", "ETag, Last-Modified")
+    if req.Method == "OPTIONS" && req.Header.Get("Access-Control-Request-Method") != "" {
+        rw.Header().Set("Access-Control-Allow-Methods", "GET, HEAD")
+        rw.Header().Set("Access-Control-Allow-Headers", "Authorization, Last-Event-ID, If-None-Match, If-Modified-Since")
+        rw.Header().Set("Access-Control-Max-Age", "600")
+        rw.WriteHeader(http.StatusNoContent)
+        return false
+    }
+    return true
+}
+
+//tweetFilter returns what the request's token may read: everything with the home
+//timeline scope, otherwise only tweets matching the archive's public track terms
+func (ts *TweetServer) tweetFilter(req *http.Request) tweetFilter {
+    token := tokenFrom(req)
+    if token == nil || token.HasScope(tweetstore.ScopeHomeTimeline) {
+        return nil
+    }
+    return ts.publicTweet
+}
+
+func (ts *TweetServer) publicTweet(tweet *twittertypes.Tweet) bool {
+    return ts.publicTrack != nil && ts.publicTrack.matches(keysFor(tweet))
+}
+
+func filterTweets(tweets []*twittertypes.Tweet, allow tweetFilter) []*twittertypes.Tweet {
+    if allow == nil {
+        return tweets
+    }
+    allowed := make([]*twittertypes.Tweet, 0, len(tweets))
+    for _, tweet := range tweets {
+        if allow(tweet) {
+            allowed = append(allowed, tweet)
+        }
+    }
+    return allowed
+}
@@ -0,0 +1,118 @@
+package tweetserver
+
+import (
+    "fmt"
+    "github.com/fcheslack/tweetlog/analytics"
+    "github.com/fcheslack/tweetlog/tweetstore"
+    "log"
+    "net/http"
+    "net/url"
+    "strconv"
+    "time"
+)
+
+const (
+    defaultClustersWindow = 24 * time.Hour
+    maxClustersWindow     = 7 * 24 * time.Hour
+    maxClustersLimit      = 100
+    maxClustersMembers    = 20
+)
+
+type clustersKey struct {
+    window   time.Duration
+    interval string
+    minSize  int
+    limit    int
+    members  int
+}
+
+//parseClustersParams reads window (a go duration, at most a week), interval (minute, hour
+//or day), min_size (the fewest tweets of a cluster), limit and members (the newest tweets
+//of each cluster to include)
+func parseClustersParams(v url.Values) (clustersKey, error) {
+    k := clustersKey{
+        window:   defaultClustersWindow,
+        interval: tweetstore.IntervalHour,
+        minSize:  tweetstore.DefaultClusterSize,
+        limit:    analytics.DefaultClusterLimit,
+    }
+    var err error
+    if s := v.Get("window"); s != "" {
+        k.window, err = time.ParseDuration(s)
+        if err != nil || k.window <= 0 || k.window > maxClustersWindow {
+            return k, fmt.Errorf("invalid window, use a duration like 24h, at most %s", maxClustersWindow)
+        }
+    }
+    if s := v.Get("interval"); s != "" {
+        switch s {
+        case tweetstore.IntervalMinute, tweetstore.IntervalHour, tweetstore.IntervalDay:
+            k.interval = s
+        default:
+            return k, fmt.Errorf("invalid interval, use minute, hour or day")
+        }
+    }
+    ints := []struct {
+        name string
+        min  int
+        max  int
+        val  *int
+    }{
+        {"min_size", 2, 1000000, &k.minSize},
+        {"limit", 1, maxClustersLimit, &k.limit},
+        {"members", 0, maxClustersMembers, &k.members},
+    }
+    for _, p := range ints {
+        if s := v.Get(p.name); s != "" {
+            *p.val, err = strconv.Atoi(s)
+            if err != nil || *p.val < p.min || *p.val > p.max {
+                return k, fmt.Errorf("invalid %s, must be %d to %d", p.name, p.min, p.max)
+            }
+        }
+    }
+    return k, nil
+}
+
+//computeClusters reports the largest clusters of the window ending now
+func (ts *TweetServer) computeClusters(k clustersKey) (*analytics.ClusterReport, error) {
+    endTime := time.Now()
+    a := &analytics.Analytics{Tweetstore: ts.TweetStore}
+    return a.Clusters(analytics.ClusterQuery{
+        From:     endTime.Add(-k.window),
+        To:       endTime,
+        Interval: k.interval,
+        MinSize:  k.minSize,
+        Limit:    k.limit,
+        Members:  k.members,
+    })
+}
+
+//clustersHandler serves the largest groups of copy-pasted tweets over ?window, with their
+//counts per ?interval, see parseClustersParams. Members of a cluster are paged through
+//with /tweets?cluster=<id>.
+func (ts *TweetServer) clustersHandler(rw http.ResponseWriter, req *http.Request) {
+    if req.Method == "GET" {
+        k, err := parseClustersParams(req.URL.Query())
+        if err != nil {
+            writeJSONError(rw, http.StatusBadRequest, err)
+            return
+        }
+        //clusters are counted over every stored tweet, not only the public searches
+        if ts.tweetFilter(req) != nil {
+            writeJSONError(rw, http.StatusForbidden, fmt.Errorf("clusters need the %s scope", tweetstore.ScopeHomeTimeline))
+            return
+        }
+        j, err := ts.cachedBody(k, func() (interface{}, error) {
+            return ts.computeClusters(k)
+        })
+        if err != nil {
+            log.Printf("Error computing clusters: %s\n", err)
+            writeJSONError(rw, http.StatusInternalServerError, fmt.Errorf("clusters failed"))
+            return
+        }
+        rw.Header().Set("Content-Type", "application/json")
+        rw.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(statsCacheTTL.Seconds())))
+        rw.Write(j)
+    } else if req.Method == "OPTIONS" {
+        rw.Header().Set("Access-Control-Allow-Methods", "GET")
+    }
+}
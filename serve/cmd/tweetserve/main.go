@@ -0,0 +1,50 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "github.com/fcheslack/tweetlog/serve"
+    "github.com/fcheslack/tweetlog/tweetstore"
+    "log"
+    "strings"
+)
+
+var (
+    port     *string = flag.String("port", "10001", "http service port")
+    dbname   *string = flag.String("dbname", "../tweets.db", "Tweet store DSN, or path to a SQLite3 DB")
+    dataPath *string = flag.String("dataPath", "./data", "Path to folder for persistent storage")
+    noauth   *bool   = flag.Bool("noauth", false, "Serve without requiring API tokens")
+    cors     *string = flag.String("cors", "", "Comma separated origins allowed cross-origin requests, or *")
+    track    *string = flag.String("publictrack", "", "Comma separated track terms of the public searches")
+)
+
+func splitList(s string) []string {
+    if s == "" {
+        return nil
+    }
+    return strings.Split(s, ",")
+}
+
+func main() {
+    flag.Parse()
+
+    store, err := tweetstore.Open(*dbname)
+    if err != nil {
+        fmt.Printf("Error opening tweet store: %s\n", err)
+        return
+    }
+    defer store.Close()
+
+    tweetServer := tweetserver.NewTweetServer(store, tweetserver.Options{
+        Address:     ":" + *port,
+        DataPath:    *dataPath,
+        //the archiver writes from its own process, so watch the store for its tweets
+        Poll:        true,
+        NoAuth:      *noauth,
+        CORSOrigins: splitList(*cors),
+        PublicTrack: splitList(*track),
+    })
+    if err := tweetServer.ListenAndServe(); err != nil {
+        log.Fatal("ListenAndServe:", err)
+    }
+}
@@ -0,0 +1,133 @@
+package tweetserver
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "github.com/fcheslack/webtypes/twitter"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+)
+
+//sseSink writes messages as server-sent events: the message Type is the event name,
+//the tweet id is the event id, and the data is the same json a websocket client gets
+type sseSink struct {
+    rw      http.ResponseWriter
+    flusher http.Flusher
+}
+
+func (s sseSink) Send(m Message) error {
+    j, err := json.Marshal(m)
+    if err != nil {
+        return err
+    }
+    var buf bytes.Buffer
+    event := m.Type
+    if event == "" {
+        event = "message"
+    }
+    fmt.Fprintf(&buf, "event: %s\n", event)
+    if tweet, ok := m.Body.(*twittertypes.Tweet); ok && tweet.Id != nil {
+        fmt.Fprintf(&buf, "id: %d\n", int64(*tweet.Id))
+    }
+    fmt.Fprintf(&buf, "data: %s\n\n", j)
+    _, err = s.rw.Write(buf.Bytes())
+    if err != nil {
+        return err
+    }
+    s.flusher.Flush()
+    return nil
+}
+
+//Close is a no-op, the stream ends when the handler returns
+func (s sseSink) Close() error {
+    return nil
+}
+
+//queryList collects a parameter given repeatedly or comma separated
+func queryList(v url.Values, name string) []string {
+    var list []string
+    for _, value := range v[name] {
+        for _, item := range strings.Split(value, ",") {
+            item = strings.TrimSpace(item)
+            if item != "" {
+                list = append(list, item)
+            }
+        }
+    }
+    return list
+}
+
+//eventsFilter builds a subscription from the track, screen_name, hashtag, has_media
+//and lang parameters, or returns nil when none are given
+func eventsFilter(v url.Values) *Filter {
+    f := &Filter{
+        Id:          "events",
+        Track:       queryList(v, "track"),
+        ScreenNames: queryList(v, "screen_name"),
+        Hashtags:    queryList(v, "hashtag"),
+        Lang:        queryList(v, "lang"),
+    }
+    f.HasMedia, _ = strconv.ParseBool(v.Get("has_media"))
+    if len(f.Track) == 0 && len(f.ScreenNames) == 0 && len(f.Hashtags) == 0 && len(f.Lang) == 0 && !f.HasMedia {
+        return nil
+    }
+    return f
+}
+
+//eventsHandler streams the live feed as server-sent events, for clients that can't use
+//websockets. A reconnecting EventSource sends Last-Event-ID, and is replayed the tweets
+//it missed the same way a websocket client connecting with ?last_id is.
+func (ts *TweetServer) eventsHandler(rw http.ResponseWriter, req *http.Request) {
+    if req.Method == "OPTIONS" {
+        rw.Header().Set("Access-Control-Allow-Methods", "GET")
+        return
+    }
+    if req.Method != "GET" {
+        rw.Header().Set("Allow", "GET")
+        writeJSONError(rw, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+        return
+    }
+    flusher, ok := rw.(http.Flusher)
+    if !ok {
+        writeJSONError(rw, http.StatusInternalServerError, fmt.Errorf("streaming not supported"))
+        return
+    }
+
+    c := newConnection(sseSink{rw: rw, flusher: flusher})
+    c.allow = ts.tweetFilter(req)
+    lastId := req.Header.Get("Last-Event-ID")
+    if lastId == "" {
+        lastId = req.URL.Query().Get("last_id")
+    }
+    if lastId != "" {
+        c.resumeFrom, _ = strconv.ParseInt(lastId, 10, 64)
+    }
+    if f := eventsFilter(req.URL.Query()); f != nil {
+        c.filters = append(c.filters, *f)
+    }
+
+    rw.Header().Set("Content-Type", "text/event-stream")
+    rw.Header().Set("Cache-Control", "no-cache")
+    rw.Header().Set("X-Accel-Buffering", "no")
+    rw.WriteHeader(http.StatusOK)
+    fmt.Fprintf(rw, "retry: 5000\n\n")
+    flusher.Flush()
+
+    select {
+    case ts.Tweethub.register <- c:
+    case <-ts.done:
+        return
+    }
+    //the hub closes c when the client goes away, which ends the writer
+    go func() {
+        <-req.Context().Done()
+        select {
+        case ts.Tweethub.unregister <- c:
+        case <-ts.done:
+        }
+    }()
+    c.writer()
+}
@@ -0,0 +1,122 @@
+package tweetserver
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+//sseEvent is one event read off a stream, its fields by name
+type sseEvent map[string]string
+
+//readEvent reads lines up to the blank line that ends an event
+func readEvent(t *testing.T, r *bufio.Reader) sseEvent {
+    t.Helper()
+    e := make(sseEvent)
+    for {
+        line, err := r.ReadString('\n')
+        if err != nil {
+            t.Fatalf("reading event stream: %s", err)
+        }
+        line = strings.TrimSuffix(line, "\n")
+        if line == "" {
+            return e
+        }
+        i := strings.Index(line, ": ")
+        if i < 0 {
+            t.Fatalf("malformed event line %q", line)
+        }
+        e[line[:i]] = line[i+2:]
+    }
+}
+
+func TestEventsResumeAndCleanup(t *testing.T) {
+    store := newTestStore(t, tweetsTestTweets...)
+    ts := NewTweetServer(store, Options{NoAuth: true})
+    ts.Run()
+    defer ts.Close()
+
+    //the handler only returns once the hub has dropped the connection
+    handlerDone := make(chan struct{}, 1)
+    srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+        ts.ServeHTTP(rw, req)
+        if req.URL.Path == "/events" {
+            handlerDone <- struct{}{}
+        }
+    }))
+    defer srv.Close()
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    req, err := http.NewRequest("GET", srv.URL+"/events", nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    req = req.WithContext(ctx)
+    req.Header.Set("Last-Event-ID", "103")
+    client := &http.Client{Timeout: 10 * time.Second}
+    resp, err := client.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        t.Fatalf("status %d", resp.StatusCode)
+    }
+    if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+        t.Errorf("content type %q", ct)
+    }
+
+    r := bufio.NewReader(resp.Body)
+    if e := readEvent(t, r); e["retry"] != "5000" {
+        t.Errorf("first event %v, want the retry interval", e)
+    }
+    //the missed tweets, oldest first, each with its id for the next Last-Event-ID
+    for _, id := range []string{"104", "105", "106"} {
+        e := readEvent(t, r)
+        if e["event"] != "tweet" || e["id"] != id {
+            t.Fatalf("got event %v, want tweet %s", e, id)
+        }
+        var m Message
+        err = json.Unmarshal([]byte(e["data"]), &m)
+        if err != nil || m.Type != "tweet" {
+            t.Errorf("tweet %s data %q: %v", id, e["data"], err)
+        }
+    }
+    e := readEvent(t, r)
+    var resumed struct {
+        Type string
+        Body resumeInfo
+    }
+    err = json.Unmarshal([]byte(e["data"]), &resumed)
+    if e["event"] != "resumed" || err != nil || resumed.Body.LastId != 106 || resumed.Body.Count != 3 || resumed.Body.Truncated {
+        t.Fatalf("got event %v, want resumed after 106", e)
+    }
+
+    //then live tweets as they are saved
+    err = store.SaveTweet(testTweet{id: 107, screenName: "dave", text: "live"}.tweet(t, time.Now()))
+    if err != nil {
+        t.Fatal(err)
+    }
+    for {
+        e = readEvent(t, r)
+        if e["event"] != "message" {
+            break
+        }
+    }
+    if e["event"] != "tweet" || e["id"] != "107" {
+        t.Fatalf("got event %v, want live tweet 107", e)
+    }
+
+    cancel()
+    select {
+    case <-handlerDone:
+    case <-time.After(5 * time.Second):
+        t.Fatalf("events handler still running after the client went away")
+    }
+}
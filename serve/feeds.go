@@ -0,0 +1,493 @@
+package tweetserver
+
+import (
+    "crypto/sha1"
+    "encoding/hex"
+    "encoding/json"
+    "encoding/xml"
+    "fmt"
+    "github.com/fcheslack/webtypes/twitter"
+    "github.com/fcheslack/tweetlog/tweetstore"
+    "html"
+    "log"
+    "net/http"
+    "net/url"
+    "path"
+    "strconv"
+    "strings"
+    "time"
+)
+
+const feedTitleLength = 80
+
+//feedEntry is a tweet prepared for any of the feed formats
+type feedEntry struct {
+    id        int64
+    url       string
+    title     string
+    html      string
+    text      string
+    author    string
+    authorUrl string
+    published time.Time
+    images    []string
+    hashtags  []string
+}
+
+//feedMedia is a media entity read from the raw tweet, since extended_entities
+//holds every photo of a multi-photo tweet where entities holds only the first
+type feedMedia struct {
+    Url             string
+    Expanded_url    string
+    Media_url_https string
+    Media_url       string
+}
+
+func tweetMedia(tweet *twittertypes.Tweet) []feedMedia {
+    if tweet.RawBytes != nil {
+        var raw struct {
+            Entities struct {
+                Media []feedMedia
+            }
+            Extended_entities struct {
+                Media []feedMedia
+            }
+        }
+        if json.Unmarshal(tweet.RawBytes, &raw) == nil {
+            if len(raw.Extended_entities.Media) > 0 {
+                return raw.Extended_entities.Media
+            }
+            if len(raw.Entities.Media) > 0 {
+                return raw.Entities.Media
+            }
+        }
+    }
+    media := make([]feedMedia, 0, len(tweet.Entities.Media))
+    for _, m := range tweet.Entities.Media {
+        media = append(media, feedMedia{Expanded_url: m.Expanded_url, Media_url: m.Media_url})
+    }
+    return media
+}
+
+//tweetTime is when a tweet was posted, falling back to the time in its snowflake id
+func tweetTime(tweet *twittertypes.Tweet, id int64) time.Time {
+    created, err := time.Parse(time.RubyDate, tweet.Created_at)
+    if err == nil {
+        return created.UTC()
+    }
+    return time.Unix(0, ((id>>22)+1288834974657)*int64(time.Millisecond)).UTC()
+}
+
+func truncateRunes(s string, n int) string {
+    r := []rune(s)
+    if len(r) <= n {
+        return s
+    }
+    return string(r[:n-1]) + "…"
+}
+
+func newFeedEntry(tweet *twittertypes.Tweet) feedEntry {
+    var e feedEntry
+    if tweet.Id != nil {
+        e.id = int64(*tweet.Id)
+    }
+    e.author = "unknown"
+    if tweet.User != nil && tweet.User.Screen_name != "" {
+        e.author = tweet.User.Screen_name
+    }
+    e.authorUrl = "https://twitter.com/" + e.author
+    e.url = fmt.Sprintf("%s/status/%d", e.authorUrl, e.id)
+    e.published = tweetTime(tweet, e.id)
+
+    //expand t.co links, and move media links out of the text into images
+    text := html.UnescapeString(tweet.Text)
+    body := html.EscapeString(text)
+    for _, u := range tweet.Entities.Urls {
+        if u.Url == "" || u.Expanded_url == "" {
+            continue
+        }
+        expanded := string(u.Expanded_url)
+        display := u.Display_url
+        if display == "" {
+            display = expanded
+        }
+        text = strings.Replace(text, u.Url, expanded, -1)
+        body = strings.Replace(body, u.Url, fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(expanded), html.EscapeString(display)), -1)
+    }
+    var mediaHtml []string
+    for _, m := range tweetMedia(tweet) {
+        if m.Url != "" {
+            text = strings.Replace(text, m.Url, "", -1)
+            body = strings.Replace(body, m.Url, "", -1)
+        }
+        img := m.Media_url_https
+        if img == "" {
+            img = m.Media_url
+        }
+        if img == "" {
+            continue
+        }
+        href := m.Expanded_url
+        if href == "" {
+            href = img
+        }
+        e.images = append(e.images, img)
+        mediaHtml = append(mediaHtml, fmt.Sprintf(`<p><a href="%s"><img src="%s" alt=""></a></p>`, html.EscapeString(href), html.EscapeString(img)))
+    }
+    e.text = strings.TrimSpace(text)
+    e.html = "<p>" + strings.Replace(strings.TrimSpace(body), "\n", "<br>", -1) + "</p>" + strings.Join(mediaHtml, "")
+    e.title = "@" + e.author + ": " + truncateRunes(strings.Join(strings.Fields(e.text), " "), feedTitleLength)
+    for _, ht := range tweet.Entities.Hashtags {
+        e.hashtags = append(e.hashtags, ht.Text)
+    }
+    return e
+}
+
+type atomLink struct {
+    Rel  string `xml:"rel,attr,omitempty"`
+    Type string `xml:"type,attr,omitempty"`
+    Href string `xml:"href,attr"`
+}
+
+type atomPerson struct {
+    Name string `xml:"name"`
+    Uri  string `xml:"uri,omitempty"`
+}
+
+type atomText struct {
+    Type string `xml:"type,attr,omitempty"`
+    Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+    Id        string     `xml:"id"`
+    Title     string     `xml:"title"`
+    Updated   string     `xml:"updated"`
+    Published string     `xml:"published"`
+    Links     []atomLink `xml:"link"`
+    Author    atomPerson `xml:"author"`
+    Content   atomText   `xml:"content"`
+}
+
+type atomFeed struct {
+    XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+    Id      string      `xml:"id"`
+    Title   string      `xml:"title"`
+    Updated string      `xml:"updated"`
+    Links   []atomLink  `xml:"link"`
+    Entries []atomEntry `xml:"entry"`
+}
+
+type rssGuid struct {
+    IsPermaLink bool   `xml:"isPermaLink,attr"`
+    Value       string `xml:",chardata"`
+}
+
+type rssItem struct {
+    Title       string   `xml:"title"`
+    Link        string   `xml:"link"`
+    Guid        rssGuid  `xml:"guid"`
+    PubDate     string   `xml:"pubDate"`
+    Creator     string   `xml:"http://purl.org/dc/elements/1.1/ creator"`
+    Categories  []string `xml:"category"`
+    Description string   `xml:"description"`
+}
+
+type rssChannel struct {
+    Title         string    `xml:"title"`
+    Link          string    `xml:"link"`
+    Description   string    `xml:"description"`
+    LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+    Self          atomLink  `xml:"http://www.w3.org/2005/Atom link"`
+    Items         []rssItem `xml:"item"`
+}
+
+type rssFeed struct {
+    XMLName xml.Name   `xml:"rss"`
+    Version string     `xml:"version,attr"`
+    Channel rssChannel `xml:"channel"`
+}
+
+type jsonFeedAuthor struct {
+    Name string `json:"name"`
+    Url  string `json:"url,omitempty"`
+}
+
+type jsonFeedAttachment struct {
+    Url      string `json:"url"`
+    MimeType string `json:"mime_type"`
+}
+
+type jsonFeedItem struct {
+    Id            string               `json:"id"`
+    Url           string               `json:"url"`
+    Title         string               `json:"title,omitempty"`
+    ContentHtml   string               `json:"content_html"`
+    ContentText   string               `json:"content_text"`
+    Image         string               `json:"image,omitempty"`
+    DatePublished string               `json:"date_published"`
+    DateModified  string               `json:"date_modified"`
+    Authors       []jsonFeedAuthor     `json:"authors"`
+    Tags          []string             `json:"tags,omitempty"`
+    Attachments   []jsonFeedAttachment `json:"attachments,omitempty"`
+}
+
+type jsonFeed struct {
+    Version     string         `json:"version"`
+    Title       string         `json:"title"`
+    HomePageUrl string         `json:"home_page_url"`
+    FeedUrl     string         `json:"feed_url"`
+    Items       []jsonFeedItem `json:"items"`
+}
+
+func imageMimeType(u string) string {
+    switch strings.ToLower(path.Ext(u)) {
+    case ".png":
+        return "image/png"
+    case ".gif":
+        return "image/gif"
+    case ".webp":
+        return "image/webp"
+    }
+    return "image/jpeg"
+}
+
+//feed is one requested feed: what it selects and how to render it
+type feed struct {
+    title    string
+    homePage string
+    selfUrl  string
+    format   string
+    updated  time.Time
+    entries  []feedEntry
+}
+
+func (f *feed) atom() ([]byte, error) {
+    doc := atomFeed{
+        Id:      f.selfUrl,
+        Title:   f.title,
+        Updated: f.updated.Format(time.RFC3339),
+        Links: []atomLink{
+            {Rel: "self", Type: "application/atom+xml", Href: f.selfUrl},
+            {Rel: "alternate", Type: "text/html", Href: f.homePage},
+        },
+    }
+    for _, e := range f.entries {
+        doc.Entries = append(doc.Entries, atomEntry{
+            Id:        e.url,
+            Title:     e.title,
+            Updated:   e.published.Format(time.RFC3339),
+            Published: e.published.Format(time.RFC3339),
+            Links:     []atomLink{{Rel: "alternate", Type: "text/html", Href: e.url}},
+            Author:    atomPerson{Name: e.author, Uri: e.authorUrl},
+            Content:   atomText{Type: "html", Body: e.html},
+        })
+    }
+    return marshalXML(doc)
+}
+
+func (f *feed) rss() ([]byte, error) {
+    doc := rssFeed{
+        Version: "2.0",
+        Channel: rssChannel{
+            Title:       f.title,
+            Link:        f.homePage,
+            Description: f.title,
+            Self:        atomLink{Rel: "self", Type: "application/rss+xml", Href: f.selfUrl},
+        },
+    }
+    if !f.updated.IsZero() {
+        doc.Channel.LastBuildDate = f.updated.Format(time.RFC1123Z)
+    }
+    for _, e := range f.entries {
+        doc.Channel.Items = append(doc.Channel.Items, rssItem{
+            Title:       e.title,
+            Link:        e.url,
+            Guid:        rssGuid{IsPermaLink: true, Value: e.url},
+            PubDate:     e.published.Format(time.RFC1123Z),
+            Creator:     "@" + e.author,
+            Categories:  e.hashtags,
+            Description: e.html,
+        })
+    }
+    return marshalXML(doc)
+}
+
+func (f *feed) jsonFeed() ([]byte, error) {
+    doc := jsonFeed{
+        Version:     "https://jsonfeed.org/version/1.1",
+        Title:       f.title,
+        HomePageUrl: f.homePage,
+        FeedUrl:     f.selfUrl,
+        Items:       make([]jsonFeedItem, 0, len(f.entries)),
+    }
+    for _, e := range f.entries {
+        item := jsonFeedItem{
+            Id:            strconv.FormatInt(e.id, 10),
+            Url:           e.url,
+            ContentHtml:   e.html,
+            ContentText:   e.text,
+            DatePublished: e.published.Format(time.RFC3339),
+            DateModified:  e.published.Format(time.RFC3339),
+            Authors:       []jsonFeedAuthor{{Name: "@" + e.author, Url: e.authorUrl}},
+            Tags:          e.hashtags,
+        }
+        for i, img := range e.images {
+            if i == 0 {
+                item.Image = img
+            }
+            item.Attachments = append(item.Attachments, jsonFeedAttachment{Url: img, MimeType: imageMimeType(img)})
+        }
+        doc.Items = append(doc.Items, item)
+    }
+    return json.Marshal(doc)
+}
+
+func marshalXML(doc interface{}) ([]byte, error) {
+    b, err := xml.Marshal(doc)
+    if err != nil {
+        return nil, err
+    }
+    return append([]byte(xml.Header), b...), nil
+}
+
+//etag identifies the feed's contents: the format and the tweets in it
+func (f *feed) etag() string {
+    h := sha1.New()
+    fmt.Fprintf(h, "%s", f.format)
+    for _, e := range f.entries {
+        fmt.Fprintf(h, ",%d", e.id)
+    }
+    return `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+}
+
+var feedContentTypes = map[string]string{
+    ".atom": "application/atom+xml; charset=utf-8",
+    ".rss":  "application/rss+xml; charset=utf-8",
+    ".json": "application/feed+json; charset=utf-8",
+}
+
+func requestUrl(req *http.Request) string {
+    scheme := "http"
+    if req.TLS != nil {
+        scheme = "https"
+    }
+    if proto := req.Header.Get("X-Forwarded-Proto"); proto != "" {
+        scheme = proto
+    }
+    return scheme + "://" + req.Host + req.URL.RequestURI()
+}
+
+//notModified reports whether the client's cached copy, per If-None-Match or If-Modified-Since, is current
+func notModified(req *http.Request, etag string, modified time.Time) bool {
+    if inm := req.Header.Get("If-None-Match"); inm != "" {
+        for _, tag := range strings.Split(inm, ",") {
+            tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+            if tag == etag || tag == "*" {
+                return true
+            }
+        }
+        return false
+    }
+    if ims := req.Header.Get("If-Modified-Since"); ims != "" && !modified.IsZero() {
+        t, err := http.ParseTime(ims)
+        if err == nil && !modified.Truncate(time.Second).After(t) {
+            return true
+        }
+    }
+    return false
+}
+
+//feedsHandler serves /feeds/search/{term} and /feeds/user/{screen_name} as
+//.atom, .rss or .json (JSON Feed 1.1), newest tweets first
+func (ts *TweetServer) feedsHandler(rw http.ResponseWriter, req *http.Request) {
+    if req.Method == "OPTIONS" {
+        rw.Header().Set("Access-Control-Allow-Methods", "GET, HEAD")
+        return
+    }
+    if req.Method != "GET" && req.Method != "HEAD" {
+        rw.Header().Set("Allow", "GET, HEAD")
+        writeJSONError(rw, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+        return
+    }
+
+    parts := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/feeds/"), "/", 2)
+    if len(parts) != 2 {
+        http.NotFound(rw, req)
+        return
+    }
+    ext := path.Ext(parts[1])
+    contentType, ok := feedContentTypes[ext]
+    name := strings.TrimSuffix(parts[1], ext)
+    if !ok || name == "" {
+        http.NotFound(rw, req)
+        return
+    }
+
+    q := tweetstore.TweetQuery{Limit: tweetstore.DefaultQueryLimit}
+    f := &feed{format: ext, selfUrl: requestUrl(req)}
+    switch parts[0] {
+    case "search":
+        q.Text = name
+        f.title = "Tweets matching " + name
+        f.homePage = "https://twitter.com/search?q=" + url.QueryEscape(name)
+    case "user":
+        name = strings.TrimPrefix(name, "@")
+        q.ScreenName = name
+        f.title = "Tweets from @" + name
+        f.homePage = "https://twitter.com/" + name
+    default:
+        http.NotFound(rw, req)
+        return
+    }
+
+    page := TweetsPage{}
+    var err error
+    if allow := ts.tweetFilter(req); ts.publicQuery(&q, allow) {
+        page, err = ts.queryPage(q, allow)
+        if err != nil {
+            writeJSONError(rw, http.StatusInternalServerError, fmt.Errorf("query failed"))
+            return
+        }
+    }
+    for _, tweet := range page.Tweets {
+        e := newFeedEntry(tweet)
+        if e.published.After(f.updated) {
+            f.updated = e.published
+        }
+        f.entries = append(f.entries, e)
+    }
+
+    etag := f.etag()
+    rw.Header().Set("ETag", etag)
+    if !f.updated.IsZero() {
+        rw.Header().Set("Last-Modified", f.updated.Format(http.TimeFormat))
+    }
+    if notModified(req, etag, f.updated) {
+        rw.WriteHeader(http.StatusNotModified)
+        return
+    }
+    if f.updated.IsZero() {
+        f.updated = time.Now().UTC()
+    }
+
+    var body []byte
+    switch ext {
+    case ".atom":
+        body, err = f.atom()
+    case ".rss":
+        body, err = f.rss()
+    default:
+        body, err = f.jsonFeed()
+    }
+    if err != nil {
+        log.Printf("Error marshalling feed: %s\n", err)
+        writeJSONError(rw, http.StatusInternalServerError, fmt.Errorf("feed failed"))
+        return
+    }
+    rw.Header().Set("Content-Type", contentType)
+    if req.Method == "HEAD" {
+        return
+    }
+    rw.Write(body)
+}
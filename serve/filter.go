@@ -0,0 +1,309 @@
+package tweetserver
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/fcheslack/webtypes/twitter"
+    "strings"
+    "unicode"
+)
+
+//Filter is a websocket client's subscription. A tweet matches when it matches any of
+//Track, ScreenNames or Hashtags (or when all three are empty), and also satisfies
+//HasMedia and Lang when they are set. Track terms follow the twitter streaming rules:
+//every word of a phrase must appear in the tweet, in any order. The index finds phrases
+//by their first word only, then checks the rest, so a phrase starting with a common
+//word is checked against every tweet containing that word.
+type Filter struct {
+    Id          string   `json:"id"`
+    Track       []string `json:"track,omitempty"`
+    ScreenNames []string `json:"screen_names,omitempty"`
+    Hashtags    []string `json:"hashtags,omitempty"`
+    HasMedia    bool     `json:"has_media,omitempty"`
+    Lang        []string `json:"lang,omitempty"`
+}
+
+//clientMessage is what websocket clients send: subscribe with a Filter body,
+//unsubscribe with a body of {"id": ...}, or resume with {"last_id": ...}
+type clientMessage struct {
+    Type string          `json:"Type"`
+    Body json.RawMessage `json:"Body"`
+}
+
+type subscription struct {
+    conn    *connection
+    filter  Filter
+    phrases [][]string
+    langs   map[string]bool
+}
+
+func newSubscription(c *connection, f Filter) (*subscription, error) {
+    if f.Id == "" {
+        return nil, fmt.Errorf("subscription needs an id")
+    }
+    s := &subscription{conn: c, filter: f}
+    for _, term := range f.Track {
+        phrase := textWords(term)
+        if len(phrase) > 0 {
+            s.phrases = append(s.phrases, phrase)
+        }
+    }
+    if len(f.Lang) > 0 {
+        s.langs = make(map[string]bool)
+        for _, lang := range f.Lang {
+            s.langs[strings.ToLower(lang)] = true
+        }
+    }
+    return s, nil
+}
+
+func (s *subscription) unfiltered() bool {
+    return len(s.phrases) == 0 && len(s.filter.ScreenNames) == 0 && len(s.filter.Hashtags) == 0
+}
+
+//restrictions checks the has_media and lang conditions, which narrow rather than select
+func (s *subscription) restrictions(t *tweetKeys) bool {
+    if s.filter.HasMedia && !t.hasMedia {
+        return false
+    }
+    if s.langs != nil && !s.langs[t.lang] {
+        return false
+    }
+    return true
+}
+
+//textWords lowercases text and splits it into words, treating #tag and @name as tag and name
+func textWords(text string) []string {
+    return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+        return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '_'
+    })
+}
+
+//tweetKeys is what the index needs from a tweet, computed once per broadcast
+type tweetKeys struct {
+    screenName string
+    hashtags   []string
+    words      map[string]bool
+    hasMedia   bool
+    lang       string
+}
+
+func keysFor(tweet *twittertypes.Tweet) *tweetKeys {
+    t := &tweetKeys{words: make(map[string]bool)}
+    if tweet.User != nil {
+        t.screenName = strings.ToLower(tweet.User.Screen_name)
+    }
+    for _, ht := range tweet.Entities.Hashtags {
+        t.hashtags = append(t.hashtags, strings.ToLower(ht.Text))
+    }
+    for _, w := range textWords(tweet.Text) {
+        t.words[w] = true
+    }
+    t.hasMedia = len(tweet.Entities.Media) > 0
+    if tweet.RawBytes != nil {
+        var extra struct {
+            Lang              string
+            Extended_entities struct {
+                Media []json.RawMessage
+            }
+        }
+        if json.Unmarshal(tweet.RawBytes, &extra) == nil {
+            t.lang = strings.ToLower(extra.Lang)
+            t.hasMedia = t.hasMedia || len(extra.Extended_entities.Media) > 0
+        }
+    }
+    return t
+}
+
+//matches checks s against a tweet directly, without the index
+func (s *subscription) matches(t *tweetKeys) bool {
+    if !s.restrictions(t) {
+        return false
+    }
+    if s.unfiltered() {
+        return true
+    }
+    for _, name := range s.filter.ScreenNames {
+        if strings.ToLower(strings.TrimPrefix(name, "@")) == t.screenName {
+            return true
+        }
+    }
+    for _, tag := range s.filter.Hashtags {
+        tag = strings.ToLower(strings.TrimPrefix(tag, "#"))
+        for _, ht := range t.hashtags {
+            if ht == tag {
+                return true
+            }
+        }
+    }
+    for _, phrase := range s.phrases {
+        if containsAll(t.words, phrase) {
+            return true
+        }
+    }
+    return false
+}
+
+type subscriptionSet map[*subscription]bool
+
+//filterIndex maps tweet attributes to the subscriptions that select them, so routing
+//a tweet costs a few map lookups per word instead of a check per client
+type filterIndex struct {
+    byScreenName map[string]subscriptionSet
+    byHashtag    map[string]subscriptionSet
+    byWord       map[string]subscriptionSet //keyed by the first word of each track phrase
+    unfiltered   subscriptionSet
+    byConn       map[*connection]map[string]*subscription
+}
+
+func newFilterIndex() *filterIndex {
+    return &filterIndex{
+        byScreenName: make(map[string]subscriptionSet),
+        byHashtag:    make(map[string]subscriptionSet),
+        byWord:       make(map[string]subscriptionSet),
+        unfiltered:   make(subscriptionSet),
+        byConn:       make(map[*connection]map[string]*subscription),
+    }
+}
+
+func addTo(m map[string]subscriptionSet, key string, s *subscription) {
+    if m[key] == nil {
+        m[key] = make(subscriptionSet)
+    }
+    m[key][s] = true
+}
+
+func removeFrom(m map[string]subscriptionSet, key string, s *subscription) {
+    delete(m[key], s)
+    if len(m[key]) == 0 {
+        delete(m, key)
+    }
+}
+
+//subscribed reports whether c has any subscriptions. Clients that never subscribe get everything.
+func (fi *filterIndex) subscribed(c *connection) bool {
+    return len(fi.byConn[c]) > 0
+}
+
+func (fi *filterIndex) add(s *subscription) {
+    subs := fi.byConn[s.conn]
+    if subs == nil {
+        subs = make(map[string]*subscription)
+        fi.byConn[s.conn] = subs
+    }
+    if old := subs[s.filter.Id]; old != nil {
+        fi.remove(old)
+    }
+    subs[s.filter.Id] = s
+
+    if s.unfiltered() {
+        fi.unfiltered[s] = true
+        return
+    }
+    for _, name := range s.filter.ScreenNames {
+        addTo(fi.byScreenName, strings.ToLower(strings.TrimPrefix(name, "@")), s)
+    }
+    for _, tag := range s.filter.Hashtags {
+        addTo(fi.byHashtag, strings.ToLower(strings.TrimPrefix(tag, "#")), s)
+    }
+    for _, phrase := range s.phrases {
+        addTo(fi.byWord, phrase[0], s)
+    }
+}
+
+func (fi *filterIndex) remove(s *subscription) {
+    delete(fi.unfiltered, s)
+    for _, name := range s.filter.ScreenNames {
+        removeFrom(fi.byScreenName, strings.ToLower(strings.TrimPrefix(name, "@")), s)
+    }
+    for _, tag := range s.filter.Hashtags {
+        removeFrom(fi.byHashtag, strings.ToLower(strings.TrimPrefix(tag, "#")), s)
+    }
+    for _, phrase := range s.phrases {
+        removeFrom(fi.byWord, phrase[0], s)
+    }
+    if subs := fi.byConn[s.conn]; subs != nil && subs[s.filter.Id] == s {
+        delete(subs, s.filter.Id)
+        if len(subs) == 0 {
+            delete(fi.byConn, s.conn)
+        }
+    }
+}
+
+func (fi *filterIndex) unsubscribe(c *connection, id string) bool {
+    s := fi.byConn[c][id]
+    if s == nil {
+        return false
+    }
+    fi.remove(s)
+    return true
+}
+
+func (fi *filterIndex) removeConn(c *connection) {
+    for _, s := range fi.byConn[c] {
+        fi.remove(s)
+    }
+}
+
+//match returns the subscribed connections a tweet should go to, with the ids of their matching subscriptions
+func (fi *filterIndex) match(tweet *twittertypes.Tweet) map[*connection][]string {
+    t := keysFor(tweet)
+    matched := make(map[*connection][]string)
+    seen := make(subscriptionSet)
+    consider := func(s *subscription) {
+        if seen[s] {
+            return
+        }
+        seen[s] = true
+        if s.restrictions(t) {
+            matched[s.conn] = append(matched[s.conn], s.filter.Id)
+        }
+    }
+
+    for s := range fi.unfiltered {
+        consider(s)
+    }
+    for s := range fi.byScreenName[t.screenName] {
+        consider(s)
+    }
+    for _, tag := range t.hashtags {
+        for s := range fi.byHashtag[tag] {
+            consider(s)
+        }
+    }
+    for word := range t.words {
+        for s := range fi.byWord[word] {
+            if seen[s] {
+                continue
+            }
+            for _, phrase := range s.phrases {
+                if phrase[0] == word && containsAll(t.words, phrase) {
+                    consider(s)
+                    break
+                }
+            }
+        }
+    }
+    return matched
+}
+
+//matchConn returns the ids of c's subscriptions that match a tweet
+func (fi *filterIndex) matchConn(c *connection, tweet *twittertypes.Tweet) []string {
+    t := keysFor(tweet)
+    var ids []string
+    for id, s := range fi.byConn[c] {
+        if s.matches(t) {
+            ids = append(ids, id)
+        }
+    }
+    return ids
+}
+
+func containsAll(words map[string]bool, phrase []string) bool {
+    for _, w := range phrase {
+        if !words[w] {
+            return false
+        }
+    }
+    return true
+}
@@ -0,0 +1,422 @@
+package tweetserver
+
+import (
+    "code.google.com/p/go.net/websocket"
+    "encoding/json"
+    "fmt"
+    "github.com/fcheslack/webtypes/twitter"
+    "github.com/fcheslack/tweetlog/tweetstore"
+)
+
+//maxReplay caps how many missed tweets a resuming client is sent.
+const maxReplay = 1000
+
+//maxHeld caps how many live messages are held for a client while its replay is sent.
+const maxHeld = 5000
+
+//messageSink is where a connection's messages are written: a websocket or an event stream.
+type messageSink interface {
+    Send(Message) error
+    Close() error
+}
+
+type wsSink struct {
+    ws *websocket.Conn
+}
+
+func (s wsSink) Send(m Message) error {
+    return websocket.JSON.Send(s.ws, m)
+}
+
+func (s wsSink) Close() error {
+    return s.ws.Close()
+}
+
+type connection struct {
+    //Where outbound messages are written.
+    out messageSink
+
+    //Buffered channel of outbound messages.
+    send chan Message
+
+    //Replayed messages, written in one go before anything else queued after them.
+    replay chan []Message
+
+    //Closed by the hub when the connection is dropped. dropReason,
+    //if set, is sent to the client before its stream is closed.
+    closed     chan struct{}
+    dropReason string
+
+    //Tweet id to resume after when registering, 0 for none.
+    resumeFrom int64
+
+    //Subscriptions to add when registering, before any replay is filtered.
+    filters []Filter
+
+    //Tweets the client's token may read, nil for all.
+    allow tweetFilter
+
+    //Owned by the hub: while resuming, live messages are held until the replay is written.
+    //Live tweets at or before replayedThrough were already sent in the replay. Once live
+    //tweets have been sent, or a replay written, the connection can't resume again.
+    resuming        bool
+    held            []Message
+    replayedThrough int64
+    live            bool
+}
+
+func newConnection(out messageSink) *connection {
+    return &connection{
+        out:    out,
+        send:   make(chan Message, 256),
+        replay: make(chan []Message),
+        closed: make(chan struct{}),
+    }
+}
+
+//hubRequest carries a client's subscribe, unsubscribe or resume message to the hub.
+type hubRequest struct {
+    conn *connection
+    msg  clientMessage
+}
+
+//replayResult is the missed tweets fetched for a resuming connection, oldest first.
+type replayResult struct {
+    conn      *connection
+    tweets    []*twittertypes.Tweet
+    lastId    int64
+    truncated bool
+}
+
+//replayWritten tells the hub a connection's replay, up to lastId, has been written.
+type replayWritten struct {
+    conn   *connection
+    lastId int64
+}
+
+//resumeInfo is the body of the "resumed" message that ends a replay. Truncated means
+//more than maxReplay tweets were missed: only the oldest were replayed, and those after
+//LastId up to the first live tweet are left for the client to page through /tweets
+//with since_id=LastId.
+type resumeInfo struct {
+    LastId    int64 `json:"last_id"`
+    Count     int   `json:"count"`
+    Truncated bool  `json:"truncated,omitempty"`
+}
+
+//tweetId accepts ids as json numbers or, since javascript can't hold them exactly, strings.
+type tweetId int64
+
+func (id *tweetId) UnmarshalJSON(b []byte) error {
+    var n json.Number
+    err := json.Unmarshal(b, &n)
+    if err != nil {
+        return err
+    }
+    i, err := n.Int64()
+    *id = tweetId(i)
+    return err
+}
+
+//reader passes client messages from ws to the hub until the websocket closes.
+func (c *connection) reader(ws *websocket.Conn, h *hub) {
+    for {
+        var message clientMessage
+        err := websocket.JSON.Receive(ws, &message)
+        if err != nil {
+            break
+        }
+        select {
+        case h.requests <- hubRequest{conn: c, msg: message}:
+        case <-h.done:
+            return
+        }
+    }
+}
+
+func (c *connection) writer() {
+    defer c.out.Close()
+    for {
+        select {
+        case message := <-c.send:
+            err := c.out.Send(message)
+            if err != nil {
+                return
+            }
+        case batch := <-c.replay:
+            for _, message := range batch {
+                err := c.out.Send(message)
+                if err != nil {
+                    return
+                }
+            }
+        case <-c.closed:
+            if c.dropReason != "" {
+                c.out.Send(Message{Type: "dropped", Body: c.dropReason})
+            }
+            return
+        }
+    }
+}
+
+type hub struct {
+    //Registered connections.
+    connections map[*connection]bool
+
+    //Inbound messages from the connections.
+    broadcast chan Message
+
+    //Register requests from the connections.
+    register chan *connection
+
+    //Unregister requests from connections.
+    unregister chan *connection
+
+    //Subscribe, unsubscribe and resume requests from connections.
+    requests chan hubRequest
+
+    //Missed tweets fetched for resuming connections.
+    replayed chan replayResult
+
+    //Resuming connections whose replay has been written.
+    written chan replayWritten
+
+    //Subscription filters of the connections that have any.
+    index *filterIndex
+
+    //Where missed tweets are replayed from.
+    store tweetstore.TweetStore
+
+    //Closed when the server shuts down.
+    done chan struct{}
+}
+
+func (h *hub) run() {
+    for {
+        select {
+        case c := <-h.register:
+            h.connections[c] = true
+            for _, f := range c.filters {
+                if s, err := newSubscription(c, f); err == nil {
+                    h.index.add(s)
+                }
+            }
+            if c.resumeFrom > 0 {
+                h.resume(c, c.resumeFrom)
+            }
+        case c := <-h.unregister:
+            h.drop(c, "")
+        case r := <-h.requests:
+            if h.connections[r.conn] {
+                h.handleRequest(r)
+            }
+        case r := <-h.replayed:
+            if h.connections[r.conn] {
+                h.writeReplay(r)
+            }
+        case w := <-h.written:
+            if h.connections[w.conn] {
+                h.release(w.conn, w.lastId)
+            }
+        case m := <-h.broadcast:
+            tweet, isTweet := m.Body.(*twittertypes.Tweet)
+            var matched map[*connection][]string
+            if isTweet && m.Type == "tweet" && len(h.index.byConn) > 0 {
+                matched = h.index.match(tweet)
+            }
+            for c := range h.connections {
+                if matched != nil && h.index.subscribed(c) {
+                    ids, ok := matched[c]
+                    if !ok {
+                        continue
+                    }
+                    cm := m
+                    cm.Subscriptions = ids
+                    h.deliver(c, cm)
+                } else {
+                    h.deliver(c, m)
+                }
+            }
+        case <-h.done:
+            for c := range h.connections {
+                h.drop(c, "server shutting down")
+            }
+            return
+        }
+    }
+}
+
+//deliver queues m for c, or holds it while c is resuming. A client that can't
+//keep up is dropped and told why, rather than being closed silently.
+func (h *hub) deliver(c *connection, m Message) {
+    if tweet, ok := m.Body.(*twittertypes.Tweet); ok {
+        if c.replayedThrough > 0 && tweet.Id != nil && int64(*tweet.Id) <= c.replayedThrough {
+            return
+        }
+        if c.allow != nil && !c.allow(tweet) {
+            return
+        }
+    }
+    if c.resuming {
+        if len(c.held) >= maxHeld {
+            h.drop(c, "slow consumer: too many messages while resuming")
+            return
+        }
+        c.held = append(c.held, m)
+        return
+    }
+    select {
+    case c.send <- m:
+        if m.Type == "tweet" {
+            c.live = true
+        }
+    default:
+        h.drop(c, "slow consumer: send buffer full")
+    }
+}
+
+func (h *hub) drop(c *connection, reason string) {
+    if h.connections[c] {
+        delete(h.connections, c)
+        h.index.removeConn(c)
+        c.dropReason = reason
+        close(c.closed)
+    }
+}
+
+//resume fetches the oldest maxReplay tweets c missed after lastId in the background
+//and holds c's live messages until they have been written. A connection that has
+//already had live tweets would get them again, so it is told to reconnect instead.
+func (h *hub) resume(c *connection, lastId int64) {
+    if c.resuming || c.live || c.replayedThrough > 0 {
+        h.deliver(c, Message{Type: "error", Body: "resume is only accepted before live tweets are sent, reconnect with last_id"})
+        return
+    }
+    c.resuming = true
+    go func() {
+        r := replayResult{conn: c, lastId: lastId, tweets: make([]*twittertypes.Tweet, 0, 200)}
+        //one more than is replayed, to know whether the replay was cut short
+        q := tweetstore.TweetQuery{SinceId: lastId, Ascending: true}
+        for len(r.tweets) <= maxReplay {
+            q.Limit = maxReplay + 1 - len(r.tweets)
+            if q.Limit > tweetstore.MaxQueryLimit {
+                q.Limit = tweetstore.MaxQueryLimit
+            }
+            tweets, err := h.store.QueryTweets(q)
+            if err != nil {
+                fmt.Printf("Error getting tweets to replay after %d: %s\n", q.SinceId, err)
+                r.truncated = true
+                break
+            }
+            r.tweets = append(r.tweets, tweets...)
+            last := len(tweets) - 1
+            if len(tweets) < q.Limit || tweets[last].Id == nil {
+                break
+            }
+            q.SinceId = int64(*tweets[last].Id)
+        }
+        if len(r.tweets) > maxReplay {
+            r.tweets = r.tweets[:maxReplay]
+            r.truncated = true
+        }
+        select {
+        case h.replayed <- r:
+        case <-h.done:
+        }
+    }()
+}
+
+//writeReplay hands the missed tweets that pass c's filters to its writer.
+func (h *hub) writeReplay(r replayResult) {
+    c := r.conn
+    batch := make([]Message, 0, len(r.tweets)+1)
+    lastId := r.lastId
+    for _, tweet := range r.tweets {
+        if tweet.Id == nil {
+            continue
+        }
+        if int64(*tweet.Id) > lastId {
+            lastId = int64(*tweet.Id)
+        }
+        if c.allow != nil && !c.allow(tweet) {
+            continue
+        }
+        m := Message{Type: "tweet", Body: tweet}
+        if h.index.subscribed(c) {
+            ids := h.index.matchConn(c, tweet)
+            if len(ids) == 0 {
+                continue
+            }
+            m.Subscriptions = ids
+        }
+        batch = append(batch, m)
+    }
+    batch = append(batch, Message{Type: "resumed", Body: resumeInfo{LastId: lastId, Count: len(batch), Truncated: r.truncated}})
+
+    go func() {
+        select {
+        case c.replay <- batch:
+        case <-c.closed:
+            return
+        }
+        select {
+        case h.written <- replayWritten{conn: c, lastId: lastId}:
+        case <-h.done:
+        }
+    }()
+}
+
+//release sends c the live messages held during its replay, skipping tweets it was already replayed.
+func (h *hub) release(c *connection, lastId int64) {
+    held := c.held
+    c.held = nil
+    c.resuming = false
+    if lastId > c.replayedThrough {
+        c.replayedThrough = lastId
+    }
+    for _, m := range held {
+        h.deliver(c, m)
+        if !h.connections[c] {
+            return
+        }
+    }
+}
+
+func (h *hub) handleRequest(r hubRequest) {
+    switch r.msg.Type {
+    case "subscribe":
+        var f Filter
+        err := json.Unmarshal(r.msg.Body, &f)
+        if err != nil {
+            h.deliver(r.conn, Message{Type: "error", Body: "invalid subscribe filter"})
+            return
+        }
+        s, err := newSubscription(r.conn, f)
+        if err != nil {
+            h.deliver(r.conn, Message{Type: "error", Body: err.Error()})
+            return
+        }
+        h.index.add(s)
+        h.deliver(r.conn, Message{Type: "subscribed", Body: f})
+    case "unsubscribe":
+        var f Filter
+        json.Unmarshal(r.msg.Body, &f)
+        if h.index.unsubscribe(r.conn, f.Id) {
+            h.deliver(r.conn, Message{Type: "unsubscribed", Body: f.Id})
+        } else {
+            h.deliver(r.conn, Message{Type: "error", Body: "no subscription " + f.Id})
+        }
+    case "resume":
+        var body struct {
+            LastId tweetId `json:"last_id"`
+        }
+        err := json.Unmarshal(r.msg.Body, &body)
+        if err != nil || body.LastId <= 0 {
+            h.deliver(r.conn, Message{Type: "error", Body: "resume needs a last_id"})
+            return
+        }
+        h.resume(r.conn, int64(body.LastId))
+    default:
+        h.deliver(r.conn, Message{Type: "error", Body: "unknown message type " + r.msg.Type})
+    }
+}
@@ -2,202 +2,435 @@ package tweetserver
 
 import (
     "code.google.com/p/go.net/websocket"
-    "database/sql"
     "encoding/json"
-    "flag"
     "fmt"
     "github.com/fcheslack/webtypes/twitter"
+    "github.com/fcheslack/tweetlog/analytics"
     "github.com/fcheslack/tweetlog/tweetstore"
-    _ "github.com/mattn/go-sqlite3"
     "log"
     "net/http"
-)
-
-var (
-    port     *string = flag.String("port", "10001", "http service port")
-    dbname   *string = flag.String("dbname", "../tweets.db", "SQLite3 DB")
-    dataPath *string = flag.String("dataPath", "./data", "Path to folder for persistent storage")
-
-    ts          = tweetstore.SqliteTweetStore{}
-    tweetServer *TweetServer
+    "strconv"
+    "sync"
+    "time"
 )
 
 type Message struct {
     Type string      `json:"Type,omitempty"`
     Body interface{} `json:"Body,omitempty"`
+
+    //ids of the client's subscriptions a tweet matched, when it has any
+    Subscriptions []string `json:"Subscriptions,omitempty"`
+}
+
+//Options configures a TweetServer. Zero values get the defaults noted on each field.
+type Options struct {
+    Address      string        //listen address for ListenAndServe, default ":10001"
+    DataPath     string        //folder for persistent storage, default "./data"
+    PollInterval time.Duration //how often to check the store for new tweets when polling, default 5s
+    KeepAlive    time.Duration //send an empty message after this long without one, default 30s
+
+    //Poll the store for new tweets instead of subscribing to it. Only needed when
+    //another process is writing to the store, since its saves are not seen here.
+    Poll bool
+
+    //Serve without API tokens. By default every request needs a bearer token issued
+    //with tweetstore.NewApiToken and saved in the store.
+    NoAuth bool
+
+    //Origins allowed to make cross-origin requests, or "*" for any. Default none.
+    CORSOrigins []string
+
+    //Track terms of the archive's public searches. Tokens with only the
+    //read-public-searches scope see just the tweets matching them.
+    PublicTrack []string
+
+    //Windows compared by /trends, default analytics.DefaultTrendConfig
+    Trends analytics.TrendConfig
 }
 
 type TweetServer struct {
     ServeMux   *http.ServeMux
     Address    string
+    DataPath   string
     TweetCast  chan Message
     Tweethub   hub
-    TweetStore *tweetstore.SqliteTweetStore
-    curTweetId int64
+    TweetStore tweetstore.TweetStore
+    Analytics  *analytics.Analytics
+
+    pollInterval  time.Duration
+    keepAlive     time.Duration
+    poll          bool
+    curTweetId    int64      //the latest tweet id broadcast, where polling resumes
+    recent        *recentIds //ids broadcast lately, to skip re-saved tweets
+    lastBroadcast time.Time
+    runOnce      sync.Once
+    closeOnce    sync.Once
+    done         chan struct{}
+
+    statsMu    sync.Mutex
+    statsCache map[interface{}]*cachedStats //of /stats and /topics
+
+    noAuth      bool
+    corsOrigins map[string]bool
+    publicTrack *subscription //nil when there are no public track terms
+    trackTerms  []string      //the public track terms, the groups of a series grouped by term
+
+    trendConfig  analytics.TrendConfig
+    trends       *analytics.TrendDetector
+    publicTrends *analytics.TrendDetector //only the tweets publicTrack matches
 }
 
-func (ts *TweetServer) Init() {
+//NewTweetServer makes a server for store. The returned server is an http.Handler,
+//so it can be mounted in another mux or tested with httptest; call Run to start
+//the live feed when doing so, or ListenAndServe to do both.
+func NewTweetServer(store tweetstore.TweetStore, opts Options) *TweetServer {
+    if opts.Address == "" {
+        opts.Address = ":10001"
+    }
+    if opts.DataPath == "" {
+        opts.DataPath = "./data"
+    }
+    if opts.PollInterval == 0 {
+        opts.PollInterval = 5 * time.Second
+    }
+    if opts.KeepAlive == 0 {
+        opts.KeepAlive = 30 * time.Second
+    }
+    if opts.Trends == (analytics.TrendConfig{}) {
+        opts.Trends = analytics.DefaultTrendConfig
+    }
+
+    ts := &TweetServer{
+        Address:      opts.Address,
+        DataPath:     opts.DataPath,
+        TweetStore:   store,
+        pollInterval: opts.PollInterval,
+        keepAlive:    opts.KeepAlive,
+        poll:         opts.Poll,
+        done:         make(chan struct{}),
+        recent:       newRecentIds(maxRecentIds),
+        statsCache:   make(map[interface{}]*cachedStats),
+        noAuth:       opts.NoAuth,
+        corsOrigins:  make(map[string]bool),
+        trendConfig:  opts.Trends,
+        trends:       analytics.NewTrendDetector(opts.Trends),
+        publicTrends: analytics.NewTrendDetector(opts.Trends),
+    }
+    ts.trackTerms = opts.PublicTrack
+    for _, origin := range opts.CORSOrigins {
+        ts.corsOrigins[origin] = true
+    }
+    if len(opts.PublicTrack) > 0 {
+        ts.publicTrack, _ = newSubscription(nil, Filter{Id: "public", Track: opts.PublicTrack})
+        if len(ts.publicTrack.phrases) == 0 {
+            ts.publicTrack = nil
+        }
+    }
     ts.Tweethub = hub{
         broadcast:   make(chan Message),
         register:    make(chan *connection),
         unregister:  make(chan *connection),
         connections: make(map[*connection]bool),
+        requests:    make(chan hubRequest),
+        replayed:    make(chan replayResult),
+        written:     make(chan replayWritten),
+        index:       newFilterIndex(),
+        store:       store,
+        done:        ts.done,
     }
 
     ts.TweetCast = make(chan Message)
     ts.ServeMux = http.NewServeMux()
 
-    ts.ServeMux.HandleFunc("/recent", recentHandler)
+    ts.ServeMux.HandleFunc("/recent", ts.recentHandler)
 
-    ts.ServeMux.Handle("/ws", websocket.Handler(wsHandler))
+    ts.ServeMux.HandleFunc("/tweets", ts.tweetsHandler)
 
-}
+    ts.ServeMux.HandleFunc("/links", ts.linksHandler)
+
+    ts.ServeMux.HandleFunc("/stats", ts.statsHandler)
+
+    ts.ServeMux.HandleFunc("/trends", ts.trendsHandler)
+
+    ts.ServeMux.HandleFunc("/topics", ts.topicsHandler)
 
-func (ts *TweetServer) Start() {
-    ts.curTweetId = ts.TweetStore.LatestTweetId()
+    ts.ServeMux.HandleFunc("/terms", ts.termsHandler)
 
-    if err := http.ListenAndServe(ts.Address, ts.ServeMux); err != nil {
-        log.Fatal("ListenAndServe:", err)
+    ts.ServeMux.HandleFunc("/clusters", ts.clustersHandler)
+
+    ts.ServeMux.HandleFunc("/feeds/", ts.feedsHandler)
+
+    ts.ServeMux.HandleFunc("/ui/", ts.uiHandler)
+
+    ts.ServeMux.Handle("/ui/static/", uiStatic())
+
+    ts.ServeMux.HandleFunc("/", func(rw http.ResponseWriter, req *http.Request) {
+        if req.URL.Path != "/" {
+            http.NotFound(rw, req)
+            return
+        }
+        http.Redirect(rw, req, "/ui/", http.StatusFound)
+    })
+
+    ts.ServeMux.Handle("/ws", websocket.Handler(ts.wsHandler))
+
+    ts.ServeMux.HandleFunc("/events", ts.eventsHandler)
+
+    ts.Analytics = &analytics.Analytics{}
+    ts.Analytics.Tweetstore = store
+    return ts
+}
+
+//ServeHTTP applies CORS and token checks, then routes the request
+func (ts *TweetServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+    if !ts.cors(rw, req) {
+        return
     }
+    req, ok := ts.authenticate(rw, req)
+    if !ok {
+        return
+    }
+    ts.ServeMux.ServeHTTP(rw, req)
+}
 
-    go ts.TimedStream()
+//Run starts the hub and the live feed in the background. It is safe to call more than once.
+func (ts *TweetServer) Run() {
+    ts.runOnce.Do(func() {
+        ts.curTweetId = ts.TweetStore.LatestTweetId()
+        //tweets stored before the server started are not news when they are saved again
+        ts.recent.floor = ts.curTweetId
+        go ts.seedTrends(ts.curTweetId, ts.trendConfig)
+        go ts.Tweethub.run()
+        if ts.poll {
+            go ts.TimedStream()
+        } else {
+            go ts.PushStream()
+        }
+    })
+}
+
+//ListenAndServe starts the live feed and serves on ts.Address until it fails
+func (ts *TweetServer) ListenAndServe() error {
+    ts.Run()
+    return http.ListenAndServe(ts.Address, ts)
+}
+
+//Close stops the live feed and hub. It does not close the tweet store.
+func (ts *TweetServer) Close() {
+    ts.closeOnce.Do(func() {
+        close(ts.done)
+    })
 }
 
 func (ts *TweetServer) BroadcastTweet(tweet *twittertypes.Tweet) {
-    /*j, err := json.Marshal(tweet)
-      if err != nil {
-          fmt.Printf("Error marshalling tweet: %s\n", err)
-          return
-      }*/
     m := Message{Type: "tweet", Body: tweet}
-    ts.Tweethub.broadcast <- m
+    ts.broadcast(m)
+}
+
+//broadcast hands m to the hub unless the server has been closed
+func (ts *TweetServer) broadcast(m Message) bool {
+    select {
+    case ts.Tweethub.broadcast <- m:
+        return true
+    case <-ts.done:
+        return false
+    }
 }
 
+//wsHandler streams live tweets. Connecting with ?last_id=N first replays the tweets
+//saved after N, so a reconnecting client doesn't miss what was sent while it was away.
 func (ts *TweetServer) wsHandler(ws *websocket.Conn) {
-    c := &connection{send: make(chan Message, 256), ws: ws}
-    tweetServer.Tweethub.register <- c
-    defer func() { ts.Tweethub.unregister <- c }()
+    c := newConnection(wsSink{ws})
+    if req := ws.Request(); req != nil {
+        c.allow = ts.tweetFilter(req)
+        if s := req.URL.Query().Get("last_id"); s != "" {
+            c.resumeFrom, _ = strconv.ParseInt(s, 10, 64)
+        }
+    }
+    select {
+    case ts.Tweethub.register <- c:
+    case <-ts.done:
+        ws.Close()
+        return
+    }
     go c.writer()
-    //c.reader()
+    c.reader(ws, &ts.Tweethub)
+    select {
+    case ts.Tweethub.unregister <- c:
+    case <-ts.done:
+    }
 }
 
-func (ts *TweetServer) TimedStream() {
+//PushStream broadcasts tweets as soon as the store commits them
+func (ts *TweetServer) PushStream() {
+    tweets, cancel := ts.TweetStore.Subscribe(1000)
+    defer cancel()
+    keepAlive := time.NewTicker(ts.keepAlive)
+    defer keepAlive.Stop()
     for {
-        <-time.After(5 * time.Second)
-        tweets := ts.TweetStore.TweetsAfterId(ts.curTweetId)
-        for _, tweet := range tweets {
-            m := Message{Type: "tweet", Body: tweet}
-            ts.Tweethub.broadcast <- m
-            if tweet.Id > ts.curTweetId {
-                ts.curTweetId = tweet.Id
+        select {
+        case tweet, ok := <-tweets:
+            if !ok {
+                return
+            }
+            if !ts.broadcastNew(tweet) {
+                return
+            }
+        case <-keepAlive.C:
+            if !ts.sendKeepAlive() {
+                return
             }
+        case <-ts.done:
+            return
         }
     }
 }
 
-func recentHandler(rw http.ResponseWriter, req *http.Request) {
-    rw.Header().Set("Access-Control-Allow-Origin", "*")
-    if req.Method == "GET" {
-        recentTweets := ts.RecentTweets(200)
-        j, err := json.Marshal(recentTweets)
-        if err != nil {
-            log.Printf("Error marshalling recent tweets: %s\n", err)
+//TimedStream polls the store for tweets saved by another process
+func (ts *TweetServer) TimedStream() {
+    keepAlive := time.NewTicker(ts.keepAlive)
+    defer keepAlive.Stop()
+    for {
+        select {
+        case <-time.After(ts.pollInterval):
+        case <-keepAlive.C:
+            if !ts.sendKeepAlive() {
+                return
+            }
+            continue
+        case <-ts.done:
+            return
+        }
+        tweets := ts.TweetStore.TweetsAfterId(ts.curTweetId)
+        if len(tweets) > 0 {
+            fmt.Printf("%d tweets after tweetid %d\n", len(tweets), ts.curTweetId)
+        }
+        //TweetsAfterId is newest first, broadcast oldest first
+        for i := len(tweets) - 1; i >= 0; i-- {
+            if !ts.broadcastNew(tweets[i]) {
+                return
+            }
         }
-        rw.Write(j)
-    } else if req.Method == "OPTIONS" {
-        rw.Header().Set("Access-Control-Allow-Methods", "GET")
     }
 }
 
-func main() {
-    flag.Parse()
-
-    db, err := sql.Open("sqlite3", *dbname)
-    if err != nil {
-        fmt.Println("Error opening sqlite3: %s\n", err)
-        return
-    }
-    defer db.Close()
-    ts.Initialize(db)
-
-    tweetServer = &TweetServer{}
-    tweetServer.Init()
-    tweetServer.Address = ":" + *port
+//maxRecentIds is how many broadcast tweet ids are remembered to skip re-saved tweets
+const maxRecentIds = 10000
 
-    tweetServer.Start()
+//recentIds is a bounded set of the tweet ids most recently broadcast
+type recentIds struct {
+    ids   map[int64]bool
+    order []int64 //ring of the ids in ids, the oldest at next once full
+    next  int
+    floor int64 //the highest id forgotten
 }
 
-type connection struct {
-    // The websocket connection.
-    ws  *websocket.Conn
-
-    // Buffered channel of outbound messages.
-    send chan Message
+func newRecentIds(n int) *recentIds {
+    return &recentIds{ids: make(map[int64]bool, n), order: make([]int64, 0, n)}
 }
 
-/*
-func (c *connection) reader() {
-    for {
-        var message Message
-        err := websocket.JSON.Receive(c.ws, &message)
-        if err != nil {
-            break
-        }
-        tweetServer.Tweethub.broadcast <- message
+//add records id and reports whether it is new. Ids at or below one already forgotten
+//are taken as seen: they are re-saves of old tweets rather than news.
+func (r *recentIds) add(id int64) bool {
+    if r.ids[id] || id <= r.floor {
+        return false
     }
-    c.ws.Close()
-}
-*/
-func (c *connection) writer() {
-    for message := range c.send {
-        err := websocket.JSON.Send(c.ws, message)
-        if err != nil {
-            break
+    if len(r.order) < cap(r.order) {
+        r.order = append(r.order, id)
+    } else {
+        old := r.order[r.next]
+        delete(r.ids, old)
+        if old > r.floor {
+            r.floor = old
         }
+        r.order[r.next] = id
+        r.next = (r.next + 1) % len(r.order)
     }
-    c.ws.Close()
+    r.ids[id] = true
+    return true
 }
 
-func wsHandler(ws *websocket.Conn) {
-    c := &connection{send: make(chan Message, 256), ws: ws}
-    tweetServer.Tweethub.register <- c
-    defer func() { tweetServer.Tweethub.unregister <- c }()
-    go c.writer()
-    //c.reader()
+//broadcastNew sends tweet to the hub unless it was sent recently, so re-saved tweets
+//(backfill overlap) are not repeated. Tweets may arrive in any order: REST backfill
+//is newest first and stream tweets can be slightly out of order.
+func (ts *TweetServer) broadcastNew(tweet *twittertypes.Tweet) bool {
+    if tweet.Id == nil || !ts.recent.add(int64(*tweet.Id)) {
+        return true
+    }
+    if int64(*tweet.Id) > ts.curTweetId {
+        ts.curTweetId = int64(*tweet.Id)
+    }
+    ts.lastBroadcast = time.Now()
+    ts.addTrend(tweet)
+    return ts.broadcast(Message{Type: "tweet", Body: tweet})
 }
 
-type hub struct {
-    // Registered connections.
-    connections map[*connection]bool
-
-    // Inbound messages from the connections.
-    broadcast chan Message
-
-    // Register requests from the connections.
-    register chan *connection
+//sendKeepAlive sends an empty message only if nothing else has gone out recently
+func (ts *TweetServer) sendKeepAlive() bool {
+    if time.Since(ts.lastBroadcast) < ts.keepAlive {
+        return true
+    }
+    ts.lastBroadcast = time.Now()
+    return ts.broadcast(Message{Type: "message", Body: ""})
+}
 
-    // Unregister requests from connections.
-    unregister chan *connection
+func (ts *TweetServer) recentHandler(rw http.ResponseWriter, req *http.Request) {
+    if req.Method == "GET" {
+        recentTweets := []*twittertypes.Tweet{}
+        allow := ts.tweetFilter(req)
+        q := tweetstore.TweetQuery{Limit: 200}
+        if allow == nil {
+            recentTweets = ts.TweetStore.RecentTweets(200)
+        } else if ts.publicQuery(&q, allow) {
+            page, err := ts.queryPage(q, allow)
+            if err != nil {
+                writeJSONError(rw, http.StatusInternalServerError, fmt.Errorf("query failed"))
+                return
+            }
+            recentTweets = page.Tweets
+        }
+        j, err := json.Marshal(recentTweets)
+        if err != nil {
+            log.Printf("Error marshalling recent tweets: %s\n", err)
+        }
+        rw.Write(j)
+    } else if req.Method == "OPTIONS" {
+        rw.Header().Set("Access-Control-Allow-Methods", "GET")
+    }
 }
 
-func (h *hub) run() {
-    for {
-        select {
-        case c := <-h.register:
-            h.connections[c] = true
-        case c := <-h.unregister:
-            delete(h.connections, c)
-            close(c.send)
-        case m := <-h.broadcast:
-            for c := range h.connections {
-                select {
-                case c.send <- m:
-                default:
-                    delete(h.connections, c)
-                    close(c.send)
-                    go c.ws.Close()
+func (ts *TweetServer) linksHandler(rw http.ResponseWriter, req *http.Request) {
+    if req.Method == "GET" {
+        startTime := time.Now().Add(-24 * time.Hour)
+        endTime := time.Now()
+        var tweeturls []*twittertypes.TwitterUrl
+        if allow := ts.tweetFilter(req); allow != nil {
+            //urls carry no tweet to check, so collect them from the allowed tweets
+            q := tweetstore.TweetQuery{From: startTime, To: endTime, Limit: tweetstore.MaxQueryLimit}
+            if ts.publicQuery(&q, allow) {
+                err := tweetstore.EachTweet(ts.TweetStore, q, func(tweet *twittertypes.Tweet) bool {
+                    if allow(tweet) {
+                        for i := range tweet.Entities.Urls {
+                            tweeturls = append(tweeturls, &tweet.Entities.Urls[i])
+                        }
+                    }
+                    return true
+                })
+                if err != nil {
+                    writeJSONError(rw, http.StatusInternalServerError, fmt.Errorf("query failed"))
+                    return
                 }
             }
+        } else {
+            tweeturls = ts.TweetStore.IntervalUrls(startTime, endTime)
         }
+
+        j, err := json.Marshal(tweeturls)
+        if err != nil {
+            log.Printf("Error marshalling recent tweets: %s\n", err)
+        }
+        rw.Write(j)
+    } else if req.Method == "OPTIONS" {
+        rw.Header().Set("Access-Control-Allow-Methods", "GET")
     }
 }
@@ -0,0 +1,214 @@
+package tweetserver
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/fcheslack/tweetlog/tweetstore"
+    "github.com/fcheslack/webtypes/twitter"
+    "net/http"
+    "net/http/httptest"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+//testTweet is a stored tweet for the handler tests. Tweets are created a minute apart,
+//ending a minute before the test server's clock.
+type testTweet struct {
+    id         int64
+    screenName string
+    text       string
+    hashtags   []string
+    urls       []string //expanded urls
+}
+
+//tweet is tt as received, created at created
+func (tt testTweet) tweet(t *testing.T, created time.Time) *twittertypes.Tweet {
+    t.Helper()
+    hashtags := make([]string, 0, len(tt.hashtags))
+    for _, h := range tt.hashtags {
+        hashtags = append(hashtags, fmt.Sprintf(`{"text":%q,"indices":[0,0]}`, h))
+    }
+    urls := make([]string, 0, len(tt.urls))
+    for _, u := range tt.urls {
+        urls = append(urls, fmt.Sprintf(`{"url":"http://t.co/x","expanded_url":%q,"display_url":%q,"indices":[0,0]}`, u, u))
+    }
+    line := fmt.Sprintf(`{"id":%d,"id_str":"%d","text":%q,"created_at":%q,"lang":"en","source":"test","user":{"id":%d,"id_str":"%d","screen_name":%q},"entities":{"hashtags":[%s],"urls":[%s],"user_mentions":[],"media":[]}}`,
+        tt.id, tt.id, tt.text, created.Format(time.RubyDate), len(tt.screenName), len(tt.screenName), tt.screenName, strings.Join(hashtags, ","), strings.Join(urls, ","))
+    tweet := &twittertypes.Tweet{}
+    err := json.Unmarshal([]byte(line), tweet)
+    if err != nil {
+        t.Fatalf("unmarshalling test tweet %d: %s", tt.id, err)
+    }
+    tweet.RawBytes = []byte(line)
+    return tweet
+}
+
+//newTestStore opens a new SQLite store holding tweets
+func newTestStore(t *testing.T, tweets ...testTweet) tweetstore.TweetStore {
+    t.Helper()
+    store, err := tweetstore.Open("sqlite3://" + filepath.Join(t.TempDir(), "serve.db"))
+    if err != nil {
+        t.Fatalf("opening sqlite store: %s", err)
+    }
+    t.Cleanup(func() { store.Close() })
+
+    now := time.Now().UTC().Truncate(time.Second)
+    for i, tt := range tweets {
+        err = store.SaveTweet(tt.tweet(t, now.Add(time.Duration(i-len(tweets))*time.Minute)))
+        if err != nil {
+            t.Fatalf("saving test tweet %d: %s", tt.id, err)
+        }
+    }
+    return store
+}
+
+//newTestToken stores a token with scopes and returns its secret
+func newTestToken(t *testing.T, store tweetstore.TweetStore, scopes ...string) string {
+    t.Helper()
+    secret, token, err := tweetstore.NewApiToken("test", scopes)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = store.SaveApiToken(token)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return secret
+}
+
+//getJSON requests path from h with token, if any, decodes the body into v and returns the status
+func getJSON(t *testing.T, h http.Handler, path string, token string, v interface{}) int {
+    t.Helper()
+    req := httptest.NewRequest("GET", path, nil)
+    if token != "" {
+        req.Header.Set("Authorization", "Bearer "+token)
+    }
+    rec := httptest.NewRecorder()
+    h.ServeHTTP(rec, req)
+    if v != nil {
+        err := json.Unmarshal(rec.Body.Bytes(), v)
+        if err != nil {
+            t.Fatalf("decoding %s response %q: %s", path, rec.Body.String(), err)
+        }
+    }
+    return rec.Code
+}
+
+func tweetIds(tweets []*twittertypes.Tweet) []int64 {
+    ids := make([]int64, 0, len(tweets))
+    for _, tweet := range tweets {
+        if tweet.Id != nil {
+            ids = append(ids, int64(*tweet.Id))
+        }
+    }
+    return ids
+}
+
+func sameIds(got []int64, want ...int64) bool {
+    if len(got) != len(want) {
+        return false
+    }
+    for i := range want {
+        if got[i] != want[i] {
+            return false
+        }
+    }
+    return true
+}
+
+//a public searches token reads none of the home timeline tweets outside the public
+//track terms, through any of the endpoints that list tweets, and still gets the public
+//tweets behind more than a page of newer home timeline ones
+func TestPublicScopeEndpoints(t *testing.T) {
+    tweets := []testTweet{
+        {id: 301, screenName: "alice", text: "golang release notes", urls: []string{"http://public.example/notes"}},
+        {id: 302, screenName: "bob", text: "secret lunch photos", urls: []string{"http://private.example/lunch"}},
+        {id: 303, screenName: "alice", text: "secret golang release party"},
+    }
+    for i := int64(0); i <= tweetstore.DefaultQueryLimit; i++ {
+        tweets = append(tweets, testTweet{id: 400 + i, screenName: "alice", text: "secret chatter"})
+    }
+    store := newTestStore(t, tweets...)
+    ts := NewTweetServer(store, Options{PublicTrack: []string{"golang release"}})
+    public := newTestToken(t, store, tweetstore.ScopePublicSearches)
+    home := newTestToken(t, store, tweetstore.ScopeHomeTimeline)
+
+    var recent []*twittertypes.Tweet
+    getJSON(t, ts, "/recent", public, &recent)
+    if got := tweetIds(recent); !sameIds(got, 303, 301) {
+        t.Errorf("/recent got %v", got)
+    }
+    getJSON(t, ts, "/recent", home, &recent)
+    if got := tweetIds(recent); len(got) != len(tweets) {
+        t.Errorf("/recent with the home timeline scope got %d tweets, want %d", len(got), len(tweets))
+    }
+
+    var links []twittertypes.TwitterUrl
+    getJSON(t, ts, "/links", public, &links)
+    if len(links) != 1 || links[0].Expanded_url != "http://public.example/notes" {
+        t.Errorf("/links got %+v", links)
+    }
+
+    for path, want := range map[string][]string{
+        "/feeds/search/secret.json": {"303"},
+        "/feeds/search/lunch.json":  {},
+        "/feeds/user/bob.json":      {},
+        "/feeds/user/alice.json":    {"303", "301"},
+    } {
+        var feed jsonFeed
+        status := getJSON(t, ts, path, public, &feed)
+        ids := make([]string, 0, len(feed.Items))
+        for _, item := range feed.Items {
+            ids = append(ids, item.Id)
+        }
+        if status != http.StatusOK || strings.Join(ids, ",") != strings.Join(want, ",") {
+            t.Errorf("%s: status %d, items %v, want %v", path, status, ids, want)
+        }
+    }
+
+    for _, path := range []string{"/ui/", "/ui/search?q=lunch", "/ui/user/bob", "/ui/tweet/302", "/ui/user/alice"} {
+        req := httptest.NewRequest("GET", path, nil)
+        req.Header.Set("Authorization", "Bearer "+public)
+        rec := httptest.NewRecorder()
+        ts.ServeHTTP(rec, req)
+        if strings.Contains(rec.Body.String(), "lunch photos") || strings.Contains(rec.Body.String(), "chatter") {
+            t.Errorf("%s shows a home timeline tweet", path)
+        }
+    }
+    for _, path := range []string{"/ui/", "/ui/user/alice"} {
+        req := httptest.NewRequest("GET", path, nil)
+        req.Header.Set("Authorization", "Bearer "+public)
+        rec := httptest.NewRecorder()
+        ts.ServeHTTP(rec, req)
+        if !strings.Contains(rec.Body.String(), "golang release notes") {
+            t.Errorf("%s leaves out the public tweets", path)
+        }
+    }
+}
+
+func TestRecentIds(t *testing.T) {
+    r := newRecentIds(3)
+    //newest first, as a REST backfill batch arrives
+    for _, id := range []int64{30, 20, 10} {
+        if !r.add(id) {
+            t.Errorf("%d not new", id)
+        }
+    }
+    if r.add(20) {
+        t.Errorf("20 added twice")
+    }
+    //forgetting 30 makes everything at or below it old
+    if !r.add(40) {
+        t.Errorf("40 not new")
+    }
+    for _, id := range []int64{30, 25} {
+        if r.add(id) {
+            t.Errorf("%d added after 30 was forgotten", id)
+        }
+    }
+    if !r.add(35) {
+        t.Errorf("35 not new")
+    }
+}
@@ -0,0 +1,349 @@
+package tweetserver
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/fcheslack/tweetlog/analytics"
+    "github.com/fcheslack/tweetlog/tweetstore"
+    "log"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+)
+
+const (
+    defaultStatsWindow = 24 * time.Hour
+    defaultStatsBucket = time.Hour
+    defaultStatsTop    = 10
+    maxStatsTop        = 100
+    maxStatsBuckets    = 500
+    maxStatsWindow     = 31 * 24 * time.Hour
+
+    //how long a computed /stats response is reused for the same parameters
+    statsCacheTTL = time.Minute
+)
+
+//Stats is the response body of /stats
+type Stats struct {
+    Window     string                       `json:"window"`
+    Bucket     string                       `json:"bucket"`
+    From       time.Time                    `json:"from"`
+    To         time.Time                    `json:"to"`
+    TweetCount int                          `json:"tweet_count"`
+    Urls       []analytics.Count            `json:"urls"`
+    Users      []analytics.Count            `json:"users"`
+    Hashtags   []analytics.Count            `json:"hashtags"`
+    Mentions   []analytics.Count            `json:"mentions"`
+    Langs      []analytics.Count            `json:"langs"`
+    Volume     []VolumeBucket               `json:"volume"`
+    Series     *tweetstore.Series           `json:"series,omitempty"`
+    Sentiment  *tweetstore.SentimentSummary `json:"sentiment,omitempty"` //of the scored tweets
+}
+
+//VolumeBucket is the number of tweets saved in [Start, End)
+type VolumeBucket struct {
+    Start time.Time `json:"start"`
+    End   time.Time `json:"end"`
+    Count int       `json:"count"`
+}
+
+type statsKey struct {
+    window time.Duration
+    bucket time.Duration
+    top    int
+    public bool //only the public search tweets, for tokens limited to them
+
+    //an aligned time series, when interval is set
+    interval  string
+    tz        string
+    groupBy   string
+    clusters  string //cluster ids counted by group_by=cluster, comma separated
+    from      int64
+    to        int64
+    sentiment bool
+}
+
+type cachedStats struct {
+    body    []byte
+    expires time.Time
+}
+
+//parseStatsParams reads window and bucket (go durations like 6h or 15m, the window at
+//most maxStatsWindow) and top
+func parseStatsParams(v url.Values) (statsKey, error) {
+    k := statsKey{window: defaultStatsWindow, bucket: defaultStatsBucket, top: defaultStatsTop}
+    var err error
+    if s := v.Get("window"); s != "" {
+        k.window, err = time.ParseDuration(s)
+        if err != nil || k.window <= 0 {
+            return k, fmt.Errorf("invalid window, use a duration like 24h")
+        }
+    }
+    if s := v.Get("bucket"); s != "" {
+        k.bucket, err = time.ParseDuration(s)
+        if err != nil || k.bucket <= 0 {
+            return k, fmt.Errorf("invalid bucket, use a duration like 1h")
+        }
+    }
+    if k.window > maxStatsWindow {
+        return k, fmt.Errorf("window too long, must be at most %s", maxStatsWindow)
+    }
+    if k.bucket > k.window {
+        return k, fmt.Errorf("bucket must not be longer than window")
+    }
+    if int64(k.window/k.bucket) > maxStatsBuckets {
+        return k, fmt.Errorf("too many buckets, window/bucket must be at most %d", maxStatsBuckets)
+    }
+    if s := v.Get("top"); s != "" {
+        k.top, err = strconv.Atoi(s)
+        if err != nil || k.top <= 0 || k.top > maxStatsTop {
+            return k, fmt.Errorf("invalid top, must be 1 to %d", maxStatsTop)
+        }
+    }
+    return k, parseSeriesParams(v, &k)
+}
+
+//parseSeriesParams reads the time series params: interval (minute, hour, day or week),
+//tz (an IANA zone name, default UTC), group_by (hashtag, user, term, source, lang or
+//cluster, with clusters an optional list of up to maxStatsTop cluster ids to count),
+//from and to (RFC 3339, default the window ending now) and sentiment (true to add
+//the mean stored sentiment of each bucket)
+func parseSeriesParams(v url.Values, k *statsKey) error {
+    k.interval = v.Get("interval")
+    if k.interval == "" {
+        return nil
+    }
+    switch k.interval {
+    case tweetstore.IntervalMinute, tweetstore.IntervalHour, tweetstore.IntervalDay, tweetstore.IntervalWeek:
+    default:
+        return fmt.Errorf("invalid interval, use minute, hour, day or week")
+    }
+    k.tz = v.Get("tz")
+    if _, err := time.LoadLocation(k.tz); err != nil {
+        return fmt.Errorf("invalid tz, use a zone name like America/New_York")
+    }
+    k.groupBy = v.Get("group_by")
+    switch k.groupBy {
+    case tweetstore.GroupNone, tweetstore.GroupHashtag, tweetstore.GroupUser, tweetstore.GroupTerm, tweetstore.GroupSource, tweetstore.GroupLang, tweetstore.GroupCluster:
+    default:
+        return fmt.Errorf("invalid group_by, use hashtag, user, term, source, lang or cluster")
+    }
+    if s := v.Get("clusters"); s != "" {
+        if k.groupBy != tweetstore.GroupCluster {
+            return fmt.Errorf("clusters needs group_by=cluster")
+        }
+        ids := strings.Split(s, ",")
+        if len(ids) > maxStatsTop {
+            return fmt.Errorf("too many clusters, at most %d", maxStatsTop)
+        }
+        for _, id := range ids {
+            n, err := strconv.ParseInt(id, 10, 64)
+            if err != nil || n <= 0 {
+                return fmt.Errorf("invalid clusters, use a comma separated list of cluster ids")
+            }
+        }
+        k.clusters = s
+    }
+    if s := v.Get("from"); s != "" {
+        t, err := time.Parse(time.RFC3339, s)
+        if err != nil {
+            return fmt.Errorf("invalid from, use an RFC 3339 time")
+        }
+        k.from = t.Unix()
+    }
+    if s := v.Get("to"); s != "" {
+        t, err := time.Parse(time.RFC3339, s)
+        if err != nil {
+            return fmt.Errorf("invalid to, use an RFC 3339 time")
+        }
+        k.to = t.Unix()
+    }
+    if s := v.Get("sentiment"); s != "" {
+        var err error
+        k.sentiment, err = strconv.ParseBool(s)
+        if err != nil {
+            return fmt.Errorf("invalid sentiment, use true or false")
+        }
+    }
+    return nil
+}
+
+//computeSeries runs the time series part of k over [startTime, endTime) unless from or to override them
+func (ts *TweetServer) computeSeries(k statsKey, startTime, endTime time.Time) (*tweetstore.Series, error) {
+    loc, err := time.LoadLocation(k.tz)
+    if err != nil {
+        return nil, err
+    }
+    q := tweetstore.SeriesQuery{
+        Start:     startTime,
+        End:       endTime,
+        Interval:  k.interval,
+        Location:  loc,
+        GroupBy:   k.groupBy,
+        Terms:     ts.trackTerms,
+        Groups:    k.top,
+        Sentiment: k.sentiment,
+    }
+    if k.clusters != "" {
+        for _, id := range strings.Split(k.clusters, ",") {
+            n, _ := strconv.ParseInt(id, 10, 64)
+            q.Clusters = append(q.Clusters, n)
+        }
+    }
+    if k.from != 0 {
+        q.Start = time.Unix(k.from, 0)
+    }
+    if k.to != 0 {
+        q.End = time.Unix(k.to, 0)
+    }
+    return ts.TweetStore.TweetSeries(q)
+}
+
+//computeStats builds the /stats document for the window ending now. Counting is done
+//by the store; for tokens limited to public searches it counts only the tweets its search
+//index matches to the public track terms, and leaves out the time series.
+func (ts *TweetServer) computeStats(k statsKey) (*Stats, error) {
+    endTime := time.Now()
+    startTime := endTime.Add(-k.window)
+    stats := &Stats{
+        Window: k.window.String(),
+        Bucket: k.bucket.String(),
+        From:   startTime.UTC(),
+        To:     endTime.UTC(),
+    }
+    //counted back from endTime, newest bucket first
+    n := int(k.window / k.bucket)
+    var counts []int
+
+    if k.public {
+        //with no public track terms there is nothing to count
+        public := &tweetstore.TrackStats{Top: make(map[tweetstore.TopKind][]tweetstore.KeyCount), Volume: make([]int, n)}
+        for _, kind := range []tweetstore.TopKind{tweetstore.TopUrls, tweetstore.TopUsers, tweetstore.TopHashtags, tweetstore.TopMentions, tweetstore.TopLangs} {
+            public.Top[kind] = []tweetstore.KeyCount{}
+        }
+        if ts.publicTrack != nil {
+            q := tweetstore.TrackStatsQuery{
+                Start:   startTime,
+                End:     endTime,
+                Track:   ts.publicTrack.phrases,
+                Bucket:  k.bucket,
+                Buckets: n,
+                Top:     k.top,
+            }
+            var err error
+            public, err = ts.TweetStore.IntervalTrackStats(q)
+            if err != nil {
+                return nil, err
+            }
+        }
+        stats.Urls = public.Top[tweetstore.TopUrls]
+        stats.Users = public.Top[tweetstore.TopUsers]
+        stats.Hashtags = public.Top[tweetstore.TopHashtags]
+        stats.Mentions = public.Top[tweetstore.TopMentions]
+        stats.Langs = public.Top[tweetstore.TopLangs]
+        stats.Sentiment = &public.Sentiment
+        counts = public.Volume
+    } else {
+        //a fresh Analytics per request, since handlers run concurrently
+        a := &analytics.Analytics{Tweetstore: ts.TweetStore}
+        stats.Urls = a.TopUrls(startTime, endTime, k.top)
+        stats.Users = a.TopUsers(startTime, endTime, k.top)
+        stats.Hashtags = a.TopHashtags(startTime, endTime, k.top)
+        stats.Mentions = a.TopMentions(startTime, endTime, k.top)
+        stats.Langs = a.TopLangs(startTime, endTime, k.top)
+        counts = ts.TweetStore.IntervalTweetCountBefore(endTime, k.bucket, n)
+        sentiment, err := ts.TweetStore.IntervalSentiment(startTime, endTime)
+        if err != nil {
+            return nil, err
+        }
+        stats.Sentiment = &sentiment
+        if k.interval != "" {
+            stats.Series, err = ts.computeSeries(k, startTime, endTime)
+            if err != nil {
+                return nil, err
+            }
+        }
+    }
+    for _, c := range counts {
+        stats.TweetCount += c
+    }
+
+    stats.Volume = make([]VolumeBucket, 0, len(counts))
+    for i := len(counts) - 1; i >= 0; i-- {
+        end := endTime.Add(-time.Duration(i) * k.bucket)
+        stats.Volume = append(stats.Volume, VolumeBucket{
+            Start: end.Add(-k.bucket).UTC(),
+            End:   end.UTC(),
+            Count: counts[i],
+        })
+    }
+    return stats, nil
+}
+
+//cachedBody returns the encoded document cached under key, computing and caching it
+//for statsCacheTTL when there is none. Keys of different documents must have different types.
+func (ts *TweetServer) cachedBody(key interface{}, compute func() (interface{}, error)) ([]byte, error) {
+    now := time.Now()
+    ts.statsMu.Lock()
+    for k, c := range ts.statsCache {
+        if now.After(c.expires) {
+            delete(ts.statsCache, k)
+        }
+    }
+    c := ts.statsCache[key]
+    ts.statsMu.Unlock()
+    if c != nil {
+        return c.body, nil
+    }
+
+    doc, err := compute()
+    if err != nil {
+        return nil, err
+    }
+    j, err := json.Marshal(doc)
+    if err != nil {
+        return nil, err
+    }
+    ts.statsMu.Lock()
+    ts.statsCache[key] = &cachedStats{body: j, expires: now.Add(statsCacheTTL)}
+    ts.statsMu.Unlock()
+    return j, nil
+}
+
+//cachedStatsBody returns the encoded /stats document for k, recomputing it once it is older than statsCacheTTL
+func (ts *TweetServer) cachedStatsBody(k statsKey) ([]byte, error) {
+    return ts.cachedBody(k, func() (interface{}, error) {
+        return ts.computeStats(k)
+    })
+}
+
+//statsHandler serves top urls, users, hashtags and mentions and tweet volume over
+//?window (default 24h) in ?bucket sized buckets (default 1h), top ?top of each list.
+//With ?interval it adds a calendar aligned series, see parseSeriesParams.
+func (ts *TweetServer) statsHandler(rw http.ResponseWriter, req *http.Request) {
+    if req.Method == "GET" {
+        k, err := parseStatsParams(req.URL.Query())
+        if err != nil {
+            writeJSONError(rw, http.StatusBadRequest, err)
+            return
+        }
+        k.public = ts.tweetFilter(req) != nil
+        if k.public && k.interval != "" {
+            writeJSONError(rw, http.StatusForbidden, fmt.Errorf("time series need the %s scope", tweetstore.ScopeHomeTimeline))
+            return
+        }
+        j, err := ts.cachedStatsBody(k)
+        if err != nil {
+            log.Printf("Error computing stats: %s\n", err)
+            writeJSONError(rw, http.StatusInternalServerError, fmt.Errorf("stats failed"))
+            return
+        }
+        rw.Header().Set("Content-Type", "application/json")
+        rw.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(statsCacheTTL.Seconds())))
+        rw.Write(j)
+    } else if req.Method == "OPTIONS" {
+        rw.Header().Set("Access-Control-Allow-Methods", "GET")
+    }
+}
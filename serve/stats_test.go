@@ -0,0 +1,146 @@
+package tweetserver
+
+import (
+    "encoding/json"
+    "github.com/fcheslack/tweetlog/tweetstore"
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestStatsSchema(t *testing.T) {
+    ts := NewTweetServer(newTestStore(t, tweetsTestTweets...), Options{NoAuth: true})
+
+    var doc map[string]json.RawMessage
+    status := getJSON(t, ts, "/stats?window=1h&bucket=10m", "", &doc)
+    if status != http.StatusOK {
+        t.Fatalf("status %d", status)
+    }
+    for _, field := range []string{"window", "bucket", "from", "to", "tweet_count", "urls", "users", "hashtags", "mentions", "langs", "volume", "sentiment"} {
+        if _, ok := doc[field]; !ok {
+            t.Errorf("no %s field", field)
+        }
+    }
+    if _, ok := doc["series"]; ok {
+        t.Errorf("series without an interval")
+    }
+
+    var stats Stats
+    getJSON(t, ts, "/stats?window=1h&bucket=10m", "", &stats)
+    if stats.Window != "1h0m0s" || stats.Bucket != "10m0s" {
+        t.Errorf("window %q bucket %q", stats.Window, stats.Bucket)
+    }
+    if stats.TweetCount != len(tweetsTestTweets) {
+        t.Errorf("tweet_count %d, want %d", stats.TweetCount, len(tweetsTestTweets))
+    }
+    //oldest bucket first, each ending where the next starts, the last at to
+    if len(stats.Volume) != 6 {
+        t.Fatalf("%d volume buckets, want 6", len(stats.Volume))
+    }
+    total := 0
+    for i, b := range stats.Volume {
+        if b.End.Sub(b.Start) != 10*time.Minute {
+            t.Errorf("bucket %d is %s long", i, b.End.Sub(b.Start))
+        }
+        if i > 0 && !b.Start.Equal(stats.Volume[i-1].End) {
+            t.Errorf("bucket %d starts at %s, the one before ends at %s", i, b.Start, stats.Volume[i-1].End)
+        }
+        total += b.Count
+    }
+    if !stats.Volume[0].Start.Equal(stats.From) || !stats.Volume[5].End.Equal(stats.To) {
+        t.Errorf("buckets span %s to %s, stats %s to %s", stats.Volume[0].Start, stats.Volume[5].End, stats.From, stats.To)
+    }
+    if total != stats.TweetCount {
+        t.Errorf("buckets hold %d tweets, tweet_count is %d", total, stats.TweetCount)
+    }
+    if len(stats.Hashtags) == 0 || len(stats.Users) == 0 {
+        t.Errorf("no top hashtags or users: %v %v", stats.Hashtags, stats.Users)
+    }
+
+    doc = nil
+    getJSON(t, ts, "/stats?window=1h&bucket=10m&interval=hour&group_by=hashtag", "", &doc)
+    if _, ok := doc["series"]; !ok {
+        t.Errorf("no series with an interval")
+    }
+
+    for _, query := range []string{"group_by=cluster", "group_by=cluster&clusters=101,103"} {
+        stats = Stats{}
+        status = getJSON(t, ts, "/stats?window=1h&bucket=10m&interval=hour&"+query, "", &stats)
+        if status != http.StatusOK || stats.Series == nil || stats.Series.GroupBy != "cluster" {
+            t.Fatalf("%q: status %d, series %+v", query, status, stats.Series)
+        }
+        for _, g := range stats.Series.Groups {
+            if strings.Contains(query, "clusters=") && g.Key != "101" && g.Key != "103" {
+                t.Errorf("%q: counted cluster %s", query, g.Key)
+            }
+        }
+    }
+}
+
+func TestStatsInvalidParams(t *testing.T) {
+    ts := NewTweetServer(newTestStore(t), Options{NoAuth: true})
+    for _, query := range []string{
+        "window=abc",
+        "window=-1h",
+        "bucket=0s",
+        "window=1h&bucket=2h",
+        "window=1000h&bucket=1m",
+        "window=1000h&bucket=10h",
+        "top=0",
+        "top=1000",
+        "interval=year",
+        "interval=hour&tz=Mars/Olympus",
+        "interval=hour&group_by=color",
+        "interval=hour&group_by=hashtag&clusters=101",
+        "interval=hour&group_by=cluster&clusters=101,x",
+        "interval=hour&group_by=cluster&clusters=-5",
+        "interval=day&from=yesterday",
+        "interval=day&sentiment=maybe",
+    } {
+        var body map[string]string
+        status := getJSON(t, ts, "/stats?"+query, "", &body)
+        if status != http.StatusBadRequest {
+            t.Errorf("%q: status %d, want 400", query, status)
+        }
+        if body["error"] == "" {
+            t.Errorf("%q: no error message", query)
+        }
+    }
+}
+
+//a public searches token's stats count only the tweets of the public track terms
+func TestStatsPublicScope(t *testing.T) {
+    store := newTestStore(t, tweetsTestTweets...)
+    ts := NewTweetServer(store, Options{PublicTrack: []string{"golang"}})
+    public := newTestToken(t, store, tweetstore.ScopePublicSearches)
+    home := newTestToken(t, store, tweetstore.ScopeHomeTimeline)
+
+    var stats Stats
+    status := getJSON(t, ts, "/stats?window=1h&bucket=10m", public, &stats)
+    if status != http.StatusOK {
+        t.Fatalf("status %d", status)
+    }
+    if stats.TweetCount != 3 {
+        t.Errorf("tweet_count %d, want the 3 golang tweets", stats.TweetCount)
+    }
+    for _, c := range stats.Users {
+        if c.Key == "carol" {
+            t.Errorf("top users include carol, who never tweeted golang: %v", stats.Users)
+        }
+    }
+    for _, c := range stats.Hashtags {
+        if c.Key == "weekend" {
+            t.Errorf("top hashtags include a home timeline tweet's: %v", stats.Hashtags)
+        }
+    }
+    if len(stats.Hashtags) != 2 {
+        t.Errorf("top hashtags %v, want golang and meetup", stats.Hashtags)
+    }
+
+    stats = Stats{}
+    getJSON(t, ts, "/stats?window=1h&bucket=10m", home, &stats)
+    if stats.TweetCount != len(tweetsTestTweets) {
+        t.Errorf("home timeline tweet_count %d, want %d", stats.TweetCount, len(tweetsTestTweets))
+    }
+}
@@ -0,0 +1,142 @@
+package tweetserver
+
+import (
+    "fmt"
+    "github.com/fcheslack/webtypes/twitter"
+    "github.com/fcheslack/tweetlog/analytics"
+    "github.com/fcheslack/tweetlog/tweetstore"
+    "log"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+)
+
+const (
+    defaultTermsWindow = 24 * time.Hour
+    maxTermsWindow     = 7 * 24 * time.Hour
+    defaultTermsLimit  = 20
+    maxTermsLimit      = 100
+)
+
+type termsKey struct {
+    window     time.Duration
+    screenName string
+    track      string
+    byTrack    bool
+    n          int
+    limit      int
+    langs      string
+    public     bool
+}
+
+//parseTermsParams reads window (a go duration, at most a week), screen_name, track,
+//group_by (track for a report per public track term), n (the longest n-gram, 1 to 3,
+//default 2), limit and lang (comma separated stopword languages, default all)
+func parseTermsParams(v url.Values) (termsKey, error) {
+    k := termsKey{
+        window:     defaultTermsWindow,
+        screenName: v.Get("screen_name"),
+        track:      v.Get("track"),
+        n:          2,
+        limit:      defaultTermsLimit,
+        langs:      v.Get("lang"),
+    }
+    var err error
+    if s := v.Get("window"); s != "" {
+        k.window, err = time.ParseDuration(s)
+        if err != nil || k.window <= 0 || k.window > maxTermsWindow {
+            return k, fmt.Errorf("invalid window, use a duration like 24h, at most %s", maxTermsWindow)
+        }
+    }
+    switch v.Get("group_by") {
+    case "":
+    case "track":
+        if k.track != "" {
+            return k, fmt.Errorf("use either track or group_by=track")
+        }
+        k.byTrack = true
+    default:
+        return k, fmt.Errorf("invalid group_by, use track")
+    }
+    if s := v.Get("n"); s != "" {
+        k.n, err = strconv.Atoi(s)
+        if err != nil || k.n < 1 || k.n > analytics.MaxNGram {
+            return k, fmt.Errorf("invalid n, must be 1 to %d", analytics.MaxNGram)
+        }
+    }
+    if s := v.Get("limit"); s != "" {
+        k.limit, err = strconv.Atoi(s)
+        if err != nil || k.limit <= 0 || k.limit > maxTermsLimit {
+            return k, fmt.Errorf("invalid limit, must be 1 to %d", maxTermsLimit)
+        }
+    }
+    return k, nil
+}
+
+//computeTerms counts the terms of the tweets in the window ending now matching k,
+//only the public search tweets for tokens limited to them
+func (ts *TweetServer) computeTerms(k termsKey, track string) (*analytics.TermReport, error) {
+    endTime := time.Now()
+    startTime := endTime.Add(-k.window)
+    var langs []string
+    if k.langs != "" {
+        langs = strings.Split(k.langs, ",")
+    }
+    c := analytics.NewTermCounter(analytics.NewTokenizer(langs...), k.n)
+    q := tweetstore.TweetQuery{From: startTime, To: endTime, ScreenName: k.screenName, Text: track, Limit: tweetstore.MaxQueryLimit}
+    err := tweetstore.EachTweet(ts.TweetStore, q, func(tweet *twittertypes.Tweet) bool {
+        if !k.public || ts.publicTweet(tweet) {
+            c.Add(tweet)
+        }
+        return true
+    })
+    if err != nil {
+        return nil, err
+    }
+    r := c.Report(k.limit)
+    r.From = startTime.UTC()
+    r.To = endTime.UTC()
+    r.ScreenName = k.screenName
+    r.Track = track
+    return r, nil
+}
+
+//termsHandler serves the most frequent terms and n-grams over ?window, of ?screen_name
+//and of ?track if given, see parseTermsParams. With ?group_by=track it serves a list,
+//one report per public track term.
+func (ts *TweetServer) termsHandler(rw http.ResponseWriter, req *http.Request) {
+    if req.Method == "GET" {
+        k, err := parseTermsParams(req.URL.Query())
+        if err != nil {
+            writeJSONError(rw, http.StatusBadRequest, err)
+            return
+        }
+        k.public = ts.tweetFilter(req) != nil
+        j, err := ts.cachedBody(k, func() (interface{}, error) {
+            if !k.byTrack {
+                return ts.computeTerms(k, k.track)
+            }
+            reports := make([]*analytics.TermReport, 0, len(ts.trackTerms))
+            for _, term := range ts.trackTerms {
+                r, err := ts.computeTerms(k, term)
+                if err != nil {
+                    return nil, err
+                }
+                reports = append(reports, r)
+            }
+            return reports, nil
+        })
+        if err != nil {
+            log.Printf("Error computing terms: %s\n", err)
+            writeJSONError(rw, http.StatusInternalServerError, fmt.Errorf("terms failed"))
+            return
+        }
+        rw.Header().Set("Content-Type", "application/json")
+        rw.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(statsCacheTTL.Seconds())))
+        rw.Write(j)
+    } else if req.Method == "OPTIONS" {
+        rw.Header().Set("Access-Control-Allow-Methods", "GET")
+    }
+}
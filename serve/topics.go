@@ -0,0 +1,142 @@
+package tweetserver
+
+import (
+    "fmt"
+    "github.com/fcheslack/webtypes/twitter"
+    "github.com/fcheslack/tweetlog/analytics"
+    "github.com/fcheslack/tweetlog/tweetstore"
+    "log"
+    "net/http"
+    "net/url"
+    "strconv"
+    "time"
+)
+
+const (
+    defaultTopicsWindow = 24 * time.Hour
+    maxTopicsWindow     = 7 * 24 * time.Hour
+    defaultTopicsLimit  = 20
+    maxTopicsLimit      = 100
+    defaultTopicsPairs  = 50
+    maxTopicsPairs      = 500
+)
+
+type topicsKey struct {
+    window   time.Duration
+    score    string
+    terms    bool
+    minCount int
+    limit    int
+    pairs    int
+    public   bool
+}
+
+//parseTopicsParams reads window (a go duration, at most a week), score (pmi, npmi or
+//jaccard), terms (true to pair words as well as hashtags), min_count, limit and pairs
+func parseTopicsParams(v url.Values) (topicsKey, error) {
+    k := topicsKey{
+        window:   defaultTopicsWindow,
+        score:    analytics.DefaultTopicConfig.Score,
+        minCount: analytics.DefaultTopicConfig.MinCount,
+        limit:    defaultTopicsLimit,
+        pairs:    defaultTopicsPairs,
+    }
+    var err error
+    if s := v.Get("window"); s != "" {
+        k.window, err = time.ParseDuration(s)
+        if err != nil || k.window <= 0 || k.window > maxTopicsWindow {
+            return k, fmt.Errorf("invalid window, use a duration like 24h, at most %s", maxTopicsWindow)
+        }
+    }
+    if s := v.Get("score"); s != "" {
+        switch s {
+        case analytics.ScorePMI, analytics.ScoreNPMI, analytics.ScoreJaccard:
+            k.score = s
+        default:
+            return k, fmt.Errorf("invalid score, use pmi, npmi or jaccard")
+        }
+    }
+    if s := v.Get("terms"); s != "" {
+        k.terms, err = strconv.ParseBool(s)
+        if err != nil {
+            return k, fmt.Errorf("invalid terms, use true or false")
+        }
+    }
+    ints := []struct {
+        name string
+        max  int
+        val  *int
+    }{
+        {"min_count", 1000000, &k.minCount},
+        {"limit", maxTopicsLimit, &k.limit},
+        {"pairs", maxTopicsPairs, &k.pairs},
+    }
+    for _, p := range ints {
+        if s := v.Get(p.name); s != "" {
+            *p.val, err = strconv.Atoi(s)
+            if err != nil || *p.val <= 0 || *p.val > p.max {
+                return k, fmt.Errorf("invalid %s, must be 1 to %d", p.name, p.max)
+            }
+        }
+    }
+    return k, nil
+}
+
+//computeTopics counts the co-occurrences of the tweets in the window ending now,
+//only the public search tweets for tokens limited to them
+func (ts *TweetServer) computeTopics(k topicsKey) (*analytics.TopicReport, error) {
+    endTime := time.Now()
+    startTime := endTime.Add(-k.window)
+    cfg := analytics.DefaultTopicConfig
+    cfg.Score = k.score
+    cfg.Terms = k.terms
+    cfg.MinCount = k.minCount
+    c := analytics.NewTopicCounter(cfg)
+    q := tweetstore.TweetQuery{From: startTime, To: endTime, Limit: tweetstore.MaxQueryLimit}
+    err := tweetstore.EachTweet(ts.TweetStore, q, func(tweet *twittertypes.Tweet) bool {
+        if !k.public || ts.publicTweet(tweet) {
+            c.Add(tweet)
+        }
+        return true
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    r := c.Report()
+    r.From = startTime.UTC()
+    r.To = endTime.UTC()
+    if len(r.Topics) > k.limit {
+        r.Topics = r.Topics[:k.limit]
+    }
+    if len(r.Pairs) > k.pairs {
+        r.Pairs = r.Pairs[:k.pairs]
+    }
+    return r, nil
+}
+
+//topicsHandler serves groups of hashtags, and words with ?terms=true, that are used
+//together over ?window, with the ?pairs strongest co-occurrences, see parseTopicsParams
+func (ts *TweetServer) topicsHandler(rw http.ResponseWriter, req *http.Request) {
+    if req.Method == "GET" {
+        k, err := parseTopicsParams(req.URL.Query())
+        if err != nil {
+            writeJSONError(rw, http.StatusBadRequest, err)
+            return
+        }
+        k.public = ts.tweetFilter(req) != nil
+        j, err := ts.cachedBody(k, func() (interface{}, error) {
+            return ts.computeTopics(k)
+        })
+        if err != nil {
+            log.Printf("Error computing topics: %s\n", err)
+            writeJSONError(rw, http.StatusInternalServerError, fmt.Errorf("topics failed"))
+            return
+        }
+        rw.Header().Set("Content-Type", "application/json")
+        rw.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(statsCacheTTL.Seconds())))
+        rw.Write(j)
+    } else if req.Method == "OPTIONS" {
+        rw.Header().Set("Access-Control-Allow-Methods", "GET")
+    }
+}
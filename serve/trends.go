@@ -0,0 +1,90 @@
+package tweetserver
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/fcheslack/webtypes/twitter"
+    "github.com/fcheslack/tweetlog/analytics"
+    "github.com/fcheslack/tweetlog/tweetstore"
+    "log"
+    "net/http"
+    "strconv"
+    "time"
+)
+
+const (
+    defaultTrendsLimit = 20
+    maxTrendsLimit     = 100
+)
+
+//addTrend counts a newly broadcast tweet towards the trends
+func (ts *TweetServer) addTrend(tweet *twittertypes.Tweet) {
+    ts.trends.Add(tweet)
+    if ts.publicTrack != nil && ts.publicTweet(tweet) {
+        ts.publicTrends.Add(tweet)
+    }
+}
+
+//seedTrends fills the trend baseline from the store with the tweets up to maxId.
+//Later tweets are counted as they are broadcast.
+func (ts *TweetServer) seedTrends(maxId int64, cfg analytics.TrendConfig) {
+    if maxId <= 0 {
+        return
+    }
+    q := tweetstore.TweetQuery{
+        From:  time.Now().Add(-(cfg.Window + cfg.Baseline)),
+        MaxId: maxId,
+        Limit: tweetstore.MaxQueryLimit,
+    }
+    err := tweetstore.EachTweet(ts.TweetStore, q, func(tweet *twittertypes.Tweet) bool {
+        select {
+        case <-ts.done:
+            return false
+        default:
+        }
+        ts.addTrend(tweet)
+        return true
+    })
+    if err != nil {
+        fmt.Printf("Error seeding trends: %s\n", err)
+    }
+}
+
+//trendsHandler serves the rising and falling hashtags, urls and terms, optionally
+//only of ?kind (hashtag, url or term), up to ?limit of each
+func (ts *TweetServer) trendsHandler(rw http.ResponseWriter, req *http.Request) {
+    if req.Method == "GET" {
+        v := req.URL.Query()
+        kind := v.Get("kind")
+        switch kind {
+        case "", analytics.TrendHashtag, analytics.TrendUrl, analytics.TrendTerm:
+        default:
+            writeJSONError(rw, http.StatusBadRequest, fmt.Errorf("invalid kind, use hashtag, url or term"))
+            return
+        }
+        limit := defaultTrendsLimit
+        if s := v.Get("limit"); s != "" {
+            var err error
+            limit, err = strconv.Atoi(s)
+            if err != nil || limit <= 0 || limit > maxTrendsLimit {
+                writeJSONError(rw, http.StatusBadRequest, fmt.Errorf("invalid limit, must be 1 to %d", maxTrendsLimit))
+                return
+            }
+        }
+
+        detector := ts.trends
+        if ts.tweetFilter(req) != nil {
+            detector = ts.publicTrends
+        }
+        j, err := json.Marshal(detector.Trends(time.Now(), kind, limit))
+        if err != nil {
+            log.Printf("Error marshalling trends: %s\n", err)
+            writeJSONError(rw, http.StatusInternalServerError, fmt.Errorf("trends failed"))
+            return
+        }
+        rw.Header().Set("Content-Type", "application/json")
+        rw.Write(j)
+    } else if req.Method == "OPTIONS" {
+        rw.Header().Set("Access-Control-Allow-Methods", "GET")
+    }
+}
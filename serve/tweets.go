@@ -0,0 +1,203 @@
+package tweetserver
+
+import (
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "github.com/fcheslack/webtypes/twitter"
+    "github.com/fcheslack/tweetlog/tweetstore"
+    "log"
+    "net/http"
+    "net/url"
+    "strconv"
+    "time"
+)
+
+//TweetsPage is the response body of /tweets. NextCursor is empty on the last page.
+type TweetsPage struct {
+    Tweets     []*twittertypes.Tweet `json:"tweets"`
+    NextCursor string                `json:"next_cursor,omitempty"`
+}
+
+//pageCursor is the position carried by an opaque /tweets cursor
+type pageCursor struct {
+    MaxId int64 `json:"m"`
+}
+
+func encodeCursor(c pageCursor) string {
+    j, _ := json.Marshal(c)
+    return base64.RawURLEncoding.EncodeToString(j)
+}
+
+func decodeCursor(s string) (pageCursor, error) {
+    var c pageCursor
+    j, err := base64.RawURLEncoding.DecodeString(s)
+    if err != nil {
+        return c, fmt.Errorf("invalid cursor")
+    }
+    err = json.Unmarshal(j, &c)
+    if err != nil || c.MaxId <= 0 {
+        return c, fmt.Errorf("invalid cursor")
+    }
+    return c, nil
+}
+
+//parseQueryTime accepts RFC3339 or unix seconds
+func parseQueryTime(s string) (time.Time, error) {
+    if unix, err := strconv.ParseInt(s, 10, 64); err == nil {
+        return time.Unix(unix, 0).UTC(), nil
+    }
+    return time.Parse(time.RFC3339, s)
+}
+
+//parseTweetQuery builds a store query from /tweets request parameters
+func parseTweetQuery(v url.Values) (tweetstore.TweetQuery, error) {
+    q := tweetstore.TweetQuery{
+        ScreenName: v.Get("screen_name"),
+        Hashtag:    v.Get("hashtag"),
+        Mention:    v.Get("mention"),
+        UrlDomain:  v.Get("url_domain"),
+        Lang:       v.Get("lang"),
+        Text:       v.Get("q"),
+    }
+    if q.Text != "" && len(tweetstore.SearchWords(q.Text)) == 0 {
+        return q, fmt.Errorf("invalid q, it has no words to search for")
+    }
+    var err error
+    for _, p := range []struct {
+        name string
+        dest *int64
+    }{{"since_id", &q.SinceId}, {"max_id", &q.MaxId}, {"in_reply_to", &q.InReplyTo}, {"cluster", &q.ClusterId}} {
+        if s := v.Get(p.name); s != "" {
+            *p.dest, err = strconv.ParseInt(s, 10, 64)
+            if err != nil || *p.dest < 0 {
+                return q, fmt.Errorf("invalid %s", p.name)
+            }
+        }
+    }
+    for _, p := range []struct {
+        name string
+        dest *time.Time
+    }{{"from", &q.From}, {"to", &q.To}} {
+        if s := v.Get(p.name); s != "" {
+            *p.dest, err = parseQueryTime(s)
+            if err != nil {
+                return q, fmt.Errorf("invalid %s, use RFC3339 or unix seconds", p.name)
+            }
+        }
+    }
+    if s := v.Get("max_account_score"); s != "" {
+        q.MaxAccountScore, err = strconv.ParseFloat(s, 64)
+        if err != nil || q.MaxAccountScore <= 0 || q.MaxAccountScore > 1 {
+            return q, fmt.Errorf("invalid max_account_score, must be above 0 and at most 1")
+        }
+    }
+    if s := v.Get("limit"); s != "" {
+        q.Limit, err = strconv.Atoi(s)
+        if err != nil || q.Limit <= 0 || q.Limit > tweetstore.MaxQueryLimit {
+            return q, fmt.Errorf("invalid limit, must be 1 to %d", tweetstore.MaxQueryLimit)
+        }
+    } else {
+        q.Limit = tweetstore.DefaultQueryLimit
+    }
+    if s := v.Get("cursor"); s != "" {
+        c, err := decodeCursor(s)
+        if err != nil {
+            return q, err
+        }
+        if q.MaxId == 0 || c.MaxId < q.MaxId {
+            q.MaxId = c.MaxId
+        }
+    }
+    return q, nil
+}
+
+func writeJSONError(rw http.ResponseWriter, status int, err error) {
+    rw.Header().Set("Content-Type", "application/json")
+    rw.WriteHeader(status)
+    j, _ := json.Marshal(map[string]string{"error": err.Error()})
+    rw.Write(j)
+}
+
+//publicQuery limits q to the public track terms when allow, the request's tweetFilter, is
+//set, so the store only looks at tweets the token may read. It reports false when allow
+//accepts nothing, as there are no public track terms.
+func (ts *TweetServer) publicQuery(q *tweetstore.TweetQuery, allow tweetFilter) bool {
+    if allow == nil {
+        return true
+    }
+    if ts.publicTrack == nil {
+        return false
+    }
+    q.Track = ts.publicTrack.phrases
+    return true
+}
+
+//maxPageQueries bounds the store queries behind one /tweets page for a public token
+const maxPageQueries = 5
+
+//queryPage fills a page of q.Limit tweets that allow accepts. The store already selects
+//tweets of the public track terms, but matches words more loosely than allow, so a page
+//may take a further query or two to fill. The cursor follows the last tweet looked at.
+func (ts *TweetServer) queryPage(q tweetstore.TweetQuery, allow tweetFilter) (TweetsPage, error) {
+    page := TweetsPage{Tweets: make([]*twittertypes.Tweet, 0, q.Limit)}
+    var lastId int64
+    more := true
+    for n := 0; more && len(page.Tweets) < q.Limit && n < maxPageQueries; n++ {
+        tweets, err := ts.TweetStore.QueryTweets(q)
+        if err != nil {
+            return page, err
+        }
+        more = len(tweets) == q.Limit
+        for i, tweet := range tweets {
+            if tweet.Id == nil {
+                continue
+            }
+            lastId = int64(*tweet.Id)
+            if allow == nil || allow(tweet) {
+                page.Tweets = append(page.Tweets, tweet)
+            }
+            if len(page.Tweets) == q.Limit {
+                more = more || i < len(tweets)-1
+                break
+            }
+        }
+        if lastId <= 1 {
+            more = false
+        }
+        q.MaxId = lastId - 1
+    }
+    if more {
+        page.NextCursor = encodeCursor(pageCursor{MaxId: lastId - 1})
+    }
+    return page, nil
+}
+
+//tweetsHandler serves filtered, cursor paginated archive queries
+func (ts *TweetServer) tweetsHandler(rw http.ResponseWriter, req *http.Request) {
+    if req.Method == "GET" {
+        q, err := parseTweetQuery(req.URL.Query())
+        if err != nil {
+            writeJSONError(rw, http.StatusBadRequest, err)
+            return
+        }
+        allow := ts.tweetFilter(req)
+        page := TweetsPage{Tweets: []*twittertypes.Tweet{}}
+        //a token without the home timeline scope reads only the public track terms
+        if ts.publicQuery(&q, allow) {
+            page, err = ts.queryPage(q, allow)
+            if err != nil {
+                writeJSONError(rw, http.StatusInternalServerError, fmt.Errorf("query failed"))
+                return
+            }
+        }
+        j, err := json.Marshal(page)
+        if err != nil {
+            log.Printf("Error marshalling tweets page: %s\n", err)
+        }
+        rw.Header().Set("Content-Type", "application/json")
+        rw.Write(j)
+    } else if req.Method == "OPTIONS" {
+        rw.Header().Set("Access-Control-Allow-Methods", "GET")
+    }
+}
@@ -0,0 +1,169 @@
+package tweetserver
+
+import (
+    "github.com/fcheslack/tweetlog/tweetstore"
+    "net/http"
+    "net/url"
+    "testing"
+)
+
+var tweetsTestTweets = []testTweet{
+    {101, "alice", "golang release notes", []string{"golang"}, nil},
+    {102, "bob", "lunch photos", nil, nil},
+    {103, "alice", "more golang news", nil, nil},
+    {104, "carol", "weekend plans", []string{"weekend"}, nil},
+    {105, "bob", "golang meetup tonight", []string{"meetup"}, nil},
+    {106, "carol", "nothing to see", nil, nil},
+}
+
+func TestTweetsFilters(t *testing.T) {
+    ts := NewTweetServer(newTestStore(t, tweetsTestTweets...), Options{NoAuth: true})
+    for _, c := range []struct {
+        query string
+        want  []int64
+    }{
+        {"", []int64{106, 105, 104, 103, 102, 101}},
+        {"screen_name=alice", []int64{103, 101}},
+        {"screen_name=@ALICE", []int64{103, 101}},
+        {"hashtag=%23weekend", []int64{104}},
+        {"q=golang", []int64{105, 103, 101}},
+        {"since_id=103", []int64{106, 105, 104}},
+        {"max_id=103", []int64{103, 102, 101}},
+        {"screen_name=bob&q=golang", []int64{105}},
+        {"screen_name=nobody", []int64{}},
+        //search text is read as words, never as fts syntax
+        {"q=golang%22", []int64{105, 103, 101}},
+        {"q=-golang", []int64{105, 103, 101}},
+        {"q=golang*", []int64{105, 103, 101}},
+        {"q=more+golang", []int64{103}},
+        {"q=NEAR(golang", []int64{}},
+        {"q=golang+OR+lunch", []int64{}},
+        {"q=c%2B%2B", []int64{}},
+    } {
+        var page TweetsPage
+        status := getJSON(t, ts, "/tweets?"+c.query, "", &page)
+        if status != http.StatusOK {
+            t.Errorf("%q: status %d", c.query, status)
+            continue
+        }
+        if got := tweetIds(page.Tweets); !sameIds(got, c.want...) {
+            t.Errorf("%q: got %v, want %v", c.query, got, c.want)
+        }
+        if page.NextCursor != "" {
+            t.Errorf("%q: cursor on the only page", c.query)
+        }
+    }
+}
+
+//following next_cursor visits every tweet once, and the last page has no cursor
+func TestTweetsCursor(t *testing.T) {
+    ts := NewTweetServer(newTestStore(t, tweetsTestTweets...), Options{NoAuth: true})
+    wantPages := [][]int64{{106, 105, 104, 103}, {102, 101}}
+    path := "/tweets?limit=4"
+    for i, want := range wantPages {
+        var page TweetsPage
+        status := getJSON(t, ts, path, "", &page)
+        if status != http.StatusOK {
+            t.Fatalf("page %d: status %d", i, status)
+        }
+        if got := tweetIds(page.Tweets); !sameIds(got, want...) {
+            t.Fatalf("page %d: got %v, want %v", i, got, want)
+        }
+        last := i == len(wantPages)-1
+        if last && page.NextCursor != "" {
+            t.Errorf("last page has cursor %q", page.NextCursor)
+        }
+        if !last && page.NextCursor == "" {
+            t.Fatalf("page %d has no cursor", i)
+        }
+        path = "/tweets?limit=4&cursor=" + url.QueryEscape(page.NextCursor)
+    }
+
+    //a page that ends exactly at the last tweet leads to one empty page
+    var page TweetsPage
+    getJSON(t, ts, "/tweets?limit=3&max_id=103", "", &page)
+    if page.NextCursor == "" {
+        t.Fatalf("full page has no cursor")
+    }
+    cursor := page.NextCursor
+    page = TweetsPage{}
+    getJSON(t, ts, "/tweets?limit=3&cursor="+url.QueryEscape(cursor), "", &page)
+    if len(page.Tweets) != 0 || page.NextCursor != "" {
+        t.Errorf("after the last tweet got %v, cursor %q", tweetIds(page.Tweets), page.NextCursor)
+    }
+}
+
+func TestTweetsInvalidParams(t *testing.T) {
+    ts := NewTweetServer(newTestStore(t), Options{NoAuth: true})
+    for _, query := range []string{
+        "limit=0",
+        "limit=abc",
+        "limit=100000",
+        "since_id=-1",
+        "max_id=x",
+        "from=yesterday",
+        "to=2013-13-01",
+        "cursor=notacursor",
+        "cursor=e30",
+        "max_account_score=2",
+        "q=%2B%2B",
+    } {
+        var body map[string]string
+        status := getJSON(t, ts, "/tweets?"+query, "", &body)
+        if status != http.StatusBadRequest {
+            t.Errorf("%q: status %d, want 400", query, status)
+        }
+        if body["error"] == "" {
+            t.Errorf("%q: no error message", query)
+        }
+    }
+}
+
+//a public searches token gets full pages of tweets matching the public track terms,
+//even where the store's word matching lets others through
+func TestTweetsPublicScope(t *testing.T) {
+    tweets := make([]testTweet, 0, 20)
+    for i := int64(0); i < 20; i++ {
+        text := "unrelated chatter"
+        switch i % 4 {
+        case 0:
+            text = "Golang release party"
+        case 2:
+            //full text search splits words at the underscore, the track filter doesn't
+            text = "golang_release party"
+        }
+        tweets = append(tweets, testTweet{id: 200 + i, screenName: "someone", text: text})
+    }
+    store := newTestStore(t, tweets...)
+    ts := NewTweetServer(store, Options{PublicTrack: []string{"golang release"}})
+    public := newTestToken(t, store, tweetstore.ScopePublicSearches)
+    home := newTestToken(t, store, tweetstore.ScopeHomeTimeline)
+
+    var page TweetsPage
+    status := getJSON(t, ts, "/tweets?limit=2", public, &page)
+    if status != http.StatusOK {
+        t.Fatalf("status %d", status)
+    }
+    if got := tweetIds(page.Tweets); !sameIds(got, 216, 212) {
+        t.Errorf("first public page got %v", got)
+    }
+    getJSON(t, ts, "/tweets?limit=2&cursor="+url.QueryEscape(page.NextCursor), public, &page)
+    if got := tweetIds(page.Tweets); !sameIds(got, 208, 204) {
+        t.Errorf("second public page got %v", got)
+    }
+    cursor := page.NextCursor
+    page = TweetsPage{}
+    getJSON(t, ts, "/tweets?limit=2&cursor="+url.QueryEscape(cursor), public, &page)
+    if got := tweetIds(page.Tweets); !sameIds(got, 200) || page.NextCursor != "" {
+        t.Errorf("last public page got %v, cursor %q", got, page.NextCursor)
+    }
+
+    getJSON(t, ts, "/tweets?limit=2", home, &page)
+    if got := tweetIds(page.Tweets); !sameIds(got, 219, 218) {
+        t.Errorf("home timeline page got %v", got)
+    }
+    status = getJSON(t, ts, "/tweets", "", nil)
+    if status != http.StatusUnauthorized {
+        t.Errorf("without a token status %d, want 401", status)
+    }
+}
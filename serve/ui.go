@@ -0,0 +1,462 @@
+package tweetserver
+
+import (
+    "embed"
+    "encoding/json"
+    "fmt"
+    "github.com/fcheslack/webtypes/twitter"
+    "github.com/fcheslack/tweetlog/tweetstore"
+    "html"
+    "html/template"
+    "io/fs"
+    "log"
+    "net/http"
+    "net/url"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+//the UI is served from the binary, with no external scripts or stylesheets
+//
+//go:embed ui/templates ui/static
+var uiFiles embed.FS
+
+const (
+    uiTokenCookie  = "tweetlog_token"
+    uiTimelineSize = 50
+    maxThreadDepth = 25
+)
+
+var uiFuncs = template.FuncMap{
+    "pct": func(n, max int) int {
+        if max <= 0 {
+            return 0
+        }
+        return n * 100 / max
+    },
+}
+
+//uiTemplates holds a template set per page, each the layout plus the page's "content"
+var uiTemplates = make(map[string]*template.Template)
+
+func init() {
+    for _, page := range []string{"timeline", "tweets", "thread", "stats", "login"} {
+        uiTemplates[page] = template.Must(template.New(page).Funcs(uiFuncs).ParseFS(uiFiles,
+            "ui/templates/layout.html", "ui/templates/tweet.html", "ui/templates/"+page+".html"))
+    }
+}
+
+//uiMedia is a media thumbnail linking to the full media
+type uiMedia struct {
+    Href  string
+    Thumb string
+}
+
+//uiTweet is a tweet with its entities rendered to html
+type uiTweet struct {
+    Id         int64
+    ScreenName string
+    Name       string
+    Time       time.Time
+    Html       template.HTML
+    Media      []uiMedia
+    InReplyTo  int64
+}
+
+//uiPage is what every page template is executed with
+type uiPage struct {
+    Title     string
+    Query     string
+    Error     string
+    Tweets    []uiTweet
+    Next      string
+    LastId    int64
+    Focus     *uiTweet
+    Ancestors []uiTweet
+    Replies   []uiTweet
+    Stats     *Stats
+    MaxVolume int
+    Windows   []string
+}
+
+type uiEntity struct {
+    Text            string
+    Screen_name     string
+    Url             string
+    Expanded_url    string
+    Display_url     string
+    Media_url_https string
+    Media_url       string
+    Indices         []int
+}
+
+//uiSource is what rendering needs from the stored tweet json, which unlike the
+//parsed tweet has the entity indices
+type uiSource struct {
+    Text                  string
+    In_reply_to_status_id *int64
+    User                  struct {
+        Screen_name string
+        Name        string
+    }
+    Entities struct {
+        Hashtags      []uiEntity
+        User_mentions []uiEntity
+        Urls          []uiEntity
+        Media         []uiEntity
+    }
+    Extended_entities struct {
+        Media []uiEntity
+    }
+}
+
+//span is an entity's place in the tweet text and the html replacing it
+type span struct {
+    start, end int
+    html       string
+}
+
+//safeUrl returns u if it is an http or https url, since it is written into html unfiltered
+func safeUrl(u string) string {
+    parsed, err := url.Parse(u)
+    if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+        return ""
+    }
+    return u
+}
+
+func escapeText(s string) string {
+    return strings.Replace(template.HTMLEscapeString(html.UnescapeString(s)), "\n", "<br>", -1)
+}
+
+//linkText renders tweet text with hashtags, mentions and links as links and media links removed.
+//Indices count code points of the text as twitter sends it, html entities and all.
+func linkText(text string, spans []span) string {
+    runes := []rune(text)
+    sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+    var b strings.Builder
+    pos := 0
+    for _, s := range spans {
+        if s.start < pos || s.end > len(runes) || s.start >= s.end {
+            continue
+        }
+        b.WriteString(escapeText(string(runes[pos:s.start])))
+        b.WriteString(s.html)
+        pos = s.end
+    }
+    b.WriteString(escapeText(string(runes[pos:])))
+    return strings.TrimSpace(b.String())
+}
+
+func renderTweet(tweet *twittertypes.Tweet) uiTweet {
+    var t uiTweet
+    if tweet.Id != nil {
+        t.Id = int64(*tweet.Id)
+    }
+    t.Time = tweetTime(tweet, t.Id)
+    if tweet.User != nil {
+        t.ScreenName = tweet.User.Screen_name
+        t.Name = string(tweet.User.Name)
+    }
+
+    var src uiSource
+    if tweet.RawBytes == nil || json.Unmarshal(tweet.RawBytes, &src) != nil {
+        t.Html = template.HTML(escapeText(tweet.Text))
+        return t
+    }
+    if src.In_reply_to_status_id != nil {
+        t.InReplyTo = *src.In_reply_to_status_id
+    }
+    if t.Name == "" {
+        t.Name = src.User.Name
+    }
+
+    var spans []span
+    add := func(e uiEntity, h string) {
+        if len(e.Indices) == 2 {
+            spans = append(spans, span{start: e.Indices[0], end: e.Indices[1], html: h})
+        }
+    }
+    for _, e := range src.Entities.Hashtags {
+        add(e, fmt.Sprintf(`<a class="hashtag" href="/ui/hashtag/%s">#%s</a>`, url.PathEscape(e.Text), template.HTMLEscapeString(e.Text)))
+    }
+    for _, e := range src.Entities.User_mentions {
+        add(e, fmt.Sprintf(`<a class="mention" href="/ui/user/%s">@%s</a>`, url.PathEscape(e.Screen_name), template.HTMLEscapeString(e.Screen_name)))
+    }
+    for _, e := range src.Entities.Urls {
+        display := e.Display_url
+        if display == "" {
+            display = e.Expanded_url
+        }
+        if safeUrl(e.Expanded_url) == "" {
+            continue
+        }
+        add(e, fmt.Sprintf(`<a class="url" href="%s" rel="nofollow noopener">%s</a>`, template.HTMLEscapeString(e.Expanded_url), template.HTMLEscapeString(display)))
+    }
+    media := src.Extended_entities.Media
+    if len(media) == 0 {
+        media = src.Entities.Media
+    }
+    for i, e := range media {
+        //every photo of a tweet shares the one media link in the text
+        if i == 0 {
+            add(e, "")
+        }
+        thumb := e.Media_url_https
+        if thumb == "" {
+            thumb = e.Media_url
+        }
+        if safeUrl(thumb) != "" {
+            t.Media = append(t.Media, uiMedia{Href: safeUrl(e.Expanded_url), Thumb: thumb + ":thumb"})
+        }
+    }
+    text := src.Text
+    if text == "" {
+        text = tweet.Text
+    }
+    t.Html = template.HTML(linkText(text, spans))
+    return t
+}
+
+func renderTweets(tweets []*twittertypes.Tweet) []uiTweet {
+    rendered := make([]uiTweet, 0, len(tweets))
+    for _, tweet := range tweets {
+        rendered = append(rendered, renderTweet(tweet))
+    }
+    return rendered
+}
+
+func (ts *TweetServer) renderPage(rw http.ResponseWriter, page string, status int, p *uiPage) {
+    rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+    rw.Header().Set("Content-Security-Policy", "default-src 'self'; img-src 'self' https: data:; connect-src 'self' ws: wss:")
+    rw.WriteHeader(status)
+    err := uiTemplates[page].ExecuteTemplate(rw, "layout", p)
+    if err != nil {
+        log.Printf("Error rendering %s page: %s\n", page, err)
+    }
+}
+
+//tweetById loads one tweet, or nil if it isn't in the archive or the request may not read it
+func (ts *TweetServer) tweetById(req *http.Request, id int64) *twittertypes.Tweet {
+    tweets, err := ts.TweetStore.QueryTweets(tweetstore.TweetQuery{SinceId: id - 1, MaxId: id, Limit: 1})
+    if err != nil || len(tweets) == 0 {
+        return nil
+    }
+    if allow := ts.tweetFilter(req); allow != nil && !allow(tweets[0]) {
+        return nil
+    }
+    return tweets[0]
+}
+
+//uiHandler serves the html pages under /ui/
+func (ts *TweetServer) uiHandler(rw http.ResponseWriter, req *http.Request) {
+    p := strings.TrimPrefix(req.URL.Path, "/ui/")
+    if p == "login" {
+        ts.uiLogin(rw, req)
+        return
+    }
+    if req.Method != "GET" && req.Method != "HEAD" {
+        rw.Header().Set("Allow", "GET, HEAD")
+        http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    switch {
+    case p == "":
+        ts.uiTimeline(rw, req)
+    case p == "search":
+        ts.uiTweets(rw, req, "Search", req.URL.Query())
+    case p == "stats":
+        ts.uiStats(rw, req)
+    case p == "logout":
+        http.SetCookie(rw, &http.Cookie{Name: uiTokenCookie, Value: "", Path: "/", MaxAge: -1})
+        http.Redirect(rw, req, "/ui/login", http.StatusSeeOther)
+    case strings.HasPrefix(p, "user/"):
+        name := strings.TrimPrefix(strings.TrimPrefix(p, "user/"), "@")
+        v := req.URL.Query()
+        v.Set("screen_name", name)
+        ts.uiTweets(rw, req, "@"+name, v)
+    case strings.HasPrefix(p, "hashtag/"):
+        tag := strings.TrimPrefix(strings.TrimPrefix(p, "hashtag/"), "#")
+        v := req.URL.Query()
+        v.Set("hashtag", tag)
+        ts.uiTweets(rw, req, "#"+tag, v)
+    case strings.HasPrefix(p, "tweet/"):
+        ts.uiThread(rw, req, strings.TrimPrefix(p, "tweet/"))
+    case strings.HasPrefix(p, "fragment/"):
+        ts.uiFragment(rw, req, strings.TrimPrefix(p, "fragment/"))
+    default:
+        http.NotFound(rw, req)
+    }
+}
+
+//uiTimeline shows the latest tweets, kept up to date over /ws by app.js
+func (ts *TweetServer) uiTimeline(rw http.ResponseWriter, req *http.Request) {
+    var tweets []*twittertypes.Tweet
+    allow := ts.tweetFilter(req)
+    q := tweetstore.TweetQuery{Limit: uiTimelineSize}
+    if allow == nil {
+        tweets = ts.TweetStore.RecentTweets(uiTimelineSize)
+    } else if ts.publicQuery(&q, allow) {
+        //a failed query shows an empty timeline, filled in over /ws
+        page, _ := ts.queryPage(q, allow)
+        tweets = page.Tweets
+    }
+    page := &uiPage{Title: "Timeline", Tweets: renderTweets(tweets), LastId: ts.TweetStore.LatestTweetId()}
+    ts.renderPage(rw, "timeline", http.StatusOK, page)
+}
+
+//uiTweets lists the tweets matching v, which takes the same parameters as /tweets
+func (ts *TweetServer) uiTweets(rw http.ResponseWriter, req *http.Request, title string, v url.Values) {
+    page := &uiPage{Title: title, Query: v.Get("q")}
+    if page.Query != "" {
+        page.Title = "Search: " + page.Query
+    }
+    q, err := parseTweetQuery(v)
+    if err != nil {
+        page.Error = err.Error()
+        ts.renderPage(rw, "tweets", http.StatusBadRequest, page)
+        return
+    }
+    allow := ts.tweetFilter(req)
+    if ts.publicQuery(&q, allow) {
+        tweets, err := ts.queryPage(q, allow)
+        if err != nil {
+            page.Error = "query failed"
+            ts.renderPage(rw, "tweets", http.StatusInternalServerError, page)
+            return
+        }
+        page.Tweets = renderTweets(tweets.Tweets)
+        if tweets.NextCursor != "" {
+            next := req.URL.Query()
+            next.Set("cursor", tweets.NextCursor)
+            page.Next = req.URL.Path + "?" + next.Encode()
+        }
+    }
+    ts.renderPage(rw, "tweets", http.StatusOK, page)
+}
+
+//uiThread shows a tweet with the tweets it replies to above it and its replies below
+func (ts *TweetServer) uiThread(rw http.ResponseWriter, req *http.Request, idStr string) {
+    id, err := strconv.ParseInt(idStr, 10, 64)
+    var tweet *twittertypes.Tweet
+    if err == nil && id > 0 {
+        tweet = ts.tweetById(req, id)
+    }
+    if tweet == nil {
+        ts.renderPage(rw, "tweets", http.StatusNotFound, &uiPage{Title: "Thread", Error: "tweet not found in the archive"})
+        return
+    }
+    focus := renderTweet(tweet)
+    page := &uiPage{Title: "Thread", Focus: &focus}
+
+    parent := focus.InReplyTo
+    for i := 0; i < maxThreadDepth && parent != 0; i++ {
+        t := ts.tweetById(req, parent)
+        if t == nil {
+            break
+        }
+        r := renderTweet(t)
+        page.Ancestors = append([]uiTweet{r}, page.Ancestors...)
+        parent = r.InReplyTo
+    }
+
+    allow := ts.tweetFilter(req)
+    q := tweetstore.TweetQuery{InReplyTo: id, Limit: tweetstore.MaxQueryLimit}
+    if ts.publicQuery(&q, allow) {
+        replies, err := ts.TweetStore.QueryTweets(q)
+        if err == nil {
+            replies = filterTweets(replies, allow)
+            //oldest reply first
+            for i := len(replies) - 1; i >= 0; i-- {
+                page.Replies = append(page.Replies, renderTweet(replies[i]))
+            }
+        }
+    }
+    ts.renderPage(rw, "thread", http.StatusOK, page)
+}
+
+//uiFragment renders one tweet for app.js to insert into the live timeline
+func (ts *TweetServer) uiFragment(rw http.ResponseWriter, req *http.Request, idStr string) {
+    id, err := strconv.ParseInt(idStr, 10, 64)
+    var tweet *twittertypes.Tweet
+    if err == nil && id > 0 {
+        tweet = ts.tweetById(req, id)
+    }
+    if tweet == nil {
+        http.NotFound(rw, req)
+        return
+    }
+    rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+    err = uiTemplates["timeline"].ExecuteTemplate(rw, "tweet", renderTweet(tweet))
+    if err != nil {
+        log.Printf("Error rendering tweet fragment: %s\n", err)
+    }
+}
+
+//uiStats is the /stats document as a dashboard
+func (ts *TweetServer) uiStats(rw http.ResponseWriter, req *http.Request) {
+    page := &uiPage{Title: "Stats", Windows: []string{"1h", "6h", "24h", "168h"}}
+    k, err := parseStatsParams(req.URL.Query())
+    if err != nil {
+        page.Error = err.Error()
+        ts.renderPage(rw, "stats", http.StatusBadRequest, page)
+        return
+    }
+    k.public = ts.tweetFilter(req) != nil
+    j, err := ts.cachedStatsBody(k)
+    if err == nil {
+        page.Stats = &Stats{}
+        err = json.Unmarshal(j, page.Stats)
+    }
+    if err != nil {
+        page.Stats = nil
+        page.Error = "stats failed"
+        ts.renderPage(rw, "stats", http.StatusInternalServerError, page)
+        return
+    }
+    for _, b := range page.Stats.Volume {
+        if b.Count > page.MaxVolume {
+            page.MaxVolume = b.Count
+        }
+    }
+    ts.renderPage(rw, "stats", http.StatusOK, page)
+}
+
+//uiLogin trades an API token for a cookie, since a browser can't send a bearer header on navigation
+func (ts *TweetServer) uiLogin(rw http.ResponseWriter, req *http.Request) {
+    if ts.noAuth {
+        http.Redirect(rw, req, "/ui/", http.StatusSeeOther)
+        return
+    }
+    page := &uiPage{Title: "Sign in"}
+    if req.Method == "POST" {
+        secret := strings.TrimSpace(req.PostFormValue("token"))
+        token, err := ts.TweetStore.ApiTokenByHash(tweetstore.HashApiToken(secret))
+        if err == nil && token != nil && !token.Revoked() {
+            http.SetCookie(rw, &http.Cookie{
+                Name:     uiTokenCookie,
+                Value:    secret,
+                Path:     "/",
+                HttpOnly: true,
+                Secure:   req.TLS != nil,
+                SameSite: http.SameSiteStrictMode,
+            })
+            http.Redirect(rw, req, "/ui/", http.StatusSeeOther)
+            return
+        }
+        page.Error = "invalid or revoked token"
+        ts.renderPage(rw, "login", http.StatusUnauthorized, page)
+        return
+    }
+    ts.renderPage(rw, "login", http.StatusOK, page)
+}
+
+func uiStatic() http.Handler {
+    static, err := fs.Sub(uiFiles, "ui/static")
+    if err != nil {
+        panic(err)
+    }
+    return http.StripPrefix("/ui/static/", http.FileServer(http.FS(static)))
+}
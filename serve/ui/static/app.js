@@ -0,0 +1,82 @@
+// Live timeline: new tweets arrive over /ws and are rendered by the server
+// at /ui/fragment/{id}, so the page and the feed look the same.
+(function () {
+    var timeline = document.getElementById('timeline');
+    var status = document.getElementById('live-status');
+    if (!timeline || !window.WebSocket || !window.fetch) {
+        return;
+    }
+    var lastId = timeline.getAttribute('data-last-id');
+    var retry = 1000;
+
+    function setStatus(text) {
+        status.textContent = text;
+    }
+
+    function tweetId(body) {
+        // ids are too big for javascript numbers, use the string form
+        return body.id_str || body.Id_str || '';
+    }
+
+    function insert(id) {
+        fetch('/ui/fragment/' + encodeURIComponent(id), {credentials: 'same-origin'})
+            .then(function (resp) { return resp.ok ? resp.text() : ''; })
+            .then(function (html) {
+                if (!html || document.getElementById('t' + id)) {
+                    return;
+                }
+                var holder = document.createElement('div');
+                holder.innerHTML = html;
+                var article = holder.firstElementChild;
+                if (!article) {
+                    return;
+                }
+                article.classList.add('new');
+                var empty = timeline.querySelector('.empty');
+                if (empty) {
+                    empty.remove();
+                }
+                timeline.insertBefore(article, timeline.firstChild);
+            });
+    }
+
+    function connect() {
+        var proto = location.protocol === 'https:' ? 'wss:' : 'ws:';
+        var url = proto + '//' + location.host + '/ws';
+        if (lastId && lastId !== '0') {
+            url += '?last_id=' + encodeURIComponent(lastId);
+        }
+        var ws = new WebSocket(url);
+        ws.onopen = function () {
+            retry = 1000;
+            setStatus('Live');
+        };
+        ws.onmessage = function (e) {
+            var m;
+            try {
+                m = JSON.parse(e.data);
+            } catch (err) {
+                return;
+            }
+            if (m.Type === 'dropped') {
+                setStatus('Disconnected: ' + m.Body);
+            }
+            if (m.Type !== 'tweet' || !m.Body) {
+                return;
+            }
+            var id = tweetId(m.Body);
+            if (id) {
+                lastId = id;
+                insert(id);
+            }
+        };
+        ws.onclose = function () {
+            setStatus('Reconnecting...');
+            setTimeout(connect, retry);
+            retry = Math.min(retry * 2, 30000);
+        };
+    }
+
+    setStatus('Connecting...');
+    connect();
+})();
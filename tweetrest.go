@@ -215,6 +215,46 @@ func (trc *TwitterClient) FillHomeTimeline(sinceId int64) (results []*twittertyp
     return
 }
 
+//Look up current versions of up to 100 tweets by id, returning each tweet's raw json
+func (trc *TwitterClient) LookupStatuses(ids []int64) ([]json.RawMessage, error) {
+    endPoint := "https://api.twitter.com/1.1/statuses/lookup.json"
+    idstrs := make([]string, len(ids))
+    for i, id := range ids {
+        idstrs[i] = strconv.FormatInt(id, 10)
+    }
+    v := url.Values{}
+    v.Set("id", strings.Join(idstrs, ","))
+    v.Set("include_entities", "1")
+
+    reqUrl, _ := url.Parse(endPoint)
+    reqUrl.RawQuery = v.Encode()
+
+    httpRequest, _ := http.NewRequest("GET", reqUrl.String(), nil)
+    trc.Service.Sign(httpRequest, trc.UserConfig)
+    resp, err := trc.HttpClient.Do(httpRequest)
+    if err != nil {
+        fmt.Printf("Error making request: %v\n", err)
+        return nil, err
+    }
+    body, err := ioutil.ReadAll(resp.Body)
+    resp.Body.Close()
+    if err != nil {
+        return nil, err
+    }
+    if resp.StatusCode != 200 {
+        //something went wrong, possibly rate-limit
+        return nil, fmt.Errorf("non-200 response from statuses/lookup: %d : %s", resp.StatusCode, body)
+    }
+
+    lookupResults := make([]json.RawMessage, 0, len(ids))
+    err = json.Unmarshal(body, &lookupResults)
+    if err != nil {
+        fmt.Printf("Error unmarshalling lookup result: %s\n", err)
+        return nil, err
+    }
+    return lookupResults, nil
+}
+
 func (trc *TwitterClient) VerifyCredentials() bool {
     endPoint := "https://api.twitter.com/1.1/account/verify_credentials.json"
     httpRequest, _ := http.NewRequest("GET", endPoint, nil)
@@ -293,6 +333,12 @@ func ReadHttpStream(resp *http.Response, linechan chan []byte) {
             fmt.Printf("%s Read line with length of 0\n", now.Format("03:04:05"))
             continue
         }
+        if rawCapture != nil {
+            err = rawCapture.WriteLine(line)
+            if err != nil {
+                fmt.Printf("Error capturing raw line: %s\n", err)
+            }
+        }
         linechan <- line
     }
 }
@@ -0,0 +1,130 @@
+package tweetstore
+
+import (
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "time"
+)
+
+//BotSignal is one reason an account looks automated. Value runs from 0, like a person,
+//to 1, like a bot, and Weight is its share of the account's score.
+type BotSignal struct {
+    Name   string  `json:"name"`
+    Value  float64 `json:"value"`
+    Weight float64 `json:"weight"`
+    Reason string  `json:"reason"`
+}
+
+//AccountScore is how automated an account looks, from 0 to 1, and why
+type AccountScore struct {
+    UserId     int64       `json:"user_id"`
+    ScreenName string      `json:"screen_name"`
+    Tweets     int         `json:"tweets"` //tweets the score is based on
+    Score      float64     `json:"score"`
+    ScoredAt   time.Time   `json:"scored_at"`
+    Signals    []BotSignal `json:"signals"` //largest share of the score first
+}
+
+//UserSnapshot is a user's profile json as it was saved with one of their tweets
+type UserSnapshot struct {
+    UserId     int64
+    ScreenName string
+    TweetId    int64
+    ObservedAt time.Time
+    Object     []byte
+}
+
+//userSnapshotsQuery selects the snapshots saved with tweets created in [start, end), newest
+//of each user first. Only the first of each user is kept by scanUserSnapshots.
+func userSnapshotsQuery(start, end string) string {
+    return "SELECT users.userid, users.screen_name, users.tweetid, tweettimestamps.timestamp, users.object FROM users " +
+        "JOIN tweettimestamps ON tweettimestamps.tweetid = users.tweetid " +
+        "WHERE tweettimestamps.timestamp >= " + start + " AND tweettimestamps.timestamp < " + end + " " +
+        "ORDER BY users.userid ASC, users.tweetid DESC;"
+}
+
+func scanUserSnapshots(rows *sql.Rows, err error) ([]UserSnapshot, error) {
+    if err != nil {
+        fmt.Printf("Error getting user snapshots: %s\n", err)
+        return nil, err
+    }
+    defer rows.Close()
+    snapshots := make([]UserSnapshot, 0, 100)
+    for rows.Next() {
+        var s UserSnapshot
+        var screenName sql.NullString
+        var observedAt int64
+        err = rows.Scan(&s.UserId, &screenName, &s.TweetId, &observedAt, &s.Object)
+        if err != nil {
+            fmt.Printf("Error scanning user snapshot row: %s\n", err)
+            return nil, err
+        }
+        if len(snapshots) > 0 && snapshots[len(snapshots)-1].UserId == s.UserId {
+            continue
+        }
+        s.ScreenName = screenName.String
+        s.ObservedAt = time.Unix(observedAt, 0).UTC()
+        snapshots = append(snapshots, s)
+    }
+    return snapshots, rows.Err()
+}
+
+func scanAccountScores(rows *sql.Rows, err error) ([]AccountScore, error) {
+    if err != nil {
+        fmt.Printf("Error getting account scores: %s\n", err)
+        return nil, err
+    }
+    defer rows.Close()
+    scores := make([]AccountScore, 0, 20)
+    for rows.Next() {
+        var s AccountScore
+        var scoredAt int64
+        var signals []byte
+        err = rows.Scan(&s.UserId, &s.ScreenName, &s.Tweets, &s.Score, &scoredAt, &signals)
+        if err != nil {
+            fmt.Printf("Error scanning account score row: %s\n", err)
+            return nil, err
+        }
+        s.ScoredAt = time.Unix(scoredAt, 0).UTC()
+        err = json.Unmarshal(signals, &s.Signals)
+        if err != nil {
+            fmt.Printf("Error decoding account score signals: %s\n", err)
+        }
+        scores = append(scores, s)
+    }
+    return scores, rows.Err()
+}
+
+//SaveAccountScore records an account's score, replacing any earlier one
+func (sts *SqliteTweetStore) SaveAccountScore(s AccountScore) error {
+    signals, err := json.Marshal(s.Signals)
+    if err != nil {
+        return err
+    }
+    tx, ownTx := sts.GetOrStartTransaction()
+    savescoreq := "INSERT OR REPLACE INTO account_scores (userid, screen_name, tweets, score, scored_at, signals) VALUES (?, ?, ?, ?, ?, ?);"
+    _, err = tx.Exec(savescoreq, s.UserId, s.ScreenName, s.Tweets, s.Score, s.ScoredAt.Unix(), string(signals))
+    if err != nil {
+        fmt.Printf("Error saving account score: %s\n", err)
+    }
+    if ownTx {
+        err := sts.CommitTransaction()
+        if err != nil {
+            fmt.Printf("Error commiting saveAccountScore TX: %s\n", err)
+            return err
+        }
+    }
+    return err
+}
+
+//Get up to limit stored account scores of at least minScore, highest first
+func (sts *SqliteTweetStore) AccountScores(minScore float64, limit int) ([]AccountScore, error) {
+    scoresq := "SELECT userid, screen_name, tweets, score, scored_at, signals FROM account_scores WHERE score >= ? ORDER BY score DESC, userid ASC LIMIT ?;"
+    return scanAccountScores(sts.DB.Query(scoresq, minScore, limit))
+}
+
+//Get the latest profile snapshot of each user who tweeted between startTime and endTime
+func (sts *SqliteTweetStore) IntervalUserSnapshots(startTime time.Time, endTime time.Time) ([]UserSnapshot, error) {
+    return scanUserSnapshots(sts.DB.Query(userSnapshotsQuery("?", "?"), startTime.Unix(), endTime.Unix()))
+}
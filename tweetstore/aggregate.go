@@ -0,0 +1,152 @@
+package tweetstore
+
+import (
+    "database/sql"
+    "fmt"
+    "time"
+)
+
+//KeyCount is a key and how many times it occurred
+type KeyCount struct {
+    Key   string `json:"key"`
+    Count int    `json:"count"`
+}
+
+//TopKind selects what IntervalTop counts
+type TopKind string
+
+const (
+    TopUrls     TopKind = "urls"     //expanded urls
+    TopUsers    TopKind = "users"    //screen names of tweet authors
+    TopHashtags TopKind = "hashtags" //hashtag text as tweeted
+    TopMentions TopKind = "mentions" //screen names mentioned
+    TopLangs    TopKind = "langs"    //tweet language codes
+)
+
+//topColumns is the table joined to tweettimestamps and the column grouped for each kind
+var topColumns = map[TopKind][2]string{
+    TopUrls:     {"urls", "urls.expanded_url"},
+    TopUsers:    {"tweets", "tweets.screen_name"},
+    TopHashtags: {"hashtags", "hashtags.text"},
+    TopMentions: {"user_mentions", "user_mentions.screen_name"},
+    TopLangs:    {"tweet_langs", "tweet_langs.lang"},
+}
+
+//topQuery builds the IntervalTop query with the backend's placeholders for start, end and limit,
+//and filter, if set, as a further condition on tweettimestamps.
+//Filtering on tweettimestamps keeps the time range on its index and off the tweet json.
+func topQuery(kind TopKind, start, end, limit string, filter string) (string, error) {
+    tc, ok := topColumns[kind]
+    if !ok {
+        return "", fmt.Errorf("unknown top kind %q", kind)
+    }
+    if filter != "" {
+        filter = " AND " + filter
+    }
+    return fmt.Sprintf("SELECT %[2]s, COUNT(*) AS n FROM tweettimestamps JOIN %[1]s ON %[1]s.tweetid = tweettimestamps.tweetid WHERE tweettimestamps.timestamp >= %[3]s AND tweettimestamps.timestamp < %[4]s AND %[2]s IS NOT NULL%[6]s GROUP BY %[2]s ORDER BY n DESC, %[2]s ASC LIMIT %[5]s;",
+        tc[0], tc[1], start, end, limit, filter), nil
+}
+
+func scanKeyCounts(rows *sql.Rows, err error) []KeyCount {
+    if err != nil {
+        fmt.Printf("Error getting top counts: %s\n", err)
+        return nil
+    }
+    defer rows.Close()
+    counts := make([]KeyCount, 0, 20)
+    for rows.Next() {
+        var c KeyCount
+        err = rows.Scan(&c.Key, &c.Count)
+        if err != nil {
+            fmt.Printf("Error scanning top count row: %s\n", err)
+            continue
+        }
+        counts = append(counts, c)
+    }
+    return counts
+}
+
+//Get the limit most frequent urls, users, hashtags, mentions or languages of tweets created between startTime and endTime
+func (sts *SqliteTweetStore) IntervalTop(kind TopKind, startTime time.Time, endTime time.Time, limit int) []KeyCount {
+    topq, err := topQuery(kind, "?", "?", "?", "")
+    if err != nil {
+        fmt.Printf("Error getting top counts: %s\n", err)
+        return nil
+    }
+    return scanKeyCounts(sts.DB.Query(topq, startTime.Unix(), endTime.Unix(), limit))
+}
+
+//intervalBuckets fills counts from rows of (bucket index, count), dropping indexes out of range
+func intervalBuckets(rows *sql.Rows, err error, numIntervals int) []int {
+    rowCounts := make([]int, numIntervals)
+    if err != nil {
+        fmt.Printf("Error getting tweet counts: %s\n", err)
+        return rowCounts
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var i int64
+        var count int
+        err = rows.Scan(&i, &count)
+        if err != nil {
+            fmt.Printf("Error scanning tweet count row: %s\n", err)
+            continue
+        }
+        if i >= 0 && i < int64(numIntervals) {
+            rowCounts[i] = count
+        }
+    }
+    return rowCounts
+}
+
+//intervalSeconds is a bucket length in whole seconds, the resolution of tweettimestamps
+func intervalSeconds(intervalDuration time.Duration) int64 {
+    secs := int64(intervalDuration / time.Second)
+    if secs < 1 {
+        secs = 1
+    }
+    return secs
+}
+
+//RelationCount is how many tweets made a relation of Type from the source user to the target user
+type RelationCount struct {
+    SourceUserId     int64
+    SourceScreenName string
+    TargetUserId     int64
+    TargetScreenName string
+    Type             string
+    Count            int
+}
+
+//relationCountQuery totals relations of tweets created in [start, end) per user pair and type.
+//Screen names can change within the window, so the greatest one stands for the user.
+func relationCountQuery(start, end string) string {
+    return fmt.Sprintf("SELECT relations.source_userid, MAX(relations.source_screen_name), relations.target_userid, MAX(relations.target_screen_name), relations.type, COUNT(*) FROM tweettimestamps JOIN relations ON relations.tweetid = tweettimestamps.tweetid WHERE tweettimestamps.timestamp >= %s AND tweettimestamps.timestamp < %s AND relations.source_userid IS NOT NULL AND relations.target_userid IS NOT NULL GROUP BY relations.source_userid, relations.target_userid, relations.type;", start, end)
+}
+
+func scanRelationCounts(rows *sql.Rows, err error) ([]RelationCount, error) {
+    if err != nil {
+        fmt.Printf("Error getting relation counts: %s\n", err)
+        return nil, err
+    }
+    defer rows.Close()
+    counts := make([]RelationCount, 0, 100)
+    for rows.Next() {
+        var c RelationCount
+        var sourceName, targetName sql.NullString
+        err = rows.Scan(&c.SourceUserId, &sourceName, &c.TargetUserId, &targetName, &c.Type, &c.Count)
+        if err != nil {
+            fmt.Printf("Error scanning relation count row: %s\n", err)
+            return nil, err
+        }
+        c.SourceScreenName = sourceName.String
+        c.TargetScreenName = targetName.String
+        counts = append(counts, c)
+    }
+    return counts, rows.Err()
+}
+
+//Get the relations made by tweets created between startTime and endTime, totalled per user pair and type
+func (sts *SqliteTweetStore) IntervalRelations(startTime time.Time, endTime time.Time) ([]RelationCount, error) {
+    return scanRelationCounts(sts.DB.Query(relationCountQuery("?", "?"), startTime.Unix(), endTime.Unix()))
+}
@@ -0,0 +1,151 @@
+package tweetstore
+
+import (
+    "database/sql"
+    "fmt"
+    "github.com/fcheslack/tweetlog/neardup"
+    "strings"
+    "time"
+)
+
+//maxClusterCandidates bounds how many earlier tweets sharing a band a new tweet is compared with
+const maxClusterCandidates = 100
+
+//DefaultClusterSize is the fewest tweets IntervalClusters reports a cluster with
+const DefaultClusterSize = 2
+
+//Cluster is a group of tweets with nearly the same text, copies of a message rather than
+//retweets of it. It is named by the id of its first tweet.
+type Cluster struct {
+    Id       int64     `json:"id"`
+    Text     string    `json:"text"`     //of the first tweet
+    Tweets   int       `json:"tweets"`   //in the interval asked for
+    Accounts int       `json:"accounts"` //that posted them
+    First    time.Time `json:"first"`
+    Last     time.Time `json:"last"`
+}
+
+//clusterCandidatesQuery selects the clustered tweets sharing any of neardup.NumBands band
+//keys with a tweet, other than the tweet itself, which is the last parameter
+func clusterCandidatesQuery(placeholder func(int) string) string {
+    bands := make([]string, neardup.NumBands)
+    for i := range bands {
+        bands[i] = placeholder(i + 1)
+    }
+    return "SELECT DISTINCT tweet_clusters.tweetid, tweet_clusters.clusterid, tweet_clusters.signature FROM tweet_lsh " +
+        "JOIN tweet_clusters ON tweet_clusters.tweetid = tweet_lsh.tweetid " +
+        "WHERE tweet_lsh.band IN (" + strings.Join(bands, ", ") + ") AND tweet_lsh.tweetid <> " + placeholder(neardup.NumBands+1) + " " +
+        fmt.Sprintf("ORDER BY tweet_clusters.tweetid DESC LIMIT %d;", maxClusterCandidates)
+}
+
+//nearestCluster compares a tweet's signature with the candidates sharing its bands and
+//returns the cluster of the most similar one at neardup.Threshold or above, with the
+//similarity. A tweet like no other starts its own cluster, with similarity 0.
+func nearestCluster(tx *sql.Tx, candidatesq string, tweetid int64, sig neardup.Signature, bands []int64) (int64, float64, error) {
+    args := make([]interface{}, 0, len(bands)+1)
+    for _, band := range bands {
+        args = append(args, band)
+    }
+    args = append(args, tweetid)
+    rows, err := tx.Query(candidatesq, args...)
+    if err != nil {
+        return tweetid, 0, err
+    }
+    defer rows.Close()
+    clusterid, best := tweetid, 0.0
+    for rows.Next() {
+        var candidateid, candidatecluster int64
+        var signature []byte
+        err = rows.Scan(&candidateid, &candidatecluster, &signature)
+        if err != nil {
+            return tweetid, 0, err
+        }
+        sim := neardup.Similarity(sig, neardup.Decode(signature))
+        if sim < neardup.Threshold {
+            continue
+        }
+        if sim > best || sim == best && candidatecluster < clusterid {
+            clusterid, best = candidatecluster, sim
+        }
+    }
+    return clusterid, best, rows.Err()
+}
+
+//intervalClustersQuery selects the clusters of at least minSize tweets created in
+//[start, end), largest first
+func intervalClustersQuery(start, end, minSize, limit string) string {
+    return "SELECT tweet_clusters.clusterid, (SELECT first.text FROM tweets AS first WHERE first.tweetid = tweet_clusters.clusterid), " +
+        "COUNT(*), COUNT(DISTINCT tweets.screen_name), MIN(tweettimestamps.timestamp), MAX(tweettimestamps.timestamp) FROM tweet_clusters " +
+        "JOIN tweettimestamps ON tweettimestamps.tweetid = tweet_clusters.tweetid " +
+        "JOIN tweets ON tweets.tweetid = tweet_clusters.tweetid " +
+        "WHERE tweettimestamps.timestamp >= " + start + " AND tweettimestamps.timestamp < " + end + " " +
+        "GROUP BY tweet_clusters.clusterid HAVING COUNT(*) >= " + minSize + " " +
+        "ORDER BY COUNT(*) DESC, tweet_clusters.clusterid ASC LIMIT " + limit + ";"
+}
+
+func scanClusters(rows *sql.Rows, err error) ([]Cluster, error) {
+    if err != nil {
+        fmt.Printf("Error getting clusters: %s\n", err)
+        return nil, err
+    }
+    defer rows.Close()
+    clusters := make([]Cluster, 0, 20)
+    for rows.Next() {
+        var c Cluster
+        var text sql.NullString
+        var first, last int64
+        err = rows.Scan(&c.Id, &text, &c.Tweets, &c.Accounts, &first, &last)
+        if err != nil {
+            fmt.Printf("Error scanning cluster row: %s\n", err)
+            return nil, err
+        }
+        c.Text = text.String
+        c.First = time.Unix(first, 0).UTC()
+        c.Last = time.Unix(last, 0).UTC()
+        clusters = append(clusters, c)
+    }
+    return clusters, rows.Err()
+}
+
+//saveCluster puts a tweet in the cluster of its most similar earlier tweet, or starts one,
+//and indexes its bands so later copies find it. Tweets without a signature, retweets and
+//short ones, are left out.
+func (sts *SqliteTweetStore) saveCluster(tx *sql.Tx, tweetid int64, sig neardup.Signature) {
+    deletebandsq := "DELETE FROM tweet_lsh WHERE tweetid = ?;"
+    deleteclusterq := "DELETE FROM tweet_clusters WHERE tweetid = ?;"
+    insertclusterq := "INSERT OR REPLACE INTO tweet_clusters (tweetid, clusterid, similarity, signature) VALUES (?, ?, ?, ?);"
+    insertbandq := "INSERT OR IGNORE INTO tweet_lsh (band, tweetid) VALUES (?, ?);"
+
+    _, err := tx.Exec(deletebandsq, tweetid)
+    if err != nil {
+        fmt.Printf("Error deleting tweet bands: %s\n", err)
+    }
+    if sig == nil {
+        _, err = tx.Exec(deleteclusterq, tweetid)
+        if err != nil {
+            fmt.Printf("Error deleting tweet cluster: %s\n", err)
+        }
+        return
+    }
+    bands := sig.Bands()
+    clusterid, similarity, err := nearestCluster(tx, clusterCandidatesQuery(func(int) string { return "?" }), tweetid, sig, bands)
+    if err != nil {
+        fmt.Printf("Error finding tweet cluster: %s\n", err)
+    }
+    _, err = tx.Exec(insertclusterq, tweetid, clusterid, similarity, sig.Bytes())
+    if err != nil {
+        fmt.Printf("Error inserting tweet cluster: %s\n", err)
+    }
+    for _, band := range bands {
+        _, err = tx.Exec(insertbandq, band, tweetid)
+        if err != nil {
+            fmt.Printf("Error inserting tweet band: %s\n", err)
+        }
+    }
+}
+
+//Get up to limit clusters of at least minSize near-duplicate tweets created between
+//startTime and endTime, largest first
+func (sts *SqliteTweetStore) IntervalClusters(startTime time.Time, endTime time.Time, minSize int, limit int) ([]Cluster, error) {
+    return scanClusters(sts.DB.Query(intervalClustersQuery("?", "?", "?", "?"), startTime.Unix(), endTime.Unix(), minSize, limit))
+}
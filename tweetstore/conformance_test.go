@@ -0,0 +1,447 @@
+package tweetstore
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/fcheslack/webtypes/twitter"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+//conformance tweet ids sit near the top of the int64 range so they are always the latest
+const conformanceBaseId int64 = 9223372036854775000
+
+//TestSqliteConformance runs the conformance checks against a new SQLite file
+func TestSqliteConformance(t *testing.T) {
+    store, err := Open("sqlite3://" + filepath.Join(t.TempDir(), "conformance.db"))
+    if err != nil {
+        t.Fatalf("opening sqlite store: %s", err)
+    }
+    defer store.Close()
+    checkConformance(t, store)
+}
+
+//TestPostgresConformance runs the conformance checks against the database named by
+//TWEETLOG_TEST_POSTGRES, eg postgres://localhost/tweetlog_test?sslmode=disable, and is
+//skipped when it is not set. The checks write marker tweets, so name a scratch database.
+func TestPostgresConformance(t *testing.T) {
+    dsn := os.Getenv("TWEETLOG_TEST_POSTGRES")
+    if dsn == "" {
+        t.Skip("TWEETLOG_TEST_POSTGRES is not set")
+    }
+    store, err := Open(dsn)
+    if err != nil {
+        t.Fatalf("opening postgres store: %s", err)
+    }
+    defer store.Close()
+    checkConformance(t, store)
+}
+
+//checkConformance runs the same set of save and load checks against any backend and
+//reports every mismatch it finds
+func checkConformance(t *testing.T, store TweetStore) {
+    fail := func(format string, args ...interface{}) {
+        t.Helper()
+        t.Errorf(format, args...)
+    }
+
+    now := time.Now().UTC().Truncate(time.Second)
+    tweets := make([]*twittertypes.Tweet, 0, 3)
+    //the last language is an old code twitter still sends, stored as "id"
+    langs := []string{"en", "en", "in"}
+    for i := 0; i < 3; i++ {
+        line := fmt.Sprintf(`{"id":%d,"id_str":"%d","text":"conformanceword tweet %d #conformancetag","created_at":%q,"lang":%q,"source":"conformance","user":{"id":1,"id_str":"1","screen_name":"conformanceuser"},"entities":{"hashtags":[{"text":"conformancetag","indices":[32,47]}],"urls":[{"url":"http://t.co/x","expanded_url":"http://example.com/%d","display_url":"example.com/%d"}],"user_mentions":[],"media":[]}}`,
+            conformanceBaseId+int64(i), conformanceBaseId+int64(i), i, now.Add(time.Duration(i-3)*time.Minute).Format(time.RubyDate), langs[i], i, i)
+        tweet := &twittertypes.Tweet{}
+        err := json.Unmarshal([]byte(line), tweet)
+        if err != nil {
+            fail("unmarshalling conformance tweet %d: %s", i, err)
+            return
+        }
+        tweet.RawBytes = []byte(line)
+        tweets = append(tweets, tweet)
+    }
+
+    err := store.SaveTweet(tweets[0])
+    if err != nil {
+        fail("SaveTweet: %s", err)
+    }
+    err = store.SaveTweets(tweets[1:])
+    if err != nil {
+        fail("SaveTweets: %s", err)
+    }
+    //saving again must replace rather than duplicate
+    err = store.SaveTweet(tweets[2])
+    if err != nil {
+        fail("SaveTweet again: %s", err)
+    }
+    //a tweet without an id or user is refused, on its own or inside a batch, and the
+    //rest of the batch is still saved
+    noId := &twittertypes.Tweet{Text: "conformanceword no id", Created_at: tweets[0].Created_at, User: tweets[0].User}
+    noUser := &twittertypes.Tweet{Id: tweets[0].Id, Text: "conformanceword no user", Created_at: tweets[0].Created_at}
+    for name, bad := range map[string]*twittertypes.Tweet{"id": noId, "user": noUser} {
+        err = store.SaveTweet(bad)
+        if err == nil {
+            fail("SaveTweet of a tweet with no %s did not fail", name)
+        }
+    }
+    err = store.BeginTransaction()
+    if err != nil {
+        fail("BeginTransaction: %s", err)
+    }
+    if store.SaveTweet(noUser) == nil {
+        fail("SaveTweet of a tweet with no user did not fail inside a transaction")
+    }
+    err = store.SaveTweet(tweets[1])
+    if err != nil {
+        fail("SaveTweet after a refused tweet: %s", err)
+    }
+    err = store.CommitTransaction()
+    if err != nil {
+        fail("CommitTransaction: %s", err)
+    }
+
+    lastId := conformanceBaseId + 2
+    if got := store.LatestTweetId(); got != lastId {
+        fail("LatestTweetId = %d, want %d", got, lastId)
+    }
+
+    after := store.TweetsAfterId(conformanceBaseId)
+    if len(after) != 2 {
+        fail("TweetsAfterId returned %d tweets, want 2", len(after))
+    } else if int64(*after[0].Id) != lastId {
+        fail("TweetsAfterId first id = %d, want %d (newest first)", int64(*after[0].Id), lastId)
+    }
+
+    recent := store.RecentTweets(2)
+    if len(recent) != 2 {
+        fail("RecentTweets(2) returned %d tweets", len(recent))
+    } else if recent[1].User == nil || recent[1].User.Screen_name != "conformanceuser" {
+        fail("RecentTweets did not round trip the stored tweet json")
+    }
+
+    interval := store.IntervalTweets(now.Add(-10*time.Minute), now.Add(time.Minute))
+    found := 0
+    for _, t := range interval {
+        if int64(*t.Id) >= conformanceBaseId {
+            found++
+        }
+    }
+    if found != 3 {
+        fail("IntervalTweets found %d conformance tweets, want 3", found)
+    }
+
+    urls := store.IntervalUrls(now.Add(-10*time.Minute), now.Add(time.Minute))
+    found = 0
+    for _, u := range urls {
+        if u.Url == "http://t.co/x" {
+            found++
+        }
+    }
+    if found != 3 {
+        fail("IntervalUrls found %d conformance urls, want 3", found)
+    }
+
+    top := store.IntervalTop(TopHashtags, now.Add(-10*time.Minute), now.Add(time.Minute), 1000)
+    found = 0
+    for _, c := range top {
+        if c.Key == "conformancetag" {
+            found = c.Count
+        }
+    }
+    if found != 3 {
+        fail("IntervalTop hashtags counted conformancetag %d times, want 3", found)
+    }
+    top = store.IntervalTop(TopUsers, now.Add(-10*time.Minute), now.Add(time.Minute), 1000)
+    found = 0
+    for _, c := range top {
+        if c.Key == "conformanceuser" {
+            found = c.Count
+        }
+    }
+    if found != 3 {
+        fail("IntervalTop users counted conformanceuser %d times, want 3", found)
+    }
+    if counts := store.IntervalTweetCount(time.Hour, 1); len(counts) != 1 || counts[0] < 3 {
+        fail("IntervalTweetCount(1h, 1) = %v, want at least 3", counts)
+    }
+    //the tweets of 3 and 2 minutes ago, one per minute counting back from 90 seconds ago
+    if counts := store.IntervalTweetCountBefore(now.Add(-90*time.Second), time.Minute, 2); len(counts) != 2 || counts[0] != 1 || counts[1] != 1 {
+        fail("IntervalTweetCountBefore(-90s, 1m, 2) = %v, want [1 1]", counts)
+    }
+    top = store.IntervalTop(TopLangs, now.Add(-10*time.Minute), now.Add(time.Minute), 1000)
+    found = 0
+    for _, c := range top {
+        if c.Key == "id" {
+            found = c.Count
+        }
+    }
+    if found < 1 {
+        fail("IntervalTop langs counted id %d times, want at least 1", found)
+    }
+    for lang, want := range map[string]int{"en": 2, "ID": 1, "in": 1, "fr": 0} {
+        byLang, err := store.QueryTweets(TweetQuery{ScreenName: "conformanceuser", Lang: lang})
+        if err != nil {
+            fail("QueryTweets lang %s: %s", lang, err)
+        } else if len(byLang) != want {
+            fail("QueryTweets lang %s returned %d tweets, want %d", lang, len(byLang), want)
+        }
+    }
+    //search text is only ever read as words, never as fts operators or a syntax error
+    for text, want := range map[string]int{"conformanceword": 3, `conformanceword"`: 3, "-conformanceword*": 3, "conformanceword tweet 2": 1, "NEAR(conformanceword": 0, "conformanceword OR nothing": 0, "++": 0} {
+        found, err := store.QueryTweets(TweetQuery{ScreenName: "conformanceuser", Text: text})
+        if err != nil {
+            fail("QueryTweets text %q: %s", text, err)
+        } else if len(found) != want {
+            fail("QueryTweets text %q returned %d tweets, want %d", text, len(found), want)
+        }
+    }
+
+    for i, compound := range []float64{0.5, -0.5} {
+        err = store.SaveSentiment(TweetSentiment{TweetId: conformanceBaseId + int64(i), Scorer: "conformance", ScoredAt: now, Sentiment: Sentiment{Compound: compound, Neutral: 1}})
+        if err != nil {
+            fail("SaveSentiment: %s", err)
+        }
+    }
+    //scoring again must replace the earlier score
+    err = store.SaveSentiment(TweetSentiment{TweetId: conformanceBaseId + 1, Scorer: "conformance", ScoredAt: now, Sentiment: Sentiment{Compound: 0.25, Neutral: 1}})
+    if err != nil {
+        fail("SaveSentiment again: %s", err)
+    }
+    scored, err := store.TweetSentiment(conformanceBaseId + 1)
+    if err != nil || scored == nil || scored.Compound != 0.25 || scored.Scorer != "conformance" || !scored.ScoredAt.Equal(now) {
+        fail("TweetSentiment did not round trip the saved score: %+v %v", scored, err)
+    }
+    if unscored, err := store.TweetSentiment(lastId); err != nil || unscored != nil {
+        fail("TweetSentiment of an unscored tweet = %+v, %v, want nil", unscored, err)
+    }
+    summary, err := store.IntervalSentiment(now.Add(-10*time.Minute), now.Add(time.Minute))
+    if err != nil {
+        fail("IntervalSentiment: %s", err)
+    } else if summary.Scored < 2 || summary.Positive < 2 {
+        fail("IntervalSentiment = %+v, want at least 2 scored and positive", summary)
+    }
+
+    //counted only over the tweets of the track phrases, in one bucket spanning the window
+    for _, c := range []struct {
+        track [][]string
+        want  int
+    }{
+        {[][]string{{"conformanceword"}}, 3},
+        {[][]string{{"conformanceword", "2"}, {"nothing", "matches"}}, 1},
+        {[][]string{{"conformanceword", "nothing"}}, 0},
+    } {
+        q := TrackStatsQuery{Start: now.Add(-10 * time.Minute), End: now.Add(time.Minute), Track: c.track, Bucket: 11 * time.Minute, Buckets: 1, Top: 1000}
+        stats, err := store.IntervalTrackStats(q)
+        if err != nil {
+            fail("IntervalTrackStats %v: %s", c.track, err)
+            continue
+        }
+        if len(stats.Volume) != 1 || stats.Volume[0] != c.want {
+            fail("IntervalTrackStats %v volume = %v, want [%d]", c.track, stats.Volume, c.want)
+        }
+        tagged := 0
+        for _, kc := range stats.Top[TopHashtags] {
+            if kc.Key == "conformancetag" {
+                tagged = kc.Count
+            }
+        }
+        if tagged != c.want {
+            fail("IntervalTrackStats %v counted conformancetag %d times, want %d", c.track, tagged, c.want)
+        }
+        //tweets 0 and 1 are scored
+        if scored := stats.Sentiment.Scored; c.want == 3 && scored != 2 || c.want == 0 && scored != 0 {
+            fail("IntervalTrackStats %v scored %d tweets", c.track, scored)
+        }
+    }
+
+    snapshots, err := store.IntervalUserSnapshots(now.Add(-10*time.Minute), now.Add(time.Minute))
+    found = 0
+    for _, u := range snapshots {
+        if u.UserId == 1 {
+            found++
+            if u.TweetId != lastId || u.ScreenName != "conformanceuser" {
+                fail("IntervalUserSnapshots = %+v, want the snapshot of tweet %d", u, lastId)
+            }
+        }
+    }
+    if err != nil || found != 1 {
+        fail("IntervalUserSnapshots found %d snapshots of the conformance user, want 1: %v", found, err)
+    }
+    err = store.SaveAccountScore(AccountScore{UserId: 1, ScreenName: "conformanceuser", Tweets: 3, Score: 0.9, ScoredAt: now, Signals: []BotSignal{{Name: "conformance", Value: 1, Weight: 1, Reason: "conformance"}}})
+    if err != nil {
+        fail("SaveAccountScore: %s", err)
+    }
+    accounts, err := store.AccountScores(0.8, 1000)
+    found = 0
+    for _, a := range accounts {
+        if a.UserId == 1 {
+            found++
+            if a.Score != 0.9 || len(a.Signals) != 1 || a.Signals[0].Reason != "conformance" || !a.ScoredAt.Equal(now) {
+                fail("AccountScores did not round trip the saved score: %+v", a)
+            }
+        }
+    }
+    if err != nil || found != 1 {
+        fail("AccountScores found the conformance user %d times, want 1: %v", found, err)
+    }
+    for max, want := range map[float64]int{0.5: 0, 0.95: 3} {
+        kept, err := store.QueryTweets(TweetQuery{ScreenName: "conformanceuser", MaxAccountScore: max})
+        if err != nil {
+            fail("QueryTweets max account score %g: %s", max, err)
+        } else if len(kept) != want {
+            fail("QueryTweets max account score %g returned %d tweets, want %d", max, len(kept), want)
+        }
+    }
+
+    //the conformance texts differ by a digit, near enough to be one cluster named by the first
+    clusters, err := store.IntervalClusters(now.Add(-10*time.Minute), now.Add(time.Minute), DefaultClusterSize, 1000)
+    found = 0
+    for _, c := range clusters {
+        if c.Id == conformanceBaseId {
+            found++
+            if c.Tweets != 3 || c.Accounts != 1 || c.Text != tweets[0].Text {
+                fail("IntervalClusters = %+v, want 3 tweets of 1 account starting with tweet %d", c, conformanceBaseId)
+            }
+        }
+    }
+    if err != nil || found != 1 {
+        fail("IntervalClusters found the conformance cluster %d times, want 1: %v", found, err)
+    }
+    members, err := store.QueryTweets(TweetQuery{ClusterId: conformanceBaseId})
+    if err != nil {
+        fail("QueryTweets cluster: %s", err)
+    } else if len(members) != 3 {
+        fail("QueryTweets cluster returned %d tweets, want 3", len(members))
+    }
+
+    //a minute aligned window holds exactly 11 minute buckets, whatever second now is
+    seriesStart := now.Truncate(time.Minute).Add(-10 * time.Minute)
+    checkSeries := func(groupBy string, key string) {
+        series, err := store.TweetSeries(SeriesQuery{
+            Start:     seriesStart,
+            End:       seriesStart.Add(11 * time.Minute),
+            Interval:  IntervalMinute,
+            GroupBy:   groupBy,
+            Terms:     []string{"conformanceword"},
+            Groups:    1000,
+            Sentiment: true,
+        })
+        if err != nil {
+            fail("TweetSeries by %q: %s", groupBy, err)
+            return
+        }
+        if len(series.Starts) != 11 {
+            fail("TweetSeries by %q has %d buckets, want 11", groupBy, len(series.Starts))
+        }
+        for _, g := range series.Groups {
+            if g.Key != key {
+                continue
+            }
+            nonzero := 0
+            for _, c := range g.Counts {
+                if c > 0 {
+                    nonzero++
+                }
+            }
+            if g.Total != 3 || nonzero != 3 {
+                fail("TweetSeries by %q counted %q %d times in %d buckets, want 3 in 3", groupBy, key, g.Total, nonzero)
+            }
+            return
+        }
+        fail("TweetSeries by %q is missing %q", groupBy, key)
+    }
+    checkSeries(GroupHashtag, "conformancetag")
+    checkSeries(GroupUser, "conformanceuser")
+    checkSeries(GroupTerm, "conformanceword")
+    checkSeries(GroupSource, "conformance")
+    checkSeries(GroupCluster, fmt.Sprint(conformanceBaseId))
+
+    if _, err := store.IntervalRelations(now.Add(-10*time.Minute), now.Add(time.Minute)); err != nil {
+        fail("IntervalRelations: %s", err)
+    }
+
+    checkIds := func(name string, q DehydrateQuery, want int) {
+        ids, err := store.DehydrateIds(q)
+        if err != nil {
+            fail("DehydrateIds %s: %s", name, err)
+            return
+        }
+        n := 0
+        for i, id := range ids {
+            if i > 0 && ids[i-1] >= id {
+                fail("DehydrateIds %s not sorted and unique at %d", name, id)
+            }
+            if id >= conformanceBaseId {
+                n++
+            }
+        }
+        if n != want {
+            fail("DehydrateIds %s matched %d conformance tweets, want %d", name, n, want)
+        }
+    }
+    checkIds("hashtag", DehydrateQuery{Hashtag: "#ConformanceTag"}, 3)
+    checkIds("search", DehydrateQuery{Search: "conformanceword"}, 3)
+    checkIds("screen_name", DehydrateQuery{ScreenName: "conformanceuser"}, 3)
+    checkIds("lang", DehydrateQuery{ScreenName: "conformanceuser", Lang: "en"}, 2)
+    checkIds("time range", DehydrateQuery{ScreenName: "conformanceuser", StartTime: now.Add(-150 * time.Second), EndTime: now}, 2)
+
+    secret, token, err := NewApiToken("conformance", []string{ScopePublicSearches})
+    if err != nil {
+        fail("NewApiToken: %s", err)
+    } else if err = store.SaveApiToken(token); err != nil {
+        fail("SaveApiToken: %s", err)
+    } else {
+        got, err := store.ApiTokenByHash(HashApiToken(secret))
+        if err != nil || got == nil || got.Id != token.Id || !got.HasScope(ScopePublicSearches) || got.Revoked() {
+            fail("ApiTokenByHash did not round trip the saved token: %+v %v", got, err)
+        }
+        revoked, err := store.RevokeApiToken(token.Id, now)
+        if err != nil || !revoked {
+            fail("RevokeApiToken = %v, %v", revoked, err)
+        }
+        got, err = store.ApiTokenByHash(token.Hash)
+        if err != nil || got == nil || !got.Revoked() {
+            fail("ApiTokenByHash after revoking: %+v %v", got, err)
+        }
+    }
+
+    //reprocessing drops the derived rows of entities a tweet no longer has
+    reprocessId := conformanceBaseId + 10
+    for _, tag := range []string{"conformancestale", "conformancefresh"} {
+        line := fmt.Sprintf(`{"id":%d,"id_str":"%d","text":"conformance reprocess #%s","created_at":%q,"user":{"id":2,"id_str":"2","screen_name":"conformancereprocess"},"entities":{"hashtags":[{"text":%q,"indices":[20,36]}],"urls":[],"user_mentions":[],"media":[]}}`,
+            reprocessId, reprocessId, tag, now.Format(time.RubyDate), tag)
+        tweet := &twittertypes.Tweet{}
+        err = json.Unmarshal([]byte(line), tweet)
+        if err != nil {
+            fail("unmarshalling reprocess tweet: %s", err)
+            return
+        }
+        tweet.RawBytes = []byte(line)
+        err = store.SaveTweet(tweet)
+        if err != nil {
+            fail("SaveTweet reprocess tweet: %s", err)
+        }
+    }
+    lastReprocessed, n, err := store.ReprocessChunk(reprocessId-1, 1)
+    if err != nil || n != 1 || lastReprocessed != reprocessId {
+        fail("ReprocessChunk = %d, %d, %v, want %d, 1", lastReprocessed, n, err, reprocessId)
+    }
+    for tag, want := range map[string]int{"conformancestale": 0, "conformancefresh": 1} {
+        tagged, err := store.QueryTweets(TweetQuery{Hashtag: tag})
+        if err != nil || len(tagged) != want {
+            fail("QueryTweets hashtag %s after reprocessing returned %d tweets, want %d: %v", tag, len(tagged), want, err)
+        }
+    }
+
+    err = store.BeginTransaction()
+    if err != nil {
+        fail("BeginTransaction: %s", err)
+    }
+    err = store.RollbackTransaction()
+    if err != nil {
+        fail("RollbackTransaction: %s", err)
+    }
+}
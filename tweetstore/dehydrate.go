@@ -0,0 +1,76 @@
+package tweetstore
+
+import (
+    "fmt"
+    "github.com/fcheslack/tweetlog/langid"
+    "strings"
+    "time"
+)
+
+//DehydrateQuery selects the tweets that go into a shareable id-only dataset.
+//Empty fields and zero times are not used to filter.
+type DehydrateQuery struct {
+    Search     string
+    Hashtag    string
+    ScreenName string
+    Lang       string
+    StartTime  time.Time
+    EndTime    time.Time
+}
+
+//Get the sorted, de-duplicated ids of all tweets matching q
+func (sts *SqliteTweetStore) DehydrateIds(q DehydrateQuery) ([]int64, error) {
+    query := "SELECT DISTINCT tweets.tweetid FROM tweets"
+    where := make([]string, 0, 5)
+    args := make([]interface{}, 0, 5)
+
+    if q.Hashtag != "" {
+        query += " JOIN hashtags ON tweets.tweetid = hashtags.tweetid"
+        where = append(where, "hashtags.text = ? COLLATE NOCASE")
+        args = append(args, strings.TrimPrefix(q.Hashtag, "#"))
+    }
+    if q.Search != "" {
+        where = append(where, "tweets.text LIKE ? ESCAPE '\\'")
+        args = append(args, containsPattern(q.Search))
+    }
+    if q.ScreenName != "" {
+        where = append(where, "tweets.screen_name = ? COLLATE NOCASE")
+        args = append(args, strings.TrimPrefix(q.ScreenName, "@"))
+    }
+    if q.Lang != "" {
+        where = append(where, "tweets.tweetid IN (SELECT tweetid FROM tweet_langs WHERE lang = ?)")
+        args = append(args, langid.Normalize(q.Lang))
+    }
+    //times are stored, and so compared, as UTC strings
+    if !q.StartTime.IsZero() {
+        where = append(where, "tweets.time >= ?")
+        args = append(args, q.StartTime.UTC())
+    }
+    if !q.EndTime.IsZero() {
+        where = append(where, "tweets.time < ?")
+        args = append(args, q.EndTime.UTC())
+    }
+    if len(where) > 0 {
+        query += " WHERE " + strings.Join(where, " AND ")
+    }
+    query += " ORDER BY tweets.tweetid ASC;"
+
+    rows, err := sts.DB.Query(query, args...)
+    if err != nil {
+        fmt.Printf("Error selecting tweet ids to dehydrate: %s\n", err)
+        return nil, err
+    }
+    defer rows.Close()
+
+    ids := make([]int64, 0, 200)
+    for rows.Next() {
+        var tweetid int64
+        err = rows.Scan(&tweetid)
+        if err != nil {
+            fmt.Printf("Error scanning dehydrate row: %s\n", err)
+            continue
+        }
+        ids = append(ids, tweetid)
+    }
+    return ids, rows.Err()
+}
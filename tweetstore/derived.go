@@ -0,0 +1,300 @@
+package tweetstore
+
+import (
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "github.com/fcheslack/tweetlog/neardup"
+    "github.com/fcheslack/webtypes/twitter"
+    "time"
+)
+
+//derivedSource holds the parts of the stored tweet json that the derived
+//tables need beyond what twittertypes.Tweet exposes
+type derivedSource struct {
+    Id                      int64
+    Created_at              string
+    Text                    string
+    Source                  string
+    Lang                    string
+    In_reply_to_status_id   *int64
+    In_reply_to_user_id     *int64
+    In_reply_to_screen_name string
+    User                    *derivedUser
+    Retweeted_status        *derivedRef
+    Quoted_status           *derivedRef
+    Entities                struct {
+        User_mentions []derivedUser
+    }
+}
+
+type derivedUser struct {
+    Id          int64
+    Screen_name string
+}
+
+type derivedRef struct {
+    Id   int64
+    User *derivedUser
+}
+
+//Relation is a directed user to user link made by one tweet
+type Relation struct {
+    TweetId          int64
+    Type             string //reply, retweet, quote or mention
+    SourceUserId     int64
+    SourceScreenName string
+    TargetUserId     int64
+    TargetScreenName string
+    TargetTweetId    int64
+}
+
+//derivedRows is everything SaveDerived writes beyond the entity tables
+type derivedRows struct {
+    src          derivedSource
+    raw          []byte
+    createdAt    time.Time
+    userObject   []byte
+    relations    []Relation
+    lang         string
+    langDetected bool
+    signature    neardup.Signature //nil for retweets, which copy by design
+}
+
+func tweetRaw(tweet *twittertypes.Tweet) []byte {
+    if tweet.RawBytes != nil {
+        return tweet.RawBytes
+    }
+    raw, err := json.Marshal(tweet)
+    if err != nil {
+        fmt.Printf("No tweet.RawBytes and error marshalling tweet: %s\n", err)
+    }
+    return raw
+}
+
+func deriveRows(tweet *twittertypes.Tweet) (*derivedRows, error) {
+    d := &derivedRows{raw: tweetRaw(tweet)}
+    err := json.Unmarshal(d.raw, &d.src)
+    if err != nil {
+        return nil, err
+    }
+    var userObject struct {
+        User json.RawMessage
+    }
+    err = json.Unmarshal(d.raw, &userObject)
+    if err == nil {
+        d.userObject = userObject.User
+    }
+    d.createdAt, err = time.Parse(time.RubyDate, d.src.Created_at)
+    if err != nil {
+        fmt.Printf("Error parsing created_at time:%s\n", err)
+    }
+    d.lang, d.langDetected = tweetLang(d.src.Lang, d.src.Text)
+    if d.src.Retweeted_status == nil {
+        d.signature = neardup.Sign(d.src.Text)
+    }
+
+    src := d.src
+    if src.User == nil {
+        return d, nil
+    }
+    rel := func(relType string, target *derivedUser, targetTweetId int64) {
+        if target == nil {
+            return
+        }
+        d.relations = append(d.relations, Relation{
+            TweetId:          src.Id,
+            Type:             relType,
+            SourceUserId:     src.User.Id,
+            SourceScreenName: src.User.Screen_name,
+            TargetUserId:     target.Id,
+            TargetScreenName: target.Screen_name,
+            TargetTweetId:    targetTweetId,
+        })
+    }
+    if src.In_reply_to_user_id != nil {
+        var replyTo int64
+        if src.In_reply_to_status_id != nil {
+            replyTo = *src.In_reply_to_status_id
+        }
+        rel("reply", &derivedUser{Id: *src.In_reply_to_user_id, Screen_name: src.In_reply_to_screen_name}, replyTo)
+    }
+    if src.Retweeted_status != nil {
+        rel("retweet", src.Retweeted_status.User, src.Retweeted_status.Id)
+    }
+    if src.Quoted_status != nil {
+        rel("quote", src.Quoted_status.User, src.Quoted_status.Id)
+    }
+    for i := range src.Entities.User_mentions {
+        rel("mention", &src.Entities.User_mentions[i], 0)
+    }
+    return d, nil
+}
+
+//tweetDomains lists the distinct domains linked from a tweet
+func tweetDomains(tweet *twittertypes.Tweet) []string {
+    domains := make([]string, 0, len(tweet.Entities.Urls))
+    seen := make(map[string]bool)
+    for _, turl := range tweet.Entities.Urls {
+        domain := UrlDomain(string(turl.Expanded_url))
+        if domain != "" && !seen[domain] {
+            seen[domain] = true
+            domains = append(domains, domain)
+        }
+    }
+    return domains
+}
+
+func nullableId(id *int64) interface{} {
+    if id == nil {
+        return nil
+    }
+    return *id
+}
+
+//SaveDerived writes the entity tables plus normtweets, users, relations, url_domains,
+//tweet_langs, tweet_clusters and the text search index for a tweet.
+//It is run for every saved tweet and again by ReprocessChunk when the derivations change.
+func (sts *SqliteTweetStore) SaveDerived(tweet *twittertypes.Tweet) error {
+    tx, ownTx := sts.GetOrStartTransaction()
+
+    sts.SaveEntities(tweet)
+
+    d, err := deriveRows(tweet)
+    if err != nil {
+        fmt.Printf("Error decoding tweet for derived tables: %s\n", err)
+    } else {
+        insertnormq := "INSERT OR REPLACE INTO normtweets (tweetid, screen_name, created_at, text, in_reply_to_user_id, in_reply_to_screen_name, source, in_reply_to_status_id, fulltweet) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);"
+        insertuserq := "INSERT OR REPLACE INTO users (userid, tweetid, screen_name, observed_at, object) VALUES (?, ?, ?, ?, ?);"
+        insertrelationq := "INSERT OR REPLACE INTO relations (tweetid, type, source_userid, source_screen_name, target_userid, target_screen_name, target_tweetid) VALUES (?, ?, ?, ?, ?, ?, ?);"
+        insertdomainq := "INSERT OR REPLACE INTO url_domains (domain, tweetid) VALUES (?, ?);"
+        insertsearchq := "INSERT OR REPLACE INTO tweetsearch (docid, tweettext) VALUES (?, ?);"
+        insertlangq := "INSERT OR REPLACE INTO tweet_langs (tweetid, lang, detected) VALUES (?, ?, ?);"
+
+        screenName := ""
+        if d.src.User != nil {
+            screenName = d.src.User.Screen_name
+        }
+        _, err = tx.Exec(insertnormq, d.src.Id, screenName, d.createdAt, d.src.Text, nullableId(d.src.In_reply_to_user_id), d.src.In_reply_to_screen_name, d.src.Source, nullableId(d.src.In_reply_to_status_id), d.raw)
+        if err != nil {
+            fmt.Printf("Error inserting normtweet: %s\n", err)
+        }
+        if d.src.User != nil && d.userObject != nil {
+            _, err = tx.Exec(insertuserq, d.src.User.Id, d.src.Id, screenName, d.createdAt, []byte(d.userObject))
+            if err != nil {
+                fmt.Printf("Error inserting user: %s\n", err)
+            }
+        }
+        for _, r := range d.relations {
+            _, err = tx.Exec(insertrelationq, r.TweetId, r.Type, r.SourceUserId, r.SourceScreenName, r.TargetUserId, r.TargetScreenName, r.TargetTweetId)
+            if err != nil {
+                fmt.Printf("Error inserting relation: %s\n", err)
+            }
+        }
+        for _, domain := range tweetDomains(tweet) {
+            _, err = tx.Exec(insertdomainq, domain, d.src.Id)
+            if err != nil {
+                fmt.Printf("Error inserting url domain: %s\n", err)
+            }
+        }
+        _, err = tx.Exec(insertsearchq, d.src.Id, d.src.Text)
+        if err != nil {
+            fmt.Printf("Error inserting searchable text: %s\n", err)
+        }
+        _, err = tx.Exec(insertlangq, d.src.Id, d.lang, d.langDetected)
+        if err != nil {
+            fmt.Printf("Error inserting tweet language: %s\n", err)
+        }
+        sts.saveCluster(tx, d.src.Id, d.signature)
+    }
+
+    if ownTx {
+        err := sts.CommitTransaction()
+        if err != nil {
+            fmt.Printf("Error commiting saveDerived TX: %s\n", err)
+        }
+    }
+    return nil
+}
+
+func (sts *SqliteTweetStore) CountTweetsAfterId(tweetid int64) int {
+    var count int
+    err := sts.DB.QueryRow("SELECT COUNT(tweetid) FROM tweets WHERE tweetid > ?;", tweetid).Scan(&count)
+    if err != nil {
+        fmt.Printf("Error counting tweets after id: %s\n", err)
+    }
+    return count
+}
+
+//derivedTables are the tables SaveDerived can write several rows to for one tweet. Their rows
+//are cleared before a tweet is reprocessed, so rows it no longer derives don't linger; the
+//tables of one row per tweet are replaced, and saveCluster clears the cluster tables itself.
+var derivedTables = []string{"media", "user_mentions", "urls", "hashtags", "users", "relations", "url_domains"}
+
+//clearDerived deletes a tweet's rows from derivedTables, with the backend's placeholder for its id
+func clearDerived(tx *sql.Tx, tweetid int64, placeholder string) error {
+    for _, table := range derivedTables {
+        _, err := tx.Exec("DELETE FROM "+table+" WHERE tweetid = "+placeholder+";", tweetid)
+        if err != nil {
+            fmt.Printf("Error clearing %s rows of tweet %d: %s\n", table, tweetid, err)
+            return err
+        }
+    }
+    return nil
+}
+
+//ReprocessChunk clears and re-runs SaveDerived for up to limit stored tweets after afterId, in id
+//order, inside a single transaction. It returns the last id processed and how many tweets were processed.
+func (sts *SqliteTweetStore) ReprocessChunk(afterId int64, limit int) (int64, int, error) {
+    chunkq := "SELECT tweetid, fulltweet FROM tweets WHERE tweetid > ? ORDER BY tweetid ASC LIMIT ?;"
+    rows, err := sts.DB.Query(chunkq, afterId, limit)
+    if err != nil {
+        fmt.Printf("Error selecting reprocess chunk: %s\n", err)
+        return afterId, 0, err
+    }
+    tweets, lastId, err := scanReprocessRows(rows, afterId)
+    if err != nil {
+        return afterId, 0, err
+    }
+
+    sts.BeginTransaction()
+    if sts.CurrentTx == nil {
+        return afterId, 0, fmt.Errorf("no SQLite transaction to reprocess in")
+    }
+    for _, tweet := range tweets {
+        err = clearDerived(sts.CurrentTx, int64(*tweet.Id), "?")
+        if err != nil {
+            sts.RollbackTransaction()
+            return afterId, 0, err
+        }
+        sts.SaveDerived(tweet)
+    }
+    err = sts.CommitTransaction()
+    return lastId, len(tweets), err
+}
+
+//scanReprocessRows reads a whole chunk before any writes happen, so the read
+//cursor is not held open while the chunk's transaction runs
+func scanReprocessRows(rows *sql.Rows, afterId int64) ([]*twittertypes.Tweet, int64, error) {
+    defer rows.Close()
+    lastId := afterId
+    tweets := make([]*twittertypes.Tweet, 0, 200)
+    for rows.Next() {
+        var tweetid int64
+        var tweetstring []byte
+        err := rows.Scan(&tweetid, &tweetstring)
+        if err != nil {
+            return nil, afterId, err
+        }
+        lastId = tweetid
+        tweet := &twittertypes.Tweet{}
+        err = json.Unmarshal(tweetstring, tweet)
+        if err != nil || tweet.Id == nil {
+            fmt.Printf("Error unmarshalling tweet %d for reprocessing: %v\n", tweetid, err)
+            continue
+        }
+        tweet.RawBytes = tweetstring
+        tweets = append(tweets, tweet)
+    }
+    return tweets, lastId, rows.Err()
+}
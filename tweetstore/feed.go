@@ -0,0 +1,72 @@
+package tweetstore
+
+import (
+    "fmt"
+    "github.com/fcheslack/webtypes/twitter"
+    "sync"
+)
+
+//Feed publishes saved tweets to in-process subscribers. Tweets saved inside a
+//transaction are held back until it commits and dropped if it rolls back.
+//The zero value is ready to use.
+type Feed struct {
+    mu      sync.Mutex
+    subs    map[int]chan *twittertypes.Tweet
+    nextSub int
+    pending []*twittertypes.Tweet
+}
+
+//Subscribe returns a channel of newly committed tweets and a func to stop the subscription.
+//A subscriber that falls more than buffer tweets behind misses tweets rather than blocking saves.
+func (f *Feed) Subscribe(buffer int) (<-chan *twittertypes.Tweet, func()) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    if f.subs == nil {
+        f.subs = make(map[int]chan *twittertypes.Tweet)
+    }
+    id := f.nextSub
+    f.nextSub++
+    ch := make(chan *twittertypes.Tweet, buffer)
+    f.subs[id] = ch
+
+    var once sync.Once
+    cancel := func() {
+        once.Do(func() {
+            f.mu.Lock()
+            defer f.mu.Unlock()
+            delete(f.subs, id)
+            close(ch)
+        })
+    }
+    return ch, cancel
+}
+
+func (f *Feed) queue(tweet *twittertypes.Tweet) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    if len(f.subs) == 0 {
+        return
+    }
+    f.pending = append(f.pending, tweet)
+}
+
+func (f *Feed) publish() {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    for _, tweet := range f.pending {
+        for _, ch := range f.subs {
+            select {
+            case ch <- tweet:
+            default:
+                fmt.Printf("Tweet feed subscriber is full, dropping tweet\n")
+            }
+        }
+    }
+    f.pending = nil
+}
+
+func (f *Feed) discard() {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.pending = nil
+}
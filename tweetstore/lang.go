@@ -0,0 +1,28 @@
+package tweetstore
+
+import (
+    "encoding/json"
+    "github.com/fcheslack/tweetlog/langid"
+    "github.com/fcheslack/webtypes/twitter"
+)
+
+//tweetLang is twitter's language code for a tweet or, when twitter left it out or
+//could not tell, the language langid detects in the text. detected reports which.
+func tweetLang(lang string, text string) (string, bool) {
+    lang = langid.Normalize(lang)
+    if lang != "" && lang != langid.Undetermined {
+        return lang, false
+    }
+    return langid.Detect(text).Lang, true
+}
+
+//TweetLang is the language a tweet is stored under in tweet_langs, for filtering
+//tweets that are already in memory the same way the store does
+func TweetLang(tweet *twittertypes.Tweet) string {
+    var src struct {
+        Lang string
+    }
+    json.Unmarshal(tweetRaw(tweet), &src)
+    lang, _ := tweetLang(src.Lang, tweet.Text)
+    return lang
+}
@@ -0,0 +1,157 @@
+package tweetstore
+
+import (
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "github.com/fcheslack/webtypes/twitter"
+    "time"
+)
+
+//MetricsSnapshot is one observation of a tweet's engagement counts.
+//ReplyCount and QuoteCount are nil when twitter did not include them.
+type MetricsSnapshot struct {
+    TweetId       int64
+    ObservedAt    time.Time
+    RetweetCount  int64
+    FavoriteCount int64
+    ReplyCount    *int64
+    QuoteCount    *int64
+}
+
+//RepollCandidate is a recent tweet with the time its metrics were last observed
+type RepollCandidate struct {
+    TweetId      int64
+    CreatedAt    time.Time
+    LastObserved time.Time
+}
+
+type metricsSource struct {
+    Id               int64
+    Retweet_count    int64
+    Favorite_count   int64
+    Reply_count      *int64
+    Quote_count      *int64
+    Retweeted_status *metricsSource
+}
+
+//metricsSnapshots reads the counts carried by a tweet, including those of the
+//retweeted tweet, which arrive with every retweet of it
+func metricsSnapshots(tweet *twittertypes.Tweet, observedAt time.Time) ([]MetricsSnapshot, error) {
+    var src metricsSource
+    err := json.Unmarshal(tweetRaw(tweet), &src)
+    if err != nil {
+        return nil, err
+    }
+    snapshots := make([]MetricsSnapshot, 0, 2)
+    for _, m := range []*metricsSource{&src, src.Retweeted_status} {
+        if m == nil || m.Id == 0 {
+            continue
+        }
+        snapshots = append(snapshots, MetricsSnapshot{
+            TweetId:       m.Id,
+            ObservedAt:    observedAt.UTC(),
+            RetweetCount:  m.Retweet_count,
+            FavoriteCount: m.Favorite_count,
+            ReplyCount:    m.Reply_count,
+            QuoteCount:    m.Quote_count,
+        })
+    }
+    return snapshots, nil
+}
+
+func nullableCount(c *int64) interface{} {
+    if c == nil {
+        return nil
+    }
+    return *c
+}
+
+//SaveMetrics appends the engagement counts carried by tweet to tweet_metrics.
+//Rows are never replaced, so repeated sightings build up a growth curve.
+func (sts *SqliteTweetStore) SaveMetrics(tweet *twittertypes.Tweet, observedAt time.Time) error {
+    snapshots, err := metricsSnapshots(tweet, observedAt)
+    if err != nil {
+        fmt.Printf("Error decoding tweet metrics: %s\n", err)
+        return err
+    }
+    tx, ownTx := sts.GetOrStartTransaction()
+    insertmetricsq := "INSERT INTO tweet_metrics (tweetid, observed_at, retweet_count, favorite_count, reply_count, quote_count) VALUES (?, ?, ?, ?, ?, ?);"
+    for _, m := range snapshots {
+        _, err = tx.Exec(insertmetricsq, m.TweetId, m.ObservedAt.Unix(), m.RetweetCount, m.FavoriteCount, nullableCount(m.ReplyCount), nullableCount(m.QuoteCount))
+        if err != nil {
+            fmt.Printf("Error inserting tweet metrics: %s\n", err)
+        }
+    }
+    if ownTx {
+        err := sts.CommitTransaction()
+        if err != nil {
+            fmt.Printf("Error commiting saveMetrics TX: %s\n", err)
+        }
+    }
+    return nil
+}
+
+//Get every metrics observation for a tweet, oldest first
+func (sts *SqliteTweetStore) TweetMetrics(tweetid int64) []MetricsSnapshot {
+    metricsq := "SELECT tweetid, observed_at, retweet_count, favorite_count, reply_count, quote_count FROM tweet_metrics WHERE tweetid = ? ORDER BY observed_at ASC;"
+    return queryMetrics(sts.DB.Query(metricsq, tweetid))
+}
+
+//Get tweets created after createdAfter along with when their metrics were last observed
+func (sts *SqliteTweetStore) RepollCandidates(createdAfter time.Time) []RepollCandidate {
+    candidatesq := "SELECT tweettimestamps.tweetid, tweettimestamps.timestamp, COALESCE(MAX(tweet_metrics.observed_at), 0) FROM tweettimestamps LEFT JOIN tweet_metrics ON tweettimestamps.tweetid = tweet_metrics.tweetid WHERE tweettimestamps.timestamp > ? GROUP BY tweettimestamps.tweetid;"
+    return queryRepollCandidates(sts.DB.Query(candidatesq, createdAfter.Unix()))
+}
+
+func queryMetrics(rows *sql.Rows, err error) []MetricsSnapshot {
+    if err != nil {
+        fmt.Printf("Error getting tweet metrics: %s\n", err)
+        return nil
+    }
+    defer rows.Close()
+    snapshots := make([]MetricsSnapshot, 0, 20)
+    for rows.Next() {
+        var m MetricsSnapshot
+        var observedAt int64
+        var replyCount, quoteCount sql.NullInt64
+        err = rows.Scan(&m.TweetId, &observedAt, &m.RetweetCount, &m.FavoriteCount, &replyCount, &quoteCount)
+        if err != nil {
+            fmt.Printf("Error scanning tweet metrics row: %s\n", err)
+            continue
+        }
+        m.ObservedAt = time.Unix(observedAt, 0).UTC()
+        if replyCount.Valid {
+            m.ReplyCount = &replyCount.Int64
+        }
+        if quoteCount.Valid {
+            m.QuoteCount = &quoteCount.Int64
+        }
+        snapshots = append(snapshots, m)
+    }
+    return snapshots
+}
+
+func queryRepollCandidates(rows *sql.Rows, err error) []RepollCandidate {
+    if err != nil {
+        fmt.Printf("Error getting repoll candidates: %s\n", err)
+        return nil
+    }
+    defer rows.Close()
+    candidates := make([]RepollCandidate, 0, 200)
+    for rows.Next() {
+        var c RepollCandidate
+        var createdAt, lastObserved int64
+        err = rows.Scan(&c.TweetId, &createdAt, &lastObserved)
+        if err != nil {
+            fmt.Printf("Error scanning repoll candidate row: %s\n", err)
+            continue
+        }
+        c.CreatedAt = time.Unix(createdAt, 0).UTC()
+        if lastObserved > 0 {
+            c.LastObserved = time.Unix(lastObserved, 0).UTC()
+        }
+        candidates = append(candidates, c)
+    }
+    return candidates
+}
@@ -0,0 +1,892 @@
+package tweetstore
+
+import (
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "github.com/fcheslack/tweetlog/langid"
+    "github.com/fcheslack/tweetlog/neardup"
+    "github.com/fcheslack/webtypes/twitter"
+    _ "github.com/lib/pq"
+    "strconv"
+    "strings"
+    "time"
+)
+
+func init() {
+    Register("postgres", OpenPostgres)
+    Register("postgresql", OpenPostgres)
+}
+
+//Open a PostgreSQL database from a postgres:// URL as understood by lib/pq
+func OpenPostgres(dsn string) (TweetStore, error) {
+    db, err := sql.Open("postgres", dsn)
+    if err != nil {
+        fmt.Printf("Error opening postgres: %s\n", err)
+        return nil, err
+    }
+    pts := &PostgresTweetStore{}
+    _, err = pts.Initialize(db)
+    if err != nil {
+        db.Close()
+        return nil, err
+    }
+    return pts, nil
+}
+
+//PostgresTweetStore keeps the same tables as SqliteTweetStore, with fulltweet as JSONB,
+//GIN indexes over the entities and a tsvector column for text search
+type PostgresTweetStore struct {
+    Feed
+    DB        *sql.DB
+    CurrentTx *sql.Tx //pointer to current in progress transaction, may be nil
+}
+
+func (pts *PostgresTweetStore) Close() error {
+    return pts.DB.Close()
+}
+
+func (pts *PostgresTweetStore) BeginTransaction() error {
+    if pts.CurrentTx == nil {
+        currentTX, err := pts.DB.Begin()
+        if err != nil {
+            fmt.Printf("Error beginning postgres transaction\n%s\n", err)
+            return err
+        }
+        pts.CurrentTx = currentTX
+    }
+    return nil
+}
+
+func (pts *PostgresTweetStore) CommitTransaction() error {
+    if pts.CurrentTx != nil {
+        err := pts.CurrentTx.Commit()
+        pts.CurrentTx = nil
+        if err != nil {
+            fmt.Printf("Error committing postgres transaction\n%s\n", err)
+            pts.discard()
+            return err
+        }
+        pts.publish()
+    }
+    return nil
+}
+
+func (pts *PostgresTweetStore) RollbackTransaction() error {
+    if pts.CurrentTx != nil {
+        err := pts.CurrentTx.Rollback()
+        pts.CurrentTx = nil
+        pts.discard()
+        if err != nil {
+            fmt.Printf("Error Rolling back postgres transaction\n%s\n", err)
+            return err
+        }
+    }
+    return nil
+}
+
+func (pts *PostgresTweetStore) GetOrStartTransaction() (*sql.Tx, bool, error) {
+    if pts.CurrentTx != nil {
+        return pts.CurrentTx, false, nil
+    }
+    err := pts.BeginTransaction()
+    return pts.CurrentTx, true, err
+}
+
+//savepoint runs fn inside a savepoint of tx. A failed statement aborts the whole of a
+//postgres transaction, so this is how one tweet of a batch fails without losing the rest.
+func (pts *PostgresTweetStore) savepoint(tx *sql.Tx, fn func() error) error {
+    _, err := tx.Exec("SAVEPOINT tweet;")
+    if err != nil {
+        fmt.Printf("Error starting savepoint: %s\n", err)
+        return err
+    }
+    err = fn()
+    if err != nil {
+        _, rerr := tx.Exec("ROLLBACK TO SAVEPOINT tweet;")
+        if rerr != nil {
+            fmt.Printf("Error rolling back to savepoint: %s\n", rerr)
+        }
+        return err
+    }
+    _, err = tx.Exec("RELEASE SAVEPOINT tweet;")
+    return err
+}
+
+//finish commits a transaction the caller started itself, or rolls it back after err.
+//Within an outer transaction it only passes err on.
+func (pts *PostgresTweetStore) finish(ownTx bool, err error) error {
+    if !ownTx {
+        return err
+    }
+    if err != nil {
+        pts.RollbackTransaction()
+        return err
+    }
+    return pts.CommitTransaction()
+}
+
+func (pts *PostgresTweetStore) Initialize(db interface{}) (bool, error) {
+    pts.DB = db.(*sql.DB)
+    sqls := []string{
+        "CREATE TABLE IF NOT EXISTS tweets (tweetid BIGINT PRIMARY KEY, screen_name TEXT, time TIMESTAMPTZ, text TEXT, fulltweet JSONB, textsearch TSVECTOR GENERATED ALWAYS AS (to_tsvector('simple', coalesce(text, ''))) STORED);",
+        "CREATE INDEX IF NOT EXISTS tweetstimeind ON tweets (time);",
+        "CREATE INDEX IF NOT EXISTS tweetsscreennameind ON tweets (lower(screen_name));",
+        "CREATE INDEX IF NOT EXISTS tweetstextsearchind ON tweets USING GIN (textsearch);",
+        "CREATE INDEX IF NOT EXISTS tweetsentitiesind ON tweets USING GIN ((fulltweet -> 'entities') jsonb_path_ops);",
+        "CREATE TABLE IF NOT EXISTS normtweets (tweetid BIGINT PRIMARY KEY, screen_name TEXT, created_at TIMESTAMPTZ, text TEXT, in_reply_to_user_id BIGINT, in_reply_to_screen_name TEXT, source TEXT, in_reply_to_status_id BIGINT, fulltweet JSONB);",
+        "CREATE TABLE IF NOT EXISTS tweettimestamps (tweetid BIGINT PRIMARY KEY, timestamp BIGINT);",
+        "CREATE INDEX IF NOT EXISTS tweettimeind ON tweettimestamps (timestamp);",
+        "CREATE TABLE IF NOT EXISTS streamevents (eventid BIGSERIAL PRIMARY KEY, eventtype TEXT, object JSONB);",
+        "CREATE TABLE IF NOT EXISTS media (mediaid BIGINT, tweetid BIGINT, expanded_url TEXT, type TEXT, object JSONB, UNIQUE (mediaid, tweetid));",
+        "CREATE TABLE IF NOT EXISTS user_mentions (userid BIGINT, tweetid BIGINT, screen_name TEXT, name TEXT, object JSONB, UNIQUE (userid, tweetid));",
+        "CREATE TABLE IF NOT EXISTS urls (expanded_url TEXT, tweetid BIGINT, url TEXT, object JSONB, UNIQUE (expanded_url, tweetid));",
+        "CREATE TABLE IF NOT EXISTS hashtags (text TEXT, tweetid BIGINT, object JSONB, UNIQUE (text, tweetid));",
+        "CREATE INDEX IF NOT EXISTS hashtagstextind ON hashtags (lower(text));",
+        "CREATE INDEX IF NOT EXISTS mentionsscreennameind ON user_mentions (lower(screen_name));",
+        "CREATE INDEX IF NOT EXISTS urlstweetind ON urls (tweetid);",
+        "CREATE INDEX IF NOT EXISTS hashtagstweetind ON hashtags (tweetid);",
+        "CREATE INDEX IF NOT EXISTS mentionstweetind ON user_mentions (tweetid);",
+        "CREATE INDEX IF NOT EXISTS mediatweetind ON media (tweetid);",
+        "CREATE TABLE IF NOT EXISTS url_domains (domain TEXT, tweetid BIGINT, UNIQUE (domain, tweetid));",
+        "CREATE INDEX IF NOT EXISTS urldomainstweetind ON url_domains (tweetid);",
+        "CREATE TABLE IF NOT EXISTS tweet_metrics (tweetid BIGINT, observed_at BIGINT, retweet_count BIGINT, favorite_count BIGINT, reply_count BIGINT, quote_count BIGINT);",
+        "CREATE INDEX IF NOT EXISTS tweetmetricsind ON tweet_metrics (tweetid, observed_at);",
+        "CREATE TABLE IF NOT EXISTS users (userid BIGINT, tweetid BIGINT, screen_name TEXT, observed_at TIMESTAMPTZ, object JSONB, UNIQUE (userid, tweetid));",
+        "CREATE INDEX IF NOT EXISTS usersscreennameind ON users (lower(screen_name));",
+        "CREATE INDEX IF NOT EXISTS userstweetind ON users (tweetid);",
+        "CREATE TABLE IF NOT EXISTS relations (tweetid BIGINT, type TEXT, source_userid BIGINT, source_screen_name TEXT, target_userid BIGINT, target_screen_name TEXT, target_tweetid BIGINT, UNIQUE (tweetid, type, target_userid));",
+        "CREATE INDEX IF NOT EXISTS relationssourceind ON relations (source_userid);",
+        "CREATE INDEX IF NOT EXISTS relationstargetind ON relations (target_userid);",
+        "CREATE INDEX IF NOT EXISTS relationstargettweetind ON relations (target_tweetid);",
+        "CREATE TABLE IF NOT EXISTS api_tokens (id TEXT PRIMARY KEY, name TEXT, hash TEXT UNIQUE, scopes TEXT, created_at BIGINT, revoked_at BIGINT);",
+        "CREATE TABLE IF NOT EXISTS tweet_sentiment (tweetid BIGINT PRIMARY KEY, scorer TEXT, scored_at BIGINT, compound DOUBLE PRECISION, positive DOUBLE PRECISION, negative DOUBLE PRECISION, neutral DOUBLE PRECISION);",
+        "CREATE TABLE IF NOT EXISTS tweet_langs (tweetid BIGINT PRIMARY KEY, lang TEXT NOT NULL, detected BOOLEAN NOT NULL);",
+        "CREATE INDEX IF NOT EXISTS tweetlangsind ON tweet_langs (lang);",
+        "CREATE TABLE IF NOT EXISTS account_scores (userid BIGINT PRIMARY KEY, screen_name TEXT, tweets INTEGER, score DOUBLE PRECISION, scored_at BIGINT, signals JSONB);",
+        "CREATE INDEX IF NOT EXISTS accountscoresind ON account_scores (score);",
+        "CREATE TABLE IF NOT EXISTS tweet_clusters (tweetid BIGINT PRIMARY KEY, clusterid BIGINT NOT NULL, similarity DOUBLE PRECISION, signature BYTEA);",
+        "CREATE INDEX IF NOT EXISTS tweetclustersind ON tweet_clusters (clusterid);",
+        "CREATE TABLE IF NOT EXISTS tweet_lsh (band BIGINT, tweetid BIGINT, UNIQUE (band, tweetid));",
+        "CREATE INDEX IF NOT EXISTS tweetlshtweetind ON tweet_lsh (tweetid);",
+    }
+
+    for _, sql := range sqls {
+        _, err := pts.DB.Exec(sql)
+        if err != nil {
+            fmt.Printf("%q: %s\n", err, sql)
+            return false, err
+        }
+    }
+    return true, nil
+}
+
+//SaveTweet saves a tweet with its entities, derived rows and metrics. Unlike the sqlite
+//store, which only gives up on the tweet's own row, it stops at the first failed statement
+//and undoes the whole tweet.
+func (pts *PostgresTweetStore) SaveTweet(tweet *twittertypes.Tweet) error {
+    if tweet.Id == nil || tweet.User == nil {
+        return fmt.Errorf("tweet has no id or user")
+    }
+    tx, ownTx, err := pts.GetOrStartTransaction()
+    if err != nil {
+        return err
+    }
+    if ownTx {
+        return pts.finish(ownTx, pts.saveTweet(tx, tweet))
+    }
+    return pts.savepoint(tx, func() error {
+        return pts.saveTweet(tx, tweet)
+    })
+}
+
+func (pts *PostgresTweetStore) saveTweet(tx *sql.Tx, tweet *twittertypes.Tweet) error {
+    storetweetq := "INSERT INTO tweets (tweetid, screen_name, time, text, fulltweet) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (tweetid) DO UPDATE SET screen_name = EXCLUDED.screen_name, time = EXCLUDED.time, text = EXCLUDED.text, fulltweet = EXCLUDED.fulltweet;"
+    storetimestampq := "INSERT INTO tweettimestamps (tweetid, timestamp) VALUES ($1, $2) ON CONFLICT (tweetid) DO UPDATE SET timestamp = EXCLUDED.timestamp;"
+
+    created_at, err := time.Parse(time.RubyDate, tweet.Created_at)
+    if err != nil {
+        fmt.Printf("Error parsing created_at time:%s\n", err)
+    }
+    raw := tweet.RawBytes
+    if raw == nil {
+        raw, err = json.Marshal(tweet)
+        if err != nil {
+            fmt.Printf("No tweet.RawBytes and error marshalling tweet: %s\n", err)
+        }
+    }
+    _, err = tx.Exec(storetweetq, int64(*tweet.Id), tweet.User.Screen_name, created_at, tweet.Text, string(raw))
+    if err != nil {
+        fmt.Printf("Error inserting tweet: %s\n", err)
+        return err
+    }
+
+    _, err = tx.Exec(storetimestampq, int64(*tweet.Id), created_at.Unix())
+    if err != nil {
+        fmt.Printf("Error inserting tweet timestamp: %s\n", err)
+        return err
+    }
+
+    err = pts.SaveDerived(tweet)
+    if err != nil {
+        return err
+    }
+    err = pts.SaveMetrics(tweet, time.Now())
+    if err != nil {
+        return err
+    }
+    pts.queue(tweet)
+    return nil
+}
+
+func (pts *PostgresTweetStore) SaveTweets(tweets []*twittertypes.Tweet) error {
+    _, ownTx, err := pts.GetOrStartTransaction()
+    if err != nil {
+        return err
+    }
+    for _, t := range tweets {
+        err := pts.SaveTweet(t)
+        if err != nil {
+            fmt.Printf("Error saving tweet from batch: %s\n", err)
+        }
+    }
+    if ownTx {
+        err := pts.CommitTransaction()
+        if err != nil {
+            fmt.Printf("Error commiting saveTweets transaction: %s\n", err)
+            return err
+        }
+    }
+    return nil
+}
+
+func (pts *PostgresTweetStore) SaveEntities(tweet *twittertypes.Tweet) error {
+    tx, ownTx, err := pts.GetOrStartTransaction()
+    if err != nil {
+        return err
+    }
+
+    insertmediaq := "INSERT INTO media VALUES ($1, $2, $3, $4, $5) ON CONFLICT (mediaid, tweetid) DO UPDATE SET expanded_url = EXCLUDED.expanded_url, type = EXCLUDED.type, object = EXCLUDED.object;"
+    insertusermentionq := "INSERT INTO user_mentions VALUES ($1, $2, $3, $4, $5) ON CONFLICT (userid, tweetid) DO UPDATE SET screen_name = EXCLUDED.screen_name, name = EXCLUDED.name, object = EXCLUDED.object;"
+    inserturlq := "INSERT INTO urls VALUES ($1, $2, $3, $4) ON CONFLICT (expanded_url, tweetid) DO UPDATE SET url = EXCLUDED.url, object = EXCLUDED.object;"
+    inserthashq := "INSERT INTO hashtags VALUES ($1, $2, $3) ON CONFLICT (text, tweetid) DO UPDATE SET object = EXCLUDED.object;"
+
+    if tweet.Id == nil {
+        return pts.finish(ownTx, fmt.Errorf("tweet has no id"))
+    }
+    tweetid := int64(*tweet.Id)
+    for _, media := range tweet.Entities.Media {
+        j, _ := json.Marshal(media)
+        _, err := tx.Exec(insertmediaq, media.Id, tweetid, media.Expanded_url, media.Type, string(j))
+        if err != nil {
+            fmt.Printf("Error inserting media: %s\n", err)
+            return pts.finish(ownTx, err)
+        }
+    }
+
+    for _, mention := range tweet.Entities.User_mentions {
+        j, _ := json.Marshal(mention)
+        n := string(mention.Name)
+        _, err := tx.Exec(insertusermentionq, mention.Id, tweetid, mention.Screen_name, n, string(j))
+        if err != nil {
+            fmt.Printf("Error inserting mention: %s\n", err)
+            return pts.finish(ownTx, err)
+        }
+    }
+
+    for _, turl := range tweet.Entities.Urls {
+        j, _ := json.Marshal(turl)
+        _, err := tx.Exec(inserturlq, string(turl.Expanded_url), tweetid, turl.Url, string(j))
+        if err != nil {
+            fmt.Printf("Error inserting url: %s\n", err)
+            return pts.finish(ownTx, err)
+        }
+    }
+
+    for _, ht := range tweet.Entities.Hashtags {
+        j, _ := json.Marshal(ht)
+        _, err := tx.Exec(inserthashq, ht.Text, tweetid, string(j))
+        if err != nil {
+            fmt.Printf("Error inserting hashtag: %s\n", err)
+            return pts.finish(ownTx, err)
+        }
+    }
+
+    err = pts.finish(ownTx, nil)
+    if err != nil {
+        fmt.Printf("Error commiting saveEntities TX: %s\n", err)
+    }
+    return err
+}
+
+func (pts *PostgresTweetStore) SaveEvent(event *twittertypes.Event) error {
+    storeeventq := "INSERT INTO streamevents (eventtype, object) VALUES ($1, $2);"
+    _ = storeeventq
+    return nil
+}
+
+func (pts *PostgresTweetStore) LatestTweetId() int64 {
+    lastIdq := "SELECT tweetid FROM tweets ORDER BY tweetid DESC LIMIT 1;"
+    row := pts.DB.QueryRow(lastIdq)
+    var lastId int64
+    err := row.Scan(&lastId)
+    if err != nil {
+        fmt.Printf("Error getting last tweet id: %s\n", err)
+        return 0
+    }
+    return lastId
+}
+
+func (pts *PostgresTweetStore) RecentTweets(count int) []*twittertypes.Tweet {
+    recenttweetsq := "SELECT tweetid, fulltweet FROM tweets ORDER BY tweetid DESC LIMIT $1;"
+    rows, err := pts.DB.Query(recenttweetsq, count)
+    if err != nil {
+        fmt.Printf("Error getting recent tweets: %s\n", err)
+        return nil
+    }
+    tweets, _ := scanTweetRows(rows)
+    return tweets
+}
+
+func (pts *PostgresTweetStore) TweetsAfterId(tweetid int64) []*twittertypes.Tweet {
+    tweetsafterq := "SELECT tweetid, fulltweet FROM tweets WHERE tweetid > $1 ORDER BY tweetid DESC;"
+    rows, err := pts.DB.Query(tweetsafterq, tweetid)
+    if err != nil {
+        fmt.Printf("Error getting tweets after id: %s\n", err)
+        return nil
+    }
+    tweets, _ := scanTweetRows(rows)
+    return tweets
+}
+
+//Get all the urls (according to twitter, so this excludes explicit media) posted between startTime and endTime
+func (pts *PostgresTweetStore) IntervalUrls(startTime time.Time, endTime time.Time) []*twittertypes.TwitterUrl {
+    var urls = make([]*twittertypes.TwitterUrl, 0, 200)
+    urlquery := "SELECT tweets.tweetid, urls.object FROM tweets JOIN urls ON tweets.tweetid = urls.tweetid WHERE tweets.time > $1 AND tweets.time < $2 ORDER BY tweets.tweetid DESC;"
+    rows, err := pts.DB.Query(urlquery, startTime, endTime)
+    if err != nil {
+        fmt.Printf("Error getting interval urls: %s\n", err)
+        return nil
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var tweetid int64
+        var tweeturlstring []byte
+        err = rows.Scan(&tweetid, &tweeturlstring)
+        if err != nil {
+            fmt.Printf("Error scanning tweeturl row: %s\n", err)
+            continue
+        }
+        var tweeturl = &twittertypes.TwitterUrl{}
+        err = json.Unmarshal(tweeturlstring, tweeturl)
+        if err != nil {
+            fmt.Printf("Error unmarshalling tweeturl row: %s\n", err)
+            fmt.Printf("Problematic tweet: %d \n%s\n", tweetid, string(tweeturlstring))
+        }
+        urls = append(urls, tweeturl)
+    }
+    return urls
+}
+
+//Get all the tweets posted between startTime and endTime
+func (pts *PostgresTweetStore) IntervalTweets(startTime time.Time, endTime time.Time) []*twittertypes.Tweet {
+    tweetsq := "SELECT tweetid, fulltweet FROM tweets WHERE tweets.time > $1 AND tweets.time < $2 ORDER BY tweets.tweetid DESC;"
+    rows, err := pts.DB.Query(tweetsq, startTime, endTime)
+    if err != nil {
+        fmt.Printf("Error getting interval tweets: %s\n", err)
+        return nil
+    }
+    tweets, _ := scanTweetRows(rows)
+    return tweets
+}
+
+func (pts *PostgresTweetStore) IntervalTop(kind TopKind, startTime time.Time, endTime time.Time, limit int) []KeyCount {
+    topq, err := topQuery(kind, "$1", "$2", "$3", "")
+    if err != nil {
+        fmt.Printf("Error getting top counts: %s\n", err)
+        return nil
+    }
+    return scanKeyCounts(pts.DB.Query(topq, startTime.Unix(), endTime.Unix(), limit))
+}
+
+func (pts *PostgresTweetStore) IntervalRelations(startTime time.Time, endTime time.Time) ([]RelationCount, error) {
+    return scanRelationCounts(pts.DB.Query(relationCountQuery("$1", "$2"), startTime.Unix(), endTime.Unix()))
+}
+
+func (pts *PostgresTweetStore) TweetSeries(q SeriesQuery) (*Series, error) {
+    bounds, err := seriesBoundaries(&q)
+    if err != nil {
+        return nil, err
+    }
+    seriesq, args, err := seriesQuery(&q, bounds, func(n int) string { return "$" + strconv.Itoa(n) })
+    if err != nil {
+        return nil, err
+    }
+    rows, err := pts.DB.Query(seriesq, args...)
+    return scanSeries(&q, bounds, rows, err)
+}
+
+func (pts *PostgresTweetStore) IntervalTweetCount(intervalDuration time.Duration, numIntervals int) []int {
+    return pts.IntervalTweetCountBefore(time.Now(), intervalDuration, numIntervals)
+}
+
+//Count tweets in each of numIntervals intervals counting back from endTime, newest first
+func (pts *PostgresTweetStore) IntervalTweetCountBefore(endTime time.Time, intervalDuration time.Duration, numIntervals int) []int {
+    secs := intervalSeconds(intervalDuration)
+    end := endTime.Unix()
+    intervalq := "SELECT ($1 - timestamp) / $2 AS bucket, COUNT(tweetid) FROM tweettimestamps WHERE timestamp > $3 AND timestamp <= $1 GROUP BY bucket;"
+    rows, err := pts.DB.Query(intervalq, end, secs, end-secs*int64(numIntervals))
+    return intervalBuckets(rows, err, numIntervals)
+}
+
+//Get the sorted, de-duplicated ids of all tweets matching q
+func (pts *PostgresTweetStore) DehydrateIds(q DehydrateQuery) ([]int64, error) {
+    query := "SELECT DISTINCT tweets.tweetid FROM tweets"
+    where := make([]string, 0, 5)
+    args := make([]interface{}, 0, 5)
+    arg := func(v interface{}) string {
+        args = append(args, v)
+        return "$" + strconv.Itoa(len(args))
+    }
+
+    if q.Hashtag != "" {
+        query += " JOIN hashtags ON tweets.tweetid = hashtags.tweetid"
+        where = append(where, "lower(hashtags.text) = lower("+arg(strings.TrimPrefix(q.Hashtag, "#"))+")")
+    }
+    if q.Search != "" {
+        where = append(where, "tweets.textsearch @@ plainto_tsquery('simple', "+arg(q.Search)+")")
+    }
+    if q.ScreenName != "" {
+        where = append(where, "lower(tweets.screen_name) = lower("+arg(strings.TrimPrefix(q.ScreenName, "@"))+")")
+    }
+    if q.Lang != "" {
+        where = append(where, "tweets.tweetid IN (SELECT tweetid FROM tweet_langs WHERE lang = "+arg(langid.Normalize(q.Lang))+")")
+    }
+    if !q.StartTime.IsZero() {
+        where = append(where, "tweets.time >= "+arg(q.StartTime.UTC()))
+    }
+    if !q.EndTime.IsZero() {
+        where = append(where, "tweets.time < "+arg(q.EndTime.UTC()))
+    }
+    if len(where) > 0 {
+        query += " WHERE " + strings.Join(where, " AND ")
+    }
+    query += " ORDER BY tweets.tweetid ASC;"
+
+    rows, err := pts.DB.Query(query, args...)
+    if err != nil {
+        fmt.Printf("Error selecting tweet ids to dehydrate: %s\n", err)
+        return nil, err
+    }
+    defer rows.Close()
+
+    ids := make([]int64, 0, 200)
+    for rows.Next() {
+        var tweetid int64
+        err = rows.Scan(&tweetid)
+        if err != nil {
+            fmt.Printf("Error scanning dehydrate row: %s\n", err)
+            continue
+        }
+        ids = append(ids, tweetid)
+    }
+    return ids, rows.Err()
+}
+
+//SaveDerived writes the entity tables plus the derived tables of a tweet, stopping at
+//the first failed statement
+func (pts *PostgresTweetStore) SaveDerived(tweet *twittertypes.Tweet) error {
+    tx, ownTx, err := pts.GetOrStartTransaction()
+    if err != nil {
+        return err
+    }
+    err = pts.saveDerived(tx, tweet)
+    if ownTx {
+        err = pts.finish(ownTx, err)
+        if err != nil {
+            fmt.Printf("Error commiting saveDerived TX: %s\n", err)
+        }
+    }
+    return err
+}
+
+func (pts *PostgresTweetStore) saveDerived(tx *sql.Tx, tweet *twittertypes.Tweet) error {
+    err := pts.SaveEntities(tweet)
+    if err != nil {
+        return err
+    }
+
+    d, err := deriveRows(tweet)
+    if err != nil {
+        //the tweet itself is kept, as in the sqlite store
+        fmt.Printf("Error decoding tweet for derived tables: %s\n", err)
+        return nil
+    }
+    insertnormq := "INSERT INTO normtweets (tweetid, screen_name, created_at, text, in_reply_to_user_id, in_reply_to_screen_name, source, in_reply_to_status_id, fulltweet) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) ON CONFLICT (tweetid) DO UPDATE SET screen_name = EXCLUDED.screen_name, created_at = EXCLUDED.created_at, text = EXCLUDED.text, in_reply_to_user_id = EXCLUDED.in_reply_to_user_id, in_reply_to_screen_name = EXCLUDED.in_reply_to_screen_name, source = EXCLUDED.source, in_reply_to_status_id = EXCLUDED.in_reply_to_status_id, fulltweet = EXCLUDED.fulltweet;"
+    insertuserq := "INSERT INTO users (userid, tweetid, screen_name, observed_at, object) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (userid, tweetid) DO UPDATE SET screen_name = EXCLUDED.screen_name, observed_at = EXCLUDED.observed_at, object = EXCLUDED.object;"
+    insertrelationq := "INSERT INTO relations (tweetid, type, source_userid, source_screen_name, target_userid, target_screen_name, target_tweetid) VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT (tweetid, type, target_userid) DO UPDATE SET source_userid = EXCLUDED.source_userid, source_screen_name = EXCLUDED.source_screen_name, target_screen_name = EXCLUDED.target_screen_name, target_tweetid = EXCLUDED.target_tweetid;"
+    insertdomainq := "INSERT INTO url_domains (domain, tweetid) VALUES ($1, $2) ON CONFLICT DO NOTHING;"
+    insertlangq := "INSERT INTO tweet_langs (tweetid, lang, detected) VALUES ($1, $2, $3) ON CONFLICT (tweetid) DO UPDATE SET lang = EXCLUDED.lang, detected = EXCLUDED.detected;"
+
+    screenName := ""
+    if d.src.User != nil {
+        screenName = d.src.User.Screen_name
+    }
+    _, err = tx.Exec(insertnormq, d.src.Id, screenName, d.createdAt, d.src.Text, nullableId(d.src.In_reply_to_user_id), d.src.In_reply_to_screen_name, d.src.Source, nullableId(d.src.In_reply_to_status_id), string(d.raw))
+    if err != nil {
+        fmt.Printf("Error inserting normtweet: %s\n", err)
+        return err
+    }
+    if d.src.User != nil && d.userObject != nil {
+        _, err = tx.Exec(insertuserq, d.src.User.Id, d.src.Id, screenName, d.createdAt, string(d.userObject))
+        if err != nil {
+            fmt.Printf("Error inserting user: %s\n", err)
+            return err
+        }
+    }
+    for _, r := range d.relations {
+        _, err = tx.Exec(insertrelationq, r.TweetId, r.Type, r.SourceUserId, r.SourceScreenName, r.TargetUserId, r.TargetScreenName, r.TargetTweetId)
+        if err != nil {
+            fmt.Printf("Error inserting relation: %s\n", err)
+            return err
+        }
+    }
+    for _, domain := range tweetDomains(tweet) {
+        _, err = tx.Exec(insertdomainq, domain, d.src.Id)
+        if err != nil {
+            fmt.Printf("Error inserting url domain: %s\n", err)
+            return err
+        }
+    }
+    _, err = tx.Exec(insertlangq, d.src.Id, d.lang, d.langDetected)
+    if err != nil {
+        fmt.Printf("Error inserting tweet language: %s\n", err)
+        return err
+    }
+    return pts.saveCluster(tx, d.src.Id, d.signature)
+}
+
+func (pts *PostgresTweetStore) CountTweetsAfterId(tweetid int64) int {
+    var count int
+    err := pts.DB.QueryRow("SELECT COUNT(tweetid) FROM tweets WHERE tweetid > $1;", tweetid).Scan(&count)
+    if err != nil {
+        fmt.Printf("Error counting tweets after id: %s\n", err)
+    }
+    return count
+}
+
+func (pts *PostgresTweetStore) ReprocessChunk(afterId int64, limit int) (int64, int, error) {
+    chunkq := "SELECT tweetid, fulltweet FROM tweets WHERE tweetid > $1 ORDER BY tweetid ASC LIMIT $2;"
+    rows, err := pts.DB.Query(chunkq, afterId, limit)
+    if err != nil {
+        fmt.Printf("Error selecting reprocess chunk: %s\n", err)
+        return afterId, 0, err
+    }
+    tweets, lastId, err := scanReprocessRows(rows, afterId)
+    if err != nil {
+        return afterId, 0, err
+    }
+
+    err = pts.BeginTransaction()
+    if err != nil {
+        return afterId, 0, err
+    }
+    for _, tweet := range tweets {
+        err = pts.savepoint(pts.CurrentTx, func() error {
+            err := clearDerived(pts.CurrentTx, int64(*tweet.Id), "$1")
+            if err != nil {
+                return err
+            }
+            return pts.SaveDerived(tweet)
+        })
+        if err != nil {
+            fmt.Printf("Error reprocessing tweet: %s\n", err)
+        }
+    }
+    err = pts.CommitTransaction()
+    return lastId, len(tweets), err
+}
+
+func (pts *PostgresTweetStore) SaveMetrics(tweet *twittertypes.Tweet, observedAt time.Time) error {
+    snapshots, err := metricsSnapshots(tweet, observedAt)
+    if err != nil {
+        fmt.Printf("Error decoding tweet metrics: %s\n", err)
+        return err
+    }
+    tx, ownTx, err := pts.GetOrStartTransaction()
+    if err != nil {
+        return err
+    }
+    insertmetricsq := "INSERT INTO tweet_metrics (tweetid, observed_at, retweet_count, favorite_count, reply_count, quote_count) VALUES ($1, $2, $3, $4, $5, $6);"
+    for _, m := range snapshots {
+        _, err = tx.Exec(insertmetricsq, m.TweetId, m.ObservedAt.Unix(), m.RetweetCount, m.FavoriteCount, nullableCount(m.ReplyCount), nullableCount(m.QuoteCount))
+        if err != nil {
+            fmt.Printf("Error inserting tweet metrics: %s\n", err)
+            return pts.finish(ownTx, err)
+        }
+    }
+    return pts.finish(ownTx, nil)
+}
+
+func (pts *PostgresTweetStore) SaveSentiment(s TweetSentiment) error {
+    tx, ownTx, err := pts.GetOrStartTransaction()
+    if err != nil {
+        return err
+    }
+    savesentimentq := "INSERT INTO tweet_sentiment (tweetid, scorer, scored_at, compound, positive, negative, neutral) VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT (tweetid) DO UPDATE SET scorer = EXCLUDED.scorer, scored_at = EXCLUDED.scored_at, compound = EXCLUDED.compound, positive = EXCLUDED.positive, negative = EXCLUDED.negative, neutral = EXCLUDED.neutral;"
+    _, err = tx.Exec(savesentimentq, s.TweetId, s.Scorer, s.ScoredAt.Unix(), s.Compound, s.Positive, s.Negative, s.Neutral)
+    if err != nil {
+        fmt.Printf("Error saving tweet sentiment: %s\n", err)
+    }
+    if ownTx {
+        if err != nil {
+            pts.RollbackTransaction()
+            return err
+        }
+        return pts.CommitTransaction()
+    }
+    return err
+}
+
+func (pts *PostgresTweetStore) TweetSentiment(tweetid int64) (*TweetSentiment, error) {
+    sentimentq := "SELECT tweetid, scorer, scored_at, compound, positive, negative, neutral FROM tweet_sentiment WHERE tweetid = $1;"
+    return scanTweetSentiment(pts.DB.QueryRow(sentimentq, tweetid))
+}
+
+func (pts *PostgresTweetStore) IntervalSentiment(startTime time.Time, endTime time.Time) (SentimentSummary, error) {
+    return scanSentimentSummary(pts.DB.QueryRow(sentimentSummaryQuery("$1", "$2", ""), startTime.Unix(), endTime.Unix()))
+}
+
+//Count the tweets of the track phrases in the store, for readers limited to them
+func (pts *PostgresTweetStore) IntervalTrackStats(q TrackStatsQuery) (*TrackStats, error) {
+    if len(q.Track) == 0 {
+        return nil, fmt.Errorf("no track phrases to count the tweets of")
+    }
+    //the phrases are the first arguments of every query, $1 to $len(q.Track)
+    phrases := make([]interface{}, 0, len(q.Track))
+    filter := trackFilter(q.Track, func(phrase []string) string {
+        phrases = append(phrases, strings.Join(phrase, " "))
+        return "tweettimestamps.tweetid IN (SELECT tweetid FROM tweets WHERE textsearch @@ plainto_tsquery('simple', $" + strconv.Itoa(len(phrases)) + "))"
+    })
+    arg := func(n int) string {
+        return "$" + strconv.Itoa(len(phrases)+n)
+    }
+    withPhrases := func(args ...interface{}) []interface{} {
+        return append(append([]interface{}{}, phrases...), args...)
+    }
+    start := q.Start.Unix()
+    end := q.End.Unix()
+
+    stats := &TrackStats{Top: make(map[TopKind][]KeyCount, len(topColumns))}
+    for kind := range topColumns {
+        topq, err := topQuery(kind, arg(1), arg(2), arg(3), filter)
+        if err != nil {
+            return nil, err
+        }
+        stats.Top[kind] = scanKeyCounts(pts.DB.Query(topq, withPhrases(start, end, q.Top)...))
+    }
+
+    secs := intervalSeconds(q.Bucket)
+    intervalq := "SELECT (" + arg(1) + " - timestamp) / " + arg(2) + " AS bucket, COUNT(tweetid) FROM tweettimestamps WHERE timestamp > " + arg(3) + " AND timestamp <= " + arg(1) + " AND " + filter + " GROUP BY bucket;"
+    rows, err := pts.DB.Query(intervalq, withPhrases(end, secs, end-secs*int64(q.Buckets))...)
+    stats.Volume = intervalBuckets(rows, err, q.Buckets)
+
+    stats.Sentiment, err = scanSentimentSummary(pts.DB.QueryRow(sentimentSummaryQuery(arg(1), arg(2), filter), withPhrases(start, end)...))
+    if err != nil {
+        return nil, err
+    }
+    return stats, nil
+}
+
+func (pts *PostgresTweetStore) SaveAccountScore(s AccountScore) error {
+    signals, err := json.Marshal(s.Signals)
+    if err != nil {
+        return err
+    }
+    tx, ownTx, err := pts.GetOrStartTransaction()
+    if err != nil {
+        return err
+    }
+    savescoreq := "INSERT INTO account_scores (userid, screen_name, tweets, score, scored_at, signals) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (userid) DO UPDATE SET screen_name = EXCLUDED.screen_name, tweets = EXCLUDED.tweets, score = EXCLUDED.score, scored_at = EXCLUDED.scored_at, signals = EXCLUDED.signals;"
+    _, err = tx.Exec(savescoreq, s.UserId, s.ScreenName, s.Tweets, s.Score, s.ScoredAt.Unix(), string(signals))
+    if err != nil {
+        fmt.Printf("Error saving account score: %s\n", err)
+    }
+    if ownTx {
+        if err != nil {
+            pts.RollbackTransaction()
+            return err
+        }
+        return pts.CommitTransaction()
+    }
+    return err
+}
+
+func (pts *PostgresTweetStore) AccountScores(minScore float64, limit int) ([]AccountScore, error) {
+    scoresq := "SELECT userid, screen_name, tweets, score, scored_at, signals FROM account_scores WHERE score >= $1 ORDER BY score DESC, userid ASC LIMIT $2;"
+    return scanAccountScores(pts.DB.Query(scoresq, minScore, limit))
+}
+
+func (pts *PostgresTweetStore) IntervalUserSnapshots(startTime time.Time, endTime time.Time) ([]UserSnapshot, error) {
+    return scanUserSnapshots(pts.DB.Query(userSnapshotsQuery("$1", "$2"), startTime.Unix(), endTime.Unix()))
+}
+
+func (pts *PostgresTweetStore) saveCluster(tx *sql.Tx, tweetid int64, sig neardup.Signature) error {
+    deletebandsq := "DELETE FROM tweet_lsh WHERE tweetid = $1;"
+    deleteclusterq := "DELETE FROM tweet_clusters WHERE tweetid = $1;"
+    insertclusterq := "INSERT INTO tweet_clusters (tweetid, clusterid, similarity, signature) VALUES ($1, $2, $3, $4) ON CONFLICT (tweetid) DO UPDATE SET clusterid = EXCLUDED.clusterid, similarity = EXCLUDED.similarity, signature = EXCLUDED.signature;"
+    insertbandq := "INSERT INTO tweet_lsh (band, tweetid) VALUES ($1, $2) ON CONFLICT DO NOTHING;"
+
+    _, err := tx.Exec(deletebandsq, tweetid)
+    if err != nil {
+        fmt.Printf("Error deleting tweet bands: %s\n", err)
+        return err
+    }
+    if sig == nil {
+        _, err = tx.Exec(deleteclusterq, tweetid)
+        if err != nil {
+            fmt.Printf("Error deleting tweet cluster: %s\n", err)
+        }
+        return err
+    }
+    bands := sig.Bands()
+    clusterid, similarity, err := nearestCluster(tx, clusterCandidatesQuery(func(i int) string { return "$" + strconv.Itoa(i) }), tweetid, sig, bands)
+    if err != nil {
+        fmt.Printf("Error finding tweet cluster: %s\n", err)
+        return err
+    }
+    _, err = tx.Exec(insertclusterq, tweetid, clusterid, similarity, sig.Bytes())
+    if err != nil {
+        fmt.Printf("Error inserting tweet cluster: %s\n", err)
+        return err
+    }
+    for _, band := range bands {
+        _, err = tx.Exec(insertbandq, band, tweetid)
+        if err != nil {
+            fmt.Printf("Error inserting tweet band: %s\n", err)
+            return err
+        }
+    }
+    return nil
+}
+
+func (pts *PostgresTweetStore) IntervalClusters(startTime time.Time, endTime time.Time, minSize int, limit int) ([]Cluster, error) {
+    return scanClusters(pts.DB.Query(intervalClustersQuery("$1", "$2", "$3", "$4"), startTime.Unix(), endTime.Unix(), minSize, limit))
+}
+
+func (pts *PostgresTweetStore) TweetMetrics(tweetid int64) []MetricsSnapshot {
+    metricsq := "SELECT tweetid, observed_at, retweet_count, favorite_count, reply_count, quote_count FROM tweet_metrics WHERE tweetid = $1 ORDER BY observed_at ASC;"
+    return queryMetrics(pts.DB.Query(metricsq, tweetid))
+}
+
+func (pts *PostgresTweetStore) RepollCandidates(createdAfter time.Time) []RepollCandidate {
+    candidatesq := "SELECT tweettimestamps.tweetid, tweettimestamps.timestamp, COALESCE(MAX(tweet_metrics.observed_at), 0) FROM tweettimestamps LEFT JOIN tweet_metrics ON tweettimestamps.tweetid = tweet_metrics.tweetid WHERE tweettimestamps.timestamp > $1 GROUP BY tweettimestamps.tweetid, tweettimestamps.timestamp;"
+    return queryRepollCandidates(pts.DB.Query(candidatesq, createdAfter.Unix()))
+}
+
+func (pts *PostgresTweetStore) SaveApiToken(t ApiToken) error {
+    inserttokenq := "INSERT INTO api_tokens (id, name, hash, scopes, created_at) VALUES ($1, $2, $3, $4, $5);"
+    _, err := pts.DB.Exec(inserttokenq, t.Id, t.Name, t.Hash, strings.Join(t.Scopes, " "), t.CreatedAt.Unix())
+    if err != nil {
+        fmt.Printf("Error inserting api token: %s\n", err)
+    }
+    return err
+}
+
+func (pts *PostgresTweetStore) ApiTokenByHash(hash string) (*ApiToken, error) {
+    tokenq := "SELECT id, name, hash, scopes, created_at, revoked_at FROM api_tokens WHERE hash = $1;"
+    t, err := scanApiToken(pts.DB.QueryRow(tokenq, hash).Scan)
+    if err == sql.ErrNoRows {
+        return nil, nil
+    }
+    return t, err
+}
+
+func (pts *PostgresTweetStore) RevokeApiToken(id string, at time.Time) (bool, error) {
+    revoketokenq := "UPDATE api_tokens SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL;"
+    res, err := pts.DB.Exec(revoketokenq, at.Unix(), id)
+    if err != nil {
+        fmt.Printf("Error revoking api token: %s\n", err)
+        return false, err
+    }
+    n, err := res.RowsAffected()
+    return n > 0, err
+}
+
+func (pts *PostgresTweetStore) ApiTokens() ([]ApiToken, error) {
+    tokensq := "SELECT id, name, hash, scopes, created_at, revoked_at FROM api_tokens ORDER BY created_at ASC;"
+    return queryApiTokens(pts.DB.Query(tokensq))
+}
+
+func (pts *PostgresTweetStore) QueryTweets(q TweetQuery) ([]*twittertypes.Tweet, error) {
+    where := make([]string, 0, 8)
+    args := make([]interface{}, 0, 8)
+    arg := func(v interface{}) string {
+        args = append(args, v)
+        return "$" + strconv.Itoa(len(args))
+    }
+
+    if q.SinceId != 0 {
+        where = append(where, "tweets.tweetid > "+arg(q.SinceId))
+    }
+    if q.MaxId != 0 {
+        where = append(where, "tweets.tweetid <= "+arg(q.MaxId))
+    }
+    if !q.From.IsZero() {
+        where = append(where, "tweets.time >= "+arg(q.From))
+    }
+    if !q.To.IsZero() {
+        where = append(where, "tweets.time < "+arg(q.To))
+    }
+    if q.ScreenName != "" {
+        where = append(where, "lower(tweets.screen_name) = lower("+arg(strings.TrimPrefix(q.ScreenName, "@"))+")")
+    }
+    if q.Hashtag != "" {
+        where = append(where, "tweets.tweetid IN (SELECT tweetid FROM hashtags WHERE lower(text) = lower("+arg(strings.TrimPrefix(q.Hashtag, "#"))+"))")
+    }
+    if q.Mention != "" {
+        where = append(where, "tweets.tweetid IN (SELECT tweetid FROM user_mentions WHERE lower(screen_name) = lower("+arg(strings.TrimPrefix(q.Mention, "@"))+"))")
+    }
+    if q.UrlDomain != "" {
+        where = append(where, "tweets.tweetid IN (SELECT tweetid FROM url_domains WHERE domain = "+arg(NormalizeDomain(q.UrlDomain))+")")
+    }
+    if q.InReplyTo != 0 {
+        where = append(where, "tweets.tweetid IN (SELECT tweetid FROM relations WHERE type = 'reply' AND target_tweetid = "+arg(q.InReplyTo)+")")
+    }
+    if q.ClusterId != 0 {
+        where = append(where, "tweets.tweetid IN (SELECT tweetid FROM tweet_clusters WHERE clusterid = "+arg(q.ClusterId)+")")
+    }
+    if q.Lang != "" {
+        where = append(where, "tweets.tweetid IN (SELECT tweetid FROM tweet_langs WHERE lang = "+arg(langid.Normalize(q.Lang))+")")
+    }
+    if q.MaxAccountScore > 0 {
+        where = append(where, "tweets.tweetid NOT IN (SELECT users.tweetid FROM users JOIN account_scores ON account_scores.userid = users.userid WHERE account_scores.score > "+arg(q.MaxAccountScore)+")")
+    }
+    if q.Text != "" {
+        words := SearchWords(q.Text)
+        if len(words) == 0 {
+            return []*twittertypes.Tweet{}, nil
+        }
+        where = append(where, "tweets.textsearch @@ plainto_tsquery('simple', "+arg(strings.Join(words, " "))+")")
+    }
+    if len(q.Track) > 0 {
+        phrases := make([]string, 0, len(q.Track))
+        for _, phrase := range q.Track {
+            phrases = append(phrases, "tweets.textsearch @@ plainto_tsquery('simple', "+arg(strings.Join(phrase, " "))+")")
+        }
+        where = append(where, "("+strings.Join(phrases, " OR ")+")")
+    }
+
+    query := "SELECT tweets.tweetid, tweets.fulltweet FROM tweets"
+    if len(where) > 0 {
+        query += " WHERE " + strings.Join(where, " AND ")
+    }
+    query += " ORDER BY tweets.tweetid " + q.order() + " LIMIT " + arg(q.limit()) + ";"
+
+    rows, err := pts.DB.Query(query, args...)
+    if err != nil {
+        fmt.Printf("Error querying tweets: %s\n", err)
+        return nil, err
+    }
+    return scanTweetRows(rows)
+}
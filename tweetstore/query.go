@@ -0,0 +1,204 @@
+package tweetstore
+
+import (
+    "fmt"
+    "github.com/fcheslack/tweetlog/langid"
+    "github.com/fcheslack/webtypes/twitter"
+    "net/url"
+    "strings"
+    "time"
+    "unicode"
+)
+
+const DefaultQueryLimit = 50
+const MaxQueryLimit = 200
+
+//TweetQuery filters archived tweets. Zero values are not used to filter.
+//Results are newest first unless Ascending; SinceId is exclusive and MaxId inclusive, as in the twitter API.
+type TweetQuery struct {
+    SinceId    int64
+    MaxId      int64
+    From       time.Time
+    To         time.Time
+    ScreenName string
+    Hashtag    string
+    Mention    string
+    UrlDomain  string
+    InReplyTo  int64  //tweet id replied to
+    ClusterId  int64  //near-duplicate cluster, the id of its first tweet
+    Lang       string //language code, as twitter gives it or as detected
+    Text       string //full text search for every one of its words, see SearchWords
+    Ascending  bool   //oldest first, to page forward from SinceId
+    Limit      int
+
+    MaxAccountScore float64    //leave out tweets of accounts with a stored score above this, 0 to keep all
+    Track           [][]string //keep only tweets with every word of any one of these phrases, as with twitter track terms
+}
+
+func (q *TweetQuery) limit() int {
+    if q.Limit <= 0 {
+        return DefaultQueryLimit
+    }
+    if q.Limit > MaxQueryLimit {
+        return MaxQueryLimit
+    }
+    return q.Limit
+}
+
+func (q *TweetQuery) order() string {
+    if q.Ascending {
+        return "ASC"
+    }
+    return "DESC"
+}
+
+//EachTweet calls fn with every tweet matching q, newest first, fetching a page of
+//q.Limit at a time so large ranges aren't held in memory. It stops early when fn returns false.
+func EachTweet(store TweetStore, q TweetQuery, fn func(*twittertypes.Tweet) bool) error {
+    q.Limit = q.limit()
+    q.Ascending = false
+    for {
+        tweets, err := store.QueryTweets(q)
+        if err != nil {
+            return err
+        }
+        for _, tweet := range tweets {
+            if !fn(tweet) {
+                return nil
+            }
+        }
+        if len(tweets) < q.Limit {
+            return nil
+        }
+        last := tweets[len(tweets)-1]
+        if last.Id == nil || int64(*last.Id) <= 1 {
+            return nil
+        }
+        q.MaxId = int64(*last.Id) - 1
+    }
+}
+
+//UrlDomain returns the normalized host of an expanded url, the key of the url_domains table
+func UrlDomain(expandedUrl string) string {
+    u, err := url.Parse(expandedUrl)
+    if err != nil {
+        return ""
+    }
+    return NormalizeDomain(u.Hostname())
+}
+
+func NormalizeDomain(domain string) string {
+    return strings.TrimPrefix(strings.ToLower(domain), "www.")
+}
+
+//likeEscaper escapes the LIKE wildcards, and the escape character itself, in literal text
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+//containsPattern is a LIKE pattern, for use with ESCAPE '\', matching text that contains s
+func containsPattern(s string) string {
+    return "%" + likeEscaper.Replace(s) + "%"
+}
+
+//matchWords is an fts MATCH expression for text containing every one of words, quoted so
+//words like OR and NEAR aren't read as operators
+func matchWords(words []string) string {
+    quoted := make([]string, 0, len(words))
+    for _, w := range words {
+        quoted = append(quoted, `"`+strings.Replace(w, `"`, `""`, -1)+`"`)
+    }
+    return strings.Join(quoted, " ")
+}
+
+//SearchWords splits full text search input into the words it matches, dropping punctuation
+//and operators the way postgres plainto_tsquery does, so both stores read it alike
+func SearchWords(text string) []string {
+    return strings.FieldsFunc(text, func(r rune) bool {
+        return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+    })
+}
+
+//QueryTweets returns the tweets matching q, newest first unless q.Ascending
+func (sts *SqliteTweetStore) QueryTweets(q TweetQuery) ([]*twittertypes.Tweet, error) {
+    where := make([]string, 0, 8)
+    args := make([]interface{}, 0, 8)
+
+    if q.SinceId != 0 {
+        where = append(where, "tweets.tweetid > ?")
+        args = append(args, q.SinceId)
+    }
+    if q.MaxId != 0 {
+        where = append(where, "tweets.tweetid <= ?")
+        args = append(args, q.MaxId)
+    }
+    //times are stored, and so compared, as UTC strings
+    if !q.From.IsZero() {
+        where = append(where, "tweets.time >= ?")
+        args = append(args, q.From.UTC())
+    }
+    if !q.To.IsZero() {
+        where = append(where, "tweets.time < ?")
+        args = append(args, q.To.UTC())
+    }
+    if q.ScreenName != "" {
+        where = append(where, "tweets.screen_name = ? COLLATE NOCASE")
+        args = append(args, strings.TrimPrefix(q.ScreenName, "@"))
+    }
+    if q.Hashtag != "" {
+        where = append(where, "tweets.tweetid IN (SELECT tweetid FROM hashtags WHERE text = ? COLLATE NOCASE)")
+        args = append(args, strings.TrimPrefix(q.Hashtag, "#"))
+    }
+    if q.Mention != "" {
+        where = append(where, "tweets.tweetid IN (SELECT tweetid FROM user_mentions WHERE screen_name = ? COLLATE NOCASE)")
+        args = append(args, strings.TrimPrefix(q.Mention, "@"))
+    }
+    if q.UrlDomain != "" {
+        where = append(where, "tweets.tweetid IN (SELECT tweetid FROM url_domains WHERE domain = ?)")
+        args = append(args, NormalizeDomain(q.UrlDomain))
+    }
+    if q.InReplyTo != 0 {
+        where = append(where, "tweets.tweetid IN (SELECT tweetid FROM relations WHERE type = 'reply' AND target_tweetid = ?)")
+        args = append(args, q.InReplyTo)
+    }
+    if q.ClusterId != 0 {
+        where = append(where, "tweets.tweetid IN (SELECT tweetid FROM tweet_clusters WHERE clusterid = ?)")
+        args = append(args, q.ClusterId)
+    }
+    if q.Lang != "" {
+        where = append(where, "tweets.tweetid IN (SELECT tweetid FROM tweet_langs WHERE lang = ?)")
+        args = append(args, langid.Normalize(q.Lang))
+    }
+    if q.MaxAccountScore > 0 {
+        where = append(where, "tweets.tweetid NOT IN (SELECT users.tweetid FROM users JOIN account_scores ON account_scores.userid = users.userid WHERE account_scores.score > ?)")
+        args = append(args, q.MaxAccountScore)
+    }
+    if q.Text != "" {
+        words := SearchWords(q.Text)
+        if len(words) == 0 {
+            return []*twittertypes.Tweet{}, nil
+        }
+        where = append(where, "tweets.tweetid IN (SELECT docid FROM tweetsearch WHERE tweettext MATCH ?)")
+        args = append(args, matchWords(words))
+    }
+    if len(q.Track) > 0 {
+        phrases := make([]string, 0, len(q.Track))
+        for _, phrase := range q.Track {
+            phrases = append(phrases, "tweets.tweetid IN (SELECT docid FROM tweetsearch WHERE tweettext MATCH ?)")
+            args = append(args, matchWords(phrase))
+        }
+        where = append(where, "("+strings.Join(phrases, " OR ")+")")
+    }
+
+    query := "SELECT tweets.tweetid, tweets.fulltweet FROM tweets"
+    if len(where) > 0 {
+        query += " WHERE " + strings.Join(where, " AND ")
+    }
+    query += " ORDER BY tweets.tweetid " + q.order() + " LIMIT ?;"
+    args = append(args, q.limit())
+
+    rows, err := sts.DB.Query(query, args...)
+    if err != nil {
+        fmt.Printf("Error querying tweets: %s\n", err)
+        return nil, err
+    }
+    return scanTweetRows(rows)
+}
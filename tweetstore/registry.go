@@ -0,0 +1,57 @@
+package tweetstore
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "sync"
+)
+
+//OpenFunc opens a TweetStore for a DSN and creates its schema if needed
+type OpenFunc func(dsn string) (TweetStore, error)
+
+var (
+    backendsMu sync.Mutex
+    backends   = make(map[string]OpenFunc)
+)
+
+//Register makes a backend available to Open for DSNs starting with scheme://
+func Register(scheme string, open OpenFunc) {
+    backendsMu.Lock()
+    defer backendsMu.Unlock()
+    if open == nil {
+        panic("tweetstore: Register open func is nil")
+    }
+    if _, dup := backends[scheme]; dup {
+        panic("tweetstore: Register called twice for backend " + scheme)
+    }
+    backends[scheme] = open
+}
+
+//Backends returns the sorted list of registered DSN schemes
+func Backends() []string {
+    backendsMu.Lock()
+    defer backendsMu.Unlock()
+    schemes := make([]string, 0, len(backends))
+    for scheme := range backends {
+        schemes = append(schemes, scheme)
+    }
+    sort.Strings(schemes)
+    return schemes
+}
+
+//Open the TweetStore for dsn. The backend is picked by the DSN scheme;
+//a DSN with no scheme is treated as the path to a SQLite file.
+func Open(dsn string) (TweetStore, error) {
+    scheme := "sqlite3"
+    if i := strings.Index(dsn, "://"); i > 0 {
+        scheme = dsn[:i]
+    }
+    backendsMu.Lock()
+    open, ok := backends[scheme]
+    backendsMu.Unlock()
+    if !ok {
+        return nil, fmt.Errorf("tweetstore: unknown backend %q (registered: %s)", scheme, strings.Join(Backends(), ", "))
+    }
+    return open(dsn)
+}
@@ -0,0 +1,104 @@
+package tweetstore
+
+import (
+    "database/sql"
+    "fmt"
+    "time"
+)
+
+//SentimentThreshold is the compound score beyond which a tweet counts as positive or negative
+const SentimentThreshold = 0.05
+
+//Sentiment is the tone of a text. Compound runs from -1, most negative, to 1, most
+//positive; Positive, Negative and Neutral are the shares of the text of each tone.
+type Sentiment struct {
+    Compound float64 `json:"compound"`
+    Positive float64 `json:"positive"`
+    Negative float64 `json:"negative"`
+    Neutral  float64 `json:"neutral"`
+}
+
+//TweetSentiment is a tweet's stored sentiment and the name of the scorer that rated it
+type TweetSentiment struct {
+    TweetId  int64
+    Scorer   string
+    ScoredAt time.Time
+    Sentiment
+}
+
+//SentimentSummary is the sentiment of the scored tweets of a period
+type SentimentSummary struct {
+    Scored   int     `json:"scored"`
+    Mean     float64 `json:"mean"` //mean compound score
+    Positive int     `json:"positive"`
+    Negative int     `json:"negative"`
+    Neutral  int     `json:"neutral"`
+}
+
+//sentimentSummaryQuery summarises tweet_sentiment for tweets created in [start, end) and
+//passing filter, if set, a further condition on tweettimestamps
+func sentimentSummaryQuery(start, end string, filter string) string {
+    if filter != "" {
+        filter = " AND " + filter
+    }
+    return fmt.Sprintf("SELECT COUNT(*), COALESCE(AVG(tweet_sentiment.compound), 0), "+
+        "COALESCE(SUM(CASE WHEN tweet_sentiment.compound >= %[3]g THEN 1 ELSE 0 END), 0), "+
+        "COALESCE(SUM(CASE WHEN tweet_sentiment.compound <= -%[3]g THEN 1 ELSE 0 END), 0) "+
+        "FROM tweettimestamps JOIN tweet_sentiment ON tweet_sentiment.tweetid = tweettimestamps.tweetid "+
+        "WHERE tweettimestamps.timestamp >= %[1]s AND tweettimestamps.timestamp < %[2]s%[4]s;", start, end, SentimentThreshold, filter)
+}
+
+func scanSentimentSummary(row *sql.Row) (SentimentSummary, error) {
+    var s SentimentSummary
+    err := row.Scan(&s.Scored, &s.Mean, &s.Positive, &s.Negative)
+    if err != nil {
+        fmt.Printf("Error getting sentiment summary: %s\n", err)
+        return s, err
+    }
+    s.Neutral = s.Scored - s.Positive - s.Negative
+    return s, nil
+}
+
+func scanTweetSentiment(row *sql.Row) (*TweetSentiment, error) {
+    var s TweetSentiment
+    var scoredAt int64
+    err := row.Scan(&s.TweetId, &s.Scorer, &scoredAt, &s.Compound, &s.Positive, &s.Negative, &s.Neutral)
+    if err == sql.ErrNoRows {
+        return nil, nil
+    }
+    if err != nil {
+        fmt.Printf("Error getting tweet sentiment: %s\n", err)
+        return nil, err
+    }
+    s.ScoredAt = time.Unix(scoredAt, 0).UTC()
+    return &s, nil
+}
+
+//SaveSentiment records a tweet's sentiment, replacing any earlier score
+func (sts *SqliteTweetStore) SaveSentiment(s TweetSentiment) error {
+    tx, ownTx := sts.GetOrStartTransaction()
+    savesentimentq := "INSERT OR REPLACE INTO tweet_sentiment (tweetid, scorer, scored_at, compound, positive, negative, neutral) VALUES (?, ?, ?, ?, ?, ?, ?);"
+    _, err := tx.Exec(savesentimentq, s.TweetId, s.Scorer, s.ScoredAt.Unix(), s.Compound, s.Positive, s.Negative, s.Neutral)
+    if err != nil {
+        fmt.Printf("Error saving tweet sentiment: %s\n", err)
+    }
+    if ownTx {
+        err := sts.CommitTransaction()
+        if err != nil {
+            fmt.Printf("Error commiting saveSentiment TX: %s\n", err)
+            return err
+        }
+    }
+    return err
+}
+
+//Get a tweet's stored sentiment, nil if it has not been scored
+func (sts *SqliteTweetStore) TweetSentiment(tweetid int64) (*TweetSentiment, error) {
+    sentimentq := "SELECT tweetid, scorer, scored_at, compound, positive, negative, neutral FROM tweet_sentiment WHERE tweetid = ?;"
+    return scanTweetSentiment(sts.DB.QueryRow(sentimentq, tweetid))
+}
+
+//Summarise the sentiment of the scored tweets created between startTime and endTime
+func (sts *SqliteTweetStore) IntervalSentiment(startTime time.Time, endTime time.Time) (SentimentSummary, error) {
+    return scanSentimentSummary(sts.DB.QueryRow(sentimentSummaryQuery("?", "?", ""), startTime.Unix(), endTime.Unix()))
+}
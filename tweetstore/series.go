@@ -0,0 +1,301 @@
+package tweetstore
+
+import (
+    "database/sql"
+    "fmt"
+    "html"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+//Series intervals
+const (
+    IntervalMinute = "minute"
+    IntervalHour   = "hour"
+    IntervalDay    = "day"
+    IntervalWeek   = "week"
+)
+
+//Series groupings. The zero value counts all tweets as one group.
+const (
+    GroupNone    = ""
+    GroupHashtag = "hashtag"
+    GroupUser    = "user"
+    GroupTerm    = "term"
+    GroupSource  = "source"
+    GroupLang    = "lang"
+    GroupCluster = "cluster"
+)
+
+const MaxSeriesBuckets = 2000
+const DefaultSeriesGroups = 10
+
+//SeriesQuery asks for tweet counts between Start and End in Interval sized buckets,
+//aligned to Interval boundaries in Location (weeks start on Monday).
+type SeriesQuery struct {
+    Start     time.Time
+    End       time.Time
+    Interval  string
+    Location  *time.Location //default UTC
+    GroupBy   string
+    Terms     []string //the track terms counted by GroupTerm; a tweet counts towards each term in its text
+    Clusters  []int64  //the clusters counted by GroupCluster, all when empty
+    Groups    int      //keep only the most frequent groups, default DefaultSeriesGroups
+    Sentiment bool     //also average the stored sentiment of each bucket
+}
+
+//SeriesGroup is one group's count in each bucket of a Series
+type SeriesGroup struct {
+    Key       string     `json:"key"`
+    Total     int        `json:"total"`
+    Counts    []int      `json:"counts"`
+    Sentiment []*float64 `json:"sentiment,omitempty"` //mean compound score, nil for buckets with no scored tweets
+}
+
+//Series is tweet volume in aligned buckets, one row of counts per group, largest group first
+type Series struct {
+    Interval string        `json:"interval"`
+    Location string        `json:"location"`
+    GroupBy  string        `json:"group_by,omitempty"`
+    Starts   []time.Time   `json:"starts"`
+    Groups   []SeriesGroup `json:"groups"`
+}
+
+//alignInterval returns the start of the bucket containing t
+func alignInterval(t time.Time, interval string) time.Time {
+    y, m, d := t.Date()
+    switch interval {
+    case IntervalMinute:
+        return time.Date(y, m, d, t.Hour(), t.Minute(), 0, 0, t.Location())
+    case IntervalHour:
+        return time.Date(y, m, d, t.Hour(), 0, 0, 0, t.Location())
+    case IntervalDay:
+        return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+    default:
+        return time.Date(y, m, d-(int(t.Weekday())+6)%7, 0, 0, 0, 0, t.Location())
+    }
+}
+
+//nextInterval returns the start of the bucket after the one starting at t. Minutes and
+//hours step in absolute time, so the hour repeated when clocks go back is two buckets.
+//Days and weeks are calendar days in t's location, so they stay aligned across DST changes.
+func nextInterval(t time.Time, interval string) time.Time {
+    switch interval {
+    case IntervalMinute:
+        return t.Add(time.Minute)
+    case IntervalHour:
+        return t.Add(time.Hour)
+    case IntervalDay:
+        return t.AddDate(0, 0, 1)
+    default:
+        return t.AddDate(0, 0, 7)
+    }
+}
+
+//seriesBoundaries returns the bucket starts covering q, plus the end of the last bucket
+func seriesBoundaries(q *SeriesQuery) ([]time.Time, error) {
+    switch q.Interval {
+    case IntervalMinute, IntervalHour, IntervalDay, IntervalWeek:
+    default:
+        return nil, fmt.Errorf("invalid interval %q, use minute, hour, day or week", q.Interval)
+    }
+    if q.Location == nil {
+        q.Location = time.UTC
+    }
+    if !q.End.After(q.Start) {
+        return nil, fmt.Errorf("series end must be after start")
+    }
+    bounds := []time.Time{alignInterval(q.Start.In(q.Location), q.Interval)}
+    for bounds[len(bounds)-1].Before(q.End) {
+        if len(bounds) > MaxSeriesBuckets {
+            return nil, fmt.Errorf("too many buckets, at most %d %ss", MaxSeriesBuckets, q.Interval)
+        }
+        bounds = append(bounds, nextInterval(bounds[len(bounds)-1], q.Interval))
+    }
+    return bounds, nil
+}
+
+//seriesQuery builds the single query counting tweets per bucket and group. Bucket
+//bounds and cluster ids are written into the query as integers; only the terms are
+//parameters, named by the backend's placeholder func.
+func seriesQuery(q *SeriesQuery, bounds []time.Time, placeholder func(int) string) (string, []interface{}, error) {
+    var b strings.Builder
+    b.WriteString("WITH buckets(i, lo, hi) AS (VALUES ")
+    for i := 0; i < len(bounds)-1; i++ {
+        if i > 0 {
+            b.WriteString(", ")
+        }
+        fmt.Fprintf(&b, "(%d, %d, %d)", i, bounds[i].Unix(), bounds[i+1].Unix())
+    }
+    b.WriteString(")")
+
+    var args []interface{}
+    var group, join string
+    switch q.GroupBy {
+    case GroupNone:
+        group = "''"
+    case GroupHashtag:
+        group = "lower(hashtags.text)"
+        join = "JOIN hashtags ON hashtags.tweetid = tweettimestamps.tweetid"
+    case GroupUser:
+        group = "tweets.screen_name"
+        join = "JOIN tweets ON tweets.tweetid = tweettimestamps.tweetid"
+    case GroupSource:
+        group = "normtweets.source"
+        join = "JOIN normtweets ON normtweets.tweetid = tweettimestamps.tweetid"
+    case GroupLang:
+        //tweets saved before languages were recorded count as undetermined until reprocessed
+        group = "COALESCE(tweet_langs.lang, 'und')"
+        join = "LEFT JOIN tweet_langs ON tweet_langs.tweetid = tweettimestamps.tweetid"
+    case GroupCluster:
+        //only tweets with a signature are clustered; a cluster's key is the id of its first tweet
+        group = "CAST(tweet_clusters.clusterid AS TEXT)"
+        join = "JOIN tweet_clusters ON tweet_clusters.tweetid = tweettimestamps.tweetid"
+        if len(q.Clusters) > 0 {
+            ids := make([]string, len(q.Clusters))
+            for i, id := range q.Clusters {
+                ids[i] = strconv.FormatInt(id, 10)
+            }
+            join += " AND tweet_clusters.clusterid IN (" + strings.Join(ids, ", ") + ")"
+        }
+    case GroupTerm:
+        if len(q.Terms) == 0 {
+            return "", nil, fmt.Errorf("grouping by term needs track terms")
+        }
+        //each term is kept as the group key beside its escaped LIKE pattern
+        b.WriteString(", terms(term, pattern) AS (VALUES ")
+        for i, term := range q.Terms {
+            if i > 0 {
+                b.WriteString(", ")
+            }
+            term = strings.ToLower(term)
+            args = append(args, term, containsPattern(term))
+            b.WriteString("(" + placeholder(len(args)-1) + ", " + placeholder(len(args)) + ")")
+        }
+        b.WriteString(")")
+        group = "terms.term"
+        join = "JOIN tweets ON tweets.tweetid = tweettimestamps.tweetid JOIN terms ON lower(tweets.text) LIKE terms.pattern ESCAPE '\\'"
+    default:
+        return "", nil, fmt.Errorf("invalid grouping %q, use hashtag, user, term, source, lang or cluster", q.GroupBy)
+    }
+    sentiment := ""
+    if q.Sentiment {
+        sentiment = ", COUNT(tweet_sentiment.compound), COALESCE(SUM(tweet_sentiment.compound), 0)"
+        join += " LEFT JOIN tweet_sentiment ON tweet_sentiment.tweetid = tweettimestamps.tweetid"
+    }
+    groupBy := "buckets.i"
+    if q.GroupBy != GroupNone {
+        //postgres refuses a constant in GROUP BY
+        groupBy += ", " + group
+    }
+    fmt.Fprintf(&b, " SELECT buckets.i, %s, COUNT(*)%s FROM buckets JOIN tweettimestamps ON tweettimestamps.timestamp >= buckets.lo AND tweettimestamps.timestamp < buckets.hi %s GROUP BY %s;", group, sentiment, join, groupBy)
+    return b.String(), args, nil
+}
+
+var tagPattern = regexp.MustCompile(`<[^>]*>`)
+
+//SourceName reduces a source, an html link to the client, to the client's name
+func SourceName(source string) string {
+    return strings.TrimSpace(html.UnescapeString(tagPattern.ReplaceAllString(source, "")))
+}
+
+//scanSeries collects (bucket, group, count) rows into a Series of the largest groups
+func scanSeries(q *SeriesQuery, bounds []time.Time, rows *sql.Rows, err error) (*Series, error) {
+    if err != nil {
+        fmt.Printf("Error getting tweet series: %s\n", err)
+        return nil, err
+    }
+    defer rows.Close()
+    n := len(bounds) - 1
+    groups := make(map[string]*SeriesGroup)
+    //sentiment sums and scored counts per group, merged before the means are taken
+    sums := make(map[string][]float64)
+    scored := make(map[string][]int)
+    for rows.Next() {
+        var i int
+        var key sql.NullString
+        var count, scoredCount int
+        var sum float64
+        dest := []interface{}{&i, &key, &count}
+        if q.Sentiment {
+            dest = append(dest, &scoredCount, &sum)
+        }
+        err = rows.Scan(dest...)
+        if err != nil {
+            fmt.Printf("Error scanning tweet series row: %s\n", err)
+            return nil, err
+        }
+        k := key.String
+        if q.GroupBy == GroupSource {
+            k = SourceName(k)
+        }
+        g := groups[k]
+        if g == nil {
+            g = &SeriesGroup{Key: k, Counts: make([]int, n)}
+            groups[k] = g
+            sums[k] = make([]float64, n)
+            scored[k] = make([]int, n)
+        }
+        if i >= 0 && i < n {
+            g.Counts[i] += count
+            g.Total += count
+            sums[k][i] += sum
+            scored[k][i] += scoredCount
+        }
+    }
+    if err = rows.Err(); err != nil {
+        return nil, err
+    }
+
+    s := &Series{Interval: q.Interval, Location: q.Location.String(), GroupBy: q.GroupBy, Starts: bounds[:n], Groups: []SeriesGroup{}}
+    for k, g := range groups {
+        if q.Sentiment {
+            g.Sentiment = make([]*float64, n)
+            for i := range g.Sentiment {
+                if scored[k][i] > 0 {
+                    mean := sums[k][i] / float64(scored[k][i])
+                    g.Sentiment[i] = &mean
+                }
+            }
+        }
+        s.Groups = append(s.Groups, *g)
+    }
+    sort.Slice(s.Groups, func(i, j int) bool {
+        if s.Groups[i].Total != s.Groups[j].Total {
+            return s.Groups[i].Total > s.Groups[j].Total
+        }
+        return s.Groups[i].Key < s.Groups[j].Key
+    })
+    limit := q.Groups
+    if limit <= 0 {
+        limit = DefaultSeriesGroups
+    }
+    if len(s.Groups) > limit {
+        s.Groups = s.Groups[:limit]
+    }
+    if q.GroupBy == GroupNone && len(s.Groups) == 0 {
+        g := SeriesGroup{Counts: make([]int, n)}
+        if q.Sentiment {
+            g.Sentiment = make([]*float64, n)
+        }
+        s.Groups = append(s.Groups, g)
+    }
+    return s, nil
+}
+
+//TweetSeries counts tweets created between q.Start and q.End per aligned bucket and group, in one query
+func (sts *SqliteTweetStore) TweetSeries(q SeriesQuery) (*Series, error) {
+    bounds, err := seriesBoundaries(&q)
+    if err != nil {
+        return nil, err
+    }
+    seriesq, args, err := seriesQuery(&q, bounds, func(int) string { return "?" })
+    if err != nil {
+        return nil, err
+    }
+    rows, err := sts.DB.Query(seriesq, args...)
+    return scanSeries(&q, bounds, rows, err)
+}
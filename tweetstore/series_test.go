@@ -0,0 +1,102 @@
+package tweetstore
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/fcheslack/webtypes/twitter"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+//hour buckets must get through the repeated and the skipped hour of DST changes
+func TestSeriesBoundariesDST(t *testing.T) {
+    ny, err := time.LoadLocation("America/New_York")
+    if err != nil {
+        t.Skipf("no zone data: %s", err)
+    }
+    for _, c := range []struct {
+        day   time.Time
+        hours []int //local hour of each bucket start
+    }{
+        //clocks go back at 2am, so 1am comes twice
+        {time.Date(2026, 11, 1, 0, 0, 0, 0, ny), []int{0, 1, 1, 2, 3}},
+        //clocks go forward at 2am, so there is no 2am
+        {time.Date(2026, 3, 8, 0, 0, 0, 0, ny), []int{0, 1, 3, 4, 5}},
+    } {
+        q := &SeriesQuery{Start: c.day, End: c.day.Add(5 * time.Hour), Interval: IntervalHour, Location: ny}
+        bounds, err := seriesBoundaries(q)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(bounds) != len(c.hours)+1 {
+            t.Fatalf("%s: %d bounds %v, want %d", c.day, len(bounds), bounds, len(c.hours)+1)
+        }
+        for i, h := range c.hours {
+            if bounds[i].Hour() != h {
+                t.Errorf("%s: bucket %d starts %s, want hour %d", c.day, i, bounds[i], h)
+            }
+            if bounds[i+1].Sub(bounds[i]) != time.Hour {
+                t.Errorf("%s: bucket %d is %s long", c.day, i, bounds[i+1].Sub(bounds[i]))
+            }
+        }
+
+        //days stay at local midnight either side of the change
+        q = &SeriesQuery{Start: c.day.AddDate(0, 0, -1), End: c.day.AddDate(0, 0, 2), Interval: IntervalDay, Location: ny}
+        bounds, err = seriesBoundaries(q)
+        if err != nil {
+            t.Fatal(err)
+        }
+        for _, b := range bounds {
+            if b.Hour() != 0 || b.Minute() != 0 {
+                t.Errorf("%s: day bucket starts at %s", c.day, b)
+            }
+        }
+    }
+}
+
+//LIKE wildcards in track terms must match only themselves
+func TestSeriesGroupTermEscapesWildcards(t *testing.T) {
+    store, err := Open("sqlite3://" + filepath.Join(t.TempDir(), "series.db"))
+    if err != nil {
+        t.Fatalf("opening sqlite store: %s", err)
+    }
+    defer store.Close()
+
+    now := time.Now().UTC().Truncate(time.Minute)
+    texts := []string{"50% off today", "500 off today", "use a_b here", "use axb here", `back\slash`}
+    for i, text := range texts {
+        line := fmt.Sprintf(`{"id":%d,"id_str":"%d","text":%q,"created_at":%q,"source":"test","user":{"id":1,"id_str":"1","screen_name":"seriesuser"},"entities":{"hashtags":[],"urls":[],"user_mentions":[],"media":[]}}`,
+            100+i, 100+i, text, now.Add(-time.Duration(i+1)*time.Minute).Format(time.RubyDate))
+        tweet := &twittertypes.Tweet{}
+        err = json.Unmarshal([]byte(line), tweet)
+        if err != nil {
+            t.Fatal(err)
+        }
+        tweet.RawBytes = []byte(line)
+        err = store.SaveTweet(tweet)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    series, err := store.TweetSeries(SeriesQuery{
+        Start:    now.Add(-time.Hour),
+        End:      now.Add(time.Hour),
+        Interval: IntervalDay,
+        GroupBy:  GroupTerm,
+        Terms:    []string{"50%", "A_B", `k\s`},
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    totals := make(map[string]int)
+    for _, g := range series.Groups {
+        totals[g.Key] = g.Total
+    }
+    for term, want := range map[string]int{"50%": 1, "a_b": 1, `k\s`: 1} {
+        if totals[term] != want {
+            t.Errorf("term %q counted %d tweets, want %d (all groups %v)", term, totals[term], want, totals)
+        }
+    }
+}
@@ -0,0 +1,167 @@
+package tweetstore
+
+import (
+    "crypto/rand"
+    "crypto/sha256"
+    "database/sql"
+    "encoding/base64"
+    "encoding/hex"
+    "fmt"
+    "strings"
+    "time"
+)
+
+//API token scopes. Public search tweets are those matching the archive's tracked
+//terms; the home timeline scope covers everything in the archive.
+const (
+    ScopePublicSearches = "read-public-searches"
+    ScopeHomeTimeline   = "read-home-timeline"
+)
+
+var KnownScopes = []string{ScopePublicSearches, ScopeHomeTimeline}
+
+//ApiToken is an issued API token. Only a hash of the secret is stored;
+//Id is not secret and is what the token is listed and revoked by.
+type ApiToken struct {
+    Id        string
+    Name      string
+    Hash      string
+    Scopes    []string
+    CreatedAt time.Time
+    RevokedAt time.Time //zero while the token is valid
+}
+
+func (t *ApiToken) HasScope(scope string) bool {
+    for _, s := range t.Scopes {
+        if s == scope {
+            return true
+        }
+    }
+    return false
+}
+
+func (t *ApiToken) Revoked() bool {
+    return !t.RevokedAt.IsZero()
+}
+
+//HashApiToken is how a presented token is looked up. Tokens are random, so an unsalted hash is enough.
+func HashApiToken(secret string) string {
+    sum := sha256.Sum256([]byte(secret))
+    return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+    b := make([]byte, n)
+    _, err := rand.Read(b)
+    if err != nil {
+        return "", err
+    }
+    return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+//NewApiToken makes a token with the given scopes. The returned secret is
+//what clients present; it is not stored and can't be recovered later.
+func NewApiToken(name string, scopes []string) (string, ApiToken, error) {
+    var t ApiToken
+    for _, scope := range scopes {
+        known := false
+        for _, k := range KnownScopes {
+            known = known || scope == k
+        }
+        if !known {
+            return "", t, fmt.Errorf("unknown scope %q, use %s", scope, strings.Join(KnownScopes, " or "))
+        }
+    }
+    if len(scopes) == 0 {
+        return "", t, fmt.Errorf("a token needs at least one scope")
+    }
+    id, err := randomString(6)
+    if err != nil {
+        return "", t, err
+    }
+    key, err := randomString(32)
+    if err != nil {
+        return "", t, err
+    }
+    secret := "tl_" + id + "_" + key
+    t = ApiToken{
+        Id:        id,
+        Name:      name,
+        Hash:      HashApiToken(secret),
+        Scopes:    scopes,
+        CreatedAt: time.Now().UTC(),
+    }
+    return secret, t, nil
+}
+
+func scanApiToken(scan func(...interface{}) error) (*ApiToken, error) {
+    var t ApiToken
+    var scopes string
+    var createdAt int64
+    var revokedAt sql.NullInt64
+    err := scan(&t.Id, &t.Name, &t.Hash, &scopes, &createdAt, &revokedAt)
+    if err != nil {
+        return nil, err
+    }
+    t.Scopes = strings.Fields(scopes)
+    t.CreatedAt = time.Unix(createdAt, 0).UTC()
+    if revokedAt.Valid {
+        t.RevokedAt = time.Unix(revokedAt.Int64, 0).UTC()
+    }
+    return &t, nil
+}
+
+func queryApiTokens(rows *sql.Rows, err error) ([]ApiToken, error) {
+    if err != nil {
+        fmt.Printf("Error getting api tokens: %s\n", err)
+        return nil, err
+    }
+    defer rows.Close()
+    tokens := make([]ApiToken, 0, 10)
+    for rows.Next() {
+        t, err := scanApiToken(rows.Scan)
+        if err != nil {
+            fmt.Printf("Error scanning api token row: %s\n", err)
+            return nil, err
+        }
+        tokens = append(tokens, *t)
+    }
+    return tokens, rows.Err()
+}
+
+func (sts *SqliteTweetStore) SaveApiToken(t ApiToken) error {
+    inserttokenq := "INSERT INTO api_tokens (id, name, hash, scopes, created_at) VALUES (?, ?, ?, ?, ?);"
+    _, err := sts.DB.Exec(inserttokenq, t.Id, t.Name, t.Hash, strings.Join(t.Scopes, " "), t.CreatedAt.Unix())
+    if err != nil {
+        fmt.Printf("Error inserting api token: %s\n", err)
+    }
+    return err
+}
+
+//ApiTokenByHash returns the token with the given hash, revoked or not, or nil if there is none
+func (sts *SqliteTweetStore) ApiTokenByHash(hash string) (*ApiToken, error) {
+    tokenq := "SELECT id, name, hash, scopes, created_at, revoked_at FROM api_tokens WHERE hash = ?;"
+    t, err := scanApiToken(sts.DB.QueryRow(tokenq, hash).Scan)
+    if err == sql.ErrNoRows {
+        return nil, nil
+    }
+    return t, err
+}
+
+//RevokeApiToken marks a token revoked, reporting false if there is no such unrevoked token
+func (sts *SqliteTweetStore) RevokeApiToken(id string, at time.Time) (bool, error) {
+    revoketokenq := "UPDATE api_tokens SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL;"
+    res, err := sts.DB.Exec(revoketokenq, at.Unix(), id)
+    if err != nil {
+        fmt.Printf("Error revoking api token: %s\n", err)
+        return false, err
+    }
+    n, err := res.RowsAffected()
+    return n > 0, err
+}
+
+//ApiTokens lists every issued token, oldest first
+func (sts *SqliteTweetStore) ApiTokens() ([]ApiToken, error) {
+    tokensq := "SELECT id, name, hash, scopes, created_at, revoked_at FROM api_tokens ORDER BY created_at ASC;"
+    return queryApiTokens(sts.DB.Query(tokensq))
+}
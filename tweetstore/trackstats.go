@@ -0,0 +1,75 @@
+package tweetstore
+
+import (
+    "fmt"
+    "strings"
+    "time"
+)
+
+//TrackStatsQuery selects the tweets IntervalTrackStats counts: those created in [Start, End)
+//with every word of any one of the Track phrases, matched as TweetQuery.Track matches them
+type TrackStatsQuery struct {
+    Start   time.Time
+    End     time.Time
+    Track   [][]string
+    Bucket  time.Duration //length of the volume buckets, counted back from End
+    Buckets int
+    Top     int //how many of each TopKind
+}
+
+//TrackStats is the IntervalTop, IntervalTweetCountBefore and IntervalSentiment of the
+//tweets a TrackStatsQuery selects
+type TrackStats struct {
+    Top       map[TopKind][]KeyCount
+    Volume    []int //newest bucket first
+    Sentiment SentimentSummary
+}
+
+//trackFilter is a condition on tweettimestamps keeping the tweets that match any of
+//track's phrases. phrase gives the condition for one phrase.
+func trackFilter(track [][]string, phrase func([]string) string) string {
+    conds := make([]string, 0, len(track))
+    for _, p := range track {
+        conds = append(conds, phrase(p))
+    }
+    return "(" + strings.Join(conds, " OR ") + ")"
+}
+
+//Count the tweets of the track phrases in the store, for readers limited to them
+func (sts *SqliteTweetStore) IntervalTrackStats(q TrackStatsQuery) (*TrackStats, error) {
+    if len(q.Track) == 0 {
+        return nil, fmt.Errorf("no track phrases to count the tweets of")
+    }
+    //every query takes the phrases after its own leading arguments
+    phrases := make([]interface{}, 0, len(q.Track))
+    filter := trackFilter(q.Track, func(phrase []string) string {
+        phrases = append(phrases, matchWords(phrase))
+        return "tweettimestamps.tweetid IN (SELECT docid FROM tweetsearch WHERE tweettext MATCH ?)"
+    })
+    withPhrases := func(args ...interface{}) []interface{} {
+        return append(args, phrases...)
+    }
+    start := q.Start.Unix()
+    end := q.End.Unix()
+
+    stats := &TrackStats{Top: make(map[TopKind][]KeyCount, len(topColumns))}
+    for kind := range topColumns {
+        topq, err := topQuery(kind, "?", "?", "?", filter)
+        if err != nil {
+            return nil, err
+        }
+        //the limit comes after the filter
+        stats.Top[kind] = scanKeyCounts(sts.DB.Query(topq, append(withPhrases(start, end), q.Top)...))
+    }
+
+    secs := intervalSeconds(q.Bucket)
+    intervalq := "SELECT (? - timestamp) / ? AS bucket, COUNT(tweetid) FROM tweettimestamps WHERE timestamp > ? AND timestamp <= ? AND " + filter + " GROUP BY bucket;"
+    rows, err := sts.DB.Query(intervalq, withPhrases(end, secs, end-secs*int64(q.Buckets), end)...)
+    stats.Volume = intervalBuckets(rows, err, q.Buckets)
+
+    stats.Sentiment, err = scanSentimentSummary(sts.DB.QueryRow(sentimentSummaryQuery("?", "?", filter), withPhrases(start, end)...))
+    if err != nil {
+        return nil, err
+    }
+    return stats, nil
+}
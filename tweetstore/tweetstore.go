@@ -6,36 +6,90 @@ import (
     "fmt"
     "github.com/fcheslack/webtypes/twitter"
     _ "github.com/mattn/go-sqlite3"
+    "strings"
     "time"
 )
 
+//TweetStore is implemented by every storage backend. Backends register themselves
+//with Register and are selected by DSN with Open.
 type TweetStore interface {
-    BeginTransaction()
-    CommitTransaction()
-    RollbackTransaction()
+    BeginTransaction() error
+    CommitTransaction() error
+    RollbackTransaction() error
     SaveTweet(*twittertypes.Tweet) error
+    SaveTweets([]*twittertypes.Tweet) error
+    SaveEntities(*twittertypes.Tweet) error
+    SaveDerived(*twittertypes.Tweet) error
+    SaveEvent(*twittertypes.Event) error
+    LatestTweetId() int64
+    RecentTweets(int) []*twittertypes.Tweet
+    TweetsAfterId(int64) []*twittertypes.Tweet
+    IntervalUrls(time.Time, time.Time) []*twittertypes.TwitterUrl
+    IntervalTweets(time.Time, time.Time) []*twittertypes.Tweet
+    IntervalTweetCount(time.Duration, int) []int
+    IntervalTweetCountBefore(time.Time, time.Duration, int) []int
+    IntervalTop(TopKind, time.Time, time.Time, int) []KeyCount
+    TweetSeries(SeriesQuery) (*Series, error)
+    IntervalRelations(time.Time, time.Time) ([]RelationCount, error)
+    SaveSentiment(TweetSentiment) error
+    TweetSentiment(int64) (*TweetSentiment, error)
+    IntervalSentiment(time.Time, time.Time) (SentimentSummary, error)
+    IntervalTrackStats(TrackStatsQuery) (*TrackStats, error)
+    SaveAccountScore(AccountScore) error
+    AccountScores(float64, int) ([]AccountScore, error)
+    IntervalUserSnapshots(time.Time, time.Time) ([]UserSnapshot, error)
+    IntervalClusters(time.Time, time.Time, int, int) ([]Cluster, error)
+    DehydrateIds(DehydrateQuery) ([]int64, error)
+    CountTweetsAfterId(int64) int
+    ReprocessChunk(int64, int) (int64, int, error)
+    QueryTweets(TweetQuery) ([]*twittertypes.Tweet, error)
+    SaveMetrics(*twittertypes.Tweet, time.Time) error
+    TweetMetrics(int64) []MetricsSnapshot
+    RepollCandidates(time.Time) []RepollCandidate
+    SaveApiToken(ApiToken) error
+    ApiTokenByHash(string) (*ApiToken, error)
+    RevokeApiToken(string, time.Time) (bool, error)
+    ApiTokens() ([]ApiToken, error)
+    Subscribe(int) (<-chan *twittertypes.Tweet, func())
+    Close() error
     /*
-       SaveMedia(*twittertypes.Tweet, *twittertypes.Media) err
-       SaveMention(*twittertypes.Tweet, *twittertypes.Mention) err
-       SaveUrl(*twittertypes.Tweet, *twittertypes.TwitterUrl) err
-       SaveHashtag(*twittertypes.Tweet, *twittertypes.Hashtag) err
-       SaveEntities(*twittertypes.Tweet) err
-       SaveEvent(*twittertypes.Event) err
        LoadTweet(int64) (*twittertypes.Tweet, err)
        //LoadEvent()
-       LoadRecent() ([]*twittertypes.Tweet, err)
-       LoadSince(int64) ([]*twittertypes.Tweet, err)
        LoadOlder(int64) ([]*twittertypes.Tweet, err)
        Search(string) ([]*twittertypes.Tweet, err)
-       SaveNormalized(*twittertypes.Tweet) err
     */
 }
 
 type SqliteTweetStore struct {
+    Feed
     DB        *sql.DB
     CurrentTx *sql.Tx //pointer to current in progress transaction, may be nil
 }
 
+func init() {
+    Register("sqlite3", OpenSqlite)
+}
+
+//Open a SQLite database file, given either as a bare path or as sqlite3://path
+func OpenSqlite(dsn string) (TweetStore, error) {
+    db, err := sql.Open("sqlite3", strings.TrimPrefix(dsn, "sqlite3://"))
+    if err != nil {
+        fmt.Printf("Error opening sqlite3: %s\n", err)
+        return nil, err
+    }
+    sts := &SqliteTweetStore{}
+    _, err = sts.Initialize(db)
+    if err != nil {
+        db.Close()
+        return nil, err
+    }
+    return sts, nil
+}
+
+func (sts *SqliteTweetStore) Close() error {
+    return sts.DB.Close()
+}
+
 func (sts *SqliteTweetStore) BeginTransaction() error {
     if sts.CurrentTx == nil {
         currentTX, err := sts.DB.Begin()
@@ -55,6 +109,7 @@ func (sts *SqliteTweetStore) CommitTransaction() error {
             fmt.Printf("Error committing SQLite transaction\n%s\n", err)
         } else {
             sts.CurrentTx = nil
+            sts.publish()
         }
     }
     return nil
@@ -67,11 +122,31 @@ func (sts *SqliteTweetStore) RollbackTransaction() error {
             fmt.Printf("Error Rolling back SQLite transaction\n%s\n", err)
         } else {
             sts.CurrentTx = nil
+            sts.discard()
         }
     }
     return nil
 }
 
+//savepoint runs fn inside an outer transaction, undoing only fn's statements if it fails
+func (sts *SqliteTweetStore) savepoint(tx *sql.Tx, fn func() error) error {
+    _, err := tx.Exec("SAVEPOINT tweet;")
+    if err != nil {
+        fmt.Printf("Error starting savepoint: %s\n", err)
+        return err
+    }
+    err = fn()
+    if err != nil {
+        _, rerr := tx.Exec("ROLLBACK TO SAVEPOINT tweet;")
+        if rerr != nil {
+            fmt.Printf("Error rolling back to savepoint: %s\n", rerr)
+        }
+        return err
+    }
+    _, err = tx.Exec("RELEASE SAVEPOINT tweet;")
+    return err
+}
+
 func (sts *SqliteTweetStore) GetOrStartTransaction() (*sql.Tx, bool) {
     ownTransaction := false
     if sts.CurrentTx == nil {
@@ -97,6 +172,36 @@ func (sts *SqliteTweetStore) Initialize(db interface{}) (bool, error) {
         "CREATE TABLE IF NOT EXISTS user_mentions (userid, tweetid, screen_name, name, object, UNIQUE(userid, tweetid));",
         "CREATE TABLE IF NOT EXISTS urls (expanded_url, tweetid, url, object, UNIQUE (expanded_url, tweetid));",
         "CREATE TABLE IF NOT EXISTS hashtags (text, tweetid, object, UNIQUE (text, tweetid));",
+        "CREATE TABLE IF NOT EXISTS url_domains (domain, tweetid, UNIQUE (domain, tweetid));",
+        "CREATE VIRTUAL TABLE IF NOT EXISTS tweetsearch USING fts4(tweettext);",
+        "CREATE INDEX IF NOT EXISTS tweetstimeind ON tweets (time);",
+        "CREATE INDEX IF NOT EXISTS tweetsscreennameind ON tweets (screen_name COLLATE NOCASE);",
+        "CREATE INDEX IF NOT EXISTS hashtagstextind ON hashtags (text COLLATE NOCASE);",
+        "CREATE INDEX IF NOT EXISTS mentionsscreennameind ON user_mentions (screen_name COLLATE NOCASE);",
+        "CREATE INDEX IF NOT EXISTS urlstweetind ON urls (tweetid);",
+        "CREATE INDEX IF NOT EXISTS hashtagstweetind ON hashtags (tweetid);",
+        "CREATE INDEX IF NOT EXISTS mentionstweetind ON user_mentions (tweetid);",
+        "CREATE INDEX IF NOT EXISTS mediatweetind ON media (tweetid);",
+        "CREATE INDEX IF NOT EXISTS urldomainstweetind ON url_domains (tweetid);",
+        "CREATE TABLE IF NOT EXISTS tweet_metrics (tweetid, observed_at, retweet_count, favorite_count, reply_count, quote_count);",
+        "CREATE INDEX IF NOT EXISTS tweetmetricsind ON tweet_metrics (tweetid, observed_at);",
+        "CREATE TABLE IF NOT EXISTS users (userid, tweetid, screen_name, observed_at, object, UNIQUE (userid, tweetid));",
+        "CREATE INDEX IF NOT EXISTS usersscreennameind ON users (screen_name);",
+        "CREATE INDEX IF NOT EXISTS userstweetind ON users (tweetid);",
+        "CREATE TABLE IF NOT EXISTS relations (tweetid, type, source_userid, source_screen_name, target_userid, target_screen_name, target_tweetid, UNIQUE (tweetid, type, target_userid));",
+        "CREATE INDEX IF NOT EXISTS relationssourceind ON relations (source_userid);",
+        "CREATE INDEX IF NOT EXISTS relationstargetind ON relations (target_userid);",
+        "CREATE INDEX IF NOT EXISTS relationstargettweetind ON relations (target_tweetid);",
+        "CREATE TABLE IF NOT EXISTS api_tokens (id TEXT PRIMARY KEY, name, hash TEXT UNIQUE, scopes, created_at, revoked_at);",
+        "CREATE TABLE IF NOT EXISTS tweet_sentiment (tweetid INTEGER PRIMARY KEY, scorer, scored_at, compound, positive, negative, neutral);",
+        "CREATE TABLE IF NOT EXISTS tweet_langs (tweetid INTEGER PRIMARY KEY, lang, detected);",
+        "CREATE INDEX IF NOT EXISTS tweetlangsind ON tweet_langs (lang);",
+        "CREATE TABLE IF NOT EXISTS account_scores (userid INTEGER PRIMARY KEY, screen_name, tweets, score, scored_at, signals);",
+        "CREATE INDEX IF NOT EXISTS accountscoresind ON account_scores (score);",
+        "CREATE TABLE IF NOT EXISTS tweet_clusters (tweetid INTEGER PRIMARY KEY, clusterid, similarity, signature);",
+        "CREATE INDEX IF NOT EXISTS tweetclustersind ON tweet_clusters (clusterid);",
+        "CREATE TABLE IF NOT EXISTS tweet_lsh (band, tweetid, UNIQUE (band, tweetid));",
+        "CREATE INDEX IF NOT EXISTS tweetlshtweetind ON tweet_lsh (tweetid);",
         //"DROP TABLE IF EXISTS tweetsearch;",
         //"CREATE VIRTUAL TABLE tweetsearch USING fts3(tweetid, tweettext); INSERT INTO tweetsearch (tweetid, tweettext) SELECT tweetid, text FROM tweets;",
     }
@@ -111,11 +216,32 @@ func (sts *SqliteTweetStore) Initialize(db interface{}) (bool, error) {
     return true, nil
 }
 
+//SaveTweet saves a tweet with its entities, derived rows and metrics. A tweet whose own
+//row can't be written is undone and the error returned; failed derived rows are only logged.
 func (sts *SqliteTweetStore) SaveTweet(tweet *twittertypes.Tweet) error {
+    if tweet.Id == nil || tweet.User == nil {
+        return fmt.Errorf("tweet has no id or user")
+    }
     tx, ownTx := sts.GetOrStartTransaction()
+    if tx == nil {
+        return fmt.Errorf("no SQLite transaction to save tweet in")
+    }
+    if ownTx {
+        err := sts.saveTweet(tx, tweet)
+        if err != nil {
+            sts.RollbackTransaction()
+            return err
+        }
+        return sts.CommitTransaction()
+    }
+    return sts.savepoint(tx, func() error {
+        return sts.saveTweet(tx, tweet)
+    })
+}
+
+func (sts *SqliteTweetStore) saveTweet(tx *sql.Tx, tweet *twittertypes.Tweet) error {
     storetweetq := "INSERT OR REPLACE INTO tweets (tweetid, screen_name, time, text, fulltweet) VALUES (?, ?, ?, ?, ?);"
     storetimestampq := "INSERT OR REPLACE INTO tweettimestamps (tweetid, timestamp) VALUES (?, ?);"
-    storesearchableq := "INSERT INTO tweetsearch (tweetid, tweettext) VALUES (?, ?);"
 
     created_at, err := time.Parse(time.RubyDate, tweet.Created_at)
     if err != nil {
@@ -128,39 +254,22 @@ func (sts *SqliteTweetStore) SaveTweet(tweet *twittertypes.Tweet) error {
             fmt.Printf("No tweet.RawBytes and error marshalling tweet: %s\n", err)
         }
     }
-    res, err := tx.Exec(storetweetq, tweet.Id, tweet.User.Screen_name, created_at, tweet.Text, raw)
+    _, err = tx.Exec(storetweetq, tweet.Id, tweet.User.Screen_name, created_at, tweet.Text, raw)
     if err != nil {
         fmt.Printf("Error inserting tweet: %s\n", err)
-        //reterr = err
-    } else {
-        r, _ := res.RowsAffected()
-        if r == 0 {
-            fmt.Printf("0 rows affected by insert - something is probably wrong")
-        }
-        //fmt.Printf("Insert tweet rows affected: %d\n", r)
+        return err
     }
 
     _, err = tx.Exec(storetimestampq, tweet.Id, created_at.Unix())
     if err != nil {
-        //reterr = err
-    }
-
-    _ = storesearchableq
-    //res, err = sts.DB.Exec(storesearchableq, tweet.Id, tweet.Text)
-    if err != nil {
-        fmt.Printf("Error storing searchable: %s\n", err)
-        //reterr = err
+        fmt.Printf("Error inserting tweet timestamp: %s\n", err)
+        return err
     }
-    //r, _ := res.RowsAffected()
-    //fmt.Printf("%d rows affected by store searchable\n", r)
-
-    //_ = reterr
-    sts.SaveEntities(tweet)
 
-    if ownTx {
-        err = sts.CommitTransaction()
-    }
-    return nil // reterr
+    sts.SaveDerived(tweet)
+    sts.SaveMetrics(tweet, time.Now())
+    sts.queue(tweet)
+    return nil
 }
 
 func (sts *SqliteTweetStore) SaveTweets(tweets []*twittertypes.Tweet) error {
@@ -243,77 +352,31 @@ func (sts *SqliteTweetStore) LatestTweetId() int64 {
     var lastId int64
     err := row.Scan(&lastId)
     if err != nil {
-        fmt.Println("Error getting last tweet id: %s\n", err)
+        fmt.Printf("Error getting last tweet id: %s\n", err)
         return 0
     }
     return lastId
 }
 
 func (sts *SqliteTweetStore) RecentTweets(count int) []*twittertypes.Tweet {
-    var tweets = make([]*twittertypes.Tweet, 0, count)
     recenttweetsq := "SELECT tweetid, fulltweet FROM tweets ORDER BY tweetid DESC LIMIT ?;"
     rows, err := sts.DB.Query(recenttweetsq, count)
     if err != nil {
-        fmt.Printf("Error getting tweets after id: %s\n", err)
+        fmt.Printf("Error getting recent tweets: %s\n", err)
         return nil
     }
-    for rows.Next() {
-        var tweetid int64
-        var tweetstring []byte
-        err = rows.Scan(&tweetid, &tweetstring)
-        if err != nil {
-            fmt.Printf("Error scanning tweetafterid row: %s\n", err)
-            continue
-        }
-        var tweet = &twittertypes.Tweet{}
-        err = json.Unmarshal(tweetstring, tweet)
-        if err != nil {
-            fmt.Printf("Error unmarshalling tweetafterid row: %s\n", err)
-            fmt.Printf("Problematic tweet: %d \n%s\n", tweetid, string(tweetstring))
-            var z twittertypes.Int64Nullable
-            z = 0
-            tweet.Id = &z
-            tweet.User = &twittertypes.User{Screen_name: "Fail"}
-        } else {
-            //fmt.Printf("Got tweet\n")
-        }
-        tweets = append(tweets, tweet)
-    }
-    err = rows.Err() // get any error encountered during iterationerr := row.Scan(&lastId)
+    tweets, _ := scanTweetRows(rows)
     return tweets
 }
 
 func (sts *SqliteTweetStore) TweetsAfterId(tweetid int64) []*twittertypes.Tweet {
-    var tweets = make([]*twittertypes.Tweet, 0, 200)
     tweetsafterq := "SELECT tweetid, fulltweet FROM tweets WHERE tweetid > ? ORDER BY tweetid DESC;"
     rows, err := sts.DB.Query(tweetsafterq, tweetid)
     if err != nil {
         fmt.Printf("Error getting tweets after id: %s\n", err)
         return nil
     }
-    for rows.Next() {
-        var tweetid int64
-        var tweetstring []byte
-        err = rows.Scan(&tweetid, &tweetstring)
-        if err != nil {
-            fmt.Printf("Error scanning tweetafterid row: %s\n", err)
-            continue
-        }
-        var tweet = &twittertypes.Tweet{}
-        err = json.Unmarshal(tweetstring, tweet)
-        if err != nil {
-            fmt.Printf("Error unmarshalling tweetafterid row: %s\n", err)
-            fmt.Printf("Problematic tweet: %d \n%s\n", tweetid, string(tweetstring))
-            var z twittertypes.Int64Nullable
-            z = 0
-            tweet.Id = &z
-            tweet.User = &twittertypes.User{Screen_name: "Fail"}
-        } else {
-            fmt.Printf("Got tweet\n")
-        }
-        tweets = append(tweets, tweet)
-    }
-    err = rows.Err() // get any error encountered during iterationerr := row.Scan(&lastId)
+    tweets, _ := scanTweetRows(rows)
     return tweets
 }
 
@@ -350,46 +413,63 @@ func (sts *SqliteTweetStore) IntervalUrls(startTime time.Time, endTime time.Time
 
 //Get all the tweets posted between startTime and endTime
 func (sts *SqliteTweetStore) IntervalTweets(startTime time.Time, endTime time.Time) []*twittertypes.Tweet {
-    var tweets = make([]*twittertypes.Tweet, 0, 200)
-    urlquery := "SELECT tweetid, fulltweet FROM tweets WHERE tweets.time > ? AND tweets.time < ? ORDER BY tweets.tweetid DESC;"
-    rows, err := sts.DB.Query(urlquery, startTime, endTime)
+    tweetsq := "SELECT tweetid, fulltweet FROM tweets WHERE tweets.time > ? AND tweets.time < ? ORDER BY tweets.tweetid DESC;"
+    rows, err := sts.DB.Query(tweetsq, startTime, endTime)
     if err != nil {
-        fmt.Printf("Error getting interval urls: %s\n", err)
+        fmt.Printf("Error getting interval tweets: %s\n", err)
         return nil
     }
+    tweets, _ := scanTweetRows(rows)
+    return tweets
+}
+
+//scanTweetRows unmarshals (tweetid, fulltweet) rows and closes them. The stored json
+//is kept in RawBytes so fields twittertypes.Tweet doesn't carry can still be read.
+//An error that ends the rows early is returned with the tweets read before it.
+func scanTweetRows(rows *sql.Rows) ([]*twittertypes.Tweet, error) {
+    defer rows.Close()
+    var tweets = make([]*twittertypes.Tweet, 0, 200)
     for rows.Next() {
         var tweetid int64
         var tweetstring []byte
-        err = rows.Scan(&tweetid, &tweetstring)
+        err := rows.Scan(&tweetid, &tweetstring)
         if err != nil {
-            fmt.Printf("Error scanning tweetafterid row: %s\n", err)
+            fmt.Printf("Error scanning tweet row: %s\n", err)
             continue
         }
         var tweet = &twittertypes.Tweet{}
         err = json.Unmarshal(tweetstring, tweet)
         if err != nil {
-            fmt.Printf("Error unmarshalling intervalTweetRow row: %s\n", err)
+            fmt.Printf("Error unmarshalling tweet row: %s\n", err)
             fmt.Printf("Problematic tweet: %d \n%s\n", tweetid, string(tweetstring))
+            var z twittertypes.Int64Nullable
+            z = 0
+            tweet.Id = &z
+            tweet.User = &twittertypes.User{Screen_name: "Fail"}
+        } else {
+            tweet.RawBytes = tweetstring
         }
         tweets = append(tweets, tweet)
     }
-    err = rows.Err() // get any error encountered during iterationerr := row.Scan(&lastId)
-    return tweets
+    err := rows.Err()
+    if err != nil {
+        fmt.Printf("Error reading tweet rows: %s\n", err)
+    }
+    return tweets, err
 }
 
 func (sts *SqliteTweetStore) IntervalTweetCount(intervalDuration time.Duration, numIntervals int) []int {
-    rowCounts := make([]int, numIntervals)
-    lastHourq := "SELECT COUNT(tweetid) FROM tweets WHERE time > ? AND time < ?;"
-    for i := 0; i < numIntervals; i++ {
-        after := time.Now().Add(-1 * time.Duration(i) * intervalDuration)
-        before := time.Now().Add(-1 * time.Duration(i+1) * intervalDuration)
-        row := sts.DB.QueryRow(lastHourq, before, after)
-        err := row.Scan(&rowCounts[i])
-        if err != nil {
-            fmt.Printf("Error scanning tweet count row: %s\n", err)
-        }
-    }
-    return rowCounts
+    return sts.IntervalTweetCountBefore(time.Now(), intervalDuration, numIntervals)
+}
+
+//Count tweets in each of numIntervals intervals counting back from endTime, newest first
+func (sts *SqliteTweetStore) IntervalTweetCountBefore(endTime time.Time, intervalDuration time.Duration, numIntervals int) []int {
+    //one pass over the timestamp index, grouped by how many intervals back each tweet is
+    secs := intervalSeconds(intervalDuration)
+    end := endTime.Unix()
+    intervalq := "SELECT (? - timestamp) / ? AS bucket, COUNT(tweetid) FROM tweettimestamps WHERE timestamp > ? AND timestamp <= ? GROUP BY bucket;"
+    rows, err := sts.DB.Query(intervalq, end, secs, end-secs*int64(numIntervals), end)
+    return intervalBuckets(rows, err, numIntervals)
 }
 
 func (sts *SqliteTweetStore) Query(query string, args ...interface{}) *sql.Rows {